@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/go-chi/chi"
+)
+
+var (
+	// adminAddr, when set, serves the operational endpoints on their own
+	// listener, see loadAdminConfig.
+	adminAddr string
+	// adminToken is the token the /admin dashboard requires.
+	adminToken string
+)
+
+// loadAdminConfig reads TODO_ADMIN_ADDR, the address of a listener for
+// the health probes, /version, /metrics, the debug endpoints and the
+// dashboard, so they can be firewalled off from the API. /metrics, /debug
+// and /admin then leave the API listener. A socket named "admin" passed by
+// systemd sets it too. TODO_ADMIN_TOKEN enables the dashboard, which may
+// only go without it on a loopback admin listener.
+func loadAdminConfig() error {
+	adminToken = os.Getenv("TODO_ADMIN_TOKEN")
+	adminAddr = os.Getenv("TODO_ADMIN_ADDR")
+	if ln, ok := inheritedListeners["admin"]; ok && adminAddr == "" {
+		adminAddr = ln.Addr().String()
+	}
+	if adminAddr == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(adminAddr); err != nil {
+		return fmt.Errorf("invalid TODO_ADMIN_ADDR %q: %w", adminAddr, err)
+	}
+	return nil
+}
+
+// mountAdmin mounts the operational endpoints the API listener serves:
+// /metrics, the debug endpoints and, with its token, the dashboard unless
+// the admin listener has them.
+func mountAdmin(r chi.Router) {
+	if adminAddr == "" {
+		r.Get("/metrics", metricsHandler)
+		if adminToken != "" {
+			r.Mount("/admin", dashboardHandlers())
+		}
+	}
+	mountDebug(r)
+}
+
+// adminServer returns the admin listener, or nil when there is none. The
+// debug endpoints are served there unless they have a listener of their
+// own, behind the debug token, or without one on a loopback address, and
+// the dashboard likewise behind the admin token. Like
+// the debug listener it has no write timeout.
+func adminServer() *http.Server {
+	if adminAddr == "" {
+		return nil
+	}
+	r := chi.NewRouter()
+	r.Use(recoverer)
+	r.Get("/healthz", healthzHandler)
+	r.Get("/readyz", readyzHandler)
+	r.Get("/version", versionHandler)
+	r.Get("/metrics", metricsHandler)
+	if debugAddr == "" && (debugToken != "" || loopbackAddr(adminAddr)) {
+		r.Mount("/debug", debugHandlers())
+	}
+	if adminToken != "" || loopbackAddr(adminAddr) {
+		r.Mount("/admin", dashboardHandlers())
+	}
+	return limitSlowClients(&http.Server{Addr: adminAddr, Handler: r, ReadTimeout: readTimeout, IdleTimeout: idleTimeout})
+}
+
+// serveAdmin runs the admin server until it is shut down.
+func serveAdmin(srv *http.Server) {
+	ln, err := listener("admin", srv.Addr)
+	if err == nil {
+		slog.Info("serving admin endpoints", "addr", ln.Addr().String())
+		err = srv.Serve(ln)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("admin listener failed", "error", err)
+	}
+}
@@ -0,0 +1,62 @@
+// Package api holds the JSON types of the todo HTTP API, shared by the
+// server and its clients.
+package api
+
+import "time"
+
+// Todo is a todo as the API returns it. Completed is "true" or "false".
+type Todo struct {
+	ID          string       `json:"id"`
+	Title       string       `json:"title"`
+	Description string       `json:"description,omitempty"`
+	Completed   string       `json:"completed"`
+	CreatedAt   time.Time    `json:"created_at"`
+	List        string       `json:"list,omitempty"`
+	Labels      []string     `json:"labels,omitempty"`
+	Priority    int          `json:"priority,omitempty"`
+	DueAt       *time.Time   `json:"due_at,omitempty"`
+	Source      string       `json:"source,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// DueToday and Overdue tell whether an open todo is due today or
+	// was due before, in the timezone of the user's preferences. Only
+	// GET /todo sets them.
+	DueToday bool `json:"due_today,omitempty"`
+	Overdue  bool `json:"overdue,omitempty"`
+	// Column and Position place the todo on the board of its list, see
+	// GET /lists/{id}/board. Todos never moved there have neither.
+	Column   string `json:"column,omitempty"`
+	Position int64  `json:"position,omitempty"`
+	// StartDate is when work on the todo starts, and DependsOn the IDs of
+	// the todos to finish before it, see GET /lists/{id}/timeline.
+	StartDate *time.Time `json:"start_date,omitempty"`
+	DependsOn []string   `json:"depends_on,omitempty"`
+	// Recurrence is a 5-field cron expression in UTC. Once the todo is
+	// completed, a copy of it is created due at the next time it matches.
+	Recurrence string `json:"recurrence,omitempty"`
+}
+
+// Attachment is the metadata of a file attached to a todo. The server
+// stores it on the todo document, the contents live in the blob store
+// under Key.
+type Attachment struct {
+	ID          string    `bson:"id" json:"id"`
+	Name        string    `bson:"name" json:"name"`
+	ContentType string    `bson:"content_type" json:"content_type"`
+	Size        int64     `bson:"size" json:"size"`
+	Key         string    `bson:"key" json:"-"`
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`
+}
+
+// TodoList is the answer of GET /todo.
+type TodoList struct {
+	Data []Todo `json:"data"`
+}
+
+// Message is the answer of writes and errors. TodoID is set when a todo
+// was created, RequestID on errors.
+type Message struct {
+	Message   string `json:"message"`
+	Error     string `json:"error,omitempty"`
+	TodoID    string `json:"todo_id,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	archiveInterval  = time.Hour
+	archiveBatchSize = 500
+)
+
+// archiveAfter is how many months after creation completed todos are moved
+// to the archive collection, 0 disables archiving.
+var archiveAfter int
+
+// loadArchiveConfig reads TODO_ARCHIVE_AFTER_MONTHS.
+func loadArchiveConfig() error {
+	v := os.Getenv("TODO_ARCHIVE_AFTER_MONTHS")
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid TODO_ARCHIVE_AFTER_MONTHS %q", v)
+	}
+	if !usingMongo() {
+		return fmt.Errorf("TODO_ARCHIVE_AFTER_MONTHS requires the mongo store")
+	}
+	archiveAfter = n
+	return nil
+}
+
+// archiveCollection holds completed todos moved out of the todo collection.
+// Documents are copied as stored, so encrypted titles stay encrypted.
+func archiveCollection() *mongo.Collection {
+	return db.Collection(collectionName+"_archive", writeCollectionOpts)
+}
+
+// ListArchived returns all archived todos.
+func (mongoRepository) ListArchived(ctx context.Context) ([]todoModel, error) {
+	return listTodos(ctx, db.Collection(collectionName+"_archive", readCollectionOpts))
+}
+
+// ensureArchiveIndexes creates the index the archive job scans and, with
+// tenancy, the one backing tenant scoped archive listings.
+func ensureArchiveIndexes(ctx context.Context) error {
+	if archiveAfter == 0 {
+		return nil
+	}
+	_, err := writeCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "completed", Value: 1}, {Key: "createAt", Value: 1}},
+	})
+	if err != nil || tenantMode == tenantModeOff {
+		return err
+	}
+	_, err = archiveCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "createAt", Value: 1}},
+	})
+	return err
+}
+
+// archiveOld moves the completed todos older than archiveAfter to the
+// archive collection. It is scheduled every archiveInterval.
+func archiveOld(ctx context.Context) error {
+	if !storeAvailable() {
+		return nil
+	}
+	n, err := archiveTodos(ctx, time.Now().AddDate(0, -archiveAfter, 0))
+	if n > 0 {
+		slog.Info("archived todos", "component", "archive", "count", n)
+	}
+	return err
+}
+
+// archiveTodos moves completed todos created before cutoff in batches. Each
+// batch is copied before it is deleted, so an interrupted run leaves
+// duplicates that the next run skips rather than losing todos.
+func archiveTodos(ctx context.Context, cutoff time.Time) (int, error) {
+	filter := bson.M{"completed": true, "createAt": bson.M{"$lt": cutoff}}
+	moved := 0
+	for {
+		var batch []bson.Raw
+		err := withRetry(ctx, func(ctx context.Context) error {
+			cursor, err := writeCollection().Find(ctx, filter, options.Find().SetLimit(archiveBatchSize))
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+
+			batch = nil
+			return cursor.All(ctx, &batch)
+		})
+		if err != nil || len(batch) == 0 {
+			return moved, err
+		}
+
+		docs := make([]interface{}, len(batch))
+		ids := make(bson.A, len(batch))
+		tenants := map[string]bool{}
+		for i, doc := range batch {
+			docs[i] = doc
+			ids[i] = doc.Lookup("_id")
+			tenant, _ := doc.Lookup("tenant_id").StringValueOK()
+			tenants[tenant] = true
+		}
+
+		err = withRetry(ctx, func(ctx context.Context) error {
+			_, err := archiveCollection().InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+			if err != nil && !onlyDuplicateKeys(err) {
+				return err
+			}
+			// Todos reopened since they were read stay in the hot collection.
+			res, err := writeCollection().DeleteMany(ctx, bson.M{
+				"_id":       bson.M{"$in": ids},
+				"completed": true,
+			})
+			if err != nil {
+				return err
+			}
+			moved += int(res.DeletedCount)
+			return nil
+		})
+		for tenant := range tenants {
+			invalidateCache(ctx, tenant)
+		}
+		if err != nil {
+			return moved, err
+		}
+		if len(batch) < archiveBatchSize {
+			return moved, nil
+		}
+	}
+}
+
+// onlyDuplicateKeys reports whether every failed insert of a bulk write was
+// rejected for a duplicate key, meaning those documents were inserted
+// already, by an earlier run or attempt.
+func onlyDuplicateKeys(err error) bool {
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) || bwe.WriteConcernError != nil {
+		return false
+	}
+	for _, we := range bwe.WriteErrors {
+		if !mongo.IsDuplicateKeyError(we) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// Files starting with _ or . are embedded too, as app bundles use them.
+//
+//go:embed all:static
+var embeddedStatic embed.FS
+
+var (
+	// staticFS holds the templates and static files, see loadAssetsConfig.
+	staticFS fs.FS
+	// staticDir is the directory overriding the embedded files, if any.
+	staticDir string
+	// devMode watches staticDir and reloads what changed, see
+	// runStaticWatcher.
+	devMode bool
+
+	// pageCache holds the parsed templates and assetHashCache the hashes
+	// of assets/, until clearStaticCaches.
+	pageCache      sync.Map
+	assetHashCache sync.Map
+)
+
+// loadAssetsConfig reads TODO_STATIC_DIR, a directory served instead of
+// the templates and static files built into the binary, and TODO_DEV, or
+// the -dev flag, to work on them: staticDir, ./static by default, is
+// watched, templates and assets are reloaded when they change and open
+// pages reload themselves. Otherwise the templates are parsed once, here,
+// so a broken one fails at startup.
+func loadAssetsConfig() error {
+	staticDir = os.Getenv("TODO_STATIC_DIR")
+	devMode = false
+	if v := os.Getenv("TODO_DEV"); v != "" {
+		var err error
+		if devMode, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("invalid TODO_DEV %q, must be true or false", v)
+		}
+	}
+	if devMode && staticDir == "" {
+		staticDir = "static"
+	}
+	clearStaticCaches()
+	if staticDir == "" {
+		staticFS, _ = fs.Sub(embeddedStatic, "static")
+		return precompilePages()
+	}
+	if fi, err := os.Stat(staticDir); err != nil || !fi.IsDir() {
+		return fmt.Errorf("static directory %q is not a directory", staticDir)
+	}
+	staticFS = os.DirFS(staticDir)
+	return precompilePages()
+}
+
+// precompilePages parses the templates of staticFS into pageCache.
+func precompilePages() error {
+	names, err := fs.Glob(staticFS, "*.tpl")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := pageTemplate(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearStaticCaches drops the parsed templates and asset hashes, so they
+// are read again from staticFS.
+func clearStaticCaches() {
+	pageCache.Clear()
+	assetHashCache.Clear()
+}
+
+// pageFuncs are the functions templates can call.
+var pageFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"path":  url.PathEscape,
+	"asset": assetPath,
+}
+
+// pageTemplate returns the template of that name in staticFS.
+func pageTemplate(name string) (*template.Template, error) {
+	if t, ok := pageCache.Load(name); ok {
+		return t.(*template.Template), nil
+	}
+	t, err := template.New(name).Funcs(pageFuncs).ParseFS(staticFS, name)
+	if err == nil {
+		pageCache.Store(name, t)
+	}
+	return t, err
+}
+
+// renderPage answers with the template of that name executed with data.
+// A template that fails is logged and answered 500.
+func renderPage(w http.ResponseWriter, status int, name string, data any) {
+	renderBlock(w, status, name, name, data)
+}
+
+// renderBlock answers with the block defined in the template of that name,
+// such as a table row, like renderPage.
+func renderBlock(w http.ResponseWriter, status int, name, block string, data any) {
+	t, err := pageTemplate(name)
+	var buf bytes.Buffer
+	if err == nil {
+		err = t.ExecuteTemplate(&buf, block, data)
+	}
+	if err != nil {
+		slog.Error("could not render page", "template", name, "block", block, "error", err)
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "could not render the page", "error": "internal server error"})
+		return
+	}
+	html := buf.String()
+	if devMode && block == name {
+		html = strings.Replace(html, "</body>", devReloadScript+"</body>", 1)
+	}
+	rnd.HTMLString(w, status, html)
+}
+
+// assetHash returns the first 12 hex digits of the SHA-256 of the file
+// assets/name of staticFS.
+func assetHash(name string) (string, error) {
+	if h, ok := assetHashCache.Load(name); ok {
+		return h.(string), nil
+	}
+	b, err := fs.ReadFile(staticFS, path.Join("assets", name))
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	h := hex.EncodeToString(sum[:6])
+	assetHashCache.Store(name, h)
+	return h, nil
+}
+
+// assetPath returns the URL of the file assets/name of staticFS, with the
+// hash of its contents before the extension, like /assets/todos.0a1b2c3d4e5f.css.
+// Templates call it as {{asset "todos.css"}}.
+func assetPath(name string) (string, error) {
+	h, err := assetHash(name)
+	if err != nil {
+		return "", err
+	}
+	ext := path.Ext(name)
+	return "/assets/" + strings.TrimSuffix(name, ext) + "." + h + ext, nil
+}
+
+// fingerprinted matches the names assetPath returns.
+var fingerprinted = regexp.MustCompile(`^(.+)\.([0-9a-f]{12})(\.[0-9a-z]+)$`)
+
+// assetsHandler serves assets/ of staticFS at /assets. Names with the hash
+// of the current contents are cached for a year, as they change with them.
+// Others, including those with the hash of older contents requested by
+// pages rendered before a deploy, get the current file revalidated on
+// every use.
+func assetsHandler() http.Handler {
+	return http.StripPrefix("/assets/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		name, hashed := r.URL.Path, ""
+		if m := fingerprinted.FindStringSubmatch(name); m != nil {
+			name, hashed = m[1]+m[3], m[2]
+		}
+		if !fs.ValidPath(name) {
+			http.NotFound(w, r)
+			return
+		}
+		h, err := assetHash(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if hashed == h {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		// Embedded files have no modification time to revalidate with.
+		w.Header().Set("ETag", `"`+h+`"`)
+		assets, _ := fs.Sub(staticFS, "assets")
+		http.ServeFileFS(w, r, assets, name)
+	}))
+}
+
+func homeHandler(w http.ResponseWriter, r *http.Request) {
+	renderPage(w, http.StatusOK, "home.tpl", matchLocale(r, ""))
+}
+
+// hashedAsset matches the names bundlers give to files whose contents
+// never change, with a hash of at least 8 characters before the
+// extension, such as index-B1k2x9Qa.js or main.3f2a9c1b.css. Hashes
+// without a digit are not told apart from words, as in vendor-polyfills.js,
+// and those files are revalidated like the others.
+var hashedAsset = regexp.MustCompile(`[.-]([0-9A-Za-z_]{8,})\.[0-9a-z]+$`)
+
+func immutableAsset(name string) bool {
+	m := hashedAsset.FindStringSubmatch(name)
+	return m != nil && strings.ContainsAny(m[1], "0123456789")
+}
+
+// appBundled reports whether staticFS holds a single-page app to serve.
+// No app is checked in, so /app is only routed once one was built into
+// static/app or TODO_STATIC_DIR has one.
+func appBundled() bool {
+	_, err := fs.Stat(staticFS, "app/index.html")
+	return err == nil
+}
+
+// appHandler serves the single-page app under app/ of staticFS at /app.
+// Paths without a file extension that match no file get index.html, so
+// the app handles its own routes on reload; missing files with one, such
+// as a stale script, get 404.
+func appHandler() http.Handler {
+	return http.StripPrefix("/app", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if r.URL.Path == "" {
+			// Relative asset paths of index.html need the slash.
+			http.Redirect(w, r, "/app/", http.StatusMovedPermanently)
+			return
+		}
+		app, _ := fs.Sub(staticFS, "app")
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if fi, err := fs.Stat(app, name); err == nil && !fi.IsDir() && name != "index.html" {
+			if immutableAsset(name) {
+				w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			} else {
+				w.Header().Set("Cache-Control", "no-cache")
+			}
+			http.ServeFileFS(w, r, app, name)
+			return
+		}
+		if path.Ext(name) != "" && name != "index.html" {
+			http.NotFound(w, r)
+			return
+		}
+		index, err := fs.ReadFile(app, "index.html")
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(index)
+	}))
+}
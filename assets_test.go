@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+// useStaticFS serves files instead of the static files until the test
+// ends.
+func useStaticFS(t *testing.T, files fs.FS) {
+	prev := staticFS
+	staticFS = files
+	t.Cleanup(func() { staticFS = prev })
+}
+
+func TestAppHandler(t *testing.T) {
+	useStaticFS(t, fstest.MapFS{
+		"app/index.html":               {Data: []byte("<html>app</html>")},
+		"app/assets/index-B1k2x9Qa.js": {Data: []byte("run()")},
+		"app/robots.txt":               {Data: []byte("User-agent: *")},
+	})
+	if !appBundled() {
+		t.Fatal("the app was not found")
+	}
+
+	tests := []struct {
+		path  string
+		code  int
+		body  string
+		cache string
+	}{
+		{"/app", http.StatusMovedPermanently, "", ""},
+		{"/app/", http.StatusOK, "<html>app</html>", "no-cache"},
+		{"/app/lists/42", http.StatusOK, "<html>app</html>", "no-cache"},
+		{"/app/assets/index-B1k2x9Qa.js", http.StatusOK, "run()", "public, max-age=31536000, immutable"},
+		{"/app/robots.txt", http.StatusOK, "User-agent: *", "no-cache"},
+		{"/app/assets/index-Zz9y8x7w.js", http.StatusNotFound, "", ""},
+	}
+	h := appHandler()
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.code || (tt.body != "" && w.Body.String() != tt.body) || w.Header().Get("Cache-Control") != tt.cache {
+			t.Errorf("%s: got %d %q cached %q, want %d %q cached %q", tt.path, w.Code, w.Body, w.Header().Get("Cache-Control"), tt.code, tt.body, tt.cache)
+		}
+	}
+}
+
+func TestAppNotBundled(t *testing.T) {
+	useStaticFS(t, fstest.MapFS{"home.tpl": {Data: []byte("home")}})
+	if appBundled() {
+		t.Error("an app was found without app/index.html")
+	}
+}
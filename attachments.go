@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/qasim-invodev/todo/api"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	presignUploadExpiry   = 15 * time.Minute
+	presignDownloadExpiry = 5 * time.Minute
+	maxAttachmentsPerTodo = 20
+)
+
+// maxAttachmentSize can be raised with TODO_ATTACHMENT_MAX_BYTES.
+var maxAttachmentSize int64 = 25 << 20
+
+// attachment is the metadata kept on the todo document, shared with the
+// API.
+type attachment = api.Attachment
+
+func loadAttachmentConfig() error {
+	if v := os.Getenv("TODO_ATTACHMENT_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return errors.New("TODO_ATTACHMENT_MAX_BYTES must be a positive number of bytes")
+		}
+		maxAttachmentSize = n
+	}
+	return loadBlobStore()
+}
+
+// AddAttachment records a on the todo with the given id, unless the todo
+// already has maxAttachmentsPerTodo attachments.
+func (mongoRepository) AddAttachment(ctx context.Context, id primitive.ObjectID, a attachment) error {
+	filter := scoped(ctx, bson.M{"_id": id})
+	filter["attachments."+strconv.Itoa(maxAttachmentsPerTodo-1)] = bson.M{"$exists": false}
+	return withRetry(ctx, func(ctx context.Context) error {
+		res, err := writeCollection().UpdateOne(ctx, filter, bson.M{"$push": bson.M{"attachments": a}},
+			options.Update().SetComment(mongoComment(ctx)))
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return errNotFound
+		}
+		return nil
+	})
+}
+
+// Attachment returns the attachment aid of the todo with the given id.
+func (mongoRepository) Attachment(ctx context.Context, id primitive.ObjectID, aid string) (attachment, error) {
+	var tm todoModel
+	opts := options.FindOne().SetProjection(bson.M{"attachments": bson.M{"$elemMatch": bson.M{"id": aid}}})
+	if rid := requestID(ctx); rid != "" {
+		opts.SetComment(rid)
+	}
+	err := withRetry(ctx, func(ctx context.Context) error {
+		return writeCollection().FindOne(ctx, scoped(ctx, bson.M{"_id": id}), opts).Decode(&tm)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && len(tm.Attachments) == 0) {
+		return attachment{}, errNotFound
+	}
+	if err != nil {
+		return attachment{}, err
+	}
+	return tm.Attachments[0], nil
+}
+
+// RemoveAttachment removes attachment aid from the todo and returns it.
+func (mongoRepository) RemoveAttachment(ctx context.Context, id primitive.ObjectID, aid string) (attachment, error) {
+	var tm todoModel
+	err := withRetry(ctx, func(ctx context.Context) error {
+		return writeCollection().FindOneAndUpdate(ctx,
+			scoped(ctx, bson.M{"_id": id, "attachments.id": aid}),
+			bson.M{"$pull": bson.M{"attachments": bson.M{"id": aid}}},
+			options.FindOneAndUpdate().SetProjection(bson.M{"attachments": bson.M{"$elemMatch": bson.M{"id": aid}}}).SetComment(mongoComment(ctx)),
+		).Decode(&tm)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return attachment{}, errNotFound
+	}
+	if err != nil {
+		return attachment{}, err
+	}
+	return tm.Attachments[0], nil
+}
+
+// deleteBlobs removes the contents of removed attachments in the background.
+// It is best effort, failures only leave unreferenced objects behind.
+func deleteBlobs(attachments []attachment) {
+	if blobs == nil || len(attachments) == 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		for _, a := range attachments {
+			if err := blobs.Delete(ctx, a.Key); err != nil {
+				slog.Error("could not delete attachment blob", "key", a.Key, "error", err)
+			}
+		}
+	}()
+}
+
+func attachmentRoutes(r chi.Router) {
+	r.With(validateBody("attachment-create")).Post("/{id}/attachments", createAttachment)
+	r.Get("/{id}/attachments/{attachmentID}", downloadAttachment)
+	r.Delete("/{id}/attachments/{attachmentID}", deleteAttachment)
+}
+
+// createAttachment records the metadata of a new attachment and returns a
+// presigned URL the client uploads the contents to.
+func createAttachment(w http.ResponseWriter, r *http.Request) {
+	objID, ok := attachmentTodoID(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name        string `json:"name"`
+		ContentType string `json:"content_type"`
+		Size        int64  `json:"size"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid request", "error": err.Error()})
+		return
+	}
+
+	aid := primitive.NewObjectID().Hex()
+	a := attachment{
+		ID:          aid,
+		Name:        req.Name,
+		ContentType: req.ContentType,
+		Size:        req.Size,
+		Key:         blobKey(r.Context(), objID, aid),
+		CreatedAt:   time.Now(),
+	}
+	uploadURL, err := blobs.PresignPut(a.Key, a.ContentType, a.Size, presignUploadExpiry)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "could not presign upload", "error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	if err := repo.AddAttachment(ctx, objID, a); err != nil {
+		if errors.Is(err, errNotFound) {
+			rnd.JSON(w, http.StatusNotFound, renderer.M{"message": "todo not found or attachment limit reached", "error": err.Error()})
+			return
+		}
+		storeErr(w, r, "could not create attachment", err)
+		return
+	}
+
+	rnd.JSON(w, http.StatusCreated, renderer.M{
+		"data":       a,
+		"upload_url": uploadURL,
+		"expires_at": time.Now().Add(presignUploadExpiry),
+	})
+}
+
+// downloadAttachment redirects to a short lived presigned download URL.
+func downloadAttachment(w http.ResponseWriter, r *http.Request) {
+	objID, ok := attachmentTodoID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	a, err := repo.Attachment(ctx, objID, chi.URLParam(r, "attachmentID"))
+	if err != nil {
+		storeErr(w, r, "could not fetch attachment", err)
+		return
+	}
+	downloadURL, err := blobs.PresignGet(a.Key, presignDownloadExpiry)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "could not presign download", "error": err.Error()})
+		return
+	}
+	http.Redirect(w, r, downloadURL, http.StatusTemporaryRedirect)
+}
+
+func deleteAttachment(w http.ResponseWriter, r *http.Request) {
+	objID, ok := attachmentTodoID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	a, err := repo.RemoveAttachment(ctx, objID, chi.URLParam(r, "attachmentID"))
+	if err != nil {
+		storeErr(w, r, "could not delete attachment", err)
+		return
+	}
+	deleteBlobs([]attachment{a})
+
+	rnd.JSON(w, http.StatusOK, renderer.M{"message": "attachment deleted successfully"})
+}
+
+// attachmentTodoID validates the todo id of an attachment route and that
+// a blob store is configured.
+func attachmentTodoID(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	if blobs == nil {
+		rnd.JSON(w, http.StatusNotImplemented, renderer.M{"message": "attachments are not configured", "error": "not implemented"})
+		return primitive.NilObjectID, false
+	}
+	id := chi.URLParam(r, "id")
+	if !primitive.IsValidObjectID(id) {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid id", "error": "bad request"})
+		return primitive.NilObjectID, false
+	}
+	objID, _ := primitive.ObjectIDFromHex(id)
+	return objID, true
+}
+
+func blobKey(ctx context.Context, todoID primitive.ObjectID, aid string) string {
+	key := "attachments/"
+	if tenant := tenantFrom(ctx); tenant != "" {
+		key += tenant + "/"
+	}
+	return key + todoID.Hex() + "/" + aid
+}
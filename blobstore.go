@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// blobStore keeps attachment contents outside of Mongo. Clients upload and
+// download directly using presigned URLs, so file bodies only pass through
+// this server for attachments it receives itself, such as those of email.
+type blobStore interface {
+	// PresignPut returns a URL accepting a PUT of exactly size bytes.
+	PresignPut(key, contentType string, size int64, expires time.Duration) (string, error)
+	// Put stores body under key.
+	Put(ctx context.Context, key, contentType string, body []byte) error
+	// PresignGet returns a URL serving the object under key.
+	PresignGet(key string, expires time.Duration) (string, error)
+	// Delete removes the object under key. Missing objects are not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// blobs is nil when no object storage is configured, which disables the
+// attachment endpoints.
+var blobs blobStore
+
+// loadBlobStore configures the S3 compatible store from TODO_S3_ENDPOINT,
+// TODO_S3_REGION, TODO_S3_BUCKET, TODO_S3_ACCESS_KEY and TODO_S3_SECRET_KEY.
+func loadBlobStore() error {
+	endpoint := os.Getenv("TODO_S3_ENDPOINT")
+	if endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid TODO_S3_ENDPOINT %q", endpoint)
+	}
+
+	s := &s3Store{
+		endpoint:  u,
+		region:    os.Getenv("TODO_S3_REGION"),
+		bucket:    os.Getenv("TODO_S3_BUCKET"),
+		accessKey: os.Getenv("TODO_S3_ACCESS_KEY"),
+		secretKey: os.Getenv("TODO_S3_SECRET_KEY"),
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.bucket == "" || s.accessKey == "" || s.secretKey == "" {
+		return fmt.Errorf("TODO_S3_BUCKET, TODO_S3_ACCESS_KEY and TODO_S3_SECRET_KEY are required with TODO_S3_ENDPOINT")
+	}
+	blobs = s
+	return nil
+}
+
+// s3Store talks to S3 or an S3 compatible server such as MinIO using
+// path-style addressing and SigV4 query string signing.
+type s3Store struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+}
+
+func (s *s3Store) PresignPut(key, contentType string, size int64, expires time.Duration) (string, error) {
+	headers := map[string]string{"content-length": strconv.FormatInt(size, 10)}
+	if contentType != "" {
+		headers["content-type"] = contentType
+	}
+	return s.presign(http.MethodPut, key, headers, expires, time.Now())
+}
+
+func (s *s3Store) Put(ctx context.Context, key, contentType string, body []byte) error {
+	u, err := s.PresignPut(key, contentType, int64(len(body)), time.Minute)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("put %s: unexpected status %s", key, resp.Status)
+	}
+	return nil
+}
+
+func (s *s3Store) PresignGet(key string, expires time.Duration) (string, error) {
+	return s.presign(http.MethodGet, key, nil, expires, time.Now())
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	u, err := s.presign(http.MethodDelete, key, nil, time.Minute, time.Now())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("delete %s: unexpected status %s", key, resp.Status)
+	}
+	return nil
+}
+
+// presign builds a SigV4 presigned URL. Every entry of headers is signed,
+// so the client has to send them with exactly these values.
+func (s *s3Store) presign(method, key string, headers map[string]string, expires time.Duration, now time.Time) (string, error) {
+	if expires < time.Second || expires > 7*24*time.Hour {
+		return "", fmt.Errorf("presign expiry %s out of range", expires)
+	}
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+
+	signed := map[string]string{"host": s.endpoint.Host}
+	for k, v := range headers {
+		signed[strings.ToLower(k)] = strings.TrimSpace(v)
+	}
+	names := make([]string, 0, len(signed))
+	for k := range signed {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + signed[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.accessKey+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", signedHeaders)
+	canonicalQuery := strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	path := "/" + s3Escape(s.bucket) + "/" + s3Escape(key)
+	canonicalRequest := strings.Join([]string{
+		method,
+		path,
+		canonicalQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	signature := sigV4Signature(s.secretKey, now, s.region, "s3", stringToSign)
+
+	return s.endpoint.Scheme + "://" + s.endpoint.Host + path + "?" + canonicalQuery + "&X-Amz-Signature=" + signature, nil
+}
+
+// s3Escape percent-encodes everything but unreserved characters and '/',
+// as SigV4 requires for the canonical URI.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// boardSpacing is the gap left between the positions of neighbouring
+// todos on a board, so a todo moved between two others gets a position of
+// its own without moving them.
+const boardSpacing = 1 << 16
+
+// boardColumns are the columns of every board, in order, see
+// loadBoardConfig. Todos in the last one are the completed ones.
+var boardColumns = []string{"todo", "doing", "done"}
+
+// loadBoardConfig reads TODO_BOARD_COLUMNS, the comma-separated columns of
+// the boards, the last one holding the completed todos.
+func loadBoardConfig() error {
+	boardColumns = []string{"todo", "doing", "done"}
+	v := os.Getenv("TODO_BOARD_COLUMNS")
+	if v == "" {
+		return nil
+	}
+	var columns []string
+	for _, c := range strings.Split(v, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" || utf8.RuneCountInString(c) > maxListLength || slices.Contains(columns, c) {
+			return fmt.Errorf("invalid TODO_BOARD_COLUMNS %q, must be distinct names", v)
+		}
+		columns = append(columns, c)
+	}
+	if len(columns) < 2 {
+		return fmt.Errorf("invalid TODO_BOARD_COLUMNS %q, a board needs at least two columns", v)
+	}
+	boardColumns = columns
+	return nil
+}
+
+type (
+	// boardColumn is a column of GET /lists/{id}/board, its todos in
+	// order.
+	boardColumn struct {
+		Name  string `json:"name"`
+		Todos []todo `json:"todos"`
+	}
+
+	moveRequest struct {
+		ID     string `json:"id"`
+		Column string `json:"column"`
+		// Index is the place in the column, 0 for the top. Larger ones
+		// move the todo to the bottom.
+		Index int `json:"index"`
+	}
+)
+
+// doneColumn returns the column of the completed todos.
+func doneColumn() string {
+	return boardColumns[len(boardColumns)-1]
+}
+
+// columnOf returns the column tm is shown in. Completed todos are in the
+// last column whatever they were moved to, and open ones without a column,
+// or with one no longer configured, in the first.
+func columnOf(tm todoModel) int {
+	if tm.Completed {
+		return len(boardColumns) - 1
+	}
+	if i := slices.Index(boardColumns[:len(boardColumns)-1], tm.Column); i >= 0 {
+		return i
+	}
+	return 0
+}
+
+// loadBoard returns the todos of list by column of boardColumns. Todos are
+// ordered by position, those never moved last, oldest first.
+func loadBoard(ctx context.Context, list string) ([][]todoModel, error) {
+	board := make([][]todoModel, len(boardColumns))
+	err := repo.Each(ctx, listQuery{List: &list}, func(t todoModel) error {
+		i := columnOf(t)
+		board[i] = append(board[i], t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range board {
+		slices.SortFunc(column, boardOrder)
+	}
+	return board, nil
+}
+
+// boardOrder orders the todos of a column.
+func boardOrder(a, b todoModel) int {
+	return cmp.Or(
+		cmp.Compare(unplaced(a), unplaced(b)),
+		cmp.Compare(a.Position, b.Position),
+		a.CreatedAt.Compare(b.CreatedAt),
+		strings.Compare(a.ID.Hex(), b.ID.Hex()),
+	)
+}
+
+// unplaced is 1 for todos never moved on the board, sorting them last.
+func unplaced(t todoModel) int {
+	if t.Position == 0 {
+		return 1
+	}
+	return 0
+}
+
+// boardPlace is where a move puts a todo: Index in Column of the board of
+// List.
+type boardPlace struct {
+	List   string `json:"list"`
+	Column string `json:"column"`
+	Index  int    `json:"index"`
+}
+
+// moveTodo moves the todo id of list to index in column, marking it
+// completed when that is the last column and open otherwise, or queues the
+// move while the database is unreachable. The store writes the move and,
+// when there is no gap left at that place, the positions of the column
+// numbered again, together, see todoStore.Move.
+func moveTodo(ctx context.Context, list string, id primitive.ObjectID, column string, index int) (queued bool, err error) {
+	if !slices.Contains(boardColumns, column) {
+		return false, fmt.Errorf("unknown column %q", column)
+	}
+	p := boardPlace{List: list, Column: column, Index: max(0, index)}
+	return bufferOr(bufferedWrite{Op: bufferedMove, Tenant: tenantFrom(ctx), ID: id, Place: &p}, func() error {
+		_, err := repo.Move(ctx, id, p)
+		return err
+	})
+}
+
+// placeTodo returns the position of a todo moved to index of column, the
+// other todos of that column in board order. When there is no gap at
+// index, the column is numbered again, which keeps its order, and
+// renumbered holds the todos whose position that changed.
+func placeTodo(column []todoModel, index int) (pos int64, renumbered []todoModel) {
+	index = max(0, min(index, len(column)))
+	if pos, ok := positionAt(column, index); ok {
+		return pos, nil
+	}
+	column = slices.Clone(column)
+	for i := range column {
+		if p := int64(i+1) * boardSpacing; column[i].Position != p {
+			column[i].Position = p
+			renumbered = append(renumbered, column[i])
+		}
+	}
+	pos, _ = positionAt(column, index)
+	return pos, renumbered
+}
+
+// positionAt returns the position between the todos before and at index
+// of column, or false when there is none: the two are neighbours, or some
+// todos were never placed.
+func positionAt(column []todoModel, index int) (int64, bool) {
+	var before, after int64
+	if index > 0 {
+		before = column[index-1].Position
+	}
+	if index < len(column) {
+		after = column[index].Position
+	} else {
+		after = before + 2*boardSpacing
+	}
+	if slices.ContainsFunc(column, func(t todoModel) bool { return t.Position == 0 }) {
+		return 0, false
+	}
+	pos := before + (after-before)/2
+	return pos, pos > before && pos < after
+}
+
+// listFilter matches the todos of list in Mongo, "" being the todos
+// without a list.
+func listFilter(list string) interface{} {
+	if list == "" {
+		return bson.M{"$in": bson.A{nil, ""}}
+	}
+	return list
+}
+
+// columnFilter selects the todos of list that columnOf puts in column c.
+func columnFilter(list string, c int) bson.M {
+	filter := bson.M{"list": listFilter(list), "completed": bson.M{"$ne": true}}
+	switch last := len(boardColumns) - 1; c {
+	case last:
+		filter["completed"] = true
+	case 0:
+		filter["column"] = bson.M{"$nin": boardColumns[1:last]}
+	default:
+		filter["column"] = boardColumns[c]
+	}
+	return filter
+}
+
+// boardProjection is what Move reads of the other todos of a column.
+var boardProjection = bson.M{"position": 1, "createAt": 1}
+
+// Move reads the todo and the column of p, then writes the move, after
+// the positions of the column numbered again when needed, in one ordered
+// BulkWrite. With the outbox enabled, all of it is one transaction, which
+// also records the only event, for id. Two concurrent moves to the same
+// gap may both get its position, the next move there numbering the column
+// again.
+func (mongoRepository) Move(ctx context.Context, id primitive.ObjectID, p boardPlace) ([]primitive.ObjectID, error) {
+	c := slices.Index(boardColumns, p.Column)
+	if c < 0 {
+		return nil, fmt.Errorf("unknown column %q", p.Column)
+	}
+	moved := []primitive.ObjectID{id}
+	var tm todoModel
+	err := withRetry(ctx, func(ctx context.Context) error {
+		return inTx(ctx, func(ctx context.Context) error {
+			coll := writeCollection()
+			findOne, find := options.FindOne(), options.Find().SetProjection(boardProjection)
+			if id := requestID(ctx); id != "" {
+				findOne.SetComment(id)
+				find.SetComment(id)
+			}
+			err := coll.FindOne(ctx, scoped(ctx, bson.M{"_id": id, "list": listFilter(p.List)}), findOne).Decode(&tm)
+			if err != nil {
+				return err
+			}
+			filter := columnFilter(p.List, c)
+			filter["_id"] = bson.M{"$ne": id}
+			cursor, err := coll.Find(ctx, scoped(ctx, filter), find)
+			if err != nil {
+				return err
+			}
+			var column []todoModel
+			if err := cursor.All(ctx, &column); err != nil {
+				return err
+			}
+			slices.SortFunc(column, boardOrder)
+
+			pos, renumbered := placeTodo(column, p.Index)
+			writes := make([]mongo.WriteModel, 0, len(renumbered)+1)
+			moved = moved[:1]
+			for _, t := range renumbered {
+				writes = append(writes, mongo.NewUpdateOneModel().
+					SetFilter(scoped(ctx, bson.M{"_id": t.ID})).
+					SetUpdate(bson.M{"$set": bson.M{"position": t.Position}}))
+				moved = append(moved, t.ID)
+			}
+			tm.Column, tm.Position, tm.Completed = p.Column, pos, c == len(boardColumns)-1
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(scoped(ctx, bson.M{"_id": id})).
+				SetUpdate(bson.M{"$set": bson.M{"column": tm.Column, "position": tm.Position, "completed": tm.Completed}}))
+			if _, err := coll.BulkWrite(ctx, writes, options.BulkWrite().SetComment(mongoComment(ctx))); err != nil {
+				return err
+			}
+			return recordEvent(ctx, eventTodoUpdated, tm)
+		})
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return moved, errNotFound
+	}
+	if err == nil {
+		notifyChange(ctx, eventTodoUpdated, tm)
+	}
+	return moved, err
+}
+
+// listParam returns the list name of the path, which is escaped as lists
+// may contain any character.
+func listParam(r *http.Request) string {
+	list := chi.URLParam(r, "id")
+	if unescaped, err := url.PathUnescape(list); err == nil {
+		return unescaped
+	}
+	return list
+}
+
+// listHandlers serves the boards of the lists. A list is the todos of
+// that name, so every name has a board, empty when it has no todos.
+func listHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Use(tenantMiddleware)
+	r.Use(requireStore)
+	r.Get("/{id}/board", fetchBoard)
+	r.Post("/{id}/board/move", moveOnBoard)
+	r.Get("/{id}/export.pdf", exportListPDF)
+	r.Get("/{id}/timeline", fetchTimeline)
+	return r
+}
+
+func fetchBoard(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	list := listParam(r)
+	board, err := loadBoard(ctx, list)
+	if err != nil {
+		storeErr(w, r, "could not fetch board", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": renderer.M{"list": list, "columns": boardJSON(board)}})
+}
+
+func boardJSON(board [][]todoModel) []boardColumn {
+	columns := make([]boardColumn, len(board))
+	for i, todos := range board {
+		columns[i] = boardColumn{Name: boardColumns[i], Todos: []todo{}}
+		for _, t := range todos {
+			columns[i].Todos = append(columns[i].Todos, toTodo(t))
+		}
+	}
+	return columns
+}
+
+// moveOnBoard moves a todo of the list to a column and place, answering
+// with the board as it is then.
+func moveOnBoard(w http.ResponseWriter, r *http.Request) {
+	var req moveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid request", "error": err.Error()})
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(req.ID)
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid id", "error": err.Error()})
+		return
+	}
+	if !slices.Contains(boardColumns, req.Column) {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "column must be one of " + strings.Join(boardColumns, ", "), "error": "bad request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	list := listParam(r)
+	queued, err := moveTodo(ctx, list, id, req.Column, req.Index)
+	if errors.Is(err, errNotFound) {
+		rnd.JSON(w, http.StatusNotFound, renderer.M{"message": "todo not found in this list", "error": "not found", "code": kindNotFound.code})
+		return
+	}
+	if err != nil {
+		storeErr(w, r, "could not move todo", err)
+		return
+	}
+	if queued {
+		rnd.JSON(w, http.StatusAccepted, renderer.M{"message": "move queued until the database is reachable"})
+		return
+	}
+	board, err := loadBoard(ctx, list)
+	if err != nil {
+		storeErr(w, r, "could not fetch board", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"message": "todo moved successfully", "data": renderer.M{"list": list, "columns": boardJSON(board)}})
+}
+
+type (
+	// boardPage is the data of board.tpl.
+	boardPage struct {
+		locale
+		Prefs   preferences
+		List    string
+		Columns []boardPageColumn
+		// Error and Message are in English, translated when rendered.
+		Error   string
+		Message string
+	}
+
+	boardPageColumn struct {
+		Name  string
+		Cards []boardCard
+	}
+
+	// boardCard is a todo on the board page, with the moves it offers.
+	boardCard struct {
+		todoRow
+		Moves []boardMove
+	}
+
+	// boardMove is a button of a card, posting the column and index to
+	// move the todo to.
+	boardMove struct {
+		Label  string
+		Title  string
+		Column string
+		Index  int
+	}
+)
+
+// boardPageHandler renders the board of a list.
+func boardPageHandler(w http.ResponseWriter, r *http.Request) {
+	p := boardPage{}
+	if r.URL.Query().Get("queued") != "" {
+		p.Message = "Move queued until the database is reachable."
+	}
+	renderBoardPage(w, r, http.StatusOK, p)
+}
+
+func renderBoardPage(w http.ResponseWriter, r *http.Request, status int, p boardPage) {
+	p.locale, p.Prefs, p.List = pageLocale(r.Context()), pagePreferences(r.Context()), listParam(r)
+	p.Error, p.Message = p.lookup(p.Error), p.lookup(p.Message)
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+	board, err := loadBoard(ctx, p.List)
+	if err != nil {
+		k := classify(err)
+		noteError(r.Context(), k.code, err)
+		p.Error = p.T("Could not fetch the board: %s", p.lookup(k.describe(err)))
+		renderPage(w, k.status, "board.tpl", p)
+		return
+	}
+	for i, todos := range board {
+		c := boardPageColumn{Name: boardColumns[i]}
+		for j, t := range todos {
+			card := boardCard{todoRow: newTodoRow(r.Context(), t)}
+			// Moves to another column go to its bottom.
+			if i > 0 {
+				card.Moves = append(card.Moves, boardMove{"←", p.T("Move to %s", boardColumns[i-1]), boardColumns[i-1], len(board[i-1])})
+			}
+			if j > 0 {
+				card.Moves = append(card.Moves, boardMove{"↑", p.T("Move up"), c.Name, j - 1})
+			}
+			if j < len(todos)-1 {
+				card.Moves = append(card.Moves, boardMove{"↓", p.T("Move down"), c.Name, j + 1})
+			}
+			if i < len(board)-1 {
+				card.Moves = append(card.Moves, boardMove{"→", p.T("Move to %s", boardColumns[i+1]), boardColumns[i+1], len(board[i+1])})
+			}
+			c.Cards = append(c.Cards, card)
+		}
+		p.Columns = append(p.Columns, c)
+	}
+	renderPage(w, status, "board.tpl", p)
+}
+
+// moveOnBoardPage moves a todo from the form of a card and goes back to
+// the board, or renders it again with the problem.
+func moveOnBoardPage(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBody)
+	if err := r.ParseForm(); err != nil {
+		renderBoardPage(w, r, http.StatusBadRequest, boardPage{Error: "The form could not be read."})
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(r.PostForm.Get("id"))
+	if err != nil {
+		renderBoardPage(w, r, http.StatusBadRequest, boardPage{Error: "Invalid todo id."})
+		return
+	}
+	index, err := strconv.Atoi(r.PostForm.Get("index"))
+	column := r.PostForm.Get("column")
+	if err != nil || !slices.Contains(boardColumns, column) {
+		renderBoardPage(w, r, http.StatusBadRequest, boardPage{Error: "Unknown column or place."})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+	queued, err := moveTodo(ctx, listParam(r), id, column, index)
+	if err != nil {
+		k := classify(err)
+		noteError(r.Context(), k.code, err)
+		l := pageLocale(r.Context())
+		renderBoardPage(w, r, k.status, boardPage{Error: l.T("Could not move the todo: %s", l.lookup(k.describe(err)))})
+		return
+	}
+	// The list may hold an escaped slash, which the decoded path loses.
+	target := strings.TrimSuffix(r.URL.EscapedPath(), "/move")
+	if queued {
+		target += "?queued=1"
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// column returns todos at positions, created a second apart in order.
+func column(positions ...int64) []todoModel {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	todos := make([]todoModel, len(positions))
+	for i, p := range positions {
+		todos[i] = todoModel{ID: primitive.NewObjectID(), Position: p, CreatedAt: start.Add(time.Duration(i) * time.Second)}
+	}
+	return todos
+}
+
+func TestPositionAt(t *testing.T) {
+	tests := []struct {
+		name      string
+		positions []int64
+		index     int
+		pos       int64
+		ok        bool
+	}{
+		{"empty column", nil, 0, boardSpacing, true},
+		{"top", []int64{100, 200}, 0, 50, true},
+		{"between", []int64{100, 200}, 1, 150, true},
+		{"bottom", []int64{100, 200}, 2, 200 + boardSpacing, true},
+		{"neighbours", []int64{100, 101}, 1, 0, false},
+		{"no room at the top", []int64{1, 200}, 0, 0, false},
+		{"unplaced todo", []int64{100, 0}, 1, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos, ok := positionAt(column(tt.positions...), tt.index)
+			if ok != tt.ok || ok && pos != tt.pos {
+				t.Errorf("positionAt = %d, %v, want %d, %v", pos, ok, tt.pos, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPlaceTodo(t *testing.T) {
+	tests := []struct {
+		name       string
+		positions  []int64
+		index      int
+		pos        int64
+		renumbered []int64
+	}{
+		{"gap", []int64{boardSpacing, 2 * boardSpacing}, 1, boardSpacing + boardSpacing/2, nil},
+		{"index past the bottom", []int64{boardSpacing}, 5, 2 * boardSpacing, nil},
+		{"negative index", []int64{boardSpacing}, -1, boardSpacing / 2, nil},
+		// Only the todos whose position changes are written.
+		{"no gap", []int64{boardSpacing, boardSpacing + 1, 3 * boardSpacing}, 1, boardSpacing + boardSpacing/2, []int64{2 * boardSpacing}},
+		{"unplaced todos", []int64{boardSpacing, 0, 0}, 3, 4 * boardSpacing, []int64{2 * boardSpacing, 3 * boardSpacing}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			todos := column(tt.positions...)
+			pos, renumbered := placeTodo(todos, tt.index)
+			if pos != tt.pos {
+				t.Errorf("position %d, want %d", pos, tt.pos)
+			}
+			var got []int64
+			for _, r := range renumbered {
+				got = append(got, r.Position)
+			}
+			if !slices.Equal(got, tt.renumbered) {
+				t.Errorf("renumbered to %v, want %v", got, tt.renumbered)
+			}
+			for i, p := range tt.positions {
+				if todos[i].Position != p {
+					t.Fatal("placeTodo changed the column it was given")
+				}
+			}
+		})
+	}
+}
+
+// TestMoveOrdering moves todos at random within a column, applying the
+// positions placeTodo gives like a store does, and checks the column
+// sorted by boardOrder is the order the moves asked for.
+func TestMoveOrdering(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	todos := column(0, 0, 0, 0, 0, 0, 0, 0)
+	var want []primitive.ObjectID
+	for _, todo := range todos {
+		want = append(want, todo.ID)
+	}
+
+	for move := 0; move < 500; move++ {
+		slices.SortFunc(todos, boardOrder)
+		from := rng.Intn(len(todos))
+		// Mostly between the same two todos, exhausting the gap.
+		index := 3
+		if move%5 == 0 {
+			index = rng.Intn(len(todos))
+		}
+		moving := todos[from]
+		others := slices.Delete(slices.Clone(todos), from, from+1)
+
+		pos, renumbered := placeTodo(others, index)
+		for _, r := range renumbered {
+			i := slices.IndexFunc(others, func(t todoModel) bool { return t.ID == r.ID })
+			others[i].Position = r.Position
+		}
+		moving.Position = pos
+		todos = append(others, moving)
+
+		want = slices.DeleteFunc(want, func(id primitive.ObjectID) bool { return id == moving.ID })
+		want = slices.Insert(want, min(index, len(want)), moving.ID)
+
+		slices.SortFunc(todos, boardOrder)
+		for i, todo := range todos {
+			if todo.ID != want[i] {
+				t.Fatalf("move %d: todo %d is %s, want %s", move, i, todo.ID.Hex(), want[i].Hex())
+			}
+		}
+	}
+}
+
+func TestColumnOf(t *testing.T) {
+	defer func(c []string) { boardColumns = c }(boardColumns)
+	boardColumns = []string{"todo", "doing", "review", "done"}
+
+	tests := []struct {
+		column    string
+		completed bool
+		want      int
+	}{
+		{"", false, 0},
+		{"todo", false, 0},
+		{"review", false, 2},
+		{"gone", false, 0},
+		{"done", false, 0},
+		{"doing", true, 3},
+	}
+	for _, tt := range tests {
+		if got := columnOf(todoModel{Column: tt.column, Completed: tt.completed}); got != tt.want {
+			t.Errorf("columnOf(%q, completed %v) = %d, want %d", tt.column, tt.completed, got, tt.want)
+		}
+	}
+}
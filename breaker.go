@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Circuit breaker settings, see loadBreakerConfig.
+var (
+	// breakerThreshold is how many store calls in a row must fail before
+	// the breaker opens. Zero disables it.
+	breakerThreshold = 5
+	// breakerCooldown is how long the breaker stays open before letting a
+	// call through to probe the store.
+	breakerCooldown = 10 * time.Second
+)
+
+var breakerTransitions = newCounterVec("todo_store_breaker_transitions_total",
+	"Changes of state of the store circuit breaker, by new state.", "state")
+
+// loadBreakerConfig reads TODO_BREAKER_THRESHOLD, the consecutive store
+// failures that open the circuit breaker, 0 to disable it, and
+// TODO_BREAKER_COOLDOWN, how long it fails calls fast before probing the
+// store again.
+func loadBreakerConfig() error {
+	if v := os.Getenv("TODO_BREAKER_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid TODO_BREAKER_THRESHOLD %q, must be a number of failures", v)
+		}
+		breakerThreshold = n
+	}
+	if v := os.Getenv("TODO_BREAKER_COOLDOWN"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			return fmt.Errorf("invalid TODO_BREAKER_COOLDOWN %q, must be a duration like 10s", v)
+		}
+		breakerCooldown = d
+	}
+	return nil
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// errBreakerOpen is returned without calling the store while the breaker
+// is open. It is transient, so writes are buffered and storeErr answers
+// 503.
+var errBreakerOpen = breakerOpenError{}
+
+type breakerOpenError struct{}
+
+func (breakerOpenError) Error() string   { return "circuit breaker open, database unavailable" }
+func (breakerOpenError) Transient() bool { return true }
+
+// circuitBreaker fails store calls fast once breakerThreshold of them
+// failed in a row. After breakerCooldown it lets a single call through:
+// its success closes the breaker, its failure opens it again.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+var breaker circuitBreaker
+
+// allow reports whether a call may go to the store, and marks it as the
+// probe when the cooldown is over.
+func (b *circuitBreaker) allow(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < breakerCooldown {
+			return errBreakerOpen
+		}
+		b.transition(breakerHalfOpen)
+	case breakerHalfOpen:
+		if b.probing {
+			return errBreakerOpen
+		}
+	default:
+		return nil
+	}
+	b.probing = true
+	return nil
+}
+
+// record counts the outcome of a call made with ctx and allowed through.
+// Only unavailability counts as a failure. Errors of the request say
+// nothing about the store, and neither does a call cut short by its own
+// context, whether the caller went away or its deadline passed: the store
+// may just have been slow for it.
+func (b *circuitBreaker) record(ctx context.Context, err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ended := ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+	failed := isTransient(err) && !ended
+	if b.state == breakerHalfOpen {
+		b.probing = false
+		switch {
+		case failed:
+			b.openedAt = now
+			b.transition(breakerOpen)
+		case !ended:
+			b.failures = 0
+			b.transition(breakerClosed)
+		}
+		return
+	}
+	if ended {
+		return
+	}
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == breakerClosed && b.failures >= breakerThreshold {
+		b.openedAt = now
+		b.transition(breakerOpen)
+	}
+}
+
+func (b *circuitBreaker) transition(to breakerState) {
+	slog.Warn("store circuit breaker changed state", "component", "breaker", "from", b.state, "to", to, "failures", b.failures)
+	b.state = to
+	breakerTransitions.inc(to.String())
+}
+
+// current returns the state of the breaker, open only until its cooldown
+// is over.
+func (b *circuitBreaker) current() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerOpen && time.Since(b.openedAt) >= breakerCooldown {
+		return breakerHalfOpen
+	}
+	return b.state
+}
+
+// retryAfter returns how long until the breaker probes the store again.
+func (b *circuitBreaker) retryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return max(time.Second, breakerCooldown-time.Since(b.openedAt))
+}
+
+// guard calls op, which uses ctx, through the breaker.
+func guard[T any](ctx context.Context, op func() (T, error)) (T, error) {
+	if err := breaker.allow(time.Now()); err != nil {
+		var zero T
+		return zero, err
+	}
+	v, err := op()
+	breaker.record(ctx, err, time.Now())
+	return v, err
+}
+
+func guardErr(ctx context.Context, op func() error) error {
+	_, err := guard(ctx, func() (struct{}, error) { return struct{}{}, op() })
+	return err
+}
+
+// breakerStore calls its store through the breaker, see loadStore.
+type breakerStore struct {
+	todoStore
+}
+
+func (s breakerStore) unwrap() todoStore { return s.todoStore }
+
+func (s breakerStore) List(ctx context.Context) ([]todoModel, error) {
+	return guard(ctx, func() ([]todoModel, error) { return s.todoStore.List(ctx) })
+}
+
+func (s breakerStore) Get(ctx context.Context, id primitive.ObjectID) (todoModel, error) {
+	return guard(ctx, func() (todoModel, error) { return s.todoStore.Get(ctx, id) })
+}
+
+func (s breakerStore) ListArchived(ctx context.Context) ([]todoModel, error) {
+	return guard(ctx, func() ([]todoModel, error) { return s.todoStore.ListArchived(ctx) })
+}
+
+func (s breakerStore) Each(ctx context.Context, q listQuery, fn func(todoModel) error) error {
+	return guardErr(ctx, func() error { return s.todoStore.Each(ctx, q, fn) })
+}
+
+func (s breakerStore) Move(ctx context.Context, id primitive.ObjectID, p boardPlace) ([]primitive.ObjectID, error) {
+	return guard(ctx, func() ([]primitive.ObjectID, error) { return s.todoStore.Move(ctx, id, p) })
+}
+
+func (s breakerStore) Create(ctx context.Context, tm todoModel) error {
+	return guardErr(ctx, func() error { return s.todoStore.Create(ctx, tm) })
+}
+
+func (s breakerStore) CreateMany(ctx context.Context, tms []todoModel) error {
+	return guardErr(ctx, func() error { return s.todoStore.CreateMany(ctx, tms) })
+}
+
+func (s breakerStore) Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error {
+	return guardErr(ctx, func() error { return s.todoStore.Update(ctx, id, fields) })
+}
+
+func (s breakerStore) Upsert(ctx context.Context, tm todoModel) (bool, error) {
+	return guard(ctx, func() (bool, error) { return s.todoStore.Upsert(ctx, tm) })
+}
+
+func (s breakerStore) Delete(ctx context.Context, id primitive.ObjectID) (todoModel, error) {
+	return guard(ctx, func() (todoModel, error) { return s.todoStore.Delete(ctx, id) })
+}
+
+func (s breakerStore) Stats(ctx context.Context, since time.Time, loc *time.Location) (todoStats, error) {
+	return guard(ctx, func() (todoStats, error) { return s.todoStore.Stats(ctx, since, loc) })
+}
+
+func (s breakerStore) AddAttachment(ctx context.Context, id primitive.ObjectID, a attachment) error {
+	return guardErr(ctx, func() error { return s.todoStore.AddAttachment(ctx, id, a) })
+}
+
+func (s breakerStore) Attachment(ctx context.Context, id primitive.ObjectID, aid string) (attachment, error) {
+	return guard(ctx, func() (attachment, error) { return s.todoStore.Attachment(ctx, id, aid) })
+}
+
+func (s breakerStore) RemoveAttachment(ctx context.Context, id primitive.ObjectID, aid string) (attachment, error) {
+	return guard(ctx, func() (attachment, error) { return s.todoStore.RemoveAttachment(ctx, id, aid) })
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// unavailable is a store error the breaker counts as a failure.
+type unavailable struct{}
+
+func (unavailable) Error() string   { return "database unavailable" }
+func (unavailable) Transient() bool { return true }
+
+func TestCircuitBreaker(t *testing.T) {
+	prevThreshold, prevCooldown := breakerThreshold, breakerCooldown
+	breakerThreshold, breakerCooldown = 3, 10*time.Second
+	t.Cleanup(func() { breakerThreshold, breakerCooldown = prevThreshold, prevCooldown })
+
+	var b circuitBreaker
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	call := func(err error) error {
+		t.Helper()
+		if err := b.allow(now); err != nil {
+			return err
+		}
+		b.record(ctx, err, now)
+		return nil
+	}
+	expect := func(want breakerState) {
+		t.Helper()
+		if b.state != want {
+			t.Fatalf("breaker %s, want %s", b.state, want)
+		}
+	}
+
+	// Errors of the request do not count, and a success resets the count.
+	call(unavailable{})
+	call(unavailable{})
+	call(errors.New("duplicate key"))
+	call(unavailable{})
+	call(unavailable{})
+	call(nil)
+	expect(breakerClosed)
+
+	// Neither do calls cut short by their own context, even when the
+	// driver reports it as unavailability, and they leave the count as it
+	// is.
+	expired, cancel := context.WithDeadline(ctx, now)
+	defer cancel()
+	<-expired.Done()
+	call(unavailable{})
+	call(unavailable{})
+	for _, err := range []error{context.DeadlineExceeded, fmt.Errorf("find: %w", context.Canceled)} {
+		call(err)
+	}
+	for range breakerThreshold {
+		b.allow(now)
+		b.record(expired, unavailable{}, now)
+	}
+	expect(breakerClosed)
+	if b.failures != 2 {
+		t.Errorf("%d failures counted, want 2", b.failures)
+	}
+	call(nil)
+
+	for range breakerThreshold {
+		call(unavailable{})
+	}
+	expect(breakerOpen)
+	now = now.Add(breakerCooldown - time.Second)
+	if err := call(nil); !errors.Is(err, errBreakerOpen) || !isTransient(err) {
+		t.Fatalf("call during the cooldown: %v, want the transient errBreakerOpen", err)
+	}
+
+	// Once cooled down, a single probe goes through and its failure opens
+	// the breaker for another cooldown.
+	now = now.Add(time.Second)
+	if err := b.allow(now); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	expect(breakerHalfOpen)
+	if err := b.allow(now); !errors.Is(err, errBreakerOpen) {
+		t.Fatalf("second call while probing: %v, want errBreakerOpen", err)
+	}
+	b.record(ctx, unavailable{}, now)
+	expect(breakerOpen)
+	if err := b.allow(now.Add(breakerCooldown / 2)); !errors.Is(err, errBreakerOpen) {
+		t.Fatalf("call after a failed probe: %v, want errBreakerOpen", err)
+	}
+
+	// A probe whose caller went away or timed out tells nothing, the next
+	// call probes.
+	now = now.Add(breakerCooldown)
+	b.allow(now)
+	b.record(ctx, context.Canceled, now)
+	expect(breakerHalfOpen)
+	b.allow(now)
+	b.record(expired, unavailable{}, now)
+	expect(breakerHalfOpen)
+
+	if err := call(nil); err != nil {
+		t.Fatalf("second probe: %v", err)
+	}
+	expect(breakerClosed)
+	if b.failures != 0 {
+		t.Errorf("%d failures counted after closing", b.failures)
+	}
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// cacheTimeout bounds every cache operation, so a slow Redis costs a
+	// request little more than a miss.
+	cacheTimeout = 250 * time.Millisecond
+	// cacheMaxBody is the largest response cached.
+	cacheMaxBody = 1 << 20
+	cachePrefix  = "todo:cache:"
+	// cacheMaxRefreshes bounds the stale responses refreshed at the same
+	// time. Others are served stale until a refresh is free.
+	cacheMaxRefreshes = 16
+)
+
+// cachedHeaders are the response headers stored with the body.
+var cachedHeaders = []string{"Content-Type", "Content-Disposition", "Content-Encoding", "ETag", "Last-Modified", "Deprecation", "Sunset"}
+
+// Response cache settings, see loadCacheConfig.
+var (
+	// cache is nil when responses are not cached.
+	cache responseCache
+	// cacheTTLs are the TTLs of path prefixes, ordered from the longest.
+	// Zero disables caching under a prefix.
+	cacheTTLs []routeDuration
+	cacheTTL  time.Duration
+	// cacheStale is how long past its TTL a response is still served,
+	// while it is refreshed in the background.
+	cacheStale time.Duration
+
+	cacheRequests = newCounterVec("todo_cache_requests_total",
+		"Cacheable requests by result: hit, stale, miss or bypass.", "result")
+
+	// cacheRefreshing holds the keys being refreshed, so a stale response
+	// is refreshed once however many requests it serves meanwhile.
+	cacheRefreshing sync.Map
+	cacheRefreshes  = make(chan struct{}, cacheMaxRefreshes)
+)
+
+// responseCache stores responses by key. Every tenant has a generation,
+// part of the keys of its responses, bumped by invalidate so they are
+// never read again and left to expire.
+type responseCache interface {
+	get(ctx context.Context, key string) ([]byte, error)
+	set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	generation(ctx context.Context, tenant string) (string, error)
+	invalidate(ctx context.Context, tenant string) error
+}
+
+// errCacheMiss is returned by get for missing and expired keys.
+var errCacheMiss = errors.New("not cached")
+
+// loadCacheConfig reads TODO_CACHE, memory or redis to cache the GET
+// responses of /todo, TODO_CACHE_URL, the redis:// URL, and
+// TODO_CACHE_MAX_ENTRIES, the size of the memory cache. Responses are kept
+// TODO_CACHE_TTL, or the TTL of their path prefix in TODO_CACHE_TTLS such
+// as "/todo/stats=5m,/todo/search=0", then served stale for
+// TODO_CACHE_STALE while they are refreshed.
+func loadCacheConfig() error {
+	cache = nil
+	switch backend := os.Getenv("TODO_CACHE"); backend {
+	case "":
+		return nil
+	case "memory":
+		size := 10000
+		if v := os.Getenv("TODO_CACHE_MAX_ENTRIES"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid TODO_CACHE_MAX_ENTRIES %q, must be a positive number", v)
+			}
+			size = n
+		}
+		cache = &memoryCache{size: size, entries: map[string]memoryEntry{}, gens: map[string]uint64{}}
+	case "redis":
+		raw := os.Getenv("TODO_CACHE_URL")
+		if raw == "" {
+			return errors.New("TODO_CACHE=redis requires TODO_CACHE_URL")
+		}
+		c, err := newRedisClient(raw)
+		if err != nil {
+			return err
+		}
+		cache = redisCache{c}
+	default:
+		return fmt.Errorf("unknown cache %q", backend)
+	}
+
+	cacheTTL = 30 * time.Second
+	if v := os.Getenv("TODO_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			return fmt.Errorf("invalid TODO_CACHE_TTL %q, must be a duration like 30s", v)
+		}
+		cacheTTL = d
+	}
+	cacheStale = 0
+	if v := os.Getenv("TODO_CACHE_STALE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid TODO_CACHE_STALE %q, must be a duration like 1m", v)
+		}
+		cacheStale = d
+	}
+	cacheTTLs = nil
+	for _, entry := range strings.Split(os.Getenv("TODO_CACHE_TTLS"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		prefix, v, ok := strings.Cut(entry, "=")
+		prefix = strings.TrimRight(strings.TrimSpace(prefix), "/")
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if !ok || !strings.HasPrefix(prefix, "/") || err != nil || d < 0 {
+			return fmt.Errorf("invalid cache TTL %q, expected /path=duration", entry)
+		}
+		cacheTTLs = append(cacheTTLs, routeDuration{prefix: prefix, d: d})
+	}
+	slices.SortStableFunc(cacheTTLs, func(a, b routeDuration) int { return len(b.prefix) - len(a.prefix) })
+	return nil
+}
+
+func cacheTTLFor(path string) time.Duration {
+	for _, t := range cacheTTLs {
+		if underPrefix(path, t.prefix) {
+			return t.d
+		}
+	}
+	return cacheTTL
+}
+
+// cachedResponse is what is stored of a response.
+type cachedResponse struct {
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	StoredAt time.Time   `json:"stored_at"`
+}
+
+// cacheMiddleware serves GET responses from the cache, keyed on the
+// tenant, the Authorization header, the path and the query, and stores
+// successful ones. The X-Cache header tells HIT, STALE, MISS or BYPASS,
+// the latter when the client asked for a fresh answer with Cache-Control:
+// no-cache or the cache is unreachable. A response past its TTL but within
+// cacheStale is served STALE and refreshed in the background. Mutations
+// invalidate the responses of their tenant, see cachingStore, which are
+// then never served stale.
+func cacheMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ttl := cacheTTLFor(r.URL.Path)
+		// Streamed answers are not kept, they are as large as the
+		// collection.
+		if cache == nil || r.Method != http.MethodGet || ttl == 0 || wantsNDJSON(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), cacheTimeout)
+		defer cancel()
+		gen, err := cache.generation(ctx, tenantFrom(r.Context()))
+		if err != nil {
+			slog.Warn("cache unavailable", "component", "cache", "error", err)
+			w.Header().Set("X-Cache", "BYPASS")
+			cacheRequests.inc("bypass")
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := cacheKey(r, gen)
+
+		if !strings.Contains(r.Header.Get("Cache-Control"), "no-cache") {
+			if raw, err := cache.get(ctx, key); err == nil {
+				var c cachedResponse
+				if err := json.Unmarshal(raw, &c); err == nil {
+					if time.Since(c.StoredAt) < ttl {
+						cacheRequests.inc("hit")
+						w.Header().Set("X-Cache", "HIT")
+					} else {
+						cacheRequests.inc("stale")
+						w.Header().Set("X-Cache", "STALE")
+						refreshCached(next, r, key, ttl)
+					}
+					for k, v := range c.Header {
+						w.Header()[k] = v
+					}
+					w.Header().Set("Age", strconv.Itoa(int(time.Since(c.StoredAt).Seconds())))
+					w.WriteHeader(c.Status)
+					w.Write(c.Body)
+					return
+				}
+			} else if !errors.Is(err, errCacheMiss) {
+				slog.Warn("cache read failed", "component", "cache", "error", err)
+			}
+			w.Header().Set("X-Cache", "MISS")
+			cacheRequests.inc("miss")
+		} else {
+			w.Header().Set("X-Cache", "BYPASS")
+			cacheRequests.inc("bypass")
+		}
+
+		cw := &cacheWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(cw, r)
+		storeCached(r.Context(), key, cw, ttl)
+	})
+}
+
+// storeCached keeps the response cw wrote under key, if it succeeded and
+// was small enough, for ttl and then cacheStale.
+func storeCached(ctx context.Context, key string, cw *cacheWriter, ttl time.Duration) {
+	if cw.status != http.StatusOK || cw.overflow {
+		return
+	}
+	header := http.Header{}
+	for _, k := range cachedHeaders {
+		if v := cw.Header().Values(k); len(v) > 0 {
+			header[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+	raw, err := json.Marshal(cachedResponse{Status: cw.status, Header: header, Body: cw.body.Bytes(), StoredAt: time.Now()})
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cacheTimeout)
+	defer cancel()
+	if err := cache.set(ctx, key, raw, ttl+cacheStale); err != nil {
+		slog.Warn("cache write failed", "component", "cache", "error", err)
+	}
+}
+
+// refreshCached serves r again with next in the background and keeps the
+// response under key, unless key is being refreshed already or
+// cacheMaxRefreshes are. The refresh runs in a context of its own, see
+// refreshContext, with the deadline of the route.
+func refreshCached(next http.Handler, r *http.Request, key string, ttl time.Duration) {
+	if _, busy := cacheRefreshing.LoadOrStore(key, struct{}{}); busy {
+		return
+	}
+	select {
+	case cacheRefreshes <- struct{}{}:
+	default:
+		cacheRefreshing.Delete(key)
+		return
+	}
+	timeout := timeoutFor(r.URL.Path)
+	if timeout == 0 {
+		timeout = handlerTimeout
+	}
+	ctx, s := refreshContext(r)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	r = r.Clone(ctx)
+	go func() {
+		defer func() {
+			cancel()
+			<-cacheRefreshes
+			cacheRefreshing.Delete(key)
+			if v := recover(); v != nil {
+				slog.Error("cache refresh panicked", "component", "cache", "request_id", requestID(ctx), "path", r.URL.Path, "panic", v)
+			}
+			if s != nil {
+				s.finish()
+			}
+		}()
+		cw := &cacheWriter{ResponseWriter: &discardWriter{header: http.Header{}}, status: http.StatusOK}
+		next.ServeHTTP(cw, r)
+		if cw.status != http.StatusOK {
+			slog.Warn("cache refresh failed", "component", "cache", "request_id", requestID(ctx), "path", r.URL.Path, "status", cw.status)
+		}
+		storeCached(ctx, key, cw, ttl)
+	}()
+}
+
+// refreshContext returns the context a refresh of r runs in: the tenant
+// and route parameters of r, with a request ID and a trace of its own,
+// whose span is nil without tracing. The access log line and span of r
+// are left behind, r being answered and logged by the time the refresh
+// runs.
+func refreshContext(r *http.Request) (context.Context, *span) {
+	ctx := context.Background()
+	if tenant := tenantFrom(r.Context()); tenant != "" {
+		ctx = withTenant(ctx, tenant)
+	}
+	ctx = context.WithValue(ctx, middleware.RequestIDKey, requestID(r.Context())+"/refresh")
+	// chi reuses the route context of r once r is done.
+	rctx := chi.NewRouteContext()
+	if orig := chi.RouteContext(r.Context()); orig != nil {
+		rctx.RoutePatterns = slices.Clone(orig.RoutePatterns)
+		rctx.URLParams.Keys = slices.Clone(orig.URLParams.Keys)
+		rctx.URLParams.Values = slices.Clone(orig.URLParams.Values)
+	}
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	if !tracingEnabled() {
+		return ctx, nil
+	}
+	ctx, s := startSpan(ctx, "cache refresh", spanKindInternal)
+	s.set("url.path", r.URL.Path)
+	return ctx, s
+}
+
+// discardWriter is the ResponseWriter of background refreshes, which only
+// the cache reads.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+func cacheKey(r *http.Request, gen string) string {
+	h := sha256.New()
+	for _, part := range []string{tenantFrom(r.Context()), gen, r.Header.Get("Authorization"), r.URL.Path, r.URL.Query().Encode()} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return cachePrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// cacheWriter keeps a copy of the body written, up to cacheMaxBody.
+type cacheWriter struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+	wrote    bool
+}
+
+func (w *cacheWriter) WriteHeader(status int) {
+	if !w.wrote {
+		w.status, w.wrote = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	if !w.overflow {
+		if w.body.Len()+len(b) > cacheMaxBody {
+			w.overflow = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// invalidateCache drops the cached responses and stats of tenant.
+func invalidateCache(ctx context.Context, tenant string) {
+	forgetStats(tenant)
+	if cache == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cacheTimeout)
+	defer cancel()
+	if err := cache.invalidate(ctx, tenant); err != nil {
+		slog.Warn("cache invalidation failed, responses stay cached until they expire", "component", "cache", "tenant", tenant, "error", err)
+	}
+}
+
+// memoryCache is a responseCache local to the process, so with several
+// instances a mutation only invalidates the responses of the one that
+// made it. When full, expired entries are dropped, then arbitrary ones.
+type memoryCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	gens    map[string]uint64
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+func (c *memoryCache) get(_ context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, errCacheMiss
+	}
+	return e.value, nil
+}
+
+func (c *memoryCache) set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= c.size {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	for k := range c.entries {
+		if len(c.entries) < c.size {
+			break
+		}
+		delete(c.entries, k)
+	}
+	c.entries[key] = memoryEntry{value: value, expires: now.Add(ttl)}
+	return nil
+}
+
+func (c *memoryCache) generation(_ context.Context, tenant string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return strconv.FormatUint(c.gens[tenant], 10), nil
+}
+
+func (c *memoryCache) invalidate(_ context.Context, tenant string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gens[tenant]++
+	return nil
+}
+
+// redisCache is a responseCache shared by the instances using the same
+// Redis, generations included, so mutations invalidate their responses
+// everywhere.
+type redisCache struct {
+	c *redisClient
+}
+
+func (c redisCache) get(ctx context.Context, key string) ([]byte, error) {
+	v, err := c.c.do(ctx, "GET", key)
+	if errors.Is(err, errRedisNil) {
+		return nil, errCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	s, _ := v.(string)
+	return []byte(s), nil
+}
+
+func (c redisCache) set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := c.c.do(ctx, "SET", key, string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+func (c redisCache) generation(ctx context.Context, tenant string) (string, error) {
+	v, err := c.c.do(ctx, "GET", cachePrefix+"gen:"+tenant)
+	if errors.Is(err, errRedisNil) {
+		return "0", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	s, _ := v.(string)
+	return s, nil
+}
+
+func (c redisCache) invalidate(ctx context.Context, tenant string) error {
+	_, err := c.c.do(ctx, "INCR", cachePrefix+"gen:"+tenant)
+	return err
+}
+
+// cachingStore invalidates the cached responses and stats of the tenant of
+// every mutation made through its store, whether it succeeded or not, as a
+// failed call may still have written.
+type cachingStore struct {
+	todoStore
+}
+
+func (s cachingStore) unwrap() todoStore { return s.todoStore }
+
+func (s cachingStore) Create(ctx context.Context, tm todoModel) error {
+	defer invalidateCache(ctx, tenantFrom(ctx))
+	return s.todoStore.Create(ctx, tm)
+}
+
+func (s cachingStore) CreateMany(ctx context.Context, tms []todoModel) error {
+	defer invalidateCache(ctx, tenantFrom(ctx))
+	return s.todoStore.CreateMany(ctx, tms)
+}
+
+func (s cachingStore) Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error {
+	defer invalidateCache(ctx, tenantFrom(ctx))
+	return s.todoStore.Update(ctx, id, fields)
+}
+
+func (s cachingStore) Move(ctx context.Context, id primitive.ObjectID, p boardPlace) ([]primitive.ObjectID, error) {
+	defer invalidateCache(ctx, tenantFrom(ctx))
+	return s.todoStore.Move(ctx, id, p)
+}
+
+func (s cachingStore) Upsert(ctx context.Context, tm todoModel) (bool, error) {
+	defer invalidateCache(ctx, tenantFrom(ctx))
+	return s.todoStore.Upsert(ctx, tm)
+}
+
+func (s cachingStore) Delete(ctx context.Context, id primitive.ObjectID) (todoModel, error) {
+	defer invalidateCache(ctx, tenantFrom(ctx))
+	return s.todoStore.Delete(ctx, id)
+}
+
+func (s cachingStore) AddAttachment(ctx context.Context, id primitive.ObjectID, a attachment) error {
+	defer invalidateCache(ctx, tenantFrom(ctx))
+	return s.todoStore.AddAttachment(ctx, id, a)
+}
+
+func (s cachingStore) RemoveAttachment(ctx context.Context, id primitive.ObjectID, aid string) (attachment, error) {
+	defer invalidateCache(ctx, tenantFrom(ctx))
+	return s.todoStore.RemoveAttachment(ctx, id, aid)
+}
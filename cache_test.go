@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+func TestCacheKey(t *testing.T) {
+	base := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/todo?status=open&list=work", nil)
+		return r.WithContext(withTenant(r.Context(), "acme"))
+	}
+	want := cacheKey(base(), "1")
+
+	tests := []struct {
+		name   string
+		change func(r *http.Request) *http.Request
+		gen    string
+		same   bool
+	}{
+		{"same request", func(r *http.Request) *http.Request { return r }, "1", true},
+		{"query order", func(r *http.Request) *http.Request {
+			r.URL.RawQuery = "list=work&status=open"
+			return r
+		}, "1", true},
+		{"other generation", func(r *http.Request) *http.Request { return r }, "2", false},
+		{"other tenant", func(r *http.Request) *http.Request {
+			return r.WithContext(withTenant(r.Context(), "other"))
+		}, "1", false},
+		{"other credentials", func(r *http.Request) *http.Request {
+			r.Header.Set("Authorization", "Bearer x")
+			return r
+		}, "1", false},
+		{"other filter", func(r *http.Request) *http.Request {
+			r.URL.RawQuery = "status=done&list=work"
+			return r
+		}, "1", false},
+		{"other path", func(r *http.Request) *http.Request {
+			r.URL.Path = "/todo/stats"
+			return r
+		}, "1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cacheKey(tt.change(base()), tt.gen)
+			if (got == want) != tt.same {
+				t.Errorf("key equal to the base key: %v, want %v", got == want, tt.same)
+			}
+		})
+	}
+}
+
+// useMemoryCache caches responses in memory for ttl, then serves them
+// stale for stale, until the test ends.
+func useMemoryCache(t *testing.T, ttl, stale time.Duration) {
+	prevCache, prevTTL, prevTTLs, prevStale := cache, cacheTTL, cacheTTLs, cacheStale
+	cache = &memoryCache{size: 100, entries: map[string]memoryEntry{}, gens: map[string]uint64{}}
+	cacheTTL, cacheTTLs, cacheStale = ttl, nil, stale
+	t.Cleanup(func() {
+		// Wait for the refreshes still running, which read the cache,
+		// by taking every slot.
+		for range cacheMaxRefreshes {
+			cacheRefreshes <- struct{}{}
+		}
+		for range cacheMaxRefreshes {
+			<-cacheRefreshes
+		}
+		cache, cacheTTL, cacheTTLs, cacheStale = prevCache, prevTTL, prevTTLs, prevStale
+	})
+}
+
+// TestStaleRefresh serves a stale response, which must be refreshed in the
+// background without touching the finished request. Run it with -race.
+func TestStaleRefresh(t *testing.T) {
+	useMemoryCache(t, 50*time.Millisecond, time.Minute)
+	apply, err := loadAccessLogSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply()
+
+	var calls atomic.Int32
+	refreshed := make(chan string, 10)
+	r := chi.NewRouter()
+	r.Use(requestIDMiddleware)
+	r.Use(requestLogger)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(withTenant(r.Context(), "acme")))
+		})
+	})
+	r.With(cacheMiddleware).Get("/todo/{id}", func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		noteTenant(r.Context(), tenantFrom(r.Context()))
+		noteError(r.Context(), "test", errors.New("noted"))
+		if n > 1 {
+			refreshed <- tenantFrom(r.Context()) + " " + chi.URLParam(r, "id") + " " + requestID(r.Context())
+		}
+		w.Write([]byte{'0' + byte(n)})
+	})
+
+	get := func() (string, string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/todo/42", nil))
+		return rec.Header().Get("X-Cache"), rec.Body.String()
+	}
+
+	if x, body := get(); x != "MISS" || body != "1" {
+		t.Fatalf("first request: %s %q, want MISS \"1\"", x, body)
+	}
+	if x, body := get(); x != "HIT" || body != "1" {
+		t.Fatalf("second request: %s %q, want HIT \"1\"", x, body)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if x, body := get(); x != "STALE" || body != "1" {
+		t.Fatalf("expired request: %s %q, want STALE \"1\"", x, body)
+	}
+
+	select {
+	case got := <-refreshed:
+		if want := "acme 42 "; len(got) <= len(want) || got[:len(want)] != want {
+			t.Errorf("refresh ran with %q, want tenant acme, id 42 and a request ID", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stale response was not refreshed")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		x, body := get()
+		if x == "HIT" && body == "2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("after the refresh: %s %q, want HIT \"2\"", x, body)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("handler ran %d times, want 2", n)
+	}
+}
+
+func TestStaleRefreshOnce(t *testing.T) {
+	useMemoryCache(t, time.Millisecond, time.Minute)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var calls atomic.Int32
+	h := cacheMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) > 1 {
+			close(started)
+			<-release
+		}
+		w.Write([]byte("ok"))
+	}))
+	get := func() string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/todo", nil))
+		return rec.Header().Get("X-Cache")
+	}
+
+	get()
+	time.Sleep(5 * time.Millisecond)
+	for i := 0; i < 20; i++ {
+		if x := get(); x != "STALE" {
+			t.Fatalf("request %d: %s, want STALE", i, x)
+		}
+	}
+	<-started
+	close(release)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("handler ran %d times, want 1 then 1 refresh", n)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/qasim-invodev/todo/api"
+)
+
+// client calls the todo HTTP API.
+type client struct {
+	cfg  config
+	http *http.Client
+}
+
+func newClient(cfg config) *client {
+	return &client{cfg: cfg, http: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// do sends body as JSON and decodes the answer into out. Answers other
+// than 2xx become errors carrying the message of the server.
+func (c *client) do(ctx context.Context, method, path string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.cfg.Server, "/")+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+	if c.cfg.Tenant != "" {
+		req.Header.Set("X-Tenant-ID", c.cfg.Tenant)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var m api.Message
+		if json.Unmarshal(data, &m) == nil && m.Message != "" {
+			msg := m.Message
+			if m.Error != "" {
+				msg += ": " + m.Error
+			}
+			if m.RequestID != "" {
+				msg += " (request " + m.RequestID + ")"
+			}
+			return errors.New(msg)
+		}
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (c *client) list(ctx context.Context, archived bool) ([]api.Todo, error) {
+	path := "/todo"
+	if archived {
+		path += "?archive=true"
+	}
+	var l api.TodoList
+	if err := c.do(ctx, http.MethodGet, path, nil, &l); err != nil {
+		return nil, err
+	}
+	return l.Data, nil
+}
+
+// resolve finds the todo id is the ID or the unique ID prefix of.
+func (c *client) resolve(ctx context.Context, id string) (api.Todo, error) {
+	todos, err := c.list(ctx, false)
+	if err != nil {
+		return api.Todo{}, err
+	}
+	var found []api.Todo
+	for _, t := range todos {
+		if t.ID == id {
+			return t, nil
+		}
+		if strings.HasPrefix(t.ID, id) {
+			found = append(found, t)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return api.Todo{}, fmt.Errorf("no todo %s", id)
+	case 1:
+		return found[0], nil
+	}
+	return api.Todo{}, fmt.Errorf("%s matches %d todos, use a longer prefix", id, len(found))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultServer = "http://localhost:9000"
+
+// config is saved by `todocli config set` and overridden by the
+// TODOCLI_* environment variables and the global flags, in that order.
+type config struct {
+	Server string `json:"server"`
+	Token  string `json:"token,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+func configPath() (string, error) {
+	if p := os.Getenv("TODOCLI_CONFIG"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "todocli", "config.json"), nil
+}
+
+func loadConfig() (config, error) {
+	c := config{Server: defaultServer}
+	path, err := configPath()
+	if err != nil {
+		return c, err
+	}
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return c, err
+	default:
+		if err := json.Unmarshal(b, &c); err != nil {
+			return c, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if v := os.Getenv("TODOCLI_SERVER"); v != "" {
+		c.Server = v
+	}
+	if v := os.Getenv("TODOCLI_TOKEN"); v != "" {
+		c.Token = v
+	}
+	if v := os.Getenv("TODOCLI_TENANT"); v != "" {
+		c.Tenant = v
+	}
+	return c, nil
+}
+
+// set changes one key of the saved configuration.
+func (c *config) set(key, value string) error {
+	switch key {
+	case "server":
+		c.Server = strings.TrimSuffix(value, "/")
+	case "token":
+		c.Token = value
+	case "tenant":
+		c.Tenant = value
+	default:
+		return fmt.Errorf("unknown key %q, expected server, token or tenant", key)
+	}
+	return nil
+}
+
+func saveConfig(c config) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	b, _ := json.MarshalIndent(c, "", "  ")
+	// The token is a credential.
+	return os.WriteFile(path, append(b, '\n'), 0o600)
+}
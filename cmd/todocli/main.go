@@ -0,0 +1,359 @@
+// Command todocli manages todos through the todo HTTP API:
+//
+//	todocli add "Buy milk" --list groceries --due 2024-06-01
+//	todocli list --filter status=open --filter label=home
+//	todocli done 65f1c2
+//	todocli rm 65f1c2
+//
+// The server, token and tenant are read from the file written by
+// `todocli config set`, the TODOCLI_SERVER, TODOCLI_TOKEN and
+// TODOCLI_TENANT environment variables and the global flags, later ones
+// taking precedence.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/qasim-invodev/todo/api"
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := rootCmd().ExecuteContext(ctx); err != nil {
+		os.Exit(1)
+	}
+}
+
+func rootCmd() *cobra.Command {
+	var (
+		cfg                   config
+		server, token, tenant string
+		output                string
+	)
+	root := &cobra.Command{
+		Use:          "todocli",
+		Short:        "Manage todos from the command line",
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if cfg, err = loadConfig(); err != nil {
+				return err
+			}
+			if server != "" {
+				cfg.Server = server
+			}
+			if token != "" {
+				cfg.Token = token
+			}
+			if tenant != "" {
+				cfg.Tenant = tenant
+			}
+			if output != outputTable && output != outputJSON {
+				return fmt.Errorf("--output must be %s or %s", outputTable, outputJSON)
+			}
+			return nil
+		},
+	}
+	root.PersistentFlags().StringVar(&server, "server", "", "`URL` of the todo server (default "+defaultServer+")")
+	root.PersistentFlags().StringVar(&token, "token", "", "bearer `token` sent to the server")
+	root.PersistentFlags().StringVar(&tenant, "tenant", "", "`tenant` sent as X-Tenant-ID")
+	root.PersistentFlags().StringVarP(&output, "output", "o", outputTable, "output `format`: table or json")
+
+	out := func() string { return output }
+	c := func() *client { return newClient(cfg) }
+	root.AddCommand(addCmd(c, out), listCmd(c, out), doneCmd(c, out), rmCmd(c, out), tuiCmd(c), configCmd(&cfg))
+	return root
+}
+
+func addCmd(c func() *client, output func() string) *cobra.Command {
+	var (
+		t      api.Todo
+		due    string
+		labels []string
+	)
+	cmd := &cobra.Command{
+		Use:   "add <title>",
+		Short: "Create a todo",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			t.Title = strings.Join(args, " ")
+			t.Labels = labels
+			if due != "" {
+				d, err := parseDue(due)
+				if err != nil {
+					return err
+				}
+				t.DueAt = &d
+			}
+			var m api.Message
+			if err := c().do(cmd.Context(), http.MethodPost, "/todo", t, &m); err != nil {
+				return err
+			}
+			if output() == outputJSON {
+				return printJSON(m)
+			}
+			fmt.Println(m.TodoID)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&t.Description, "description", "d", "", "description of the todo")
+	cmd.Flags().StringVarP(&t.List, "list", "l", "", "`list` the todo belongs to")
+	cmd.Flags().StringSliceVar(&labels, "label", nil, "`label` of the todo, repeatable")
+	cmd.Flags().IntVarP(&t.Priority, "priority", "p", 0, "priority from 1 (highest) to 4")
+	cmd.Flags().StringVar(&due, "due", "", "due `date`, YYYY-MM-DD or RFC 3339")
+	return cmd
+}
+
+// parseDue reads a due date. A bare date is due at the end of that day in
+// local time.
+func parseDue(s string) (time.Time, error) {
+	if d, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return d.Add(24*time.Hour - time.Second), nil
+	}
+	d, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid due date %q, expected YYYY-MM-DD or RFC 3339", s)
+	}
+	return d, nil
+}
+
+func listCmd(c func() *client, output func() string) *cobra.Command {
+	var (
+		filters  []string
+		archived bool
+	)
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List todos",
+		Long: `List todos, oldest first.
+
+Filters are key=value pairs and all must match:
+
+  status=open|done   completion
+  list=NAME          the list
+  label=NAME         one of the labels
+  priority=N         the priority
+  q=TEXT             text contained in the title, ignoring case`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			match, err := parseFilters(filters)
+			if err != nil {
+				return err
+			}
+			todos, err := c().list(cmd.Context(), archived)
+			if err != nil {
+				return err
+			}
+			shown := []api.Todo{}
+			for _, t := range todos {
+				if match(t) {
+					shown = append(shown, t)
+				}
+			}
+			slices.SortStableFunc(shown, func(a, b api.Todo) int { return a.CreatedAt.Compare(b.CreatedAt) })
+			if output() == outputJSON {
+				return printJSON(shown)
+			}
+			printTable(shown)
+			return nil
+		},
+	}
+	cmd.Flags().StringArrayVarP(&filters, "filter", "f", nil, "`key=value` filter, repeatable")
+	cmd.Flags().BoolVar(&archived, "archived", false, "list archived todos instead")
+	return cmd
+}
+
+// parseFilters turns --filter flags into a predicate matching all of them.
+func parseFilters(filters []string) (func(api.Todo) bool, error) {
+	var preds []func(api.Todo) bool
+	for _, f := range filters {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid filter %q, expected key=value", f)
+		}
+		switch key {
+		case "status":
+			if value != "open" && value != "done" {
+				return nil, fmt.Errorf("status must be open or done")
+			}
+			want := strconv.FormatBool(value == "done")
+			preds = append(preds, func(t api.Todo) bool { return t.Completed == want })
+		case "list":
+			preds = append(preds, func(t api.Todo) bool { return t.List == value })
+		case "label":
+			preds = append(preds, func(t api.Todo) bool { return slices.Contains(t.Labels, value) })
+		case "priority":
+			p, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("priority must be a number")
+			}
+			preds = append(preds, func(t api.Todo) bool { return t.Priority == p })
+		case "q":
+			q := strings.ToLower(value)
+			preds = append(preds, func(t api.Todo) bool { return strings.Contains(strings.ToLower(t.Title), q) })
+		default:
+			return nil, fmt.Errorf("unknown filter %q", key)
+		}
+	}
+	return func(t api.Todo) bool {
+		for _, p := range preds {
+			if !p(t) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func printTable(todos []api.Todo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tDONE\tTITLE\tLIST\tLABELS\tPRIORITY\tDUE")
+	for _, t := range todos {
+		done := ""
+		if t.Completed == "true" {
+			done = "x"
+		}
+		priority, due := "", ""
+		if t.Priority > 0 {
+			priority = strconv.Itoa(t.Priority)
+		}
+		if t.DueAt != nil {
+			due = t.DueAt.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", shortID(t.ID), done, t.Title, t.List, strings.Join(t.Labels, ","), priority, due)
+	}
+	w.Flush()
+}
+
+// shortID is the ID prefix shown in tables, usually enough to tell todos
+// apart in done and rm.
+func shortID(id string) string {
+	return id[:min(8, len(id))]
+}
+
+func doneCmd(c func() *client, output func() string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "done <id>...",
+		Short: "Complete todos by ID or unique ID prefix",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cl := c()
+			var results []api.Message
+			for _, id := range args {
+				t, err := cl.resolve(cmd.Context(), id)
+				if err != nil {
+					return err
+				}
+				var m api.Message
+				body := api.Todo{Title: t.Title, Completed: "true"}
+				if err := cl.do(cmd.Context(), http.MethodPut, "/todo/"+t.ID, body, &m); err != nil {
+					return fmt.Errorf("%s: %w", t.ID, err)
+				}
+				results = append(results, m)
+				if output() == outputTable {
+					fmt.Printf("done %s %s\n", shortID(t.ID), t.Title)
+				}
+			}
+			if output() == outputJSON {
+				return printJSON(results)
+			}
+			return nil
+		},
+	}
+}
+
+func rmCmd(c func() *client, output func() string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "rm <id>...",
+		Short: "Delete todos by ID or unique ID prefix",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cl := c()
+			var results []api.Message
+			for _, id := range args {
+				t, err := cl.resolve(cmd.Context(), id)
+				if err != nil {
+					return err
+				}
+				var m api.Message
+				if err := cl.do(cmd.Context(), http.MethodDelete, "/todo/"+t.ID, nil, &m); err != nil {
+					return fmt.Errorf("%s: %w", t.ID, err)
+				}
+				results = append(results, m)
+				if output() == outputTable {
+					fmt.Printf("removed %s %s\n", shortID(t.ID), t.Title)
+				}
+			}
+			if output() == outputJSON {
+				return printJSON(results)
+			}
+			return nil
+		},
+	}
+}
+
+func configCmd(cfg *config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Show or change the saved configuration",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "show",
+		Short: "Show the effective configuration",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			shown := *cfg
+			if shown.Token != "" {
+				shown.Token = "(set)"
+			}
+			return printJSON(shown)
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "set <server|token|tenant> <value>",
+		Short: "Save a configuration value",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Only the saved file is changed, not environment or flag
+			// overrides.
+			path, err := configPath()
+			if err != nil {
+				return err
+			}
+			saved := config{Server: defaultServer}
+			if b, err := os.ReadFile(path); err == nil {
+				if err := json.Unmarshal(b, &saved); err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+			}
+			if err := saved.set(args[0], args[1]); err != nil {
+				return err
+			}
+			return saveConfig(saved)
+		},
+	})
+	return cmd
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
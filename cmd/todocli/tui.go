@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/qasim-invodev/todo/api"
+	"github.com/qasim-invodev/todo/tui"
+	"github.com/spf13/cobra"
+)
+
+func tuiCmd(c func() *client) *cobra.Command {
+	return &cobra.Command{
+		Use:   "tui",
+		Short: "Manage todos in an interactive terminal UI",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return tui.Run(cmd.Context(), apiStore{c()})
+		},
+	}
+}
+
+// apiStore runs the terminal UI through the API.
+type apiStore struct {
+	c *client
+}
+
+func (s apiStore) List(ctx context.Context) ([]api.Todo, error) {
+	return s.c.list(ctx, false)
+}
+
+func (s apiStore) Add(ctx context.Context, title, list string) error {
+	return s.c.do(ctx, http.MethodPost, "/todo", api.Todo{Title: title, List: list}, nil)
+}
+
+func (s apiStore) SetCompleted(ctx context.Context, t api.Todo, completed bool) error {
+	body := api.Todo{Title: t.Title, Completed: strconv.FormatBool(completed)}
+	return s.c.do(ctx, http.MethodPut, "/todo/"+t.ID, body, nil)
+}
+
+func (s apiStore) Delete(ctx context.Context, id string) error {
+	return s.c.do(ctx, http.MethodDelete, "/todo/"+id, nil, nil)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The configuration is read from TODO_* environment variables. Flags and
+// a config file feed the same variables, with flags first, then the
+// environment, then the file, then the defaults of each loader:
+//
+//   - config flags set their variable while the flags are parsed,
+//   - the file only sets variables that are still unset.
+
+// configFlags registers the flags overriding configuration variables on
+// fs and returns the path of the config file flag.
+func configFlags(fs *flag.FlagSet) *string {
+	for _, f := range []struct{ name, env, usage string }{
+		{"addr", "TODO_ADDR", "`address` to listen on"},
+		{"log-level", "TODO_LOG_LEVEL", "log `level`: debug, info, warn or error"},
+		{"log-format", "TODO_LOG_FORMAT", "log `format`: json or text"},
+		{"store", "TODO_STORE", "store `backend`: mongo or dynamodb"},
+		{"mongo-uri", "TODO_MONGO_URI", "MongoDB connection `uri`, may include credentials and TLS options"},
+		{"mongo-database", "TODO_MONGO_DATABASE", "MongoDB database `name`"},
+		{"mongo-collection", "TODO_MONGO_COLLECTION", "MongoDB collection `name` for todos"},
+	} {
+		env := f.env
+		fs.Func(f.name, f.usage+", overrides "+env, func(v string) error {
+			return os.Setenv(env, v)
+		})
+	}
+	fs.BoolFunc("dev", "reload templates and static files when they change, sets TODO_DEV", func(v string) error {
+		return os.Setenv("TODO_DEV", v)
+	})
+	return fs.String("config", os.Getenv("TODO_CONFIG"), "YAML or TOML config `file`, defaults to TODO_CONFIG")
+}
+
+// The config file applied last and the variables it set, so a reload
+// can replace them without touching those set by flags or environment.
+var (
+	configPath string
+	fileVars   map[string]string
+)
+
+// applyConfigFile sets the variables of the config file at path that are
+// not set already. An empty path does nothing.
+func applyConfigFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	vars, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	return setFileVars(path, vars)
+}
+
+func setFileVars(path string, vars map[string]string) error {
+	configPath, fileVars = path, map[string]string{}
+	for k, v := range vars {
+		if _, set := os.LookupEnv(k); !set {
+			if err := os.Setenv(k, v); err != nil {
+				return err
+			}
+			fileVars[k] = v
+		}
+	}
+	return nil
+}
+
+// reapplyConfigFile reads the config file applied at startup again. The
+// variables it set before are replaced, those removed from the file go
+// back to their default.
+func reapplyConfigFile() error {
+	if configPath == "" {
+		return nil
+	}
+	vars, err := readConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+	for k, v := range fileVars {
+		if os.Getenv(k) == v {
+			os.Unsetenv(k)
+		}
+	}
+	return setFileVars(configPath, vars)
+}
+
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var vars map[string]string
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		vars, err = parseYAMLConfig(data)
+	case ".toml":
+		vars, err = parseTOMLConfig(data)
+	default:
+		return nil, fmt.Errorf("%s: config files must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return vars, nil
+}
+
+// configVar maps a key of the config file, in a section or not, to its
+// variable: mongo.uri and mongo_uri both become TODO_MONGO_URI.
+func configVar(section, key string) (string, error) {
+	if section != "" {
+		key = section + "_" + key
+	}
+	name := strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+	for _, r := range name {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '_' {
+			return "", fmt.Errorf("invalid key %q", key)
+		}
+	}
+	if !strings.HasPrefix(name, "TODO_") {
+		name = "TODO_" + name
+	}
+	return name, nil
+}
+
+// configValue reads a scalar, unquoting double and single quoted strings
+// and dropping the comment after unquoted ones.
+func configValue(v string) (string, error) {
+	v = strings.TrimSpace(v)
+	switch {
+	case strings.HasPrefix(v, `"`):
+		end := strings.LastIndex(v, `"`)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", v)
+		}
+		return strconv.Unquote(v[:end+1])
+	case strings.HasPrefix(v, "'"):
+		end := strings.LastIndex(v, "'")
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", v)
+		}
+		return strings.ReplaceAll(v[1:end], "''", "'"), nil
+	}
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v), nil
+}
+
+// parseYAMLConfig reads the YAML subset config files use: scalars by key,
+// optionally one level deep under a section key.
+func parseYAMLConfig(data []byte) (map[string]string, error) {
+	vars := map[string]string{}
+	section := ""
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		indented := line[0] == ' ' || line[0] == '\t'
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", n)
+		}
+		key = strings.TrimSpace(key)
+		if !indented {
+			section = ""
+			if strings.TrimSpace(value) == "" {
+				section = key
+				continue
+			}
+		} else if section == "" {
+			return nil, fmt.Errorf("line %d: unexpected indentation", n)
+		}
+		name, err := configVar(section, key)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if vars[name], err = configValue(value); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return vars, sc.Err()
+}
+
+// parseTOMLConfig reads the TOML subset config files use: key = value
+// pairs, optionally under [section] tables.
+func parseTOMLConfig(data []byte) (map[string]string, error) {
+	vars := map[string]string{}
+	section := ""
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			end := strings.Index(line, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated table", n)
+			}
+			section = strings.TrimSpace(line[1:end])
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		name, err := configVar(section, strings.Trim(strings.TrimSpace(key), `"`))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if vars[name], err = configValue(value); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return vars, sc.Err()
+}
+
+// validateConfig serves `config validate`: it loads the configuration
+// like the server does, without opening the write buffer or connecting to
+// MongoDB, and reports the first problem.
+func validateConfig(args []string) int {
+	fs := flag.NewFlagSet("config validate", flag.ExitOnError)
+	configFile := configFlags(fs)
+	fs.Parse(args)
+
+	err := applyConfigFile(*configFile)
+	if err == nil {
+		err = loadConfig()
+	}
+	if err == nil && usingMongo() {
+		if _, merr := mongoClientOptions(); merr != nil {
+			err = fmt.Errorf("invalid MongoDB configuration: %w", merr)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println("configuration is valid")
+	return 0
+}
+
+// errUsage is returned for unknown subcommands.
+var errUsage = errors.New("usage: todo config validate [-config file] [flags]")
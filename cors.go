@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// corsSettings are read by loadCORSSettings and can be reloaded, see
+// reloadConfig.
+type corsSettings struct {
+	origins     []string
+	methods     string
+	headers     string
+	credentials bool
+	maxAge      time.Duration
+}
+
+var cors atomic.Pointer[corsSettings]
+
+// corsExposedHeaders are the response headers browsers let scripts read.
+const corsExposedHeaders = "X-Request-Id, Retry-After, Deprecation, Sunset"
+
+// loadCORSSettings reads TODO_CORS_ORIGINS, the origins allowed to call
+// the API from a browser: exact origins, * for any, or
+// https://*.example.com for the subdomains of a domain.
+// TODO_CORS_METHODS, TODO_CORS_HEADERS, TODO_CORS_CREDENTIALS and
+// TODO_CORS_MAX_AGE tune what they may send and how long preflights are
+// cached.
+func loadCORSSettings() (func(), error) {
+	c := &corsSettings{}
+	for _, o := range strings.Split(os.Getenv("TODO_CORS_ORIGINS"), ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			if o != "*" && !strings.Contains(o, "://") {
+				return nil, fmt.Errorf("invalid origin %q, must include the scheme", o)
+			}
+			c.origins = append(c.origins, strings.ToLower(o))
+		}
+	}
+	c.methods = listHeader(envOr("TODO_CORS_METHODS", "GET, POST, PUT, PATCH, DELETE"))
+	c.headers = listHeader(envOr("TODO_CORS_HEADERS", "Content-Type, Authorization, X-Request-Id, "+tenantHeader))
+
+	if v := os.Getenv("TODO_CORS_CREDENTIALS"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TODO_CORS_CREDENTIALS %q", v)
+		}
+		c.credentials = b
+	}
+	if c.credentials && slices.Contains(c.origins, "*") {
+		return nil, errors.New("TODO_CORS_CREDENTIALS cannot be used with any origin, list them instead")
+	}
+
+	c.maxAge = 10 * time.Minute
+	if v := os.Getenv("TODO_CORS_MAX_AGE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid TODO_CORS_MAX_AGE %q, must be a duration like 10m", v)
+		}
+		c.maxAge = d
+	}
+	return func() { cors.Store(c) }, nil
+}
+
+// listHeader normalizes a comma-separated list for a header value.
+func listHeader(v string) string {
+	var items []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	return strings.Join(items, ", ")
+}
+
+func (c *corsSettings) allowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, o := range c.origins {
+		if o == "*" || o == origin {
+			return true
+		}
+		if scheme, host, ok := strings.Cut(o, "://*."); ok {
+			if rest, ok := strings.CutPrefix(origin, scheme+"://"); ok && strings.HasSuffix(rest, "."+host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// corsMiddleware adds the CORS headers for allowed origins and answers
+// their preflight requests. Requests from other origins get no headers,
+// so browsers block them.
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := cors.Load()
+		if c == nil || len(c.origins) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		origin := r.Header.Get("Origin")
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if origin == "" || !c.allowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if slices.Equal(c.origins, []string{"*"}) {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if c.credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		h.Set("Access-Control-Allow-Methods", c.methods)
+		h.Set("Access-Control-Allow-Headers", c.headers)
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.maxAge.Seconds())))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
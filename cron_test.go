@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, spec := range []string{"* * * * *", "0 9 * * 1-5", "*/15 0-6/2 1,15 * 7", "5/20 * * 1-12 0"} {
+		if _, err := parseCron(spec); err != nil {
+			t.Errorf("parseCron(%q): %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "1,,2 * * * *", "@daily"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q) was accepted", spec)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	tests := []struct {
+		spec string
+		from string
+		want string
+	}{
+		{"* * * * *", "2024-05-01 09:00", "2024-05-01 09:01"},
+		{"*/15 * * * *", "2024-05-01 09:14", "2024-05-01 09:15"},
+		{"*/15 * * * *", "2024-05-01 09:50", "2024-05-01 10:00"},
+		{"0 9 * * *", "2024-05-01 09:00", "2024-05-02 09:00"},
+		{"30 23 31 * *", "2024-04-15 00:00", "2024-05-31 23:30"},
+		// 2024-05-01 is a Wednesday.
+		{"0 9 * * 1-5", "2024-05-03 10:00", "2024-05-06 09:00"},
+		{"0 0 * * 7", "2024-05-01 00:00", "2024-05-05 00:00"},
+		// Either day field matches when both are restricted.
+		{"0 0 13 * 5", "2024-05-01 00:00", "2024-05-03 00:00"},
+		{"0 0 13 * 5", "2024-05-10 00:00", "2024-05-13 00:00"},
+		// Both must when one starts with *.
+		{"0 0 */2 * 5", "2024-05-01 00:00", "2024-05-03 00:00"},
+		{"0 0 */2 * 5", "2024-05-03 00:00", "2024-05-17 00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		{"0 12 1 1 *", "2024-12-31 13:00", "2025-01-01 12:00"},
+	}
+	for _, tt := range tests {
+		spec, err := parseCron(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := spec.next(at(tt.from)); !got.Equal(at(tt.want)) {
+			t.Errorf("%q after %s: got %s, want %s", tt.spec, tt.from, got.Format("2006-01-02 15:04"), tt.want)
+		}
+	}
+
+	never, _ := parseCron("0 0 30 2 *")
+	if got := never.next(at("2024-01-01 00:00")); !got.IsZero() {
+		t.Errorf("February 30th matched %s", got)
+	}
+}
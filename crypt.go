@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Encrypted fields are stored as encPrefix followed by the base64 encoded
+// nonce and AES-GCM ciphertext. The todo ID is used as additional data so a
+// ciphertext cannot be copied onto another document.
+const encPrefix = "enc:v1:"
+
+// fieldCipher is nil when TODO_ENCRYPTION_KEY is unset, in which case
+// fields are stored in plain text.
+var fieldCipher cipher.AEAD
+
+// loadEncryptionKey reads the base64 encoded 256-bit TODO_ENCRYPTION_KEY.
+func loadEncryptionKey() error {
+	encoded := os.Getenv("TODO_ENCRYPTION_KEY")
+	if encoded == "" {
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("TODO_ENCRYPTION_KEY is not valid base64: %w", err)
+	}
+	if len(key) != 32 {
+		return fmt.Errorf("TODO_ENCRYPTION_KEY must be 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	fieldCipher, err = cipher.NewGCM(block)
+	return err
+}
+
+// encryptField encrypts plaintext for the todo id. It is a no-op when
+// encryption is disabled.
+func encryptField(id primitive.ObjectID, plaintext string) (string, error) {
+	if fieldCipher == nil {
+		return plaintext, nil
+	}
+	nonce := make([]byte, fieldCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := fieldCipher.Seal(nonce, nonce, []byte(plaintext), id[:])
+	return encPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptField reverses encryptField. Values without encPrefix were written
+// before encryption was enabled and are returned unchanged.
+func decryptField(id primitive.ObjectID, value string) (string, error) {
+	if !strings.HasPrefix(value, encPrefix) {
+		return value, nil
+	}
+	if fieldCipher == nil {
+		return "", errors.New("encrypted field found but TODO_ENCRYPTION_KEY is not set")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encPrefix))
+	if err != nil || len(sealed) < fieldCipher.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted field on todo %s", id.Hex())
+	}
+	nonce, ciphertext := sealed[:fieldCipher.NonceSize()], sealed[fieldCipher.NonceSize():]
+	plaintext, err := fieldCipher.Open(nil, nonce, ciphertext, id[:])
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt todo %s: %w", id.Hex(), err)
+	}
+	return string(plaintext), nil
+}
+
+// contentFields are the fields of a todo holding what users wrote, which
+// are encrypted.
+var contentFields = []string{"title", "description"}
+
+// encryptContent encrypts the title and description of tm in place. An
+// empty description stays empty, so it is still left out of the document.
+func encryptContent(tm *todoModel) (err error) {
+	if tm.Title, err = encryptField(tm.ID, tm.Title); err != nil {
+		return err
+	}
+	if tm.Description != "" {
+		tm.Description, err = encryptField(tm.ID, tm.Description)
+	}
+	return err
+}
+
+// decryptContent reverses encryptContent.
+func decryptContent(tm *todoModel) (err error) {
+	if tm.Title, err = decryptField(tm.ID, tm.Title); err != nil {
+		return err
+	}
+	tm.Description, err = decryptField(tm.ID, tm.Description)
+	return err
+}
+
+// encryptUpdate encrypts the content fields of an update of the todo id in
+// place.
+func encryptUpdate(id primitive.ObjectID, fields bson.M) error {
+	for _, k := range contentFields {
+		v, ok := fields[k].(string)
+		if !ok {
+			continue
+		}
+		encrypted, err := encryptField(id, v)
+		if err != nil {
+			return err
+		}
+		fields[k] = encrypted
+	}
+	return nil
+}
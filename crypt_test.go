@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// useEncryptionKey encrypts fields with key until the test ends.
+func useEncryptionKey(t *testing.T, key string) {
+	prev := fieldCipher
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fieldCipher, err = cipher.NewGCM(block); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fieldCipher = prev })
+}
+
+// TestContentRoundTrip stores a todo as each store does, which must keep
+// its title and description encrypted and read them back.
+func TestContentRoundTrip(t *testing.T) {
+	useEncryptionKey(t, strings.Repeat("k", 32))
+	tm := todoModel{ID: primitive.NewObjectID(), Title: "Invoice", Description: "Please pay the attached invoice by Friday."}
+
+	stores := []struct {
+		name  string
+		store func(todoModel) (stored todoModel, err error)
+	}{
+		{"mongo", func(tm todoModel) (todoModel, error) {
+			if err := encryptContent(&tm); err != nil {
+				return todoModel{}, err
+			}
+			raw, err := bson.Marshal(tm)
+			if err != nil {
+				return todoModel{}, err
+			}
+			var stored todoModel
+			err = bson.Unmarshal(raw, &stored)
+			return stored, err
+		}},
+		{"dynamodb", func(tm todoModel) (todoModel, error) {
+			item, err := (&dynamoRepository{}).todoItem(context.Background(), tm)
+			if err != nil {
+				return todoModel{}, err
+			}
+			return itemToTodo(item)
+		}},
+	}
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			stored, err := s.store(tm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(stored.Title, encPrefix) || !strings.HasPrefix(stored.Description, encPrefix) {
+				t.Fatalf("stored title %q and description %q, want them encrypted", stored.Title, stored.Description)
+			}
+			if err := decryptContent(&stored); err != nil {
+				t.Fatal(err)
+			}
+			if stored.Title != tm.Title || stored.Description != tm.Description {
+				t.Errorf("read back %q, %q, want %q, %q", stored.Title, stored.Description, tm.Title, tm.Description)
+			}
+		})
+	}
+
+	fields := bson.M{"title": "Invoice", "description": "Paid", "completed": true}
+	if err := encryptUpdate(tm.ID, fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range contentFields {
+		if v, _ := fields[k].(string); !strings.HasPrefix(v, encPrefix) {
+			t.Errorf("update sets %s to %q, want it encrypted", k, v)
+		}
+	}
+}
+
+func TestEncryptField(t *testing.T) {
+	useEncryptionKey(t, strings.Repeat("k", 32))
+	id := primitive.NewObjectID()
+
+	for _, plaintext := range []string{"", "Buy milk", "Café ☕ à 9h"} {
+		sealed, err := encryptField(id, plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(sealed, encPrefix) || strings.Contains(sealed, plaintext) && plaintext != "" {
+			t.Errorf("encrypted %q to %q", plaintext, sealed)
+		}
+		if got, err := decryptField(id, sealed); err != nil || got != plaintext {
+			t.Errorf("decrypted %q to %q, %v", plaintext, got, err)
+		}
+	}
+
+	a, _ := encryptField(id, "Buy milk")
+	b, _ := encryptField(id, "Buy milk")
+	if a == b {
+		t.Error("the same plaintext encrypted twice gave the same ciphertext")
+	}
+}
+
+func TestDecryptFieldRejects(t *testing.T) {
+	useEncryptionKey(t, strings.Repeat("k", 32))
+	id := primitive.NewObjectID()
+	sealed, err := encryptField(id, "Buy milk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, encPrefix))
+	tampered := func(i int) string {
+		b := append([]byte(nil), raw...)
+		b[i] ^= 1
+		return encPrefix + base64.StdEncoding.EncodeToString(b)
+	}
+
+	tests := []struct {
+		name  string
+		id    primitive.ObjectID
+		value string
+	}{
+		{"tampered nonce", id, tampered(0)},
+		{"tampered ciphertext", id, tampered(fieldCipher.NonceSize())},
+		{"tampered tag", id, tampered(len(raw) - 1)},
+		{"truncated", id, encPrefix + base64.StdEncoding.EncodeToString(raw[:len(raw)-1])},
+		{"shorter than a nonce", id, encPrefix + base64.StdEncoding.EncodeToString(raw[:4])},
+		{"not base64", id, encPrefix + "!!!"},
+		// The ID is additional data, a value copied onto another todo does
+		// not decrypt.
+		{"other todo", primitive.NewObjectID(), sealed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := decryptField(tt.id, tt.value); err == nil {
+				t.Errorf("decrypted to %q", got)
+			}
+		})
+	}
+
+	useEncryptionKey(t, strings.Repeat("w", 32))
+	if got, err := decryptField(id, sealed); err == nil {
+		t.Errorf("decrypted with the wrong key to %q", got)
+	}
+}
+
+// TestDecryptFieldPlaintext reads rows written before encryption was
+// enabled, or after it was disabled.
+func TestDecryptFieldPlaintext(t *testing.T) {
+	id := primitive.NewObjectID()
+	useEncryptionKey(t, strings.Repeat("k", 32))
+	sealed, err := encryptField(id, "Buy milk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := decryptField(id, "Buy milk"); err != nil || got != "Buy milk" {
+		t.Errorf("legacy row read as %q, %v", got, err)
+	}
+
+	fieldCipher = nil
+	if got, err := encryptField(id, "Buy milk"); err != nil || got != "Buy milk" {
+		t.Errorf("without a key, encrypted to %q, %v", got, err)
+	}
+	if got, err := decryptField(id, "Buy milk"); err != nil || got != "Buy milk" {
+		t.Errorf("without a key, read as %q, %v", got, err)
+	}
+	if _, err := decryptField(id, sealed); err == nil {
+		t.Error("an encrypted row was read without a key")
+	}
+}
+
+func TestLoadEncryptionKey(t *testing.T) {
+	prev := fieldCipher
+	t.Cleanup(func() { fieldCipher = prev })
+
+	for _, tt := range []struct {
+		key string
+		ok  bool
+	}{
+		{base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))), true},
+		{base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 16))), false},
+		{"not base64!", false},
+	} {
+		fieldCipher = nil
+		t.Setenv("TODO_ENCRYPTION_KEY", tt.key)
+		if err := loadEncryptionKey(); (err == nil) != tt.ok || tt.ok && fieldCipher == nil {
+			t.Errorf("key %q: %v", tt.key, err)
+		}
+	}
+}
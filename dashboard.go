@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// dashboardActivity is how many recent changes the dashboard lists.
+	dashboardActivity = 20
+	// dashboardTenants is how many tenants, those with the most open
+	// todos, the dashboard lists.
+	dashboardTenants = 50
+	// dashboardFailures is how many failed webhook deliveries it lists.
+	dashboardFailures = 10
+)
+
+type (
+	// dashboardPage is the data of admin.tpl.
+	dashboardPage struct {
+		locale
+		At       time.Time
+		Store    storeStatus
+		Totals   tenantCounts
+		Tenants  []tenantCounts
+		Activity []todoEvent
+		// Webhooks is nil when webhooks are not enabled.
+		Webhooks *webhookHealth
+		// Errors tell which parts could not be loaded.
+		Errors []string
+	}
+
+	storeStatus struct {
+		Backend   string
+		Connected bool
+		// Breaker is the state of the circuit breaker, "" when it is off.
+		Breaker string
+		// Ping is how long MongoDB took to answer a ping, 0 for other
+		// stores or when it did not.
+		Ping time.Duration
+		// BufferedWrites is -1 without a write buffer.
+		BufferedWrites int
+	}
+
+	webhookHealth struct {
+		Webhooks  int64
+		Pending   int64
+		Delivered int64
+		Failed    int64
+		Failures  []webhookFailure
+	}
+
+	// webhookFailure is a delivery that gave up, with its last attempt.
+	webhookFailure struct {
+		URL        string
+		EventType  string
+		Attempts   int
+		At         time.Time
+		StatusCode int
+		Error      string
+	}
+)
+
+// dashboardHandlers serves the dashboard at /admin: the store status,
+// todo counts of all tenants, the last changes made through this process
+// and the health of webhook deliveries, for deployments too small for a
+// metrics stack, with the background jobs at /admin/jobs and the scheduled
+// tasks at /admin/tasks. It requires the admin token, see mountAdmin.
+func dashboardHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Use(requireAdminToken)
+	r.Get("/", dashboardHandler)
+	r.Mount("/jobs", adminJobHandlers())
+	r.Mount("/tasks", adminTaskHandlers())
+	return r
+}
+
+// requireAdminToken lets requests through with adminToken as a bearer
+// token or as the password of basic authentication, which browsers prompt
+// for. Without a token, only the loopback admin listener mounts /admin.
+func requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminToken != "" {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				_, got, _ = r.BasicAuth()
+			}
+			if subtle.ConstantTimeCompare([]byte(got), []byte(adminToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="todo admin"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	p := dashboardPage{locale: matchLocale(r, ""), At: time.Now().UTC(), Store: currentStoreStatus(ctx)}
+	fail := func(what string, err error) {
+		slog.Warn("could not load dashboard", "component", "admin", "part", what, "error", err)
+		p.Errors = append(p.Errors, p.T("Could not load the %s: %s", p.lookup(what), p.lookup(classify(err).describe(err))))
+	}
+
+	counts, err := dashboardCounts(ctx)
+	if err != nil {
+		fail("todo counts", err)
+	}
+	p.Totals = sumCounts(counts)
+	p.Tenants = counts[:min(dashboardTenants, len(counts))]
+	p.Activity = changes.recent(dashboardActivity)
+	if usingMongo() && webhooksEnabled() {
+		if p.Webhooks, err = loadWebhookHealth(ctx); err != nil {
+			fail("webhook deliveries", err)
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	renderPage(w, http.StatusOK, "admin.tpl", p)
+}
+
+func currentStoreStatus(ctx context.Context) storeStatus {
+	s := storeStatus{Backend: "dynamodb", Connected: storeAvailable(), BufferedWrites: -1}
+	if usingMongo() {
+		s.Backend = "mongo"
+		start := time.Now()
+		if client != nil && pingMongo(ctx) == nil {
+			s.Ping = time.Since(start).Round(time.Millisecond)
+		}
+	}
+	if breakerThreshold > 0 {
+		s.Breaker = breaker.current().String()
+	}
+	if writes != nil {
+		s.BufferedWrites = writes.Len()
+	}
+	return s
+}
+
+// dashboardCounts counts the todos of every tenant with MongoDB. Other
+// stores only list the todos of one tenant, those of the default one are
+// counted.
+func dashboardCounts(ctx context.Context) ([]tenantCounts, error) {
+	if usingMongo() {
+		return countTodos(ctx)
+	}
+	todos, err := repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var c tenantCounts
+	now := time.Now()
+	for _, t := range todos {
+		switch {
+		case t.Completed:
+			c.Completed++
+		case t.DueAt != nil && t.DueAt.Before(now):
+			c.Open++
+			c.Overdue++
+		default:
+			c.Open++
+		}
+	}
+	return []tenantCounts{c}, nil
+}
+
+// loadWebhookHealth counts the webhooks and the deliveries kept of each
+// status, and returns the last failed ones.
+func loadWebhookHealth(ctx context.Context) (*webhookHealth, error) {
+	var h webhookHealth
+	var err error
+	if h.Webhooks, err = webhooksCollection().CountDocuments(ctx, bson.M{}); err != nil {
+		return nil, err
+	}
+	cursor, err := deliveriesCollection().Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$status", "n": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var byStatus []struct {
+		Status string `bson:"_id"`
+		N      int64  `bson:"n"`
+	}
+	if err := cursor.All(ctx, &byStatus); err != nil {
+		return nil, err
+	}
+	for _, s := range byStatus {
+		switch s.Status {
+		case deliveryPending:
+			h.Pending = s.N
+		case deliveryDelivered:
+			h.Delivered = s.N
+		case deliveryFailed:
+			h.Failed = s.N
+		}
+	}
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(dashboardFailures).SetProjection(bson.M{"payload": 0})
+	cursor, err = deliveriesCollection().Find(ctx, bson.M{"status": deliveryFailed}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var failed []webhookDelivery
+	if err := cursor.All(ctx, &failed); err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, len(failed))
+	for i, d := range failed {
+		ids[i] = d.WebhookID
+	}
+	cursor, err = webhooksCollection().Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.M{"url": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var hooks []webhook
+	if err := cursor.All(ctx, &hooks); err != nil {
+		return nil, err
+	}
+	urls := make(map[primitive.ObjectID]string, len(hooks))
+	for _, wh := range hooks {
+		urls[wh.ID] = wh.URL
+	}
+	for _, d := range failed {
+		f := webhookFailure{URL: urls[d.WebhookID], EventType: d.EventType, Attempts: d.Attempts, At: d.CreatedAt}
+		if n := len(d.History); n > 0 {
+			last := d.History[n-1]
+			f.At, f.StatusCode, f.Error = last.At, last.StatusCode, last.Error
+		}
+		h.Failures = append(h.Failures, f)
+	}
+	return &h, nil
+}
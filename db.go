@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// MongoDB connection settings, read by loadCollectionOptions from
+// TODO_MONGO_URI, TODO_MONGO_DATABASE, TODO_MONGO_COLLECTION,
+// TODO_MONGO_MAX_WAIT and TODO_MONGO_BATCH_SIZE.
+var (
+	mongoURI       string
+	dbName         string
+	collectionName string
+	// mongoMaxWait is how long waitForMongo retries before giving up,
+	// forever when zero.
+	mongoMaxWait time.Duration
+	// mongoBatchSize is the most todos a cursor fetches per round trip,
+	// the driver's default when zero.
+	mongoBatchSize int32
+)
+
+// mongoClientOptions builds the client options from the connection string.
+// Credentials and TLS files can also be given separately so secrets don't
+// have to be embedded in the URI:
+//
+//	TODO_MONGO_USERNAME, TODO_MONGO_PASSWORD, TODO_MONGO_AUTH_SOURCE
+//	TODO_MONGO_TLS_CA_FILE, TODO_MONGO_TLS_CERT_FILE, TODO_MONGO_TLS_KEY_FILE
+func mongoClientOptions() (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(mongoURI)
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid MongoDB URI: %w", err)
+	}
+	opts.SetMonitor(mongoMonitor())
+	// While MongoDB is down, store calls fail choosing a server before the
+	// request times out, so the circuit breaker counts them.
+	if opts.ServerSelectionTimeout == nil {
+		opts.SetServerSelectionTimeout(requestTimeout / 2)
+	}
+
+	if user := os.Getenv("TODO_MONGO_USERNAME"); user != "" {
+		opts.SetAuth(options.Credential{
+			Username:   user,
+			Password:   os.Getenv("TODO_MONGO_PASSWORD"),
+			AuthSource: os.Getenv("TODO_MONGO_AUTH_SOURCE"),
+		})
+	}
+
+	caFile := os.Getenv("TODO_MONGO_TLS_CA_FILE")
+	certFile, keyFile := os.Getenv("TODO_MONGO_TLS_CERT_FILE"), os.Getenv("TODO_MONGO_TLS_KEY_FILE")
+	if caFile == "" && certFile == "" {
+		return opts, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return opts.SetTLSConfig(cfg), nil
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// Read preference and write concern are configured per operation class so
+// list endpoints can be served from secondaries in a replica set while
+// mutations keep going to the primary with the requested durability.
+var readCollectionOpts, writeCollectionOpts *options.CollectionOptions
+
+// loadCollectionOptions reads the connection settings and builds the
+// collection options for reads and writes from TODO_READ_PREFERENCE,
+// TODO_READ_MAX_STALENESS and TODO_WRITE_CONCERN.
+func loadCollectionOptions() error {
+	mongoURI = envOr("TODO_MONGO_URI", "mongodb://127.0.0.1:27017")
+	dbName = envOr("TODO_MONGO_DATABASE", "demo_todo")
+	collectionName = envOr("TODO_MONGO_COLLECTION", "todo")
+	mongoMaxWait = 0
+	if v := os.Getenv("TODO_MONGO_MAX_WAIT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid TODO_MONGO_MAX_WAIT %q, must be a duration like 5m", v)
+		}
+		mongoMaxWait = d
+	}
+	mongoBatchSize = 0
+	if v := os.Getenv("TODO_MONGO_BATCH_SIZE"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid TODO_MONGO_BATCH_SIZE %q, must be a positive number", v)
+		}
+		mongoBatchSize = int32(n)
+	}
+
+	rp, err := parseReadPreference(os.Getenv("TODO_READ_PREFERENCE"), os.Getenv("TODO_READ_MAX_STALENESS"))
+	if err != nil {
+		return err
+	}
+	wc, err := parseWriteConcern(os.Getenv("TODO_WRITE_CONCERN"))
+	if err != nil {
+		return err
+	}
+
+	readCollectionOpts = options.Collection().SetReadPreference(rp)
+	writeCollectionOpts = options.Collection().SetReadPreference(readpref.Primary())
+	if wc != nil {
+		writeCollectionOpts.SetWriteConcern(wc)
+	}
+	return nil
+}
+
+// parseReadPreference accepts primary, primaryPreferred, secondary,
+// secondaryPreferred or nearest, defaulting to primary.
+func parseReadPreference(mode, maxStaleness string) (*readpref.ReadPref, error) {
+	if mode == "" {
+		return readpref.Primary(), nil
+	}
+	m, err := readpref.ModeFromString(mode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid read preference %q: %w", mode, err)
+	}
+
+	var opts []readpref.Option
+	if maxStaleness != "" {
+		d, err := time.ParseDuration(maxStaleness)
+		if err != nil {
+			return nil, fmt.Errorf("invalid read max staleness %q: %w", maxStaleness, err)
+		}
+		opts = append(opts, readpref.WithMaxStaleness(d))
+	}
+
+	rp, err := readpref.New(m, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid read preference %q: %w", mode, err)
+	}
+	return rp, nil
+}
+
+// parseWriteConcern accepts "majority", a node count such as "1", or a
+// replica set tag name. An empty value keeps the driver default.
+func parseWriteConcern(w string) (*writeconcern.WriteConcern, error) {
+	switch {
+	case w == "":
+		return nil, nil
+	case w == "majority":
+		return writeconcern.Majority(), nil
+	}
+	if n, err := strconv.Atoi(w); err == nil {
+		if n < 0 {
+			return nil, fmt.Errorf("invalid write concern %q", w)
+		}
+		return &writeconcern.WriteConcern{W: n}, nil
+	}
+	return writeconcern.Custom(w), nil
+}
+
+// findOptions returns the options of queries reading todos, batched by
+// mongoBatchSize.
+func findOptions() *options.FindOptions {
+	opts := options.Find()
+	if mongoBatchSize > 0 {
+		opts.SetBatchSize(mongoBatchSize)
+	}
+	return opts
+}
+
+// readCollection returns the todo collection for read-heavy list operations.
+func readCollection() *mongo.Collection {
+	return db.Collection(collectionName, readCollectionOpts)
+}
+
+// writeCollection returns the todo collection for mutations.
+func writeCollection() *mongo.Collection {
+	return db.Collection(collectionName, writeCollectionOpts)
+}
+
+const (
+	connectBaseDelay    = 500 * time.Millisecond
+	connectMaxDelay     = 30 * time.Second
+	healthCheckInterval = 10 * time.Second
+)
+
+// dbConnected tracks whether MongoDB answered the last ping. Handlers serve
+// 503 while it is false instead of waiting for server selection to time out.
+var dbConnected atomic.Bool
+
+// storeAvailable reports whether the configured store can take requests:
+// it answers pings and the circuit breaker is not open.
+func storeAvailable() bool {
+	return (!usingMongo() || dbConnected.Load()) && breaker.current() != breakerOpen
+}
+
+// waitForMongo pings MongoDB with exponential backoff until it answers,
+// then prepares the collections. It returns early only when ctx is done,
+// mongoMaxWait elapsed, or preparing the collections fails for a reason
+// other than connectivity.
+func waitForMongo(ctx context.Context) error {
+	delay := connectBaseDelay
+	giveUp := time.Now().Add(mongoMaxWait)
+	for attempt := 1; ; attempt++ {
+		err := pingMongo(ctx)
+		if err == nil {
+			err = prepareCollections(ctx)
+		}
+		if err == nil {
+			dbConnected.Store(true)
+			slog.Info("connected to MongoDB")
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !isTransient(err) && !mongo.IsNetworkError(err) && !errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+
+		if mongoMaxWait > 0 {
+			left := time.Until(giveUp)
+			if left <= 0 {
+				return fmt.Errorf("no answer within %s after %d attempts: %w", mongoMaxWait, attempt, err)
+			}
+			delay = min(delay, left)
+		}
+		slog.Warn("MongoDB unavailable, retrying", "attempt", attempt, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, connectMaxDelay)
+	}
+}
+
+// monitorMongo connects in the background and keeps dbConnected up to date
+// until ctx is done. The driver reconnects on its own, this only tracks
+// the state.
+func monitorMongo(ctx context.Context) {
+	if !usingMongo() {
+		return
+	}
+	if err := waitForMongo(ctx); err != nil {
+		if ctx.Err() == nil {
+			fatal("failed to connect to MongoDB", "error", err)
+		}
+		return
+	}
+
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := pingMongo(ctx)
+		if was := dbConnected.Swap(err == nil); was != (err == nil) {
+			if err != nil {
+				slog.Error("lost connection to MongoDB", "error", err)
+			} else {
+				slog.Info("reconnected to MongoDB")
+			}
+		}
+	}
+}
+
+func pingMongo(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return client.Ping(ctx, nil)
+}
+
+// prepareCollections applies the schema and indexes the API relies on.
+func prepareCollections(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if err := applySchemaValidation(ctx); err != nil {
+		return fmt.Errorf("could not apply todo schema validation: %w", err)
+	}
+	if err := ensureTenantIndex(ctx); err != nil {
+		return fmt.Errorf("could not create tenant index: %w", err)
+	}
+	if err := shardCollection(ctx); err != nil {
+		return fmt.Errorf("could not shard todo collection: %w", err)
+	}
+	if err := ensureArchiveIndexes(ctx); err != nil {
+		return fmt.Errorf("could not create archive indexes: %w", err)
+	}
+	if err := ensureRecurringIndexes(ctx); err != nil {
+		return fmt.Errorf("could not create recurring todo indexes: %w", err)
+	}
+	if err := ensureOutboxIndexes(ctx); err != nil {
+		return fmt.Errorf("could not create outbox indexes: %w", err)
+	}
+	if err := ensureWebhookIndexes(ctx); err != nil {
+		return fmt.Errorf("could not create webhook indexes: %w", err)
+	}
+	if err := ensureJobIndexes(ctx); err != nil {
+		return fmt.Errorf("could not create job indexes: %w", err)
+	}
+	if err := ensureGitHubIndexes(ctx); err != nil {
+		return fmt.Errorf("could not create GitHub indexes: %w", err)
+	}
+	if err := ensureGitHubHookIndexes(ctx); err != nil {
+		return fmt.Errorf("could not create GitHub hook indexes: %w", err)
+	}
+	if err := ensureICalIndexes(ctx); err != nil {
+		return fmt.Errorf("could not create calendar subscription indexes: %w", err)
+	}
+	if err := ensureGoogleIndexes(ctx); err != nil {
+		return fmt.Errorf("could not create Google Tasks indexes: %w", err)
+	}
+	if err := ensureSlackIndexes(ctx); err != nil {
+		return fmt.Errorf("could not create Slack indexes: %w", err)
+	}
+	if err := ensureTeamsIndexes(ctx); err != nil {
+		return fmt.Errorf("could not create Teams indexes: %w", err)
+	}
+	if err := ensurePushIndexes(ctx); err != nil {
+		return fmt.Errorf("could not create push indexes: %w", err)
+	}
+	if err := ensureTelegramIndexes(ctx); err != nil {
+		return fmt.Errorf("could not create Telegram indexes: %w", err)
+	}
+	if err := ensureEmailIndexes(ctx); err != nil {
+		return fmt.Errorf("could not create email indexes: %w", err)
+	}
+	if err := ensureDigestIndexes(ctx); err != nil {
+		return fmt.Errorf("could not create digest indexes: %w", err)
+	}
+	return nil
+}
+
+// requireStore answers 503 while the store is unreachable. Writes still go
+// through when the write buffer can queue them.
+func requireStore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !storeAvailable() && (writes == nil || r.Method == http.MethodGet) {
+			w.Header().Set("Retry-After", "5")
+			rnd.JSON(w, http.StatusServiceUnavailable, renderer.M{"message": "database unavailable", "error": "service unavailable"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// healthzHandler reports that the process is up, for liveness probes.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	rnd.JSON(w, http.StatusOK, renderer.M{"status": "ok"})
+}
+
+// readyzHandler reports whether the store is reachable, for readiness
+// probes and load balancers.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	status, database := http.StatusOK, "connected"
+	if !storeAvailable() {
+		status, database = http.StatusServiceUnavailable, "disconnected"
+	}
+	body := renderer.M{"status": http.StatusText(status), "database": database}
+	if breakerThreshold > 0 {
+		body["circuit_breaker"] = breaker.current().String()
+	}
+	if writes != nil {
+		body["buffered_writes"] = writes.Len()
+	}
+	rnd.JSON(w, status, body)
+}
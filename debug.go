@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// Debug endpoint settings, see loadDebugConfig.
+var (
+	// debugToken is the bearer token the debug endpoints require.
+	debugToken string
+	// debugAddr, when set, serves the debug endpoints on their own
+	// listener instead of the API one.
+	debugAddr string
+)
+
+// loadDebugConfig reads TODO_DEBUG_TOKEN, which mounts the pprof and
+// expvar endpoints under /debug behind that bearer token, and
+// TODO_DEBUG_ADDR, which serves them on a separate listener instead. The
+// token may only be left out on a loopback address.
+func loadDebugConfig() error {
+	debugToken = os.Getenv("TODO_DEBUG_TOKEN")
+	debugAddr = os.Getenv("TODO_DEBUG_ADDR")
+	if ln, ok := inheritedListeners["debug"]; ok && debugAddr == "" {
+		debugAddr = ln.Addr().String()
+	}
+	if debugAddr == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(debugAddr); err != nil {
+		return fmt.Errorf("invalid TODO_DEBUG_ADDR %q: %w", debugAddr, err)
+	}
+	if debugToken == "" && !loopbackAddr(debugAddr) {
+		return errors.New("TODO_DEBUG_TOKEN is required unless TODO_DEBUG_ADDR is a loopback address")
+	}
+	return nil
+}
+
+// loopbackAddr reports whether the listen address addr only accepts local
+// connections.
+func loopbackAddr(addr string) bool {
+	host, _, _ := net.SplitHostPort(addr)
+	ip := net.ParseIP(host)
+	return host == "localhost" || ip != nil && ip.IsLoopback()
+}
+
+// debugHandlers serves the profiles of net/http/pprof under /pprof, the
+// expvar variables, such as memstats, at /vars, the configuration reload
+// at /config/reload and the maintenance mode at /maintenance. It is
+// mounted at /debug.
+func debugHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Use(requireDebugToken)
+	r.Get("/vars", expvar.Handler().ServeHTTP)
+	r.Post("/config/reload", reloadHandler)
+	r.Get("/maintenance", maintenanceHandler)
+	r.Put("/maintenance", maintenanceHandler)
+	r.HandleFunc("/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/pprof/profile", pprof.Profile)
+	r.HandleFunc("/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/pprof/trace", pprof.Trace)
+	// Index also serves the named profiles: heap, goroutine, allocs...
+	r.HandleFunc("/pprof/*", pprof.Index)
+	return r
+}
+
+func requireDebugToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if debugToken != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(debugToken)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// mountDebug mounts the debug endpoints on the API router when they are
+// enabled without a listener of their own or the admin one.
+func mountDebug(r chi.Router) {
+	if debugToken != "" && debugAddr == "" && adminAddr == "" {
+		r.Mount("/debug", debugHandlers())
+	}
+}
+
+// debugServer returns the listener of the debug endpoints, or nil when
+// they have none. It has no write timeout so CPU profiles and traces can
+// run for as long as asked.
+func debugServer() *http.Server {
+	if debugAddr == "" {
+		return nil
+	}
+	r := chi.NewRouter()
+	r.Mount("/debug", debugHandlers())
+	return limitSlowClients(&http.Server{Addr: debugAddr, Handler: r, ReadTimeout: readTimeout, IdleTimeout: idleTimeout})
+}
+
+// serveDebug runs the debug server until it is shut down.
+func serveDebug(srv *http.Server) {
+	slog.Info("serving debug endpoints", "addr", srv.Addr)
+	ln, err := listener("debug", srv.Addr)
+	if err == nil {
+		err = srv.Serve(ln)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("debug listener failed", "error", err)
+	}
+}
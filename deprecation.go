@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// deprecation is a part of the API that will be removed, announced to
+// clients using it with the Deprecation (RFC 9745) and Sunset (RFC 8594)
+// headers and a warning in JSON answers.
+type deprecation struct {
+	since   time.Time
+	sunset  time.Time
+	warning string
+}
+
+// deprecations are what the deprecated middleware can mark routes with.
+var deprecations = map[string]deprecation{
+	"string-completed": {
+		since:   time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC),
+		sunset:  time.Date(2027, 4, 16, 0, 0, 0, 0, time.UTC),
+		warning: `completed is sent and read as the string "true" or "false"; v2 will use a JSON boolean`,
+	},
+}
+
+// deprecated marks the route as using the deprecation of that name. When
+// several apply, the headers announce the earliest dates and every
+// warning is listed. It has to run inside cacheMiddleware, so cached
+// answers carry the warnings too.
+func deprecated(name string) func(http.Handler) http.Handler {
+	d, ok := deprecations[name]
+	if !ok {
+		panic("unknown deprecation " + name)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			if !earlierHeader(h.Get("Deprecation"), d.since) {
+				h.Set("Deprecation", "@"+strconv.FormatInt(d.since.Unix(), 10))
+			}
+			if got, err := http.ParseTime(h.Get("Sunset")); err != nil || d.sunset.Before(got) {
+				h.Set("Sunset", d.sunset.Format(http.TimeFormat))
+			}
+			next.ServeHTTP(&warningWriter{ResponseWriter: w, warning: d.warning}, r)
+		})
+	}
+}
+
+// earlierHeader reports whether the Deprecation header value v is set to
+// t or earlier.
+func earlierHeader(v string, t time.Time) bool {
+	unix, err := strconv.ParseInt(strings.TrimPrefix(v, "@"), 10, 64)
+	return err == nil && unix <= t.Unix()
+}
+
+// warningWriter adds its warning to the warnings field of the JSON object
+// answers written through it. Other answers pass through untouched.
+type warningWriter struct {
+	http.ResponseWriter
+	warning string
+	wrote   bool
+}
+
+func (w *warningWriter) WriteHeader(status int) {
+	if !w.wrote && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		// The body grows, a length set by the handler would be wrong.
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *warningWriter) Write(b []byte) (int, error) {
+	first := !w.wrote
+	w.wrote = true
+	if !first || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		return w.ResponseWriter.Write(b)
+	}
+	if _, err := w.ResponseWriter.Write(withWarning(b, w.warning)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// withWarning appends warning to the warnings of the JSON object body. A
+// body that is only the start of an object, streamed in several writes,
+// gets the warnings as its first field.
+func withWarning(body []byte, warning string) []byte {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil || fields == nil {
+		if !bytes.HasPrefix(body, []byte("{")) {
+			return body
+		}
+		warnings, _ := json.Marshal([]string{warning})
+		return slices.Concat([]byte(`{"warnings":`), warnings, []byte(","), body[1:])
+	}
+	var warnings []string
+	json.Unmarshal(fields["warnings"], &warnings)
+	if slices.Contains(warnings, warning) {
+		return body
+	}
+	fields["warnings"], _ = json.Marshal(append(warnings, warning))
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+func (w *warningWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *warningWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
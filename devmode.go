@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// devPollInterval is how often dev mode looks for changed static files.
+// Polling needs no file notification API, and the directory is small.
+const devPollInterval = 500 * time.Millisecond
+
+// devReloadScript is added to pages in dev mode to reload them when the
+// static files change.
+const devReloadScript = `<script>new EventSource("/dev/reload").addEventListener("reload", function () { location.reload(); });</script>
+`
+
+var (
+	devReloadMu sync.Mutex
+	// devReloaded is closed, and replaced, when the static files change.
+	devReloaded = make(chan struct{})
+)
+
+// runStaticWatcher clears the caches of the static files in dev mode when
+// any of them changes, and tells the pages listening on /dev/reload, until
+// ctx is done.
+func runStaticWatcher(ctx context.Context) {
+	if !devMode {
+		return
+	}
+	slog.Info("watching static files", "component", "dev", "dir", staticDir)
+	ticker := time.NewTicker(devPollInterval)
+	defer ticker.Stop()
+
+	last, _ := staticFingerprint()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		fp, err := staticFingerprint()
+		if err != nil {
+			slog.Warn("could not read static files", "component", "dev", "error", err)
+			continue
+		}
+		if fp == last {
+			continue
+		}
+		last = fp
+		clearStaticCaches()
+		if err := precompilePages(); err != nil {
+			slog.Error("static files changed, template broken", "component", "dev", "error", err)
+		} else {
+			slog.Info("static files changed, reloaded", "component", "dev")
+		}
+		devReloadMu.Lock()
+		close(devReloaded)
+		devReloaded = make(chan struct{})
+		devReloadMu.Unlock()
+	}
+}
+
+// staticFingerprint sums the names, sizes and modification times of the
+// static files.
+func staticFingerprint() (uint64, error) {
+	h := fnv.New64a()
+	err := fs.WalkDir(staticFS, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s %d %d\n", name, fi.Size(), fi.ModTime().UnixNano())
+		return nil
+	})
+	return h.Sum64(), err
+}
+
+// devReloadHandler streams a reload event to a page when the static files
+// change. It is only routed in dev mode.
+func devReloadHandler(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// The stream outlives the server's write timeout.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	devReloadMu.Lock()
+	reloaded := devReloaded
+	devReloadMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if rc.Flush() != nil {
+		return
+	}
+	select {
+	case <-r.Context().Done():
+	case <-reloaded:
+		fmt.Fprint(w, "event: reload\ndata: {}\n\n")
+		rc.Flush()
+	}
+}
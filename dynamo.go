@@ -0,0 +1,854 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The DynamoDB store uses a single table keyed by pk and sk. Todos of a
+// tenant share a partition and sort by ID, which starts with the creation
+// time:
+//
+//	pk = TENANT#<tenant or "default">
+//	sk = TODO#<object id hex>
+const (
+	dynamoTodoPrefix     = "TODO#"
+	dynamoDefaultTenant  = "default"
+	dynamoBatchWriteSize = 25
+	// dynamoTransactSize is the most actions of one TransactWriteItems.
+	dynamoTransactSize = 100
+)
+
+type (
+	// attrValue is a DynamoDB AttributeValue in its JSON wire format.
+	attrValue struct {
+		S    *string              `json:"S,omitempty"`
+		N    *string              `json:"N,omitempty"`
+		BOOL *bool                `json:"BOOL,omitempty"`
+		L    *[]attrValue         `json:"L,omitempty"`
+		M    map[string]attrValue `json:"M,omitempty"`
+	}
+
+	dynamoItem map[string]attrValue
+
+	// dynamoRepository stores todos in DynamoDB through its JSON API.
+	dynamoRepository struct {
+		endpoint string
+		region   string
+		table    string
+		creds    awsCredentials
+		http     *http.Client
+	}
+
+	// dynamoError is an error response from DynamoDB.
+	dynamoError struct {
+		Type    string
+		Message string
+		Status  int
+	}
+)
+
+// newDynamoRepository reads TODO_DYNAMODB_TABLE, TODO_DYNAMODB_ENDPOINT and
+// the standard AWS_REGION, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN variables.
+func newDynamoRepository() (*dynamoRepository, error) {
+	d := &dynamoRepository{
+		endpoint: os.Getenv("TODO_DYNAMODB_ENDPOINT"),
+		region:   os.Getenv("AWS_REGION"),
+		table:    os.Getenv("TODO_DYNAMODB_TABLE"),
+		creds: awsCredentials{
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		},
+		http: &http.Client{Timeout: 10 * time.Second},
+	}
+	if d.table == "" || d.region == "" {
+		return nil, errors.New("TODO_DYNAMODB_TABLE and AWS_REGION are required for the dynamodb store")
+	}
+	if d.creds.AccessKey == "" || d.creds.SecretKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required for the dynamodb store")
+	}
+	if d.endpoint == "" {
+		d.endpoint = "https://dynamodb." + d.region + ".amazonaws.com"
+	}
+	if u, err := url.Parse(d.endpoint); err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid TODO_DYNAMODB_ENDPOINT %q", d.endpoint)
+	}
+	return d, nil
+}
+
+func (e *dynamoError) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+// Transient reports whether the request may succeed when retried.
+func (e *dynamoError) Transient() bool {
+	switch e.Type {
+	case "ProvisionedThroughputExceededException", "ThrottlingException", "RequestLimitExceeded",
+		"InternalServerError", "ServiceUnavailable", "TransactionConflictException":
+		return true
+	}
+	return e.Status >= 500
+}
+
+func (e *dynamoError) is(errType string) bool {
+	return e.Type == errType
+}
+
+// call invokes a DynamoDB API operation. Network failures are reported as
+// transient dynamoErrors so withRetry picks them up.
+func (d *dynamoRepository) call(ctx context.Context, op string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810."+op)
+	signRequest(req, body, d.creds, d.region, "dynamodb", time.Now())
+
+	resp, err := d.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &dynamoError{Type: "RequestError", Message: err.Error(), Status: http.StatusServiceUnavailable}
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type     string `json:"__type"`
+			Message  string `json:"message"`
+			MessageU string `json:"Message"`
+		}
+		json.Unmarshal(raw, &e)
+		de := &dynamoError{Type: e.Type, Message: e.Message, Status: resp.StatusCode}
+		if i := strings.LastIndex(de.Type, "#"); i >= 0 {
+			de.Type = de.Type[i+1:]
+		}
+		if de.Message == "" {
+			de.Message = e.MessageU
+		}
+		return de
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(raw, out)
+}
+
+func (d *dynamoRepository) partition(ctx context.Context) string {
+	tenant := tenantFrom(ctx)
+	if tenant == "" {
+		tenant = dynamoDefaultTenant
+	}
+	return "TENANT#" + tenant
+}
+
+func (d *dynamoRepository) key(ctx context.Context, id primitive.ObjectID) dynamoItem {
+	return dynamoItem{
+		"pk": avS(d.partition(ctx)),
+		"sk": avS(dynamoTodoPrefix + id.Hex()),
+	}
+}
+
+// dynamoSummary are the attributes read for listQuery.Summary.
+var dynamoSummary = []string{"id", "title", "completed", "due_at", "created_at"}
+
+// List returns all todos of the tenant.
+func (d *dynamoRepository) List(ctx context.Context) ([]todoModel, error) {
+	var todos []todoModel
+	err := d.query(ctx, nil, func(tm todoModel) error {
+		todos = append(todos, tm)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return todos, nil
+}
+
+// Get returns the todo with the given id.
+func (d *dynamoRepository) Get(ctx context.Context, id primitive.ObjectID) (todoModel, error) {
+	var out struct {
+		Item dynamoItem `json:"Item"`
+	}
+	err := withRetry(ctx, func(ctx context.Context) error {
+		return d.call(ctx, "GetItem", map[string]interface{}{
+			"TableName": d.table,
+			"Key":       d.key(ctx, id),
+		}, &out)
+	})
+	if err != nil {
+		return todoModel{}, err
+	}
+	if len(out.Item) == 0 {
+		return todoModel{}, errNotFound
+	}
+	tm, err := itemToTodo(out.Item)
+	if err != nil {
+		return todoModel{}, err
+	}
+	if err = decryptContent(&tm); err != nil {
+		return todoModel{}, err
+	}
+	return tm, nil
+}
+
+// Each calls fn with the todos q selects a page of the query at a time.
+// Archived todos are not supported. The list is filtered here, as a
+// FilterExpression would read the same items of the partition.
+func (d *dynamoRepository) Each(ctx context.Context, q listQuery, fn func(todoModel) error) error {
+	if q.Archived {
+		return errUnsupported
+	}
+	var projection []string
+	if q.Summary {
+		projection = dynamoSummary
+	}
+	if q.List != nil {
+		if projection != nil {
+			projection = append(slices.Clone(projection), "list")
+		}
+		each := fn
+		fn = func(tm todoModel) error {
+			if tm.List != *q.List {
+				return nil
+			}
+			return each(tm)
+		}
+	}
+	return d.query(ctx, projection, fn)
+}
+
+// dynamoBoard are the attributes Move reads of the todos of the tenant.
+var dynamoBoard = []string{"id", "list", "column", "position", "completed", "created_at"}
+
+// Move reads the todo and the tenant's todos in its target column, then
+// writes the move, after the positions of the column numbered again when
+// needed, with TransactWriteItems so they apply together. A column too
+// large for one transaction is numbered again in several, in order, the
+// last one holding the move. Only the move publishes an event.
+func (d *dynamoRepository) Move(ctx context.Context, id primitive.ObjectID, p boardPlace) ([]primitive.ObjectID, error) {
+	c := slices.Index(boardColumns, p.Column)
+	if c < 0 {
+		return nil, fmt.Errorf("unknown column %q", p.Column)
+	}
+	moved := []primitive.ObjectID{id}
+	var out struct {
+		Item dynamoItem `json:"Item"`
+	}
+	err := withRetry(ctx, func(ctx context.Context) error {
+		return d.call(ctx, "GetItem", map[string]interface{}{
+			"TableName":      d.table,
+			"Key":            d.key(ctx, id),
+			"ConsistentRead": true,
+		}, &out)
+	})
+	if err != nil {
+		return moved, err
+	}
+	if len(out.Item) == 0 || out.Item.str("list") != p.List {
+		return moved, errNotFound
+	}
+	var column []todoModel
+	err = d.query(ctx, dynamoBoard, func(tm todoModel) error {
+		if tm.ID != id && tm.List == p.List && columnOf(tm) == c {
+			column = append(column, tm)
+		}
+		return nil
+	})
+	if err != nil {
+		return moved, err
+	}
+	slices.SortFunc(column, boardOrder)
+
+	pos, renumbered := placeTodo(column, p.Index)
+	completed := c == len(boardColumns)-1
+	actions := make([]interface{}, 0, len(renumbered)+1)
+	for _, tm := range renumbered {
+		actions = append(actions, d.transactUpdate(ctx, tm.ID, "SET #position = :position", dynamoItem{":position": avN(tm.Position)}))
+		moved = append(moved, tm.ID)
+	}
+	actions = append(actions, d.transactUpdate(ctx, id, "SET #position = :position, #column = :column, completed = :completed", dynamoItem{
+		":position":  avN(pos),
+		":column":    avS(p.Column),
+		":completed": avBool(completed),
+	}))
+	for start := 0; start < len(actions); start += dynamoTransactSize {
+		in := map[string]interface{}{
+			"TransactItems": actions[start:min(start+dynamoTransactSize, len(actions))],
+			// Retries of the same transaction are then applied once.
+			"ClientRequestToken": primitive.NewObjectID().Hex(),
+		}
+		err := withRetry(ctx, func(ctx context.Context) error {
+			return d.call(ctx, "TransactWriteItems", in, nil)
+		})
+		if err := dynamoNotFound(err); err != nil {
+			return moved, err
+		}
+	}
+	out.Item["position"], out.Item["column"], out.Item["completed"] = avN(pos), avS(p.Column), avBool(completed)
+	d.notify(ctx, eventTodoUpdated, out.Item)
+	return moved, nil
+}
+
+// transactUpdate is an Update action of TransactWriteItems on the todo id,
+// which must exist. update may name #position and #column, both reserved
+// words.
+func (d *dynamoRepository) transactUpdate(ctx context.Context, id primitive.ObjectID, update string, values dynamoItem) map[string]interface{} {
+	names := map[string]string{"#position": "position"}
+	if strings.Contains(update, "#column") {
+		names["#column"] = "column"
+	}
+	return map[string]interface{}{
+		"Update": map[string]interface{}{
+			"TableName":                 d.table,
+			"Key":                       d.key(ctx, id),
+			"UpdateExpression":          update,
+			"ConditionExpression":       "attribute_exists(pk)",
+			"ExpressionAttributeNames":  names,
+			"ExpressionAttributeValues": values,
+		},
+	}
+}
+
+// query calls fn with the todos of the tenant with only the attributes of
+// projection, or all of them when nil, following LastEvaluatedKey until
+// the partition is exhausted.
+func (d *dynamoRepository) query(ctx context.Context, projection []string, fn func(todoModel) error) error {
+	in := map[string]interface{}{
+		"TableName":              d.table,
+		"KeyConditionExpression": "pk = :pk AND begins_with(sk, :sk)",
+		"ExpressionAttributeValues": dynamoItem{
+			":pk": avS(d.partition(ctx)),
+			":sk": avS(dynamoTodoPrefix),
+		},
+	}
+	if projection != nil {
+		// Names go through placeholders, as some are reserved words.
+		names := map[string]string{}
+		placeholders := make([]string, len(projection))
+		for i, name := range projection {
+			placeholders[i] = "#" + name
+			names["#"+name] = name
+		}
+		in["ProjectionExpression"] = strings.Join(placeholders, ", ")
+		in["ExpressionAttributeNames"] = names
+	}
+
+	for {
+		var out struct {
+			Items            []dynamoItem `json:"Items"`
+			LastEvaluatedKey dynamoItem   `json:"LastEvaluatedKey"`
+		}
+		err := withRetry(ctx, func(ctx context.Context) error {
+			return d.call(ctx, "Query", in, &out)
+		})
+		if err != nil {
+			return err
+		}
+		for _, item := range out.Items {
+			tm, err := itemToTodo(item)
+			if err != nil {
+				return err
+			}
+			if err = decryptContent(&tm); err != nil {
+				return err
+			}
+			if err := fn(tm); err != nil {
+				return err
+			}
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		in["ExclusiveStartKey"] = out.LastEvaluatedKey
+	}
+}
+
+func (d *dynamoRepository) Create(ctx context.Context, tm todoModel) error {
+	item, err := d.todoItem(ctx, tm)
+	if err != nil {
+		return err
+	}
+
+	attempt := 0
+	err = withRetry(ctx, func(ctx context.Context) error {
+		attempt++
+		err := d.call(ctx, "PutItem", map[string]interface{}{
+			"TableName":           d.table,
+			"Item":                item,
+			"ConditionExpression": "attribute_not_exists(pk)",
+		}, nil)
+		var de *dynamoError
+		if attempt > 1 && errors.As(err, &de) && de.is("ConditionalCheckFailedException") {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	d.notify(ctx, eventTodoCreated, item)
+	return nil
+}
+
+// CreateMany writes todos with BatchWriteItem, resubmitting unprocessed
+// items with backoff.
+func (d *dynamoRepository) CreateMany(ctx context.Context, tms []todoModel) error {
+	for start := 0; start < len(tms); start += dynamoBatchWriteSize {
+		end := min(start+dynamoBatchWriteSize, len(tms))
+		items := make([]dynamoItem, 0, end-start)
+		requests := make([]interface{}, 0, end-start)
+		for _, tm := range tms[start:end] {
+			item, err := d.todoItem(ctx, tm)
+			if err != nil {
+				return err
+			}
+			items = append(items, item)
+			requests = append(requests, map[string]interface{}{"PutRequest": map[string]interface{}{"Item": item}})
+		}
+
+		pending := map[string][]interface{}{d.table: requests}
+		for attempt := 0; len(pending[d.table]) > 0; attempt++ {
+			if attempt > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(backoff(attempt)):
+				}
+			}
+			var out struct {
+				UnprocessedItems map[string][]interface{} `json:"UnprocessedItems"`
+			}
+			err := withRetry(ctx, func(ctx context.Context) error {
+				return d.call(ctx, "BatchWriteItem", map[string]interface{}{"RequestItems": pending}, &out)
+			})
+			if err != nil {
+				return err
+			}
+			pending = out.UnprocessedItems
+		}
+		for _, item := range items {
+			d.notify(ctx, eventTodoCreated, item)
+		}
+	}
+	return nil
+}
+
+func (d *dynamoRepository) Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error {
+	var sets []string
+	names := map[string]string{}
+	values := dynamoItem{}
+	if err := encryptUpdate(id, fields); err != nil {
+		return err
+	}
+	i := 0
+	for k, v := range fields {
+		av, err := toAttrValue(v)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", k, err)
+		}
+		n, p := "#f"+strconv.Itoa(i), ":v"+strconv.Itoa(i)
+		names[n] = k
+		values[p] = av
+		sets = append(sets, n+" = "+p)
+		i++
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+
+	var out struct {
+		Attributes dynamoItem `json:"Attributes"`
+	}
+	err := withRetry(ctx, func(ctx context.Context) error {
+		return d.call(ctx, "UpdateItem", map[string]interface{}{
+			"TableName":                 d.table,
+			"Key":                       d.key(ctx, id),
+			"UpdateExpression":          "SET " + strings.Join(sets, ", "),
+			"ConditionExpression":       "attribute_exists(pk)",
+			"ExpressionAttributeNames":  names,
+			"ExpressionAttributeValues": values,
+			"ReturnValues":              "ALL_NEW",
+		}, &out)
+	})
+	if err := dynamoNotFound(err); err != nil {
+		return err
+	}
+	d.notify(ctx, eventTodoUpdated, out.Attributes)
+	return nil
+}
+
+// Upsert writes title and completed unconditionally and keeps created_at of
+// an existing item. The old item tells whether it was created.
+func (d *dynamoRepository) Upsert(ctx context.Context, tm todoModel) (created bool, err error) {
+	item, err := d.todoItem(ctx, tm)
+	if err != nil {
+		return false, err
+	}
+	sets := []string{"#title = :title", "completed = :completed", "id = :id", "created_at = if_not_exists(created_at, :created_at)"}
+	values := dynamoItem{
+		":title":      item["title"],
+		":completed":  item["completed"],
+		":id":         item["id"],
+		":created_at": item["created_at"],
+	}
+	if av, ok := item["tenant_id"]; ok {
+		sets = append(sets, "tenant_id = :tenant_id")
+		values[":tenant_id"] = av
+	}
+
+	var out struct {
+		Attributes dynamoItem `json:"Attributes"`
+	}
+	err = withRetry(ctx, func(ctx context.Context) error {
+		return d.call(ctx, "UpdateItem", map[string]interface{}{
+			"TableName":                 d.table,
+			"Key":                       d.key(ctx, tm.ID),
+			"UpdateExpression":          "SET " + strings.Join(sets, ", "),
+			"ExpressionAttributeNames":  map[string]string{"#title": "title"},
+			"ExpressionAttributeValues": values,
+			"ReturnValues":              "ALL_OLD",
+		}, &out)
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(out.Attributes) == 0 {
+		d.notify(ctx, eventTodoCreated, item)
+		return true, nil
+	}
+	item["created_at"] = out.Attributes["created_at"]
+	d.notify(ctx, eventTodoUpdated, item)
+	return false, nil
+}
+
+func (d *dynamoRepository) Delete(ctx context.Context, id primitive.ObjectID) (todoModel, error) {
+	var out struct {
+		Attributes dynamoItem `json:"Attributes"`
+	}
+	err := withRetry(ctx, func(ctx context.Context) error {
+		return d.call(ctx, "DeleteItem", map[string]interface{}{
+			"TableName":           d.table,
+			"Key":                 d.key(ctx, id),
+			"ConditionExpression": "attribute_exists(pk)",
+			"ReturnValues":        "ALL_OLD",
+		}, &out)
+	})
+	if err := dynamoNotFound(err); err != nil {
+		return todoModel{}, err
+	}
+	d.notify(ctx, eventTodoDeleted, out.Attributes)
+	return itemToTodo(out.Attributes)
+}
+
+// notify publishes a change of item, as stored, see notifyChange.
+func (d *dynamoRepository) notify(ctx context.Context, eventType string, item dynamoItem) {
+	tm, err := itemToTodo(item)
+	if err != nil {
+		slog.Error("could not publish event", "event", eventType, "error", err)
+		return
+	}
+	notifyChange(ctx, eventType, tm)
+}
+
+// ListArchived is not supported, todos are never archived in DynamoDB.
+func (d *dynamoRepository) ListArchived(ctx context.Context) ([]todoModel, error) {
+	return nil, errUnsupported
+}
+
+// Stats needs aggregations DynamoDB does not offer.
+func (d *dynamoRepository) Stats(ctx context.Context, since time.Time, loc *time.Location) (todoStats, error) {
+	return todoStats{}, errUnsupported
+}
+
+func (d *dynamoRepository) AddAttachment(ctx context.Context, id primitive.ObjectID, a attachment) error {
+	empty := []attrValue{}
+	err := withRetry(ctx, func(ctx context.Context) error {
+		return d.call(ctx, "UpdateItem", map[string]interface{}{
+			"TableName":           d.table,
+			"Key":                 d.key(ctx, id),
+			"UpdateExpression":    "SET attachments = list_append(if_not_exists(attachments, :empty), :a)",
+			"ConditionExpression": "attribute_exists(pk) AND (attribute_not_exists(attachments) OR size(attachments) < :max)",
+			"ExpressionAttributeValues": dynamoItem{
+				":empty": {L: &empty},
+				":a":     {L: &[]attrValue{attachmentToAttr(a)}},
+				":max":   avN(maxAttachmentsPerTodo),
+			},
+		}, nil)
+	})
+	return dynamoNotFound(err)
+}
+
+func (d *dynamoRepository) Attachment(ctx context.Context, id primitive.ObjectID, aid string) (attachment, error) {
+	tm, err := d.get(ctx, id)
+	if err != nil {
+		return attachment{}, err
+	}
+	for _, a := range tm.Attachments {
+		if a.ID == aid {
+			return a, nil
+		}
+	}
+	return attachment{}, errNotFound
+}
+
+// RemoveAttachment removes the attachment by its list index, guarded by a
+// condition on its ID in case the list changed since it was read.
+func (d *dynamoRepository) RemoveAttachment(ctx context.Context, id primitive.ObjectID, aid string) (attachment, error) {
+	tm, err := d.get(ctx, id)
+	if err != nil {
+		return attachment{}, err
+	}
+	for i, a := range tm.Attachments {
+		if a.ID != aid {
+			continue
+		}
+		path := "attachments[" + strconv.Itoa(i) + "]"
+		err := withRetry(ctx, func(ctx context.Context) error {
+			return d.call(ctx, "UpdateItem", map[string]interface{}{
+				"TableName":                 d.table,
+				"Key":                       d.key(ctx, id),
+				"UpdateExpression":          "REMOVE " + path,
+				"ConditionExpression":       path + ".id = :aid",
+				"ExpressionAttributeValues": dynamoItem{":aid": avS(aid)},
+			}, nil)
+		})
+		return a, dynamoNotFound(err)
+	}
+	return attachment{}, errNotFound
+}
+
+func (d *dynamoRepository) get(ctx context.Context, id primitive.ObjectID) (todoModel, error) {
+	var out struct {
+		Item dynamoItem `json:"Item"`
+	}
+	err := withRetry(ctx, func(ctx context.Context) error {
+		return d.call(ctx, "GetItem", map[string]interface{}{
+			"TableName":      d.table,
+			"Key":            d.key(ctx, id),
+			"ConsistentRead": true,
+		}, &out)
+	})
+	if err != nil {
+		return todoModel{}, err
+	}
+	if len(out.Item) == 0 {
+		return todoModel{}, errNotFound
+	}
+	return itemToTodo(out.Item)
+}
+
+// todoItem converts tm to an item of the tenant of ctx, encrypting fields.
+func (d *dynamoRepository) todoItem(ctx context.Context, tm todoModel) (dynamoItem, error) {
+	if err := encryptContent(&tm); err != nil {
+		return nil, err
+	}
+	item := d.key(ctx, tm.ID)
+	item["id"] = avS(tm.ID.Hex())
+	item["title"] = avS(tm.Title)
+	item["completed"] = avBool(tm.Completed)
+	item["created_at"] = avS(tm.CreatedAt.UTC().Format(time.RFC3339Nano))
+	if tenant := tenantFrom(ctx); tenant != "" {
+		item["tenant_id"] = avS(tenant)
+	}
+	if tm.Description != "" {
+		item["description"] = avS(tm.Description)
+	}
+	if tm.List != "" {
+		item["list"] = avS(tm.List)
+	}
+	if len(tm.Labels) > 0 {
+		labels := make([]attrValue, len(tm.Labels))
+		for i, l := range tm.Labels {
+			labels[i] = avS(l)
+		}
+		item["labels"] = attrValue{L: &labels}
+	}
+	if tm.Priority != 0 {
+		item["priority"] = avN(tm.Priority)
+	}
+	if tm.DueAt != nil {
+		item["due_at"] = avS(tm.DueAt.UTC().Format(time.RFC3339Nano))
+	}
+	if tm.StartDate != nil {
+		item["start_date"] = avS(tm.StartDate.UTC().Format(time.RFC3339Nano))
+	}
+	if len(tm.DependsOn) > 0 {
+		deps := make([]attrValue, len(tm.DependsOn))
+		for i, id := range tm.DependsOn {
+			deps[i] = avS(id.Hex())
+		}
+		item["depends_on"] = attrValue{L: &deps}
+	}
+	if tm.Recurrence != "" {
+		item["recurrence"] = avS(tm.Recurrence)
+	}
+	if tm.Source != "" {
+		item["source"] = avS(tm.Source)
+	}
+	if tm.Column != "" {
+		item["column"] = avS(tm.Column)
+	}
+	if tm.Position != 0 {
+		item["position"] = avN(tm.Position)
+	}
+	return item, nil
+}
+
+func itemToTodo(item dynamoItem) (todoModel, error) {
+	id, err := primitive.ObjectIDFromHex(item.str("id"))
+	if err != nil {
+		return todoModel{}, fmt.Errorf("invalid todo item id: %w", err)
+	}
+	createdAt, _ := time.Parse(time.RFC3339Nano, item.str("created_at"))
+	tm := todoModel{
+		ID:          id,
+		Title:       item.str("title"),
+		Completed:   item.boolean("completed"),
+		CreatedAt:   createdAt,
+		TenantID:    item.str("tenant_id"),
+		List:        item.str("list"),
+		Source:      item.str("source"),
+		Description: item.str("description"),
+		Recurrence:  item.str("recurrence"),
+	}
+	if l := item["labels"].L; l != nil {
+		for _, av := range *l {
+			if av.S != nil {
+				tm.Labels = append(tm.Labels, *av.S)
+			}
+		}
+	}
+	tm.Priority, _ = strconv.Atoi(item.num("priority"))
+	tm.Column = item.str("column")
+	tm.Position, _ = strconv.ParseInt(item.num("position"), 10, 64)
+	if due, err := time.Parse(time.RFC3339Nano, item.str("due_at")); err == nil {
+		tm.DueAt = &due
+	}
+	if start, err := time.Parse(time.RFC3339Nano, item.str("start_date")); err == nil {
+		tm.StartDate = &start
+	}
+	if l := item["depends_on"].L; l != nil {
+		for _, av := range *l {
+			if av.S == nil {
+				continue
+			}
+			if id, err := primitive.ObjectIDFromHex(*av.S); err == nil {
+				tm.DependsOn = append(tm.DependsOn, id)
+			}
+		}
+	}
+	if l := item["attachments"].L; l != nil {
+		for _, av := range *l {
+			tm.Attachments = append(tm.Attachments, attrToAttachment(av.M))
+		}
+	}
+	return tm, nil
+}
+
+func attachmentToAttr(a attachment) attrValue {
+	return attrValue{M: dynamoItem{
+		"id":           avS(a.ID),
+		"name":         avS(a.Name),
+		"content_type": avS(a.ContentType),
+		"size":         avN(a.Size),
+		"key":          avS(a.Key),
+		"created_at":   avS(a.CreatedAt.UTC().Format(time.RFC3339Nano)),
+	}}
+}
+
+func attrToAttachment(m dynamoItem) attachment {
+	size, _ := strconv.ParseInt(m.num("size"), 10, 64)
+	createdAt, _ := time.Parse(time.RFC3339Nano, m.str("created_at"))
+	return attachment{
+		ID:          m.str("id"),
+		Name:        m.str("name"),
+		ContentType: m.str("content_type"),
+		Size:        size,
+		Key:         m.str("key"),
+		CreatedAt:   createdAt,
+	}
+}
+
+func (item dynamoItem) str(name string) string {
+	if v := item[name].S; v != nil {
+		return *v
+	}
+	return ""
+}
+
+func (item dynamoItem) num(name string) string {
+	if v := item[name].N; v != nil {
+		return *v
+	}
+	return ""
+}
+
+func (item dynamoItem) boolean(name string) bool {
+	if v := item[name].BOOL; v != nil {
+		return *v
+	}
+	return false
+}
+
+func avS(s string) attrValue { return attrValue{S: &s} }
+
+func avBool(b bool) attrValue { return attrValue{BOOL: &b} }
+
+func avN[T int | int64](n T) attrValue {
+	s := strconv.FormatInt(int64(n), 10)
+	return attrValue{N: &s}
+}
+
+func toAttrValue(v interface{}) (attrValue, error) {
+	switch v := v.(type) {
+	case string:
+		return avS(v), nil
+	case bool:
+		return avBool(v), nil
+	case int:
+		return avN(v), nil
+	case int64:
+		return avN(v), nil
+	case time.Time:
+		return avS(v.UTC().Format(time.RFC3339Nano)), nil
+	}
+	return attrValue{}, fmt.Errorf("unsupported value type %T", v)
+}
+
+// dynamoNotFound maps failed existence conditions to errNotFound.
+func dynamoNotFound(err error) error {
+	var de *dynamoError
+	if errors.As(err, &de) && (de.is("ConditionalCheckFailedException") ||
+		de.is("TransactionCanceledException") && strings.Contains(de.Message, "ConditionalCheckFailed")) {
+		return errNotFound
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeDynamo answers DynamoDB operations with handle, by operation name.
+func fakeDynamo(t *testing.T, handle func(op string, in map[string]json.RawMessage) (int, string)) *dynamoRepository {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			t.Errorf("request body: %v", err)
+		}
+		status, body := handle(strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810."), in)
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return &dynamoRepository{
+		endpoint: srv.URL,
+		region:   "eu-west-1",
+		table:    "todos",
+		creds:    awsCredentials{AccessKey: "AKID", SecretKey: "secret", SessionToken: "token"},
+		http:     srv.Client(),
+	}
+}
+
+func TestDynamoCall(t *testing.T) {
+	var req *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		body, _ = io.ReadAll(r.Body)
+		if r.Header.Get("X-Amz-Target") == "DynamoDB_20120810.PutItem" {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException","message":"The conditional request failed"}`)
+			return
+		}
+		io.WriteString(w, `{"Item":{"id":{"S":"1"}}}`)
+	}))
+	defer srv.Close()
+
+	d := &dynamoRepository{
+		endpoint: srv.URL,
+		region:   "eu-west-1",
+		table:    "todos",
+		creds:    awsCredentials{AccessKey: "AKID", SecretKey: "secret", SessionToken: "token"},
+		http:     srv.Client(),
+	}
+	var out struct{ Item dynamoItem }
+	if err := d.call(context.Background(), "GetItem", map[string]string{"TableName": "todos"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if s := out.Item["id"].S; s == nil || *s != "1" {
+		t.Errorf("item %v", out.Item)
+	}
+
+	hash := sha256.Sum256(body)
+	auth := req.Header.Get("Authorization")
+	if req.Header.Get("Content-Type") != "application/x-amz-json-1.0" ||
+		req.Header.Get("X-Amz-Target") != "DynamoDB_20120810.GetItem" ||
+		req.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(hash[:]) ||
+		req.Header.Get("X-Amz-Security-Token") != "token" {
+		t.Errorf("headers %v", req.Header)
+	}
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/"+time.Now().UTC().Format("20060102")+"/eu-west-1/dynamodb/aws4_request, ") ||
+		!strings.Contains(auth, "SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date;x-amz-security-token;x-amz-target, ") {
+		t.Errorf("authorization %s", auth)
+	}
+
+	err := d.call(context.Background(), "PutItem", map[string]string{"TableName": "todos"}, nil)
+	var de *dynamoError
+	if !errors.As(err, &de) || !de.is("ConditionalCheckFailedException") || de.Message != "The conditional request failed" || de.Transient() {
+		t.Errorf("PutItem error %#v", err)
+	}
+}
+
+func TestDynamoCreate(t *testing.T) {
+	var stored dynamoItem
+	var condition string
+	d := fakeDynamo(t, func(op string, in map[string]json.RawMessage) (int, string) {
+		if op != "PutItem" {
+			t.Errorf("unexpected %s", op)
+			return http.StatusBadRequest, `{}`
+		}
+		json.Unmarshal(in["Item"], &stored)
+		json.Unmarshal(in["ConditionExpression"], &condition)
+		return http.StatusOK, `{}`
+	})
+
+	tm := todoModel{ID: primitive.NewObjectID(), Title: "Buy milk", CreatedAt: time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)}
+	if err := d.Create(withTenant(context.Background(), "acme"), tm); err != nil {
+		t.Fatal(err)
+	}
+	if stored.str("pk") != "TENANT#acme" || stored.str("sk") != dynamoTodoPrefix+tm.ID.Hex() || stored.str("tenant_id") != "acme" {
+		t.Errorf("stored under %s %s for tenant %q", stored.str("pk"), stored.str("sk"), stored.str("tenant_id"))
+	}
+	if condition != "attribute_not_exists(pk)" {
+		t.Errorf("condition %q, want the create to fail on an existing todo", condition)
+	}
+	got, err := itemToTodo(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != tm.ID || got.Title != tm.Title || got.Completed || !got.CreatedAt.Equal(tm.CreatedAt) || got.TenantID != "acme" {
+		t.Errorf("read back %+v, want %+v", got, tm)
+	}
+}
+
+func TestDynamoRetry(t *testing.T) {
+	calls := 0
+	d := fakeDynamo(t, func(op string, in map[string]json.RawMessage) (int, string) {
+		calls++
+		if calls == 1 {
+			return http.StatusBadRequest, `{"__type":"com.amazonaws.dynamodb.v20120810#ProvisionedThroughputExceededException","message":"slow down"}`
+		}
+		// The first attempt went through after all.
+		return http.StatusBadRequest, `{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException"}`
+	})
+	if err := d.Create(context.Background(), todoModel{ID: primitive.NewObjectID(), Title: "Buy milk"}); err != nil {
+		t.Errorf("retried create: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("%d calls, want a throttled one and its retry", calls)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	emailAddressesCollectionName = "email_addresses"
+
+	maxInboundEmailSize = 50 << 20
+	mailgunMaxSkew      = 15 * time.Minute
+)
+
+var (
+	emailDomain       string
+	mailgunSigningKey string
+)
+
+// loadEmailConfig enables creating todos by email when TODO_EMAIL_DOMAIN,
+// the domain inbound mail is routed to, is set. Mail is received from a
+// Mailgun route forwarding to POST /email/inbound, signed with
+// TODO_MAILGUN_SIGNING_KEY.
+func loadEmailConfig() error {
+	emailDomain = strings.ToLower(os.Getenv("TODO_EMAIL_DOMAIN"))
+	mailgunSigningKey = os.Getenv("TODO_MAILGUN_SIGNING_KEY")
+	if emailDomain == "" {
+		return nil
+	}
+	if mailgunSigningKey == "" {
+		return errors.New("TODO_EMAIL_DOMAIN requires TODO_MAILGUN_SIGNING_KEY")
+	}
+	if !usingMongo() {
+		return errors.New("creating todos by email requires the mongo store")
+	}
+	return nil
+}
+
+func emailEnabled() bool {
+	return emailDomain != ""
+}
+
+// emailAddress is the secret inbound address of a tenant,
+// <ID>@TODO_EMAIL_DOMAIN.
+type emailAddress struct {
+	ID        string    `bson:"_id"`
+	TenantID  string    `bson:"tenant_id"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+func emailAddressesCollection() *mongo.Collection {
+	return db.Collection(emailAddressesCollectionName, writeCollectionOpts)
+}
+
+func ensureEmailIndexes(ctx context.Context) error {
+	if !emailEnabled() {
+		return nil
+	}
+	_, err := emailAddressesCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "tenant_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func (a emailAddress) String() string {
+	return a.ID + "@" + emailDomain
+}
+
+func emailHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(requireStore)
+	rg.Use(requireEmail)
+	rg.Post("/inbound", receiveEmail)
+	rg.Group(func(r chi.Router) {
+		r.Use(tenantMiddleware)
+		r.Get("/address", fetchEmailAddress)
+		r.Post("/address", rotateEmailAddress)
+	})
+	return rg
+}
+
+func requireEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !emailEnabled() {
+			rnd.JSON(w, http.StatusNotImplemented, renderer.M{"message": "creating todos by email is not configured", "error": "not implemented"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fetchEmailAddress returns the inbound address of the tenant, creating it
+// on first use.
+func fetchEmailAddress(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	var a emailAddress
+	err := emailAddressesCollection().FindOne(ctx, bson.M{"tenant_id": tenantFrom(ctx)}).Decode(&a)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		a, err = newEmailAddress(ctx)
+		if mongo.IsDuplicateKeyError(err) {
+			// Created concurrently.
+			err = emailAddressesCollection().FindOne(ctx, bson.M{"tenant_id": tenantFrom(ctx)}).Decode(&a)
+		}
+	}
+	if err != nil {
+		storeErr(w, r, "could not fetch email address", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"address": a.String()})
+}
+
+// rotateEmailAddress replaces the inbound address of the tenant, for when
+// the old one leaked. Mail to the old address is rejected.
+func rotateEmailAddress(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	_, err := emailAddressesCollection().DeleteOne(ctx, bson.M{"tenant_id": tenantFrom(ctx)})
+	var a emailAddress
+	if err == nil {
+		a, err = newEmailAddress(ctx)
+	}
+	if err != nil {
+		storeErr(w, r, "could not rotate email address", err)
+		return
+	}
+	rnd.JSON(w, http.StatusCreated, renderer.M{"address": a.String()})
+}
+
+func newEmailAddress(ctx context.Context) (emailAddress, error) {
+	b := make([]byte, 15)
+	rand.Read(b)
+	a := emailAddress{
+		ID:        strings.ToLower(base32.StdEncoding.EncodeToString(b)),
+		TenantID:  tenantFrom(ctx),
+		CreatedAt: time.Now(),
+	}
+	_, err := emailAddressesCollection().InsertOne(ctx, a)
+	return a, err
+}
+
+// receiveEmail turns a message forwarded by a Mailgun route into a todo of
+// the tenant it was addressed to: the subject becomes the title, the text
+// body the description and attachments are stored when object storage is
+// configured. Mailgun does not retry a 406, which answers unknown
+// recipients.
+func receiveEmail(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxInboundEmailSize)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid message", "error": err.Error()})
+		return
+	}
+	if r.MultipartForm != nil {
+		defer r.MultipartForm.RemoveAll()
+	}
+	if !validMailgunSignature(r.PostFormValue("timestamp"), r.PostFormValue("token"), r.PostFormValue("signature")) {
+		rnd.JSON(w, http.StatusUnauthorized, renderer.M{"message": "invalid signature", "error": "unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	tenant, ok, err := emailTenant(ctx, r.PostFormValue("recipient"))
+	if err != nil {
+		storeErr(w, r, "could not look up recipient", err)
+		return
+	}
+	if !ok {
+		rnd.JSON(w, http.StatusNotAcceptable, renderer.M{"message": "unknown recipient", "error": "not acceptable"})
+		return
+	}
+	ctx = withTenant(ctx, tenant)
+
+	title := strings.Join(strings.Fields(r.PostFormValue("subject")), " ")
+	if title == "" {
+		title = "(no subject)"
+	}
+	body := r.PostFormValue("stripped-text")
+	if body == "" {
+		body = r.PostFormValue("body-plain")
+	}
+	tm := todoModel{
+		ID:          primitive.NewObjectID(),
+		Title:       truncate(title, maxTitleLength),
+		Description: truncate(strings.TrimSpace(body), maxDescriptionLength),
+		CreatedAt:   time.Now(),
+		Source:      "email",
+	}
+	if id := r.PostFormValue("Message-Id"); id != "" {
+		tm.Source = "email:" + id
+	}
+	if err := repo.Create(ctx, tm); err != nil {
+		storeErr(w, r, "could not create todo", err)
+		return
+	}
+
+	stored, skipped := 0, 0
+	if r.MultipartForm != nil {
+		for _, files := range r.MultipartForm.File {
+			for _, fh := range files {
+				if err := storeEmailAttachment(ctx, tm.ID, fh); err != nil {
+					slog.Error("attachment not stored", "component", "email", "file", fh.Filename, "todo", tm.ID.Hex(), "error", err)
+					skipped++
+					continue
+				}
+				stored++
+			}
+		}
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"todo_id": tm.ID, "attachments": stored, "attachments_skipped": skipped})
+}
+
+// emailTenant finds the tenant of the first recipient addressed to
+// TODO_EMAIL_DOMAIN. A +suffix on the local part is ignored.
+func emailTenant(ctx context.Context, recipients string) (string, bool, error) {
+	for _, rcpt := range strings.Split(recipients, ",") {
+		local, domain, ok := strings.Cut(strings.ToLower(strings.TrimSpace(rcpt)), "@")
+		if !ok || domain != emailDomain {
+			continue
+		}
+		local, _, _ = strings.Cut(local, "+")
+		var a emailAddress
+		err := emailAddressesCollection().FindOne(ctx, bson.M{"_id": local}).Decode(&a)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return "", false, err
+		}
+		return a.TenantID, true, nil
+	}
+	return "", false, nil
+}
+
+func storeEmailAttachment(ctx context.Context, todoID primitive.ObjectID, fh *multipart.FileHeader) error {
+	if blobs == nil {
+		return errors.New("object storage is not configured")
+	}
+	if fh.Size > maxAttachmentSize {
+		return errors.New("larger than " + strconv.FormatInt(maxAttachmentSize, 10) + " bytes")
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	aid := primitive.NewObjectID().Hex()
+	a := attachment{
+		ID:          aid,
+		Name:        truncate(fh.Filename, 255),
+		ContentType: fh.Header.Get("Content-Type"),
+		Size:        int64(len(data)),
+		Key:         blobKey(ctx, todoID, aid),
+		CreatedAt:   time.Now(),
+	}
+	if a.Name == "" {
+		a.Name = "attachment"
+	}
+	if err := blobs.Put(ctx, a.Key, a.ContentType, data); err != nil {
+		return err
+	}
+	if err := repo.AddAttachment(ctx, todoID, a); err != nil {
+		deleteBlobs([]attachment{a})
+		return err
+	}
+	return nil
+}
+
+// validMailgunSignature checks the HMAC-SHA256 of timestamp and token
+// with the webhook signing key and rejects stale timestamps.
+func validMailgunSignature(timestamp, token, signature string) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if d := time.Since(time.Unix(ts, 0)); d > mailgunMaxSkew || d < -mailgunMaxSkew {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(mailgunSigningKey))
+	mac.Write([]byte(timestamp + token))
+	return hmac.Equal([]byte(signature), []byte(hex.EncodeToString(mac.Sum(nil))))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errValidation is wrapped by errors about a todo the store refused as
+// invalid, so they are answered 400 rather than 500.
+var errValidation = errors.New("invalid todo")
+
+// mongoDocumentValidationFailure is the code of writes rejected by the
+// collection's JSON schema, see schema.go.
+const mongoDocumentValidationFailure = 121
+
+// errorKind is a class of store error: the status it is answered with and
+// a stable code for clients and the logs. Only not_found, validation and
+// unsupported errors are shown to clients as they are, the others get a
+// fixed description so driver errors do not leak into responses.
+type errorKind struct {
+	code   string
+	status int
+	detail string
+}
+
+var (
+	kindNotFound    = errorKind{"not_found", http.StatusNotFound, ""}
+	kindValidation  = errorKind{"validation", http.StatusBadRequest, ""}
+	kindConflict    = errorKind{"conflict", http.StatusConflict, "id already in use"}
+	kindUnsupported = errorKind{"unsupported", http.StatusNotImplemented, ""}
+	kindTimeout     = errorKind{"timeout", http.StatusGatewayTimeout, "the database took too long to answer"}
+	kindBreakerOpen = errorKind{"unavailable", http.StatusServiceUnavailable, "database temporarily unavailable"}
+	kindUnavailable = errorKind{"unavailable", http.StatusServiceUnavailable, "database temporarily unavailable"}
+	// 499 is the status nginx logs when the client went away first.
+	kindCanceled = errorKind{"canceled", 499, "the request was canceled"}
+	kindStorage  = errorKind{"storage", http.StatusInternalServerError, "the database failed to complete the request"}
+)
+
+// classify returns the kind of err.
+func classify(err error) errorKind {
+	var se mongo.ServerError
+	switch {
+	case errors.Is(err, errNotFound):
+		return kindNotFound
+	case errors.Is(err, errValidation),
+		errors.As(err, &se) && se.HasErrorCode(mongoDocumentValidationFailure):
+		return kindValidation
+	case mongo.IsDuplicateKeyError(err):
+		return kindConflict
+	case errors.Is(err, errUnsupported):
+		return kindUnsupported
+	case errors.Is(err, context.DeadlineExceeded):
+		return kindTimeout
+	case errors.Is(err, errBreakerOpen):
+		return kindBreakerOpen
+	case isTransient(err):
+		return kindUnavailable
+	case errors.Is(err, context.Canceled):
+		return kindCanceled
+	default:
+		return kindStorage
+	}
+}
+
+// describe returns what clients are told about err, of kind k.
+func (k errorKind) describe(err error) string {
+	if k.detail != "" {
+		return k.detail
+	}
+	return err.Error()
+}
+
+// storeErr answers a failed store call with the status and code of its
+// kind, and records the error itself for the access log line of r.
+func storeErr(w http.ResponseWriter, r *http.Request, message string, err error) {
+	k := classify(err)
+	noteError(r.Context(), k.code, err)
+	if k == kindBreakerOpen {
+		w.Header().Set("Retry-After", strconv.Itoa(int(breaker.retryAfter().Seconds())))
+	}
+	rnd.JSON(w, k.status, renderer.M{"message": message, "error": k.describe(err), "code": k.code})
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	eventBusTimeout  = 10 * time.Second
+	natsDefaultPort  = "4222"
+	kafkaContentType = "application/vnd.kafka.json.v2+json"
+)
+
+// loadEventBusConfig registers a publisher for TODO_EVENT_BUS:
+//
+//	kafka  produces through a Kafka REST Proxy at TODO_EVENT_BUS_URL
+//	nats   publishes to NATS JetStream at TODO_EVENT_BUS_URL
+//
+// TODO_EVENT_TOPIC names the topic or subject and may contain {type} and
+// {tenant}. Events go through the outbox, which retries until the broker
+// acknowledges them, so delivery is at least once. Consumers deduplicate
+// on the event id.
+func loadEventBusConfig() error {
+	bus := os.Getenv("TODO_EVENT_BUS")
+	if bus == "" {
+		return nil
+	}
+	raw := os.Getenv("TODO_EVENT_BUS_URL")
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid TODO_EVENT_BUS_URL %q", raw)
+	}
+	topic := envOr("TODO_EVENT_TOPIC", "todo.events")
+
+	var p eventPublisher
+	switch bus {
+	case "kafka":
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("TODO_EVENT_BUS_URL must be the http URL of a Kafka REST Proxy")
+		}
+		p = &kafkaRestPublisher{endpoint: u, topic: topic, http: &http.Client{Timeout: eventBusTimeout}}
+	case "nats":
+		n := &natsPublisher{subject: topic, inbox: "_INBOX." + primitive.NewObjectID().Hex()}
+		switch u.Scheme {
+		case "nats":
+		case "tls":
+			n.tls = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+		default:
+			return fmt.Errorf("unsupported NATS scheme %q", u.Scheme)
+		}
+		n.addr = hostPort(u, natsDefaultPort)
+		if u.User != nil {
+			n.user = u.User.Username()
+			n.pass, _ = u.User.Password()
+		}
+		p = n
+	default:
+		return fmt.Errorf("unknown event bus %q", bus)
+	}
+	if !outboxEnabled {
+		return errors.New("TODO_EVENT_BUS requires TODO_OUTBOX")
+	}
+	registerPublisher(p)
+	return nil
+}
+
+// eventTopic expands the {type} and {tenant} placeholders of topic.
+func eventTopic(topic string, ev todoEvent) string {
+	tenant := ev.TenantID
+	if tenant == "" {
+		tenant = "default"
+	}
+	return strings.NewReplacer("{type}", ev.Type, "{tenant}", tenant).Replace(topic)
+}
+
+// kafkaRestPublisher produces events through the Confluent REST Proxy v2
+// API, keyed by todo id so the events of a todo stay in one partition.
+type kafkaRestPublisher struct {
+	endpoint *url.URL
+	topic    string
+	http     *http.Client
+}
+
+func (k *kafkaRestPublisher) Name() string { return "kafka" }
+
+func (k *kafkaRestPublisher) Publish(ctx context.Context, ev todoEvent) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"records": []interface{}{map[string]interface{}{"key": ev.Todo.ID, "value": ev}},
+	})
+	if err != nil {
+		return err
+	}
+	u := *k.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/topics/" + url.PathEscape(eventTopic(k.topic, ev))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", kafkaContentType)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := k.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+		Message string `json:"message"`
+	}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	json.Unmarshal(raw, &out)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kafka rest proxy: %s %s", resp.Status, out.Message)
+	}
+	for _, o := range out.Offsets {
+		if o.ErrorCode != nil || o.Error != "" {
+			return fmt.Errorf("kafka rest proxy: %s", o.Error)
+		}
+	}
+	return nil
+}
+
+// natsPublisher publishes to JetStream over the NATS text protocol and
+// waits for the stream's PubAck. The event id is sent as Nats-Msg-Id, so
+// JetStream drops redeliveries within its duplicate window. The connection
+// is opened lazily and dropped on any error.
+type natsPublisher struct {
+	addr    string
+	tls     *tls.Config
+	user    string
+	pass    string
+	subject string
+	inbox   string
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (n *natsPublisher) Name() string { return "nats" }
+
+func (n *natsPublisher) Publish(ctx context.Context, ev todoEvent) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, eventBusTimeout)
+	defer cancel()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		if err := n.connect(ctx); err != nil {
+			return err
+		}
+	}
+	if err := n.publish(ctx, eventTopic(n.subject, ev), ev.ID, payload); err != nil {
+		n.close()
+		return err
+	}
+	return nil
+}
+
+func (n *natsPublisher) connect(ctx context.Context) error {
+	var conn net.Conn
+	var err error
+	if n.tls != nil {
+		conn, err = (&tls.Dialer{Config: n.tls}).DialContext(ctx, "tcp", n.addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", n.addr)
+	}
+	if err != nil {
+		return err
+	}
+	n.conn, n.r = conn, bufio.NewReader(conn)
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	line, err := n.r.ReadString('\n')
+	if err == nil && !strings.HasPrefix(line, "INFO ") {
+		err = fmt.Errorf("nats: unexpected greeting %q", strings.TrimSpace(line))
+	}
+	if err != nil {
+		n.close()
+		return err
+	}
+
+	connectOpts := map[string]interface{}{
+		"verbose":       false,
+		"pedantic":      false,
+		"headers":       true,
+		"no_responders": true,
+		"name":          "todo",
+		"lang":          "go",
+		"version":       "1.0.0",
+		"protocol":      1,
+	}
+	if n.user != "" {
+		connectOpts["user"], connectOpts["pass"] = n.user, n.pass
+	}
+	opts, _ := json.Marshal(connectOpts)
+	_, err = fmt.Fprintf(conn, "CONNECT %s\r\nSUB %s.* 1\r\nPING\r\n", opts, n.inbox)
+	if err == nil {
+		err = n.await(func(op string, _ []byte, _ []byte) (bool, error) { return op == "PONG", nil })
+	}
+	if err != nil {
+		n.close()
+		return err
+	}
+	return nil
+}
+
+func (n *natsPublisher) publish(ctx context.Context, subject, msgID string, payload []byte) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		n.conn.SetDeadline(deadline)
+	}
+	reply := n.inbox + "." + msgID
+	hdr := "NATS/1.0\r\nNats-Msg-Id: " + msgID + "\r\n\r\n"
+	_, err := fmt.Fprintf(n.conn, "HPUB %s %s %d %d\r\n%s%s\r\n", subject, reply, len(hdr), len(hdr)+len(payload), hdr, payload)
+	if err != nil {
+		return err
+	}
+
+	return n.await(func(op string, hdr, body []byte) (bool, error) {
+		if op != "MSG "+reply {
+			return false, nil
+		}
+		if bytes.HasPrefix(hdr, []byte("NATS/1.0 503")) {
+			return true, fmt.Errorf("nats: no stream for subject %s", subject)
+		}
+		var ack struct {
+			Error *struct {
+				Description string `json:"description"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(body, &ack); err != nil {
+			return true, fmt.Errorf("nats: invalid ack: %w", err)
+		}
+		if ack.Error != nil {
+			return true, fmt.Errorf("nats: %s", ack.Error.Description)
+		}
+		return true, nil
+	})
+}
+
+// await reads protocol messages, answering server pings, until done
+// reports true. Messages are passed as "MSG <subject>" with their headers
+// and body, other operations by name.
+func (n *natsPublisher) await(done func(op string, hdr, body []byte) (bool, error)) error {
+	for {
+		line, err := n.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var op string
+		var hdr, body []byte
+		switch fields[0] {
+		case "PING":
+			if _, err := io.WriteString(n.conn, "PONG\r\n"); err != nil {
+				return err
+			}
+			continue
+		case "-ERR":
+			return fmt.Errorf("nats: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		case "MSG", "HMSG":
+			// MSG <subject> <sid> [reply] <size>
+			// HMSG <subject> <sid> [reply] <header size> <total size>
+			hdrSize := 0
+			total, err := strconv.Atoi(fields[len(fields)-1])
+			if err == nil && fields[0] == "HMSG" {
+				hdrSize, err = strconv.Atoi(fields[len(fields)-2])
+			}
+			if err != nil || hdrSize > total {
+				return fmt.Errorf("nats: malformed %q", strings.TrimSpace(line))
+			}
+			data := make([]byte, total+2)
+			if _, err := io.ReadFull(n.r, data); err != nil {
+				return err
+			}
+			op, hdr, body = "MSG "+fields[1], data[:hdrSize], data[hdrSize:total]
+		default:
+			op = fields[0]
+		}
+
+		ok, err := done(op, hdr, body)
+		if ok || err != nil {
+			return err
+		}
+	}
+}
+
+func (n *natsPublisher) close() {
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn, n.r = nil, nil
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// subscriberBuffer is how many events a subscriber may fall behind
+	// before it is dropped. Clients reconnect and refetch after that.
+	subscriberBuffer = 64
+	// changeHistory is how many recent events are kept for subscribers
+	// resuming after a reconnect.
+	changeHistory = 1024
+)
+
+// change is an event with its position in the hub. Sequence numbers start
+// over when the process restarts, epoch tells the runs apart.
+type change struct {
+	Seq   uint64
+	Event todoEvent
+}
+
+// changeHub fans out the changes made through this process to live
+// subscribers such as WebSocket and SSE clients. Unlike the outbox it does
+// not see changes made by other instances and only remembers the last
+// changeHistory events.
+type changeHub struct {
+	mu      sync.Mutex
+	epoch   string
+	seq     uint64
+	history []change
+	subs    map[chan change]string
+}
+
+var changes = &changeHub{
+	epoch: primitive.NewObjectID().Hex(),
+	subs:  map[chan change]string{},
+}
+
+// subscribe returns a channel receiving the events of tenant. With after
+// set, events of the current epoch following that sequence number are
+// replayed first, and missed reports whether some of them are no longer
+// kept. The channel is closed by cancel, or by the hub when the subscriber
+// falls behind.
+func (h *changeHub) subscribe(tenant string, after uint64) (events <-chan change, missed bool, cancel func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var backlog []change
+	if after > 0 {
+		missed = after > h.seq || (len(h.history) > 0 && h.history[0].Seq > after+1)
+		for _, c := range h.history {
+			if c.Seq > after && c.Event.TenantID == tenant {
+				backlog = append(backlog, c)
+			}
+		}
+	}
+	ch := make(chan change, len(backlog)+subscriberBuffer)
+	for _, c := range backlog {
+		ch <- c
+	}
+	h.subs[ch] = tenant
+
+	return ch, missed, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *changeHub) publish(ev todoEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	c := change{Seq: h.seq, Event: ev}
+	if len(h.history) == changeHistory {
+		h.history = append(h.history[:0], h.history[1:]...)
+	}
+	h.history = append(h.history, c)
+
+	for ch, tenant := range h.subs {
+		if tenant != ev.TenantID {
+			continue
+		}
+		select {
+		case ch <- c:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// recent returns the last n events of every tenant kept by the hub, the
+// newest first.
+func (h *changeHub) recent(n int) []todoEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	events := make([]todoEvent, 0, min(n, len(h.history)))
+	for i := len(h.history) - 1; i >= 0 && len(events) < n; i-- {
+		events = append(events, h.history[i].Event)
+	}
+	return events
+}
+
+// notifyChange publishes a change of tm, as stored, to the hub. Stores call
+// it once the change is committed.
+func notifyChange(ctx context.Context, eventType string, tm todoModel) {
+	if err := decryptContent(&tm); err != nil {
+		slog.Error("could not publish event", "event", eventType, "todo", tm.ID.Hex(), "error", err)
+		return
+	}
+	changes.publish(todoEvent{
+		ID:         primitive.NewObjectID().Hex(),
+		Type:       eventType,
+		TenantID:   tenantFrom(ctx),
+		OccurredAt: time.Now(),
+		Todo:       toTodo(tm),
+	})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fragmentRoutes adds the endpoints HTMX calls from /web, which answer with
+// the table row of a todo in todos.tpl to swap in place of the old one.
+// Errors answer with the alerts block instead, retargeted to #alerts.
+func fragmentRoutes(r chi.Router) {
+	r.Post("/todos", createTodoFragment)
+	r.Get("/todos/{id}", todoRowFragment)
+	r.Put("/todos/{id}", saveTodoFragment)
+	r.Delete("/todos/{id}", deleteTodoFragment)
+	r.Get("/todos/{id}/edit", editTodoFragment)
+	r.Post("/todos/{id}/toggle", toggleTodoFragment)
+}
+
+// fragmentErr answers with msg, translated, in the alerts block of the
+// page.
+func fragmentErr(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	l := pageLocale(r.Context())
+	w.Header().Set("HX-Retarget", "#alerts")
+	w.Header().Set("HX-Reswap", "innerHTML")
+	renderBlock(w, status, "todos.tpl", "alerts", todosPage{locale: l, Error: l.lookup(msg)})
+}
+
+// fragmentStoreErr is storeErr for fragments.
+func fragmentStoreErr(w http.ResponseWriter, r *http.Request, message string, err error) {
+	k := classify(err)
+	noteError(r.Context(), k.code, err)
+	if k == kindBreakerOpen {
+		w.Header().Set("Retry-After", strconv.Itoa(int(breaker.retryAfter().Seconds())))
+	}
+	l := pageLocale(r.Context())
+	fragmentErr(w, r, k.status, l.lookup(message)+": "+l.lookup(k.describe(err)))
+}
+
+// fragmentID returns the todo ID of the path, answering 400 when invalid.
+func fragmentID(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		fragmentErr(w, r, http.StatusBadRequest, "Invalid todo id.")
+		return id, false
+	}
+	return id, true
+}
+
+func renderRow(w http.ResponseWriter, r *http.Request, status int, tm todoModel) {
+	renderBlock(w, status, "todos.tpl", "row", newTodoRow(r.Context(), tm))
+}
+
+// createTodoFragment creates a todo from the form of the page and answers
+// with its row, 202 when the create was queued.
+func createTodoFragment(w http.ResponseWriter, r *http.Request) {
+	form, err := readTodoForm(w, r)
+	if err != nil {
+		fragmentErr(w, r, http.StatusBadRequest, "The form could not be read.")
+		return
+	}
+	t, msg := form.todo(pagePreferences(r.Context()))
+	if msg == "" {
+		msg = validateNewTodo(t)
+	}
+	if msg != "" {
+		fragmentErr(w, r, http.StatusUnprocessableEntity, msg)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+	tm := newTodoModel(t)
+	queued, err := saveNewTodo(ctx, tm)
+	if err != nil {
+		fragmentStoreErr(w, r, "Could not create the todo", err)
+		return
+	}
+	if queued {
+		renderRow(w, r, http.StatusAccepted, tm)
+		return
+	}
+	renderRow(w, r, http.StatusCreated, tm)
+}
+
+// todoRowFragment answers with the row of a todo, such as when an edit is
+// canceled.
+func todoRowFragment(w http.ResponseWriter, r *http.Request) {
+	id, ok := fragmentID(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+	tm, err := repo.Get(ctx, id)
+	if err != nil {
+		fragmentStoreErr(w, r, "Could not fetch the todo", err)
+		return
+	}
+	renderRow(w, r, http.StatusOK, tm)
+}
+
+// editTodoFragment answers with the row of a todo as a form editing its
+// title.
+func editTodoFragment(w http.ResponseWriter, r *http.Request) {
+	id, ok := fragmentID(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+	tm, err := repo.Get(ctx, id)
+	if err != nil {
+		fragmentStoreErr(w, r, "Could not fetch the todo", err)
+		return
+	}
+	renderBlock(w, http.StatusOK, "todos.tpl", "edit-row", newTodoRow(r.Context(), tm))
+}
+
+// saveTodoFragment saves the title of the edit row, like PUT /todo/{id},
+// and answers with the row.
+func saveTodoFragment(w http.ResponseWriter, r *http.Request) {
+	id, ok := fragmentID(w, r)
+	if !ok {
+		return
+	}
+	form, err := readTodoForm(w, r)
+	if err != nil {
+		fragmentErr(w, r, http.StatusBadRequest, "The form could not be read.")
+		return
+	}
+	if msg := validateTitle(form.Title); msg != "" {
+		fragmentErr(w, r, http.StatusUnprocessableEntity, msg)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+	tm, err := repo.Get(ctx, id)
+	if err != nil {
+		fragmentStoreErr(w, r, "Could not fetch the todo", err)
+		return
+	}
+	if _, err := updateTodoFields(ctx, id, bson.M{"title": form.Title}); err != nil {
+		fragmentStoreErr(w, r, "Could not update the todo", err)
+		return
+	}
+	tm.Title = form.Title
+	renderRow(w, r, http.StatusOK, tm)
+}
+
+// toggleTodoFragment sets whether a todo is completed from its checkbox,
+// which is only sent when checked, and answers with the row.
+func toggleTodoFragment(w http.ResponseWriter, r *http.Request) {
+	id, ok := fragmentID(w, r)
+	if !ok {
+		return
+	}
+	form, err := readTodoForm(w, r)
+	if err != nil {
+		fragmentErr(w, r, http.StatusBadRequest, "The form could not be read.")
+		return
+	}
+	completed := form.Completed == "true"
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+	tm, err := repo.Get(ctx, id)
+	if err != nil {
+		fragmentStoreErr(w, r, "Could not fetch the todo", err)
+		return
+	}
+	if _, err := updateTodoFields(ctx, id, bson.M{"completed": completed}); err != nil {
+		fragmentStoreErr(w, r, "Could not update the todo", err)
+		return
+	}
+	tm.Completed = completed
+	renderRow(w, r, http.StatusOK, tm)
+}
+
+// deleteTodoFragment deletes a todo and answers with nothing, which
+// removes its row.
+func deleteTodoFragment(w http.ResponseWriter, r *http.Request) {
+	id, ok := fragmentID(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+	if _, err := removeTodo(ctx, id); err != nil {
+		fragmentStoreErr(w, r, "Could not delete the todo", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// gatewayTestServer serves the gateway from store behind tenantMiddleware,
+// as the API listener does, until the test ends. Closing stopping shuts
+// the watches down.
+func gatewayTestServer(t *testing.T, store todoStore) (url string, stopping chan struct{}) {
+	prevRepo, prevRnd := repo, rnd
+	repo, rnd = store, renderer.New()
+	t.Cleanup(func() { repo, rnd = prevRepo, prevRnd })
+
+	stopping = make(chan struct{})
+	gateway, err := gatewayHandlers(stopping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(tenantMiddleware(gateway))
+	t.Cleanup(srv.Close)
+	return srv.URL, stopping
+}
+
+// callGateway sends body to path with method and decodes the JSON answer
+// into v, returning the status.
+func callGateway(t *testing.T, method, url, body string, v any) int {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set(tenantHeader, "acme")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	return resp.StatusCode
+}
+
+func TestGateway(t *testing.T) {
+	url, _ := gatewayTestServer(t, &memStore{todos: testTodos()})
+
+	var page struct {
+		Todos []struct {
+			ID        string `json:"id"`
+			Title     string `json:"title"`
+			Completed bool   `json:"completed"`
+			CreatedAt string `json:"created_at"`
+		}
+		NextPageToken string `json:"next_page_token"`
+	}
+	if code := callGateway(t, http.MethodGet, url+"/v1/todos?page_size=2&completed=false", "", &page); code != http.StatusOK {
+		t.Fatalf("list answered %d", code)
+	}
+	if len(page.Todos) != 2 || page.Todos[1].Title != "Bread" || page.Todos[0].CreatedAt != "2024-05-01T09:00:00Z" || page.NextPageToken != "" {
+		t.Errorf("listed %+v", page)
+	}
+
+	var created struct {
+		Todo struct {
+			ID       string `json:"id"`
+			Title    string `json:"title"`
+			Priority int    `json:"priority"`
+		}
+		Queued bool `json:"queued"`
+	}
+	if code := callGateway(t, http.MethodPost, url+"/v1/todos", `{"title": "Jam", "priority": 2}`, &created); code != http.StatusOK {
+		t.Fatalf("create answered %d", code)
+	}
+	if created.Todo.ID == "" || created.Todo.Title != "Jam" || created.Todo.Priority != 2 || created.Queued {
+		t.Errorf("created %+v", created)
+	}
+
+	var updated struct {
+		Todo struct {
+			Title     string `json:"title"`
+			Completed bool   `json:"completed"`
+		}
+	}
+	if code := callGateway(t, http.MethodPut, url+"/v1/todos/"+created.Todo.ID, `{"title": "Jam jar", "completed": true}`, &updated); code != http.StatusOK {
+		t.Fatalf("update answered %d", code)
+	}
+	if updated.Todo.Title != "Jam jar" || !updated.Todo.Completed {
+		t.Errorf("updated %+v", updated)
+	}
+
+	var deleted map[string]any
+	if code := callGateway(t, http.MethodDelete, url+"/v1/todos/"+created.Todo.ID, "", &deleted); code != http.StatusOK {
+		t.Fatalf("delete answered %d", code)
+	}
+
+	var failed struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if code := callGateway(t, http.MethodGet, url+"/v1/todos/"+created.Todo.ID, "", &failed); code != http.StatusNotFound || failed.Code != 5 {
+		t.Errorf("deleted todo answered %d %+v", code, failed)
+	}
+	if code := callGateway(t, http.MethodGet, url+"/v1/todos/not-an-id", "", &failed); code != http.StatusBadRequest || failed.Message != "invalid id" {
+		t.Errorf("invalid id answered %d %+v", code, failed)
+	}
+}
+
+func TestGatewayTenant(t *testing.T) {
+	prevMode := tenantMode
+	tenantMode = tenantModeHeader
+	t.Cleanup(func() { tenantMode = prevMode })
+	store := &memStore{todos: testTodos()}
+	url, _ := gatewayTestServer(t, store)
+
+	resp, err := http.Get(url + "/v1/todos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("no tenant answered %d, want 400", resp.StatusCode)
+	}
+
+	// Metadata the client sends is not forwarded.
+	req, _ := http.NewRequest(http.MethodGet, url+"/v1/todos", nil)
+	req.Header.Set(tenantHeader, "Acme")
+	req.Header.Set("Grpc-Metadata-X-Tenant-Id", "other")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(store.tenants) != 1 || store.tenants[0] != "acme" {
+		t.Errorf("answered %d for tenants %q, want 200 for acme", resp.StatusCode, store.tenants)
+	}
+}
+
+func TestGatewayWatch(t *testing.T) {
+	url, stopping := gatewayTestServer(t, &memStore{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+	// The gateway sends the headers with the first event, so events are
+	// published until the test ends.
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for ctx.Err() == nil {
+			changes.publish(todoEvent{ID: "ev1", Type: eventTodoCreated, OccurredAt: time.Now(), Todo: todo{ID: "t1", Title: "Tea"}})
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	defer func() {
+		cancel()
+		<-published
+	}()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url+gatewayWatchPath, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	lines := bufio.NewReader(resp.Body)
+	line, err := lines.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ev struct {
+		Result struct {
+			Type string `json:"type"`
+			Todo struct {
+				Title string `json:"title"`
+			}
+		}
+	}
+	if err := json.Unmarshal(line, &ev); err != nil {
+		t.Fatalf("%s: %v", line, err)
+	}
+	if ev.Result.Type != eventTodoCreated || ev.Result.Todo.Title != "Tea" {
+		t.Errorf("got %s", line)
+	}
+
+	close(stopping)
+	rest, _ := io.ReadAll(lines)
+	if !strings.Contains(string(rest), `"code":14`) {
+		t.Errorf("after the shutdown got %s, want UNAVAILABLE", rest)
+	}
+}
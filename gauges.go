@@ -0,0 +1,191 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const todoGaugesTimeout = 10 * time.Second
+
+var (
+	// todoGaugesInterval is how often the todo gauges are counted again,
+	// see loadGaugesConfig. Zero turns them off.
+	todoGaugesInterval = 30 * time.Second
+	// gaugeTenants is how many tenants, those with the most open todos,
+	// get gauges of their own.
+	gaugeTenants int
+
+	todoGauges struct {
+		mu       sync.Mutex
+		at       time.Time
+		total    tenantCounts
+		tenants  int64
+		top      []tenantCounts
+		archived int64
+	}
+)
+
+// tenantCounts are the todos of one tenant, or of all of them.
+type tenantCounts struct {
+	Tenant    string `bson:"_id"`
+	Open      int64  `bson:"open"`
+	Overdue   int64  `bson:"overdue"`
+	Completed int64  `bson:"completed"`
+}
+
+// loadGaugesConfig reads TODO_METRICS_INTERVAL, how often the todo gauges
+// of /metrics are counted, and TODO_METRICS_TENANTS, how many tenants get
+// gauges of their own. Per-tenant series are off by default as each tenant
+// adds some to every scrape.
+func loadGaugesConfig() error {
+	if v := os.Getenv("TODO_METRICS_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid TODO_METRICS_INTERVAL %q, must be a duration like 1m", v)
+		}
+		todoGaugesInterval = d
+	}
+	if v := os.Getenv("TODO_METRICS_TENANTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid TODO_METRICS_TENANTS %q, must be a number of tenants", v)
+		}
+		if n > 0 && tenantMode == tenantModeOff {
+			return fmt.Errorf("TODO_METRICS_TENANTS requires TODO_TENANT_MODE")
+		}
+		gaugeTenants = n
+	}
+	return nil
+}
+
+// runTodoGauges counts the todos every todoGaugesInterval while MongoDB
+// is reachable, so scrapes only read the last counts.
+func runTodoGauges(ctx context.Context) {
+	if todoGaugesInterval == 0 || !usingMongo() {
+		return
+	}
+	ticker := time.NewTicker(todoGaugesInterval)
+	defer ticker.Stop()
+
+	for {
+		if dbConnected.Load() {
+			if err := collectTodoGauges(ctx); err != nil && ctx.Err() == nil {
+				slog.Error("could not count todos", "component", "metrics", "error", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collectTodoGauges counts the todos of every tenant, see countTodos, and
+// the archived ones from the collection metadata.
+func collectTodoGauges(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, todoGaugesTimeout)
+	defer cancel()
+
+	counts, err := countTodos(ctx)
+	if err != nil {
+		return err
+	}
+	var archived int64
+	if archiveAfter > 0 {
+		if archived, err = archiveCollection().EstimatedDocumentCount(ctx); err != nil {
+			return err
+		}
+	}
+
+	total := sumCounts(counts)
+	todoGauges.mu.Lock()
+	defer todoGauges.mu.Unlock()
+	todoGauges.at, todoGauges.total, todoGauges.tenants, todoGauges.archived = time.Now(), total, int64(len(counts)), archived
+	todoGauges.top = counts[:min(gaugeTenants, len(counts))]
+	return nil
+}
+
+// countTodos counts the open, overdue and completed todos of every tenant
+// in one aggregation, the tenants with the most open todos first.
+func countTodos(ctx context.Context) ([]tenantCounts, error) {
+	open := bson.M{"$eq": bson.A{"$completed", false}}
+	cursor, err := readCollection().Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":  "$tenant_id",
+			"open": bson.M{"$sum": bson.M{"$cond": bson.A{open, 1, 0}}},
+			// Missing due dates compare lower than any date.
+			"overdue": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$and": bson.A{
+				open,
+				bson.M{"$gt": bson.A{"$due_at", nil}},
+				bson.M{"$lt": bson.A{"$due_at", time.Now()}},
+			}}, 1, 0}}},
+			"completed": bson.M{"$sum": bson.M{"$cond": bson.A{open, 0, 1}}},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var counts []tenantCounts
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(counts, func(a, b tenantCounts) int {
+		return cmp.Or(cmp.Compare(b.Open, a.Open), cmp.Compare(a.Tenant, b.Tenant))
+	})
+	return counts, nil
+}
+
+// sumCounts returns the counts of all tenants together.
+func sumCounts(counts []tenantCounts) tenantCounts {
+	var total tenantCounts
+	for _, c := range counts {
+		total.Open += c.Open
+		total.Overdue += c.Overdue
+		total.Completed += c.Completed
+	}
+	return total
+}
+
+// writeTodoGauges writes the last counts, if any were taken yet.
+func writeTodoGauges(w io.Writer) {
+	todoGauges.mu.Lock()
+	defer todoGauges.mu.Unlock()
+	if todoGauges.at.IsZero() {
+		return
+	}
+	writeGauge(w, "todo_todos_open", "Todos not completed, of all tenants.", todoGauges.total.Open)
+	writeGauge(w, "todo_todos_overdue", "Todos not completed and past their due date, of all tenants.", todoGauges.total.Overdue)
+	writeGauge(w, "todo_todos_completed", "Completed todos not archived yet, of all tenants.", todoGauges.total.Completed)
+	if archiveAfter > 0 {
+		writeGauge(w, "todo_todos_archived", "Todos moved to the archive collection, estimated.", todoGauges.archived)
+	}
+	if tenantMode != tenantModeOff {
+		writeGauge(w, "todo_tenants", "Tenants with at least one todo.", todoGauges.tenants)
+	}
+	if gaugeTenants > 0 {
+		writeTenantGauge(w, "todo_tenant_todos_open", "Todos not completed, of the tenants with the most.", func(c tenantCounts) int64 { return c.Open })
+		writeTenantGauge(w, "todo_tenant_todos_overdue", "Todos not completed and past their due date, of the tenants with the most open todos.", func(c tenantCounts) int64 { return c.Overdue })
+	}
+	writeGauge(w, "todo_todos_counted_timestamp_seconds", "When the todo gauges were last counted.", todoGauges.at.Unix())
+}
+
+// writeTenantGauge writes a gauge labeled by tenant for the tenants with
+// the most open todos. The caller holds todoGauges.mu.
+func writeTenantGauge(w io.Writer, name, help string, value func(tenantCounts) int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	for _, c := range todoGauges.top {
+		fmt.Fprintf(w, "%s{tenant=\"%s\"} %d\n", name, escapeLabel(c.Tenant), value(c))
+	}
+}
@@ -0,0 +1,664 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	githubLinksCollectionName  = "github_links"
+	githubIssuesCollectionName = "github_issues"
+
+	githubTimeout           = 10 * time.Second
+	githubSyncTimeout       = time.Minute
+	githubMaxPages          = 10
+	githubMaxBody           = 1 << 20
+	maxGitHubLinksPerTenant = 20
+)
+
+// githubAPI is the base URL of the GitHub REST API.
+var githubAPI = "https://api.github.com"
+
+var validRepo = regexp.MustCompile(`^[A-Za-z0-9-]+/[A-Za-z0-9._-]+$`)
+
+type (
+	// githubLink connects a list of a tenant to a GitHub repository. Issues
+	// assigned to the owner of the token become todos of the list. The
+	// token and the secret of the repository webhook are stored encrypted
+	// when encryption is enabled.
+	githubLink struct {
+		ID        primitive.ObjectID `bson:"_id" json:"id"`
+		TenantID  string             `bson:"tenant_id" json:"-"`
+		List      string             `bson:"list" json:"list"`
+		Repo      string             `bson:"repo" json:"repo"`
+		Login     string             `bson:"login" json:"login"`
+		Token     string             `bson:"token" json:"-"`
+		Secret    string             `bson:"secret" json:"-"`
+		CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+		SyncedAt  *time.Time         `bson:"synced_at,omitempty" json:"synced_at,omitempty"`
+	}
+
+	// githubIssue maps an issue to the todo created for it. State and
+	// UpdatedAt are those of the issue when the two were last in sync.
+	githubIssue struct {
+		ID           primitive.ObjectID `bson:"_id" json:"-"`
+		LinkID       primitive.ObjectID `bson:"link_id" json:"-"`
+		Number       int                `bson:"number" json:"number"`
+		TodoID       primitive.ObjectID `bson:"todo_id" json:"todo_id"`
+		State        string             `bson:"state" json:"state"`
+		UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
+		LastConflict *time.Time         `bson:"last_conflict,omitempty" json:"last_conflict,omitempty"`
+		LastError    string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	}
+
+	// ghIssue is the part of a GitHub issue the sync reads.
+	ghIssue struct {
+		Number    int       `json:"number"`
+		Title     string    `json:"title"`
+		State     string    `json:"state"`
+		UpdatedAt time.Time `json:"updated_at"`
+		Assignees []struct {
+			Login string `json:"login"`
+		} `json:"assignees"`
+		PullRequest json.RawMessage `json:"pull_request"`
+	}
+)
+
+func githubLinksCollection() *mongo.Collection {
+	return db.Collection(githubLinksCollectionName, writeCollectionOpts)
+}
+
+func githubIssuesCollection() *mongo.Collection {
+	return db.Collection(githubIssuesCollectionName, writeCollectionOpts)
+}
+
+// githubSyncEnabled reports whether GitHub sync can be used. Completed
+// todos reach GitHub through the outbox, so it requires TODO_OUTBOX.
+func githubSyncEnabled() bool {
+	return outboxEnabled
+}
+
+func ensureGitHubIndexes(ctx context.Context) error {
+	if !githubSyncEnabled() {
+		return nil
+	}
+	_, err := githubLinksCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "tenant_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = githubIssuesCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "link_id", Value: 1}, {Key: "number", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "todo_id", Value: 1}}},
+	})
+	return err
+}
+
+func (l githubLink) source(number int) string {
+	return "github:" + l.Repo + "#" + strconv.Itoa(number)
+}
+
+func (l githubLink) assigned(is ghIssue) bool {
+	for _, a := range is.Assignees {
+		if strings.EqualFold(a.Login, l.Login) {
+			return true
+		}
+	}
+	return false
+}
+
+// githubRequest calls the GitHub API with token and decodes the answer into
+// out. It returns the response so callers can follow its Link header.
+func githubRequest(ctx context.Context, token, method, path string, in, out interface{}) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, githubTimeout)
+	defer cancel()
+
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	target := path
+	if !strings.HasPrefix(path, githubAPI) {
+		target = githubAPI + path
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 8*githubMaxBody))
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, &githubError{Status: resp.StatusCode, Message: strings.TrimSpace(string(raw))}
+	}
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return resp, fmt.Errorf("github: invalid response: %w", err)
+		}
+	}
+	return resp, nil
+}
+
+type githubError struct {
+	Status  int
+	Message string
+}
+
+func (e *githubError) Error() string {
+	return fmt.Sprintf("github: status %d: %s", e.Status, e.Message)
+}
+
+// permanent reports whether retrying cannot help, because the token was
+// revoked or the repository or issue is gone or read only.
+func (e *githubError) permanent() bool {
+	switch e.Status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusGone, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
+
+// applyIssue brings the todo of an issue assigned to the link owner up to
+// date, creating it the first time. Changes older than the last sync are
+// ignored, so replayed webhooks and the echo of our own updates do nothing.
+func applyIssue(ctx context.Context, l githubLink, is ghIssue) (created bool, err error) {
+	ctx = withTenant(ctx, l.TenantID)
+	title := truncate(strings.TrimSpace(is.Title), maxTitleLength)
+	if title == "" {
+		title = "#" + strconv.Itoa(is.Number)
+	}
+
+	var m githubIssue
+	err = githubIssuesCollection().FindOne(ctx, bson.M{"link_id": l.ID, "number": is.Number}).Decode(&m)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		if !l.assigned(is) {
+			return false, nil
+		}
+		tm := todoModel{
+			ID:        primitive.NewObjectID(),
+			Title:     title,
+			Completed: is.State == "closed",
+			CreatedAt: time.Now(),
+			List:      l.List,
+			Source:    l.source(is.Number),
+		}
+		m = githubIssue{ID: primitive.NewObjectID(), LinkID: l.ID, Number: is.Number, TodoID: tm.ID, State: is.State, UpdatedAt: is.UpdatedAt}
+		if _, err := githubIssuesCollection().InsertOne(ctx, m); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				// A concurrent webhook or sync created it.
+				return false, nil
+			}
+			return false, err
+		}
+		if err := repo.Create(ctx, tm); err != nil {
+			githubIssuesCollection().DeleteOne(ctx, bson.M{"_id": m.ID})
+			return false, err
+		}
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+
+	if !is.UpdatedAt.After(m.UpdatedAt) {
+		return false, nil
+	}
+	_, err = githubIssuesCollection().UpdateByID(ctx, m.ID, bson.M{"$set": bson.M{"state": is.State, "updated_at": is.UpdatedAt}})
+	if err != nil {
+		return false, err
+	}
+	err = repo.Update(ctx, m.TodoID, bson.M{"title": title, "completed": is.State == "closed"})
+	if errors.Is(err, errNotFound) {
+		// The todo was deleted, stop following the issue.
+		_, err = githubIssuesCollection().DeleteOne(ctx, bson.M{"_id": m.ID})
+	}
+	return false, err
+}
+
+// syncLink pulls every issue of the repository assigned to the link owner.
+// It catches up on webhooks that were missed while the server was down.
+func syncLink(ctx context.Context, l githubLink) (found, created int, err error) {
+	token, err := decryptField(l.ID, l.Token)
+	if err != nil {
+		return 0, 0, err
+	}
+	q := url.Values{"assignee": {l.Login}, "state": {"all"}, "per_page": {"100"}}
+	next := "/repos/" + l.Repo + "/issues?" + q.Encode()
+	for page := 0; next != "" && page < githubMaxPages; page++ {
+		var issues []ghIssue
+		resp, err := githubRequest(ctx, token, http.MethodGet, next, nil, &issues)
+		if err != nil {
+			return found, created, err
+		}
+		for _, is := range issues {
+			if len(is.PullRequest) > 0 {
+				continue
+			}
+			found++
+			c, err := applyIssue(ctx, l, is)
+			if err != nil {
+				return found, created, err
+			}
+			if c {
+				created++
+			}
+		}
+		next = nextPage(resp.Header.Get("Link"))
+	}
+	now := time.Now()
+	githubLinksCollection().UpdateByID(ctx, l.ID, bson.M{"$set": bson.M{"synced_at": now}})
+	return found, created, nil
+}
+
+var linkNext = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// nextPage returns the URL of the next page from a Link header.
+func nextPage(link string) string {
+	if m := linkNext.FindStringSubmatch(link); m != nil && strings.HasPrefix(m[1], githubAPI) {
+		return m[1]
+	}
+	return ""
+}
+
+// githubPublisher closes or reopens the issue of a todo when the todo is
+// completed or reopened. When the issue changed on GitHub after the todo
+// did, GitHub wins: the push is skipped, the conflict recorded, and the
+// issue webhook brings the todo back in line.
+type githubPublisher struct{}
+
+func (githubPublisher) Name() string { return "github" }
+
+func (githubPublisher) Publish(ctx context.Context, ev todoEvent) error {
+	todoID, err := primitive.ObjectIDFromHex(ev.Todo.ID)
+	if err != nil {
+		return nil
+	}
+	if ev.Type == eventTodoDeleted {
+		_, err := githubIssuesCollection().DeleteMany(ctx, bson.M{"todo_id": todoID})
+		return err
+	}
+	if ev.Type != eventTodoUpdated {
+		return nil
+	}
+
+	var m githubIssue
+	err = githubIssuesCollection().FindOne(ctx, bson.M{"todo_id": todoID}).Decode(&m)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	state := "open"
+	if ev.Todo.Completed == "true" {
+		state = "closed"
+	}
+	if state == m.State {
+		return nil
+	}
+
+	var l githubLink
+	err = githubLinksCollection().FindOne(ctx, bson.M{"_id": m.LinkID}).Decode(&l)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	token, err := decryptField(l.ID, l.Token)
+	if err != nil {
+		return err
+	}
+
+	path := "/repos/" + l.Repo + "/issues/" + strconv.Itoa(m.Number)
+	var current ghIssue
+	if _, err := githubRequest(ctx, token, http.MethodGet, path, nil, &current); err != nil {
+		return githubPublishErr(ctx, m, err)
+	}
+	if current.UpdatedAt.After(m.UpdatedAt) && current.UpdatedAt.After(ev.OccurredAt) {
+		now := time.Now()
+		slog.Info("issue changed on GitHub after its todo, keeping the issue", "component", "github", "repo", l.Repo, "issue", m.Number, "todo", ev.Todo.ID)
+		_, err := githubIssuesCollection().UpdateByID(ctx, m.ID, bson.M{"$set": bson.M{"last_conflict": now}})
+		return err
+	}
+
+	var updated ghIssue
+	if _, err := githubRequest(ctx, token, http.MethodPatch, path, map[string]string{"state": state}, &updated); err != nil {
+		return githubPublishErr(ctx, m, err)
+	}
+	_, err = githubIssuesCollection().UpdateByID(ctx, m.ID, bson.M{
+		"$set":   bson.M{"state": updated.State, "updated_at": updated.UpdatedAt},
+		"$unset": bson.M{"last_error": ""},
+	})
+	return err
+}
+
+// githubPublishErr records permanent GitHub errors on the mapping instead
+// of retrying them. Other errors are returned for the outbox to retry.
+func githubPublishErr(ctx context.Context, m githubIssue, err error) error {
+	var gerr *githubError
+	if !errors.As(err, &gerr) || !gerr.permanent() {
+		return err
+	}
+	slog.Error("could not update issue", "component", "github", "issue", m.Number, "error", err)
+	_, err = githubIssuesCollection().UpdateByID(ctx, m.ID, bson.M{"$set": bson.M{"last_error": gerr.Error()}})
+	return err
+}
+
+func githubHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(requireStore)
+	rg.Use(requireGitHub)
+	rg.Post("/hooks/{id}", receiveGitHubHook)
+	rg.Group(func(r chi.Router) {
+		r.Use(tenantMiddleware)
+		r.Get("/links", fetchGitHubLinks)
+		r.Post("/links", createGitHubLink)
+		r.Delete("/links/{id}", deleteGitHubLink)
+		r.Post("/links/{id}/sync", syncGitHubLink)
+		r.Get("/links/{id}/issues", fetchGitHubIssues)
+	})
+	return rg
+}
+
+func requireGitHub(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !githubSyncEnabled() {
+			rnd.JSON(w, http.StatusNotImplemented, renderer.M{"message": "GitHub sync requires TODO_OUTBOX", "error": "not implemented"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func fetchGitHubLinks(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	cursor, err := githubLinksCollection().Find(ctx, bson.M{"tenant_id": tenantFrom(ctx)})
+	if err != nil {
+		storeErr(w, r, "could not fetch GitHub links", err)
+		return
+	}
+	links := []githubLink{}
+	if err := cursor.All(ctx, &links); err != nil {
+		storeErr(w, r, "could not fetch GitHub links", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": links})
+}
+
+// createGitHubLink links a list to a repository from {"list", "repo",
+// "token"} and imports the issues assigned to the owner of the token. The
+// answer carries the path and secret for the repository webhook, which
+// must send issues events as JSON. The secret is only ever returned here.
+func createGitHubLink(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		List  string `json:"list"`
+		Repo  string `json:"repo"`
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid request", "error": err.Error()})
+		return
+	}
+	if !validRepo.MatchString(req.Repo) {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "repo must be owner/name", "error": "bad request"})
+		return
+	}
+	if req.Token == "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "token is required", "error": "bad request"})
+		return
+	}
+	if msg := validateDetails(req.List, nil, 0); msg != "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": msg, "error": "bad request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), githubSyncTimeout)
+	defer cancel()
+
+	var user struct {
+		Login string `json:"login"`
+	}
+	if _, err := githubRequest(ctx, req.Token, http.MethodGet, "/user", nil, &user); err != nil {
+		rnd.JSON(w, http.StatusBadGateway, renderer.M{"message": "could not verify the GitHub token", "error": err.Error()})
+		return
+	}
+	if _, err := githubRequest(ctx, req.Token, http.MethodGet, "/repos/"+req.Repo, nil, nil); err != nil {
+		rnd.JSON(w, http.StatusBadGateway, renderer.M{"message": "could not access the repository", "error": err.Error()})
+		return
+	}
+
+	n, err := githubLinksCollection().CountDocuments(ctx, bson.M{"tenant_id": tenantFrom(ctx)})
+	if err == nil && n >= maxGitHubLinksPerTenant {
+		rnd.JSON(w, http.StatusConflict, renderer.M{"message": "GitHub link limit reached", "error": "conflict"})
+		return
+	}
+
+	b := make([]byte, 32)
+	rand.Read(b)
+	secret := hex.EncodeToString(b)
+	l := githubLink{
+		ID:        primitive.NewObjectID(),
+		TenantID:  tenantFrom(ctx),
+		List:      req.List,
+		Repo:      req.Repo,
+		Login:     user.Login,
+		CreatedAt: time.Now(),
+	}
+	if err == nil {
+		l.Token, err = encryptField(l.ID, req.Token)
+	}
+	if err == nil {
+		l.Secret, err = encryptField(l.ID, secret)
+	}
+	if err == nil {
+		_, err = githubLinksCollection().InsertOne(ctx, l)
+	}
+	if err != nil {
+		storeErr(w, r, "could not create GitHub link", err)
+		return
+	}
+
+	found, created, err := syncLink(ctx, l)
+	res := renderer.M{
+		"data":         l,
+		"webhook_path": "/github/hooks/" + l.ID.Hex(),
+		"secret":       secret,
+		"found":        found,
+		"created":      created,
+	}
+	if err != nil {
+		res["sync_error"] = err.Error()
+	}
+	rnd.JSON(w, http.StatusCreated, res)
+}
+
+// deleteGitHubLink unlinks a repository. The todos stay.
+func deleteGitHubLink(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	res, err := githubLinksCollection().DeleteOne(ctx, bson.M{"_id": id, "tenant_id": tenantFrom(ctx)})
+	if err == nil && res.DeletedCount == 0 {
+		err = errNotFound
+	}
+	if err != nil {
+		storeErr(w, r, "could not delete GitHub link", err)
+		return
+	}
+	if _, err := githubIssuesCollection().DeleteMany(ctx, bson.M{"link_id": id}); err != nil {
+		slog.Error("could not delete issues", "component", "github", "link", id.Hex(), "error", err)
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"message": "GitHub link deleted successfully"})
+}
+
+func syncGitHubLink(w http.ResponseWriter, r *http.Request) {
+	l, ok := tenantGitHubLink(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), githubSyncTimeout)
+	defer cancel()
+
+	found, created, err := syncLink(ctx, l)
+	if err != nil {
+		rnd.JSON(w, http.StatusBadGateway, renderer.M{"message": "could not sync with GitHub", "error": err.Error()})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"found": found, "created": created})
+}
+
+// fetchGitHubIssues lists the issues of a link with their todos, last
+// conflict and last error.
+func fetchGitHubIssues(w http.ResponseWriter, r *http.Request) {
+	l, ok := tenantGitHubLink(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	cursor, err := githubIssuesCollection().Find(ctx, bson.M{"link_id": l.ID}, options.Find().SetSort(bson.M{"number": 1}))
+	if err != nil {
+		storeErr(w, r, "could not fetch GitHub issues", err)
+		return
+	}
+	issues := []githubIssue{}
+	if err := cursor.All(ctx, &issues); err != nil {
+		storeErr(w, r, "could not fetch GitHub issues", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": issues})
+}
+
+func tenantGitHubLink(w http.ResponseWriter, r *http.Request) (githubLink, bool) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return githubLink{}, false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	var l githubLink
+	err := githubLinksCollection().FindOne(ctx, bson.M{"_id": id, "tenant_id": tenantFrom(ctx)}).Decode(&l)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = errNotFound
+	}
+	if err != nil {
+		storeErr(w, r, "could not fetch GitHub link", err)
+		return githubLink{}, false
+	}
+	return l, true
+}
+
+// receiveGitHubHook handles the repository webhook of a link. Requests are
+// authenticated with the X-Hub-Signature-256 HMAC of the link secret.
+func receiveGitHubHook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, githubMaxBody))
+	if err != nil {
+		rnd.JSON(w, http.StatusRequestEntityTooLarge, renderer.M{"message": "payload is too large", "error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), githubTimeout)
+	defer cancel()
+
+	var l githubLink
+	err = githubLinksCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&l)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = errNotFound
+	}
+	if err != nil {
+		storeErr(w, r, "could not fetch GitHub link", err)
+		return
+	}
+	secret, err := decryptField(l.ID, l.Secret)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "could not verify signature", "error": err.Error()})
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(r.Header.Get("X-Hub-Signature-256")), []byte(want)) {
+		rnd.JSON(w, http.StatusUnauthorized, renderer.M{"message": "invalid signature", "error": "unauthorized"})
+		return
+	}
+
+	if r.Header.Get("X-GitHub-Event") != "issues" {
+		rnd.JSON(w, http.StatusOK, renderer.M{"message": "event ignored"})
+		return
+	}
+	var ev struct {
+		Issue      ghIssue `json:"issue"`
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &ev); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid payload", "error": err.Error()})
+		return
+	}
+	if !strings.EqualFold(ev.Repository.FullName, l.Repo) {
+		rnd.JSON(w, http.StatusOK, renderer.M{"message": "event ignored"})
+		return
+	}
+	if _, err := applyIssue(ctx, l, ev.Issue); err != nil {
+		storeErr(w, r, "could not apply issue", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"message": "issue synced"})
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	githubRulesCollectionName     = "github_rules"
+	githubHookTodosCollectionName = "github_hook_todos"
+
+	githubHookTodosTTL = 90 * 24 * time.Hour
+	maxGitHubRuleRepos = 50
+
+	githubEventAssigned        = "issue_assigned"
+	githubEventReviewRequested = "review_requested"
+)
+
+var (
+	// githubWebhookSecret verifies the deliveries of the organization or
+	// app webhook posting to /hooks/github.
+	githubWebhookSecret string
+
+	githubHookEvents = []string{githubEventAssigned, githubEventReviewRequested}
+
+	// validRepoFilter matches owner/name and owner/* filters.
+	validRepoFilter = regexp.MustCompile(`^[A-Za-z0-9-]+/(\*|[A-Za-z0-9._-]+)$`)
+)
+
+// githubRule says which GitHub events create todos for a tenant: those
+// for Login in a repository matching one of Repos, or any repository when
+// Repos is empty. The todos go to List.
+type githubRule struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	TenantID  string             `bson:"tenant_id" json:"-"`
+	Login     string             `bson:"login" json:"login"`
+	Repos     []string           `bson:"repos" json:"repos"`
+	Events    []string           `bson:"events" json:"events"`
+	List      string             `bson:"list,omitempty" json:"list,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+func loadGitHubHookConfig() error {
+	githubWebhookSecret = os.Getenv("TODO_GITHUB_WEBHOOK_SECRET")
+	if githubWebhookSecret != "" && !usingMongo() {
+		return errors.New("TODO_GITHUB_WEBHOOK_SECRET requires the mongo store")
+	}
+	return nil
+}
+
+func githubHooksEnabled() bool {
+	return githubWebhookSecret != ""
+}
+
+func githubRulesCollection() *mongo.Collection {
+	return db.Collection(githubRulesCollectionName, writeCollectionOpts)
+}
+
+func githubHookTodosCollection() *mongo.Collection {
+	return db.Collection(githubHookTodosCollectionName, writeCollectionOpts)
+}
+
+// ensureGitHubHookIndexes makes the rule unique per tenant, indexes it by
+// login for deliveries and expires the records of created todos.
+func ensureGitHubHookIndexes(ctx context.Context) error {
+	if !githubHooksEnabled() {
+		return nil
+	}
+	_, err := githubRulesCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "tenant_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "login", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = githubHookTodosCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(githubHookTodosTTL.Seconds())),
+	})
+	return err
+}
+
+// matches reports whether the rule takes event for repo.
+func (rl githubRule) matches(event, repo string) bool {
+	if !slices.Contains(rl.Events, event) {
+		return false
+	}
+	if len(rl.Repos) == 0 {
+		return true
+	}
+	repo = strings.ToLower(repo)
+	for _, f := range rl.Repos {
+		if ok, _ := path.Match(f, repo); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func githubHookHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(requireStore)
+	rg.Use(requireGitHubHooks)
+	rg.Post("/", receiveGitHubEvent)
+	rg.Group(func(r chi.Router) {
+		r.Use(tenantMiddleware)
+		r.Get("/rule", fetchGitHubRule)
+		r.Put("/rule", saveGitHubRule)
+		r.Delete("/rule", deleteGitHubRule)
+	})
+	return rg
+}
+
+func requireGitHubHooks(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !githubHooksEnabled() {
+			rnd.JSON(w, http.StatusNotImplemented, renderer.M{"message": "GitHub hooks require TODO_GITHUB_WEBHOOK_SECRET", "error": "not implemented"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func findGitHubRule(ctx context.Context) (githubRule, error) {
+	var rl githubRule
+	err := githubRulesCollection().FindOne(ctx, bson.M{"tenant_id": tenantFrom(ctx)}).Decode(&rl)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = errNotFound
+	}
+	return rl, err
+}
+
+func fetchGitHubRule(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	rl, err := findGitHubRule(ctx)
+	if err != nil {
+		storeErr(w, r, "could not fetch GitHub rule", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": rl})
+}
+
+// saveGitHubRule sets the rule of the tenant from {"login", "repos",
+// "events", "list"}. Events defaults to both issue_assigned and
+// review_requested.
+func saveGitHubRule(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Login  string   `json:"login"`
+		Repos  []string `json:"repos"`
+		Events []string `json:"events"`
+		List   string   `json:"list"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid request", "error": err.Error()})
+		return
+	}
+	login := strings.ToLower(strings.TrimSpace(req.Login))
+	if login == "" || strings.ContainsAny(login, " /") {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "login must be a GitHub username", "error": "bad request"})
+		return
+	}
+	if len(req.Repos) > maxGitHubRuleRepos {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "at most " + strconv.Itoa(maxGitHubRuleRepos) + " repos are allowed", "error": "bad request"})
+		return
+	}
+	repos := []string{}
+	for _, f := range req.Repos {
+		if !validRepoFilter.MatchString(f) {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "repos must be owner/name or owner/*", "error": "bad request"})
+			return
+		}
+		repos = append(repos, strings.ToLower(f))
+	}
+	if len(req.Events) == 0 {
+		req.Events = githubHookEvents
+	}
+	for _, e := range req.Events {
+		if !slices.Contains(githubHookEvents, e) {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "events must be one of " + strings.Join(githubHookEvents, ", "), "error": "bad request"})
+			return
+		}
+	}
+	if msg := validateDetails(req.List, nil, 0); msg != "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": msg, "error": "bad request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	rl, err := findGitHubRule(ctx)
+	created := errors.Is(err, errNotFound)
+	switch {
+	case created:
+		rl = githubRule{ID: primitive.NewObjectID(), TenantID: tenantFrom(ctx), CreatedAt: time.Now()}
+	case err != nil:
+		storeErr(w, r, "could not save GitHub rule", err)
+		return
+	}
+	rl.Login = login
+	rl.Repos = repos
+	rl.Events = req.Events
+	rl.List = req.List
+	rl.UpdatedAt = time.Now()
+	if created {
+		// A rule saved concurrently makes this a duplicate key, 409.
+		_, err = githubRulesCollection().InsertOne(ctx, rl)
+	} else {
+		_, err = githubRulesCollection().ReplaceOne(ctx, bson.M{"_id": rl.ID}, rl)
+	}
+	if err != nil {
+		storeErr(w, r, "could not save GitHub rule", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": rl})
+}
+
+func deleteGitHubRule(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	res, err := githubRulesCollection().DeleteOne(ctx, bson.M{"tenant_id": tenantFrom(ctx)})
+	if err == nil && res.DeletedCount == 0 {
+		err = errNotFound
+	}
+	if err != nil {
+		storeErr(w, r, "could not delete GitHub rule", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"message": "GitHub rule deleted successfully"})
+}
+
+// receiveGitHubEvent handles the deliveries of an organization or app
+// webhook, authenticated with the X-Hub-Signature-256 HMAC of
+// TODO_GITHUB_WEBHOOK_SECRET. An issue assigned to, or a review requested
+// from, the login of a rule creates a todo for its tenant, once per issue
+// or pull request.
+func receiveGitHubEvent(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, githubMaxBody))
+	if err != nil {
+		rnd.JSON(w, http.StatusRequestEntityTooLarge, renderer.M{"message": "payload is too large", "error": err.Error()})
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(githubWebhookSecret))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(r.Header.Get("X-Hub-Signature-256")), []byte(want)) {
+		rnd.JSON(w, http.StatusUnauthorized, renderer.M{"message": "invalid signature", "error": "unauthorized"})
+		return
+	}
+
+	var ev struct {
+		Action   string `json:"action"`
+		Assignee struct {
+			Login string `json:"login"`
+		} `json:"assignee"`
+		RequestedReviewer struct {
+			Login string `json:"login"`
+		} `json:"requested_reviewer"`
+		Issue       ghItem `json:"issue"`
+		PullRequest ghItem `json:"pull_request"`
+		Repository  struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &ev); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid payload", "error": err.Error()})
+		return
+	}
+
+	var (
+		event string
+		login string
+		item  ghItem
+		title string
+	)
+	switch {
+	case r.Header.Get("X-GitHub-Event") == "issues" && ev.Action == "assigned":
+		event, login, item = githubEventAssigned, ev.Assignee.Login, ev.Issue
+		title = item.Title
+	case r.Header.Get("X-GitHub-Event") == "pull_request" && ev.Action == "review_requested":
+		// Reviews requested from a team carry no reviewer login.
+		event, login, item = githubEventReviewRequested, ev.RequestedReviewer.Login, ev.PullRequest
+		title = "Review: " + item.Title
+	}
+	if event == "" || login == "" || !validRepo.MatchString(ev.Repository.FullName) {
+		rnd.JSON(w, http.StatusOK, renderer.M{"message": "event ignored"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), githubTimeout)
+	defer cancel()
+
+	cursor, err := githubRulesCollection().Find(ctx, bson.M{"login": strings.ToLower(login)})
+	if err != nil {
+		storeErr(w, r, "could not fetch GitHub rules", err)
+		return
+	}
+	var rules []githubRule
+	if err := cursor.All(ctx, &rules); err != nil {
+		storeErr(w, r, "could not fetch GitHub rules", err)
+		return
+	}
+
+	source := "github:" + ev.Repository.FullName + "#" + strconv.Itoa(item.Number)
+	title = truncate(strings.TrimSpace(title), maxTitleLength)
+	if title == "" || title == "Review:" {
+		title = source
+	}
+	created := 0
+	for _, rl := range rules {
+		if !rl.matches(event, ev.Repository.FullName) {
+			continue
+		}
+		c, err := createGitHubHookTodo(withTenant(ctx, rl.TenantID), rl, event, source, title, item.HTMLURL)
+		if err != nil {
+			storeErr(w, r, "could not create todo", err)
+			return
+		}
+		if c {
+			created++
+		}
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"message": "event processed", "created": created})
+}
+
+// ghItem is the part of an issue or pull request a delivery reads.
+type ghItem struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	HTMLURL string `json:"html_url"`
+}
+
+// createGitHubHookTodo creates the todo for an event unless one was
+// already created for the same issue or pull request, so redeliveries and
+// re-requested reviews do not pile up.
+func createGitHubHookTodo(ctx context.Context, rl githubRule, event, source, title, link string) (bool, error) {
+	key := rl.TenantID + "|" + event + "|" + strings.ToLower(source)
+	tm := todoModel{
+		ID:          primitive.NewObjectID(),
+		Title:       title,
+		Description: link,
+		CreatedAt:   time.Now(),
+		List:        rl.List,
+		Source:      source,
+	}
+	_, err := githubHookTodosCollection().InsertOne(ctx, bson.M{"_id": key, "todo_id": tm.ID, "at": time.Now()})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := repo.Create(ctx, tm); err != nil {
+		githubHookTodosCollection().DeleteOne(ctx, bson.M{"_id": key})
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,777 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	googleLinksCollectionName = "google_links"
+	googleTasksCollectionName = "google_tasks"
+
+	googleTimeout        = 10 * time.Second
+	googleSyncTimeout    = 5 * time.Minute
+	googleSyncLease      = 10 * time.Minute
+	googleMaxPages       = 20
+	googleConflictsKept  = 50
+	googlePendingTimeout = time.Hour
+	googleDueLayout      = "2006-01-02T00:00:00.000Z"
+
+	googleLinkPending = "pending"
+	googleLinkActive  = "active"
+)
+
+var (
+	googleAuthURL  = "https://accounts.google.com/o/oauth2/v2/auth"
+	googleTokenURL = "https://oauth2.googleapis.com/token"
+	googleTasksAPI = "https://tasks.googleapis.com/tasks/v1"
+
+	googleClientID     string
+	googleClientSecret string
+	googleRedirectURL  string
+	googleSyncInterval time.Duration
+)
+
+// loadGoogleConfig enables Google Tasks sync when TODO_GOOGLE_CLIENT_ID is
+// set. TODO_GOOGLE_CLIENT_SECRET and TODO_GOOGLE_REDIRECT_URL, the public
+// URL of /google/callback, come from the same OAuth client.
+// TODO_GOOGLE_SYNC_INTERVAL defaults to 5m.
+func loadGoogleConfig() error {
+	googleClientID = os.Getenv("TODO_GOOGLE_CLIENT_ID")
+	if googleClientID == "" {
+		return nil
+	}
+	googleClientSecret = os.Getenv("TODO_GOOGLE_CLIENT_SECRET")
+	googleRedirectURL = os.Getenv("TODO_GOOGLE_REDIRECT_URL")
+	if googleClientSecret == "" || googleRedirectURL == "" {
+		return errors.New("TODO_GOOGLE_CLIENT_ID requires TODO_GOOGLE_CLIENT_SECRET and TODO_GOOGLE_REDIRECT_URL")
+	}
+	d, err := time.ParseDuration(envOr("TODO_GOOGLE_SYNC_INTERVAL", "5m"))
+	if err != nil || d < time.Minute {
+		return fmt.Errorf("invalid TODO_GOOGLE_SYNC_INTERVAL %q", os.Getenv("TODO_GOOGLE_SYNC_INTERVAL"))
+	}
+	googleSyncInterval = d
+	if !usingMongo() {
+		return errors.New("Google Tasks sync requires the mongo store")
+	}
+	if !outboxEnabled {
+		return errors.New("Google Tasks sync requires TODO_OUTBOX")
+	}
+	return nil
+}
+
+func googleSyncEnabled() bool {
+	return googleClientID != ""
+}
+
+type (
+	// googleLink connects a list of a tenant to a Google Tasks list. It is
+	// pending until the OAuth consent comes back with the state. The
+	// refresh token is stored encrypted when encryption is enabled.
+	googleLink struct {
+		ID           primitive.ObjectID `bson:"_id" json:"id"`
+		TenantID     string             `bson:"tenant_id" json:"-"`
+		List         string             `bson:"list" json:"list"`
+		Status       string             `bson:"status" json:"status"`
+		State        string             `bson:"state,omitempty" json:"-"`
+		RefreshToken string             `bson:"refresh_token,omitempty" json:"-"`
+		TaskList     string             `bson:"task_list,omitempty" json:"task_list,omitempty"`
+		CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+		NextSyncAt   time.Time          `bson:"next_sync_at" json:"next_sync_at"`
+		LeaseUntil   time.Time          `bson:"lease_until" json:"-"`
+		LastReport   *googleSyncReport  `bson:"last_report,omitempty" json:"last_report,omitempty"`
+	}
+
+	// googleTask maps a task to its todo. Synced holds both as they were
+	// after the last sync, so either side can tell what changed since.
+	googleTask struct {
+		ID             primitive.ObjectID `bson:"_id"`
+		LinkID         primitive.ObjectID `bson:"link_id"`
+		TaskID         string             `bson:"task_id"`
+		TodoID         primitive.ObjectID `bson:"todo_id"`
+		Synced         taskSnapshot       `bson:"synced"`
+		RemoteUpdated  string             `bson:"remote_updated"`
+		LocalUpdatedAt *time.Time         `bson:"local_updated_at,omitempty"`
+	}
+
+	taskSnapshot struct {
+		Title     string `bson:"title" json:"title"`
+		Completed bool   `bson:"completed" json:"completed"`
+		Due       string `bson:"due,omitempty" json:"due,omitempty"`
+	}
+
+	// googleSyncReport is what the last sync of a link did.
+	googleSyncReport struct {
+		StartedAt     time.Time        `bson:"started_at" json:"started_at"`
+		FinishedAt    time.Time        `bson:"finished_at" json:"finished_at"`
+		Pulled        int              `bson:"pulled" json:"pulled"`
+		Pushed        int              `bson:"pushed" json:"pushed"`
+		CreatedLocal  int              `bson:"created_local" json:"created_local"`
+		CreatedRemote int              `bson:"created_remote" json:"created_remote"`
+		Deleted       int              `bson:"deleted" json:"deleted"`
+		Conflicts     []googleConflict `bson:"conflicts,omitempty" json:"conflicts,omitempty"`
+		Error         string           `bson:"error,omitempty" json:"error,omitempty"`
+	}
+
+	// googleConflict is a task and todo that both changed since the last
+	// sync. The later change wins and the other is overwritten.
+	googleConflict struct {
+		TodoID primitive.ObjectID `bson:"todo_id" json:"todo_id"`
+		TaskID string             `bson:"task_id" json:"task_id"`
+		Winner string             `bson:"winner" json:"winner"`
+		Lost   taskSnapshot       `bson:"lost" json:"lost"`
+	}
+
+	// gTask is a Google Tasks task.
+	gTask struct {
+		ID      string `json:"id,omitempty"`
+		Title   string `json:"title"`
+		Status  string `json:"status"`
+		Due     string `json:"due,omitempty"`
+		Updated string `json:"updated,omitempty"`
+		Deleted bool   `json:"deleted,omitempty"`
+	}
+)
+
+func googleLinksCollection() *mongo.Collection {
+	return db.Collection(googleLinksCollectionName, writeCollectionOpts)
+}
+
+func googleTasksCollection() *mongo.Collection {
+	return db.Collection(googleTasksCollectionName, writeCollectionOpts)
+}
+
+func ensureGoogleIndexes(ctx context.Context) error {
+	if !googleSyncEnabled() {
+		return nil
+	}
+	_, err := googleLinksCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}}},
+		{Keys: bson.D{{Key: "state", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_sync_at", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = googleTasksCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "link_id", Value: 1}, {Key: "task_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "todo_id", Value: 1}}},
+	})
+	return err
+}
+
+func snapshotTodo(tm todoModel) taskSnapshot {
+	s := taskSnapshot{Title: tm.Title, Completed: tm.Completed}
+	if tm.DueAt != nil {
+		s.Due = tm.DueAt.UTC().Format(googleDueLayout)
+	}
+	return s
+}
+
+func snapshotTask(t gTask) taskSnapshot {
+	s := taskSnapshot{Title: strings.TrimSpace(t.Title), Completed: t.Status == "completed"}
+	if due, err := time.Parse(time.RFC3339Nano, t.Due); err == nil {
+		s.Due = due.UTC().Format(googleDueLayout)
+	}
+	return s
+}
+
+func (s taskSnapshot) task() gTask {
+	t := gTask{Title: s.Title, Status: "needsAction", Due: s.Due}
+	if s.Completed {
+		t.Status = "completed"
+	}
+	return t
+}
+
+// fields are the todo fields to update from a task. A due date removed on
+// Google's side is kept on the todo.
+func (s taskSnapshot) fields() bson.M {
+	title := truncate(s.Title, maxTitleLength)
+	if title == "" {
+		title = "(untitled)"
+	}
+	fields := bson.M{"title": title, "completed": s.Completed}
+	if due, err := time.Parse(googleDueLayout, s.Due); err == nil {
+		fields["due_at"] = due
+	}
+	return fields
+}
+
+// googlePublisher stamps when a synced todo last changed, which decides
+// conflicts. The sync job does the pushing.
+type googlePublisher struct{}
+
+func (googlePublisher) Name() string { return "google" }
+
+func (googlePublisher) Publish(ctx context.Context, ev todoEvent) error {
+	if ev.Type != eventTodoUpdated {
+		return nil
+	}
+	todoID, err := primitive.ObjectIDFromHex(ev.Todo.ID)
+	if err != nil {
+		return nil
+	}
+	_, err = googleTasksCollection().UpdateMany(ctx,
+		bson.M{"todo_id": todoID, "$or": bson.A{
+			bson.M{"local_updated_at": bson.M{"$exists": false}},
+			bson.M{"local_updated_at": bson.M{"$lt": ev.OccurredAt}},
+		}},
+		bson.M{"$set": bson.M{"local_updated_at": ev.OccurredAt}})
+	return err
+}
+
+// syncGoogleLinks syncs the links that are due. Links are claimed with a lease
+// so only one sync of a link runs at a time across instances. It is
+// scheduled every minute.
+func syncGoogleLinks(ctx context.Context) error {
+	for {
+		l, err := claimGoogleLink(ctx, bson.M{"next_sync_at": bson.M{"$lte": time.Now()}})
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not claim link: %w", err)
+		}
+		if rep := syncGoogleLink(ctx, l); rep.Error != "" {
+			slog.Warn("sync failed", "component", "google", "link", l.ID.Hex(), "error", rep.Error)
+		}
+	}
+}
+
+// claimGoogleLink leases an active link matching filter that is not being
+// synced.
+func claimGoogleLink(ctx context.Context, filter bson.M) (googleLink, error) {
+	now := time.Now()
+	filter["status"] = googleLinkActive
+	filter["lease_until"] = bson.M{"$lte": now}
+	var l googleLink
+	err := googleLinksCollection().FindOneAndUpdate(ctx, filter,
+		bson.M{"$set": bson.M{"lease_until": now.Add(googleSyncLease)}},
+	).Decode(&l)
+	return l, err
+}
+
+// syncGoogleLink syncs the todos of a link's list with its task list,
+// stores the report on the link and releases it until the next run.
+func syncGoogleLink(ctx context.Context, l googleLink) googleSyncReport {
+	rep := googleSyncReport{StartedAt: time.Now()}
+	ctx, cancel := context.WithTimeout(withTenant(ctx, l.TenantID), googleSyncTimeout)
+	defer cancel()
+
+	if err := syncGoogle(ctx, l, &rep); err != nil {
+		rep.Error = err.Error()
+	}
+	rep.FinishedAt = time.Now()
+	_, err := googleLinksCollection().UpdateByID(ctx, l.ID, bson.M{"$set": bson.M{
+		"last_report":  rep,
+		"next_sync_at": rep.StartedAt.Add(googleSyncInterval),
+		"lease_until":  time.Time{},
+	}})
+	if err != nil {
+		slog.Error("could not store sync report", "component", "google", "link", l.ID.Hex(), "error", err)
+	}
+	return rep
+}
+
+func syncGoogle(ctx context.Context, l googleLink, rep *googleSyncReport) error {
+	refresh, err := decryptField(l.ID, l.RefreshToken)
+	if err != nil {
+		return err
+	}
+	tok, err := googleToken(ctx, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refresh}})
+	if err != nil {
+		return err
+	}
+	token := tok.AccessToken
+	tasks, err := googleListTasks(ctx, token, l.TaskList)
+	if err != nil {
+		return err
+	}
+
+	todos, err := repo.List(ctx)
+	if err != nil {
+		return err
+	}
+	archived, err := repo.ListArchived(ctx)
+	if err != nil {
+		return err
+	}
+	local := map[primitive.ObjectID]todoModel{}
+	for _, tm := range todos {
+		local[tm.ID] = tm
+	}
+	for _, tm := range archived {
+		// Archived todos are kept but no longer synced.
+		local[tm.ID] = todoModel{}
+	}
+
+	cursor, err := googleTasksCollection().Find(ctx, bson.M{"link_id": l.ID})
+	if err != nil {
+		return err
+	}
+	var mappings []googleTask
+	if err := cursor.All(ctx, &mappings); err != nil {
+		return err
+	}
+	byTask := map[string]googleTask{}
+	mapped := map[primitive.ObjectID]bool{}
+	for _, m := range mappings {
+		byTask[m.TaskID] = m
+		mapped[m.TodoID] = true
+	}
+
+	taskPath := "/lists/" + url.PathEscape(l.TaskList) + "/tasks"
+	for _, t := range tasks {
+		m, ok := byTask[t.ID]
+		if !ok {
+			if t.Deleted || strings.TrimSpace(t.Title) == "" {
+				continue
+			}
+			if err := createLocalTask(ctx, l, t, rep); err != nil {
+				return err
+			}
+			continue
+		}
+		delete(byTask, t.ID)
+
+		tm, exists := local[m.TodoID]
+		switch {
+		case t.Deleted:
+			if exists && tm.ID != primitive.NilObjectID {
+				if _, err := repo.Delete(ctx, m.TodoID); err != nil && !errors.Is(err, errNotFound) {
+					return err
+				}
+			}
+			rep.Deleted++
+			if _, err := googleTasksCollection().DeleteOne(ctx, bson.M{"_id": m.ID}); err != nil {
+				return err
+			}
+			continue
+		case !exists:
+			if err := googleRequest(ctx, token, http.MethodDelete, taskPath+"/"+url.PathEscape(t.ID), nil, nil); err != nil {
+				return err
+			}
+			rep.Deleted++
+			if _, err := googleTasksCollection().DeleteOne(ctx, bson.M{"_id": m.ID}); err != nil {
+				return err
+			}
+			continue
+		case tm.ID == primitive.NilObjectID:
+			continue
+		}
+
+		remote, current := snapshotTask(t), snapshotTodo(tm)
+		remoteChanged := t.Updated != m.RemoteUpdated && remote != m.Synced
+		localChanged := current != m.Synced
+		if remoteChanged && localChanged && remote != current {
+			remoteAt, _ := time.Parse(time.RFC3339Nano, t.Updated)
+			c := googleConflict{TodoID: tm.ID, TaskID: t.ID, Winner: "google", Lost: current}
+			if m.LocalUpdatedAt != nil && m.LocalUpdatedAt.After(remoteAt) {
+				c.Winner, c.Lost = "todo", remote
+				remoteChanged = false
+			} else {
+				localChanged = false
+			}
+			rep.Conflicts = append(rep.Conflicts, c)
+		}
+
+		synced, updated := m.Synced, t.Updated
+		switch {
+		case remoteChanged:
+			fields := remote.fields()
+			if err := repo.Update(ctx, tm.ID, fields); err != nil && !errors.Is(err, errNotFound) {
+				return err
+			}
+			rep.Pulled++
+			synced = remote
+			synced.Title = fields["title"].(string)
+			if synced.Due == "" {
+				synced.Due = current.Due
+			}
+		case localChanged:
+			var out gTask
+			if err := googleRequest(ctx, token, http.MethodPatch, taskPath+"/"+url.PathEscape(t.ID), current.task(), &out); err != nil {
+				return err
+			}
+			rep.Pushed++
+			synced, updated = current, out.Updated
+		default:
+			continue
+		}
+		_, err := googleTasksCollection().UpdateByID(ctx, m.ID, bson.M{"$set": bson.M{"synced": synced, "remote_updated": updated}})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Tasks missing from the full listing were purged on Google's side.
+	for _, m := range byTask {
+		if _, err := googleTasksCollection().DeleteOne(ctx, bson.M{"_id": m.ID}); err != nil {
+			return err
+		}
+	}
+
+	for _, tm := range todos {
+		if tm.List != l.List || mapped[tm.ID] {
+			continue
+		}
+		s := snapshotTodo(tm)
+		var out gTask
+		if err := googleRequest(ctx, token, http.MethodPost, taskPath, s.task(), &out); err != nil {
+			return err
+		}
+		_, err := googleTasksCollection().InsertOne(ctx, googleTask{
+			ID: primitive.NewObjectID(), LinkID: l.ID, TaskID: out.ID, TodoID: tm.ID, Synced: s, RemoteUpdated: out.Updated,
+		})
+		if err != nil {
+			return err
+		}
+		rep.CreatedRemote++
+	}
+	if len(rep.Conflicts) > googleConflictsKept {
+		rep.Conflicts = rep.Conflicts[:googleConflictsKept]
+	}
+	return nil
+}
+
+func createLocalTask(ctx context.Context, l googleLink, t gTask, rep *googleSyncReport) error {
+	s := snapshotTask(t)
+	tm := todoModel{
+		ID:        primitive.NewObjectID(),
+		Title:     truncate(s.Title, maxTitleLength),
+		Completed: s.Completed,
+		CreatedAt: time.Now(),
+		List:      l.List,
+		Source:    "google:" + t.ID,
+	}
+	if due, err := time.Parse(googleDueLayout, s.Due); err == nil {
+		tm.DueAt = &due
+	}
+	_, err := googleTasksCollection().InsertOne(ctx, googleTask{
+		ID: primitive.NewObjectID(), LinkID: l.ID, TaskID: t.ID, TodoID: tm.ID, Synced: snapshotTodo(tm), RemoteUpdated: t.Updated,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := repo.Create(ctx, tm); err != nil {
+		return err
+	}
+	rep.CreatedLocal++
+	return nil
+}
+
+func googleListTasks(ctx context.Context, token, taskList string) ([]gTask, error) {
+	var tasks []gTask
+	q := url.Values{"showCompleted": {"true"}, "showHidden": {"true"}, "showDeleted": {"true"}, "maxResults": {"100"}}
+	for page := 0; page < googleMaxPages; page++ {
+		var out struct {
+			Items         []gTask `json:"items"`
+			NextPageToken string  `json:"nextPageToken"`
+		}
+		if err := googleRequest(ctx, token, http.MethodGet, "/lists/"+url.PathEscape(taskList)+"/tasks?"+q.Encode(), nil, &out); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, out.Items...)
+		if out.NextPageToken == "" {
+			return tasks, nil
+		}
+		q.Set("pageToken", out.NextPageToken)
+	}
+	return nil, fmt.Errorf("google: task list has more than %d pages", googleMaxPages)
+}
+
+type googleTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	Error        string `json:"error"`
+}
+
+// googleToken exchanges an authorization code or refresh token at the
+// token endpoint.
+func googleToken(ctx context.Context, form url.Values) (googleTokenResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, googleTimeout)
+	defer cancel()
+
+	form.Set("client_id", googleClientID)
+	form.Set("client_secret", googleClientSecret)
+	var out googleTokenResponse
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, googleTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return out, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return out, err
+	}
+	defer resp.Body.Close()
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&out)
+	if resp.StatusCode != http.StatusOK || out.AccessToken == "" {
+		return out, fmt.Errorf("google: token request failed: %s %s", resp.Status, out.Error)
+	}
+	return out, nil
+}
+
+// googleRequest calls the Tasks API and decodes the answer into out.
+func googleRequest(ctx context.Context, token, method, path string, in, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, googleTimeout)
+	defer cancel()
+
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, googleTasksAPI+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("google: %s %s: %s", method, path, resp.Status)
+	}
+	if out != nil {
+		return json.Unmarshal(raw, out)
+	}
+	return nil
+}
+
+func googleHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(requireStore)
+	rg.Use(requireGoogle)
+	rg.Get("/callback", googleCallback)
+	rg.Group(func(r chi.Router) {
+		r.Use(tenantMiddleware)
+		r.Get("/links", fetchGoogleLinks)
+		r.Post("/links", createGoogleLink)
+		r.Delete("/links/{id}", deleteGoogleLink)
+		r.Post("/links/{id}/sync", syncGoogleLinkNow)
+	})
+	return rg
+}
+
+func requireGoogle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !googleSyncEnabled() {
+			rnd.JSON(w, http.StatusNotImplemented, renderer.M{"message": "Google Tasks sync is not configured", "error": "not implemented"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func fetchGoogleLinks(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	cursor, err := googleLinksCollection().Find(ctx, bson.M{"tenant_id": tenantFrom(ctx)})
+	if err != nil {
+		storeErr(w, r, "could not fetch Google links", err)
+		return
+	}
+	links := []googleLink{}
+	if err := cursor.All(ctx, &links); err != nil {
+		storeErr(w, r, "could not fetch Google links", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": links})
+}
+
+// createGoogleLink starts linking {"list"} to Google Tasks. The answer
+// carries the consent URL to open; the link is active once Google
+// redirects back to /google/callback.
+func createGoogleLink(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		List string `json:"list"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid request", "error": err.Error()})
+		return
+	}
+	if req.List == "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "list is required", "error": "bad request"})
+		return
+	}
+	if msg := validateDetails(req.List, nil, 0); msg != "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": msg, "error": "bad request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	b := make([]byte, 32)
+	rand.Read(b)
+	l := googleLink{
+		ID:        primitive.NewObjectID(),
+		TenantID:  tenantFrom(ctx),
+		List:      req.List,
+		Status:    googleLinkPending,
+		State:     hex.EncodeToString(b),
+		CreatedAt: time.Now(),
+	}
+	if _, err := googleLinksCollection().InsertOne(ctx, l); err != nil {
+		storeErr(w, r, "could not create Google link", err)
+		return
+	}
+	q := url.Values{
+		"client_id":     {googleClientID},
+		"redirect_uri":  {googleRedirectURL},
+		"response_type": {"code"},
+		"scope":         {"https://www.googleapis.com/auth/tasks"},
+		"access_type":   {"offline"},
+		"prompt":        {"consent"},
+		"state":         {l.State},
+	}
+	rnd.JSON(w, http.StatusCreated, renderer.M{"data": l, "auth_url": googleAuthURL + "?" + q.Encode()})
+}
+
+// googleCallback completes the OAuth consent: it stores the refresh token,
+// creates a task list named after the list and activates the link.
+func googleCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	state, code := q.Get("state"), q.Get("code")
+	if state == "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "state is required", "error": "bad request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	var l googleLink
+	err := googleLinksCollection().FindOne(ctx, bson.M{
+		"state":      state,
+		"status":     googleLinkPending,
+		"created_at": bson.M{"$gt": time.Now().Add(-googlePendingTimeout)},
+	}).Decode(&l)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = errNotFound
+	}
+	if err != nil {
+		storeErr(w, r, "unknown or expired link", err)
+		return
+	}
+	if code == "" {
+		googleLinksCollection().DeleteOne(ctx, bson.M{"_id": l.ID})
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "consent was not granted", "error": q.Get("error")})
+		return
+	}
+
+	tok, err := googleToken(ctx, url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {googleRedirectURL}})
+	if err == nil && tok.RefreshToken == "" {
+		err = errors.New("google: no refresh token was issued")
+	}
+	var list gTask
+	if err == nil {
+		err = googleRequest(ctx, tok.AccessToken, http.MethodPost, "/users/@me/lists", map[string]string{"title": l.List}, &list)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusBadGateway, renderer.M{"message": "could not link Google Tasks", "error": err.Error()})
+		return
+	}
+	refresh, err := encryptField(l.ID, tok.RefreshToken)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "could not link Google Tasks", "error": err.Error()})
+		return
+	}
+	_, err = googleLinksCollection().UpdateByID(ctx, l.ID, bson.M{
+		"$set":   bson.M{"status": googleLinkActive, "refresh_token": refresh, "task_list": list.ID, "next_sync_at": time.Now(), "lease_until": time.Time{}},
+		"$unset": bson.M{"state": ""},
+	})
+	if err != nil {
+		storeErr(w, r, "could not link Google Tasks", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"message": "Google Tasks linked successfully"})
+}
+
+// deleteGoogleLink unlinks a list. The todos and tasks stay.
+func deleteGoogleLink(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	res, err := googleLinksCollection().DeleteOne(ctx, bson.M{"_id": id, "tenant_id": tenantFrom(ctx)})
+	if err == nil && res.DeletedCount == 0 {
+		err = errNotFound
+	}
+	if err != nil {
+		storeErr(w, r, "could not delete Google link", err)
+		return
+	}
+	if _, err := googleTasksCollection().DeleteMany(ctx, bson.M{"link_id": id}); err != nil {
+		slog.Error("could not delete tasks", "component", "google", "link", id.Hex(), "error", err)
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"message": "Google link deleted successfully"})
+}
+
+// syncGoogleLinkNow syncs a link right away and answers with the report.
+func syncGoogleLinkNow(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	l, err := claimGoogleLink(ctx, bson.M{"_id": id, "tenant_id": tenantFrom(ctx)})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		rnd.JSON(w, http.StatusConflict, renderer.M{"message": "no active link, or a sync of it is running", "error": "conflict"})
+		return
+	}
+	if err != nil {
+		storeErr(w, r, "could not sync Google link", err)
+		return
+	}
+
+	rep := syncGoogleLink(ctx, l)
+	status := http.StatusOK
+	if rep.Error != "" {
+		status = http.StatusBadGateway
+	}
+	rnd.JSON(w, status, renderer.M{"data": rep})
+}
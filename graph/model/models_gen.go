@@ -0,0 +1,138 @@
+// Code generated by github.com/99designs/gqlgen, DO NOT EDIT.
+
+package model
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+type Attachment struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"contentType"`
+	Size        int       `json:"size"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type CreateTodoInput struct {
+	Title       string     `json:"title"`
+	Description *string    `json:"description,omitempty"`
+	List        *string    `json:"list,omitempty"`
+	Labels      []string   `json:"labels,omitempty"`
+	Priority    *int       `json:"priority,omitempty"`
+	DueAt       *time.Time `json:"dueAt,omitempty"`
+}
+
+type CreateTodoPayload struct {
+	Todo   *Todo `json:"todo"`
+	Queued bool  `json:"queued"`
+}
+
+type DeleteTodoPayload struct {
+	ID     string `json:"id"`
+	Queued bool   `json:"queued"`
+}
+
+type Mutation struct {
+}
+
+type Query struct {
+}
+
+type Subscription struct {
+}
+
+type Todo struct {
+	ID          string        `json:"id"`
+	Title       string        `json:"title"`
+	Description *string       `json:"description,omitempty"`
+	Completed   bool          `json:"completed"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	List        *string       `json:"list,omitempty"`
+	Labels      []string      `json:"labels"`
+	Priority    *int          `json:"priority,omitempty"`
+	DueAt       *time.Time    `json:"dueAt,omitempty"`
+	DueToday    bool          `json:"dueToday"`
+	Overdue     bool          `json:"overdue"`
+	Source      *string       `json:"source,omitempty"`
+	Column      *string       `json:"column,omitempty"`
+	Position    *float64      `json:"position,omitempty"`
+	Attachments []*Attachment `json:"attachments"`
+}
+
+type TodoConnection struct {
+	Nodes       []*Todo `json:"nodes"`
+	EndCursor   *string `json:"endCursor,omitempty"`
+	HasNextPage bool    `json:"hasNextPage"`
+}
+
+type TodoEvent struct {
+	ID         string        `json:"id"`
+	Type       TodoEventType `json:"type"`
+	OccurredAt time.Time     `json:"occurredAt"`
+	Todo       *Todo         `json:"todo"`
+}
+
+type TodoFilter struct {
+	Completed    *bool      `json:"completed,omitempty"`
+	CreatedAfter *time.Time `json:"createdAfter,omitempty"`
+	Archived     *bool      `json:"archived,omitempty"`
+	List         *string    `json:"list,omitempty"`
+	Label        *string    `json:"label,omitempty"`
+}
+
+type UpdateTodoInput struct {
+	Title     *string `json:"title,omitempty"`
+	Completed *bool   `json:"completed,omitempty"`
+}
+
+type UpdateTodoPayload struct {
+	Todo   *Todo `json:"todo,omitempty"`
+	Queued bool  `json:"queued"`
+}
+
+type TodoEventType string
+
+const (
+	TodoEventTypeCreated TodoEventType = "CREATED"
+	TodoEventTypeUpdated TodoEventType = "UPDATED"
+	TodoEventTypeDeleted TodoEventType = "DELETED"
+)
+
+var AllTodoEventType = []TodoEventType{
+	TodoEventTypeCreated,
+	TodoEventTypeUpdated,
+	TodoEventTypeDeleted,
+}
+
+func (e TodoEventType) IsValid() bool {
+	switch e {
+	case TodoEventTypeCreated, TodoEventTypeUpdated, TodoEventTypeDeleted:
+		return true
+	}
+	return false
+}
+
+func (e TodoEventType) String() string {
+	return string(e)
+}
+
+func (e *TodoEventType) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = TodoEventType(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid TodoEventType", str)
+	}
+	return nil
+}
+
+func (e TodoEventType) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
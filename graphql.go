@@ -0,0 +1,225 @@
+package main
+
+//go:generate go run github.com/99designs/gqlgen@v0.17.55 generate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/go-chi/chi"
+	"github.com/qasim-invodev/todo/graph"
+	"github.com/qasim-invodev/todo/graph/model"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// maxGraphQLComplexity bounds the cost of an operation, every field
+	// selected counting 1 and the fields reading or writing the store
+	// graphQLStoreCost more, so that aliases cannot scan the store many
+	// times in one request.
+	maxGraphQLComplexity = 250
+	graphQLStoreCost     = 10
+)
+
+// graphQLHandlers serves graph/schema.graphqls at /graphql, queries and
+// mutations at POST /graphql and the todoChanged subscription at POST
+// /graphql/stream as Server-Sent Events. Both are scoped to the tenant of
+// the request.
+func graphQLHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(tenantMiddleware)
+	rg.Post("/", newGraphQLServer(false).ServeHTTP)
+	stream := newGraphQLServer(true)
+	rg.Post("/stream", func(w http.ResponseWriter, r *http.Request) {
+		// The stream outlives the server's write timeout.
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		stream.ServeHTTP(w, r)
+	})
+	return rg
+}
+
+// newGraphQLServer returns the gqlgen server of the schema, serving the
+// subscription alone when stream is set and everything else otherwise.
+// Introspection is not served.
+func newGraphQLServer(stream bool) *handler.Server {
+	cfg := graph.Config{Resolvers: &gqlResolver{}}
+	storeCost := func(childComplexity int) int { return graphQLStoreCost + childComplexity }
+	cfg.Complexity.Query.Todo = func(c int, _ string) int { return storeCost(c) }
+	cfg.Complexity.Query.Todos = func(c int, _ *model.TodoFilter, _ int, _ *string) int { return storeCost(c) }
+	cfg.Complexity.Mutation.CreateTodo = func(c int, _ model.CreateTodoInput) int { return storeCost(c) }
+	cfg.Complexity.Mutation.UpdateTodo = func(c int, _ string, _ model.UpdateTodoInput) int { return storeCost(c) }
+	cfg.Complexity.Mutation.CompleteTodo = func(c int, _ string) int { return storeCost(c) }
+	cfg.Complexity.Mutation.DeleteTodo = func(c int, _ string) int { return storeCost(c) }
+
+	srv := handler.New(graph.NewExecutableSchema(cfg))
+	if stream {
+		srv.AddTransport(transport.SSE{})
+	} else {
+		srv.AddTransport(transport.POST{})
+	}
+	srv.Use(extension.FixedComplexityLimit(maxGraphQLComplexity))
+	srv.AroundOperations(func(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+		op := graphql.GetOperationContext(ctx).Operation.Operation
+		switch {
+		case op == ast.Subscription && !stream:
+			return graphql.OneShot(graphql.ErrorResponse(ctx, "Subscriptions are served at /graphql/stream."))
+		case op != ast.Subscription && stream:
+			return graphql.OneShot(graphql.ErrorResponse(ctx, "Only subscriptions are served at /graphql/stream."))
+		// As requireStore does for REST, queries fail at once while the
+		// store is unavailable, mutations too unless they can be queued.
+		case op != ast.Subscription && !storeAvailable() && (writes == nil || op == ast.Query):
+			return graphql.OneShot(&graphql.Response{Errors: gqlerror.List{{
+				Message:    "database unavailable",
+				Extensions: map[string]any{"code": kindUnavailable.code},
+			}}})
+		}
+		return next(ctx)
+	})
+	srv.AroundFields(func(ctx context.Context, next graphql.Resolver) (any, error) {
+		if fc := graphql.GetFieldContext(ctx); fc.IsResolver && fc.Object != "Subscription" {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, requestTimeout)
+			defer cancel()
+		}
+		return next(ctx)
+	})
+	srv.SetErrorPresenter(presentGraphQLError)
+	srv.SetRecoverFunc(func(ctx context.Context, v any) error {
+		slog.Error("graphql resolver panicked", "panic", v, "stack", string(debug.Stack()))
+		return gqlerror.Errorf("internal server error")
+	})
+	return srv
+}
+
+// presentGraphQLError describes the store errors of resolvers like storeErr
+// does for REST, with the same codes in extensions. gqlgen wraps them in
+// the error it presents; its own errors and those the resolvers build, see
+// gqlInvalid, are kept as they are.
+func presentGraphQLError(ctx context.Context, err error) *gqlerror.Error {
+	gerr := graphql.DefaultErrorPresenter(ctx, err)
+	var own *gqlerror.Error
+	if fc := graphql.GetFieldContext(ctx); fc == nil || !fc.IsResolver || gerr.Err == nil || errors.As(gerr.Err, &own) {
+		return gerr
+	}
+	k := classify(gerr.Err)
+	if k == kindStorage {
+		slog.Error("graphql store call failed", "error_code", k.code, "error", gerr.Err)
+	}
+	gerr.Message = k.describe(gerr.Err)
+	gerr.Extensions = map[string]any{"code": k.code}
+	return gerr
+}
+
+// gqlInvalid returns an error about invalid input, with the validation code
+// REST answers use.
+func gqlInvalid(format string, args ...any) *gqlerror.Error {
+	return &gqlerror.Error{Message: fmt.Sprintf(format, args...), Extensions: map[string]any{"code": kindValidation.code}}
+}
+
+// gqlTodoID parses the id argument of a field.
+func gqlTodoID(id string) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, gqlInvalid("invalid id")
+	}
+	return objID, nil
+}
+
+// gqlOptional returns s, or null when it is empty.
+func gqlOptional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// gqlTodo converts t, whose due flags are set, to the Todo of the schema.
+func gqlTodo(t todo) *model.Todo {
+	gt := &model.Todo{
+		ID:          t.ID,
+		Title:       t.Title,
+		Description: gqlOptional(t.Description),
+		Completed:   t.Completed == "true",
+		CreatedAt:   t.CreatedAt,
+		List:        gqlOptional(t.List),
+		Labels:      t.Labels,
+		DueAt:       t.DueAt,
+		DueToday:    t.DueToday,
+		Overdue:     t.Overdue,
+		Source:      gqlOptional(t.Source),
+		Column:      gqlOptional(t.Column),
+		Attachments: []*model.Attachment{},
+	}
+	if gt.Labels == nil {
+		gt.Labels = []string{}
+	}
+	if t.Priority != 0 {
+		gt.Priority = &t.Priority
+	}
+	if t.Column != "" {
+		position := float64(t.Position)
+		gt.Position = &position
+	}
+	for _, a := range t.Attachments {
+		gt.Attachments = append(gt.Attachments, &model.Attachment{
+			ID:          a.ID,
+			Name:        a.Name,
+			ContentType: a.ContentType,
+			Size:        int(a.Size),
+			CreatedAt:   a.CreatedAt,
+		})
+	}
+	return gt
+}
+
+// gqlTodoOf returns the Todo of the schema for tm as the tenant sees it.
+func gqlTodoOf(ctx context.Context, tm todoModel) *model.Todo {
+	t := toTodo(tm)
+	dueFlags(&t, preferencesOrDefault(ctx).location())
+	return gqlTodo(t)
+}
+
+// gqlEvent converts a change event; todo.created is CREATED and so on.
+func gqlEvent(e todoEvent) *model.TodoEvent {
+	return &model.TodoEvent{
+		ID:         e.ID,
+		Type:       model.TodoEventType(strings.ToUpper(strings.TrimPrefix(e.Type, "todo."))),
+		OccurredAt: e.OccurredAt,
+		Todo:       gqlTodo(e.Todo),
+	}
+}
+
+// gqlUpdate sets fields of the todo of id, reading it back unless the
+// update was queued.
+func gqlUpdate(ctx context.Context, id string, fields bson.M) (*model.UpdateTodoPayload, error) {
+	objID, err := gqlTodoID(id)
+	if err != nil {
+		return nil, err
+	}
+	queued, err := updateTodoFields(ctx, objID, fields)
+	if err != nil {
+		return nil, err
+	}
+	p := &model.UpdateTodoPayload{Queued: queued}
+	if !queued {
+		tm, err := repo.Get(ctx, objID)
+		if err != nil {
+			return nil, err
+		}
+		p.Todo = gqlTodoOf(ctx, tm)
+	}
+	return p, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+// THIS CODE WILL BE UPDATED WITH SCHEMA CHANGES. PREVIOUS IMPLEMENTATION FOR SCHEMA CHANGES WILL BE KEPT IN THE COMMENT SECTION. IMPLEMENTATION FOR UNCHANGED SCHEMA WILL BE KEPT.
+
+import (
+	"context"
+
+	"github.com/qasim-invodev/todo/graph"
+	"github.com/qasim-invodev/todo/graph/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// gqlResolver resolves the fields of graph/schema.graphqls through the
+// same store and helpers as the REST handlers.
+type gqlResolver struct{}
+
+// CreateTodo is the resolver for the createTodo field.
+func (r *mutationGqlResolver) CreateTodo(ctx context.Context, input model.CreateTodoInput) (*model.CreateTodoPayload, error) {
+	t := todo{Title: input.Title, Labels: input.Labels, DueAt: input.DueAt}
+	if input.Description != nil {
+		t.Description = *input.Description
+	}
+	if input.List != nil {
+		t.List = *input.List
+	}
+	if input.Priority != nil {
+		t.Priority = *input.Priority
+	}
+	if msg := validateNewTodo(t); msg != "" {
+		return nil, gqlInvalid("%s", msg)
+	}
+
+	if t.List == "" {
+		t.List = preferencesOrDefault(ctx).DefaultList
+	}
+	tm := newTodoModel(t)
+	queued, err := saveNewTodo(ctx, tm)
+	if err != nil {
+		return nil, err
+	}
+	// The todo is returned as it will be stored when queued too.
+	return &model.CreateTodoPayload{Todo: gqlTodoOf(ctx, tm), Queued: queued}, nil
+}
+
+// UpdateTodo is the resolver for the updateTodo field.
+func (r *mutationGqlResolver) UpdateTodo(ctx context.Context, id string, input model.UpdateTodoInput) (*model.UpdateTodoPayload, error) {
+	fields := bson.M{}
+	if input.Title != nil {
+		if msg := validateTitle(*input.Title); msg != "" {
+			return nil, gqlInvalid("%s", msg)
+		}
+		fields["title"] = *input.Title
+	}
+	if input.Completed != nil {
+		fields["completed"] = *input.Completed
+	}
+	if len(fields) == 0 {
+		return nil, gqlInvalid("input must set title or completed")
+	}
+	return gqlUpdate(ctx, id, fields)
+}
+
+// CompleteTodo is the resolver for the completeTodo field.
+func (r *mutationGqlResolver) CompleteTodo(ctx context.Context, id string) (*model.UpdateTodoPayload, error) {
+	return gqlUpdate(ctx, id, bson.M{"completed": true})
+}
+
+// DeleteTodo is the resolver for the deleteTodo field.
+func (r *mutationGqlResolver) DeleteTodo(ctx context.Context, id string) (*model.DeleteTodoPayload, error) {
+	objID, err := gqlTodoID(id)
+	if err != nil {
+		return nil, err
+	}
+	queued, err := removeTodo(ctx, objID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.DeleteTodoPayload{ID: objID.Hex(), Queued: queued}, nil
+}
+
+// Todo is the resolver for the todo field.
+func (r *queryGqlResolver) Todo(ctx context.Context, id string) (*model.Todo, error) {
+	objID, err := gqlTodoID(id)
+	if err != nil {
+		return nil, err
+	}
+	tm, err := repo.Get(ctx, objID)
+	if err != nil {
+		// There is no such todo.
+		if classify(err) == kindNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return gqlTodoOf(ctx, tm), nil
+}
+
+// Todos is the resolver for the todos field.
+func (r *queryGqlResolver) Todos(ctx context.Context, filter *model.TodoFilter, first int, after *string) (*model.TodoConnection, error) {
+	var (
+		q listQuery
+		f pageFilter
+	)
+	if filter != nil {
+		f.Completed, q.List = filter.Completed, filter.List
+		if filter.CreatedAfter != nil {
+			f.CreatedAfter = *filter.CreatedAfter
+		}
+		if filter.Archived != nil {
+			q.Archived = *filter.Archived
+		}
+		if filter.Label != nil {
+			f.Label = *filter.Label
+		}
+	}
+	if first < 0 || first > maxTodoPageSize {
+		return nil, gqlInvalid("first must be between 0 and %d", maxTodoPageSize)
+	}
+	var afterID primitive.ObjectID
+	if after != nil {
+		var err error
+		if afterID, err = primitive.ObjectIDFromHex(*after); err != nil {
+			return nil, gqlInvalid("invalid after cursor")
+		}
+	}
+
+	todos, more, err := listTodoPage(ctx, q, f, afterID, first)
+	if err != nil {
+		return nil, err
+	}
+	conn := &model.TodoConnection{Nodes: make([]*model.Todo, 0, len(todos)), HasNextPage: more}
+	for _, tm := range todos {
+		conn.Nodes = append(conn.Nodes, gqlTodoOf(ctx, tm))
+	}
+	if len(todos) > 0 {
+		conn.EndCursor = gqlOptional(todos[len(todos)-1].ID.Hex())
+	}
+	return conn, nil
+}
+
+// TodoChanged is the resolver for the todoChanged field.
+func (r *subscriptionGqlResolver) TodoChanged(ctx context.Context) (<-chan *model.TodoEvent, error) {
+	events, _, cancel := changes.subscribe(tenantFrom(ctx), 0)
+	out := make(chan *model.TodoEvent)
+	go func() {
+		defer close(out)
+		defer cancel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case c, ok := <-events:
+				if !ok {
+					// Fell behind, the stream completes and the client
+					// queries the todos again before subscribing anew.
+					return
+				}
+				select {
+				case out <- gqlEvent(c.Event):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Mutation returns graph.MutationResolver implementation.
+func (r *gqlResolver) Mutation() graph.MutationResolver { return &mutationGqlResolver{r} }
+
+// Query returns graph.QueryResolver implementation.
+func (r *gqlResolver) Query() graph.QueryResolver { return &queryGqlResolver{r} }
+
+// Subscription returns graph.SubscriptionResolver implementation.
+func (r *gqlResolver) Subscription() graph.SubscriptionResolver { return &subscriptionGqlResolver{r} }
+
+type mutationGqlResolver struct{ *gqlResolver }
+type queryGqlResolver struct{ *gqlResolver }
+type subscriptionGqlResolver struct{ *gqlResolver }
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+)
+
+// graphQLTestServer serves /graphql from store until the test ends.
+func graphQLTestServer(t *testing.T, store todoStore) string {
+	prevRepo, prevRnd := repo, rnd
+	repo, rnd = store, renderer.New()
+	t.Cleanup(func() { repo, rnd = prevRepo, prevRnd })
+
+	r := chi.NewRouter()
+	r.Mount("/graphql", graphQLHandlers())
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// postGraphQL posts query with vars to url and returns the body of the
+// answer.
+func postGraphQL(t *testing.T, url, query string, vars map[string]any) string {
+	t.Helper()
+	body, _ := json.Marshal(map[string]any{"query": query, "variables": vars})
+	resp, err := http.Post(url+"/graphql", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var b strings.Builder
+	bufio.NewReader(resp.Body).WriteTo(&b)
+	return strings.TrimSpace(b.String())
+}
+
+func TestGraphQLQuery(t *testing.T) {
+	url := graphQLTestServer(t, &memStore{todos: testTodos()})
+	tests := []struct {
+		name  string
+		query string
+		vars  map[string]any
+		want  string
+	}{
+		{
+			"page",
+			`query Page($n: Int!) { todos(first: $n) { nodes { ...item } endCursor hasNextPage } }
+			fragment item on Todo { id title priority labels }`,
+			map[string]any{"n": 2},
+			`{"data":{"todos":{"nodes":[` +
+				`{"id":"663200000000000000000001","title":"Tea","priority":1,"labels":[]},` +
+				`{"id":"663200000000000000000002","title":"Milk","priority":null,"labels":["shop"]}` +
+				`],"endCursor":"663200000000000000000002","hasNextPage":true}}}`,
+		},
+		{
+			"next page with filter",
+			`{ todos(after: "663200000000000000000001", filter: {completed: false}) { nodes { title } hasNextPage } }`,
+			nil,
+			`{"data":{"todos":{"nodes":[{"title":"Bread"}],"hasNextPage":false}}}`,
+		},
+		{
+			"aliases, directives and __typename",
+			`query($skip: Boolean = true) { a: todo(id: "663200000000000000000001") { __typename title done: completed @skip(if: $skip) } b: todo(id: "663200000000000000000009") { title } }`,
+			nil,
+			`{"data":{"a":{"__typename":"Todo","title":"Tea"},"b":null}}`,
+		},
+		{
+			"invalid argument",
+			`{ todos(first: 5000) { hasNextPage } }`,
+			nil,
+			`{"errors":[{"message":"first must be between 0 and 1000","path":["todos"],"extensions":{"code":"validation"}}],"data":null}`,
+		},
+		{
+			"nullable field error",
+			`{ todo(id: "nope") { title } }`,
+			nil,
+			`{"errors":[{"message":"invalid id","path":["todo"],"extensions":{"code":"validation"}}],"data":{"todo":null}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := postGraphQL(t, url, tt.query, tt.vars); got != tt.want {
+				t.Errorf("got\n%s, want\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphQLRefused(t *testing.T) {
+	url := graphQLTestServer(t, &memStore{})
+	aliases := make([]string, 25)
+	for i := range aliases {
+		aliases[i] = "t" + string(rune('a'+i)) + `: todo(id: "663200000000000000000001") { id }`
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`{ todos { nodes { title } `, "Expected Name, found <EOF>"},
+		{`{ todo(id: "x") { name } }`, `Cannot query field "name" on type "Todo".`},
+		{`{ __schema { types { name } } }`, "introspection disabled"},
+		{"{ " + strings.Join(aliases, " ") + " }", "operation has complexity 275, which exceeds the limit of 250"},
+		{`subscription { todoChanged { id } }`, "Subscriptions are served at /graphql/stream."},
+	}
+	for _, tt := range tests {
+		got := postGraphQL(t, url, tt.query, nil)
+		var resp struct {
+			Errors []struct{ Message string }
+		}
+		if err := json.Unmarshal([]byte(got), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Errors) == 0 || resp.Errors[0].Message != tt.want {
+			t.Errorf("%s\nanswered %s, want the error %s", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	store := &memStore{todos: testTodos()}
+	url := graphQLTestServer(t, store)
+
+	got := postGraphQL(t, url, `mutation($in: CreateTodoInput!) { createTodo(input: $in) { todo { title labels priority dueAt } queued } }`,
+		map[string]any{"in": map[string]any{"title": "Jam", "labels": "shop", "priority": 2, "dueAt": "2024-05-02T10:00:00Z"}})
+	want := `{"data":{"createTodo":{"todo":{"title":"Jam","labels":["shop"],"priority":2,"dueAt":"2024-05-02T10:00:00Z"},"queued":false}}}`
+	if got != want || len(store.todos) != 4 {
+		t.Errorf("createTodo answered\n%s, want\n%s", got, want)
+	}
+
+	got = postGraphQL(t, url, `mutation { completeTodo(id: "663200000000000000000001") { todo { completed } } updateTodo(id: "663200000000000000000003", input: {title: "Rye"}) { todo { title completed } } }`, nil)
+	want = `{"data":{"completeTodo":{"todo":{"completed":true}},"updateTodo":{"todo":{"title":"Rye","completed":false}}}}`
+	if got != want {
+		t.Errorf("updates answered\n%s, want\n%s", got, want)
+	}
+
+	// The payload is non-null, so its error nulls the data.
+	got = postGraphQL(t, url, `mutation { createTodo(input: {title: ""}) { queued } }`, nil)
+	want = `{"errors":[{"message":"title is required","path":["createTodo"],"extensions":{"code":"validation"}}],"data":null}`
+	if got != want {
+		t.Errorf("invalid createTodo answered\n%s, want\n%s", got, want)
+	}
+
+	got = postGraphQL(t, url, `mutation { deleteTodo(id: "663200000000000000000009") { id } }`, nil)
+	if !strings.Contains(got, `"code":"not_found"`) {
+		t.Errorf("deleting a missing todo answered %s", got)
+	}
+}
+
+// TestGraphQLCreateWhileStoreDown queues a create while MongoDB is down,
+// like POST /todo, while queries fail at once.
+func TestGraphQLCreateWhileStoreDown(t *testing.T) {
+	useWriteBuffer(t)
+	url := graphQLTestServer(t, repo)
+
+	got := postGraphQL(t, url, `mutation { createTodo(input: {title: "buy milk"}) { todo { title } queued } }`, nil)
+	want := `{"data":{"createTodo":{"todo":{"title":"buy milk"},"queued":true}}}`
+	if got != want {
+		t.Errorf("got\n%s, want\n%s", got, want)
+	}
+	got = postGraphQL(t, url, `{ todos { hasNextPage } }`, nil)
+	want = `{"errors":[{"message":"database unavailable","extensions":{"code":"unavailable"}}],"data":null}`
+	if got != want {
+		t.Errorf("query answered\n%s, want\n%s", got, want)
+	}
+}
+
+func TestGraphQLSubscription(t *testing.T) {
+	url := graphQLTestServer(t, &memStore{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+	// The subscription starts after the headers are sent, so events are
+	// published until the test ends.
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for ctx.Err() == nil {
+			changes.publish(todoEvent{ID: "ev1", Type: eventTodoUpdated, OccurredAt: time.Now(), Todo: todo{ID: "t1", Title: "Tea"}})
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	defer func() {
+		cancel()
+		<-published
+	}()
+	body := `{"query": "subscription { todoChanged { type todo { title } } }"}`
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url+"/graphql/stream", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("answered %s with %s", resp.Status, resp.Header.Get("Content-Type"))
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() && lines.Text() != "event: next" {
+	}
+	lines.Scan()
+	want := `data: {"data":{"todoChanged":{"type":"UPDATED","todo":{"title":"Tea"}}}}`
+	if lines.Text() != want {
+		t.Errorf("got %q, want %q", lines.Text(), want)
+	}
+}
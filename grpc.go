@@ -0,0 +1,409 @@
+package main
+
+//go:generate buf generate proto --template proto/buf.gen.yaml --path proto/todo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"time"
+
+	todov1 "github.com/qasim-invodev/todo/proto/todo/v1"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	maxGRPCMessage = 4 << 20
+	// defaultGRPCPageSize is the page_size of ListTodos when unset.
+	defaultGRPCPageSize = 100
+)
+
+// grpcAddr, when set, serves the todo service of proto/todo/v1/todo.proto
+// over gRPC on its own listener, see loadGRPCConfig.
+var grpcAddr string
+
+// loadGRPCConfig reads TODO_GRPC_ADDR, the address of the gRPC listener
+// for service to service consumers. A socket named "grpc" passed by systemd
+// sets it too. It speaks HTTP/2 without TLS, so it belongs on a private
+// network or behind a proxy terminating TLS.
+func loadGRPCConfig() error {
+	grpcAddr = os.Getenv("TODO_GRPC_ADDR")
+	if ln, ok := inheritedListeners["grpc"]; ok && grpcAddr == "" {
+		grpcAddr = ln.Addr().String()
+	}
+	if grpcAddr == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(grpcAddr); err != nil {
+		return fmt.Errorf("invalid TODO_GRPC_ADDR %q: %w", grpcAddr, err)
+	}
+	return nil
+}
+
+// grpcServer returns the gRPC listener, or nil when there is none. The
+// grpc server is served through an http.Server like the other listeners,
+// so it is inherited, upgraded and shut down with them. Like the admin
+// listener it has no write timeout, which would cut watch streams.
+// Shutting it down ends them, so it does not wait for them.
+func grpcServer() *http.Server {
+	if grpcAddr == "" {
+		return nil
+	}
+	stopping := make(chan struct{})
+	srv := limitSlowClients(&http.Server{
+		Addr:        grpcAddr,
+		Handler:     h2c.NewHandler(grpcHandler(newGRPCServer(stopping)), &http2.Server{IdleTimeout: idleTimeout}),
+		ReadTimeout: readTimeout,
+		IdleTimeout: idleTimeout,
+	})
+	srv.RegisterOnShutdown(func() { close(stopping) })
+	return srv
+}
+
+// serveGRPC runs the gRPC server until it is shut down.
+func serveGRPC(srv *http.Server) {
+	ln, err := listener("grpc", srv.Addr)
+	if err == nil {
+		slog.Info("serving gRPC", "addr", ln.Addr().String())
+		err = srv.Serve(ln)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("gRPC listener failed", "error", err)
+	}
+}
+
+// newGRPCServer returns the server of TodoService. Closing stopping ends
+// the watch streams. No compressor is registered, so compressed messages
+// are refused with UNIMPLEMENTED.
+func newGRPCServer(stopping <-chan struct{}) *grpc.Server {
+	gs := grpc.NewServer(
+		grpc.MaxRecvMsgSize(maxGRPCMessage),
+		grpc.UnaryInterceptor(grpcUnaryInterceptor),
+		grpc.StreamInterceptor(grpcStreamInterceptor),
+	)
+	todov1.RegisterTodoServiceServer(gs, &todoService{stopping: stopping})
+	return gs
+}
+
+// grpcHandler scopes the calls of gs to the tenant of the request, the
+// x-tenant-id metadata being the X-Tenant-ID header. The interceptors
+// refuse calls without one.
+func grpcHandler(gs *grpc.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tenantMode != tenantModeOff {
+			r = r.WithContext(withTenant(r.Context(), requestTenant(r)))
+		}
+		gs.ServeHTTP(w, r)
+	})
+}
+
+// grpcUnaryInterceptor checks the tenant and the store before a unary
+// call, bounds it by requestTimeout unless the client asked for less, and
+// maps the error it ends with to a gRPC status.
+func grpcUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer recoverGRPC(info.FullMethod, &err)
+	if err := checkGRPCTenant(ctx); err != nil {
+		return nil, err
+	}
+	// As requireStore does for REST, reads fail at once while the store
+	// is unavailable, writes too unless they can be queued.
+	read := info.FullMethod == todov1.TodoService_ListTodos_FullMethodName || info.FullMethod == todov1.TodoService_GetTodo_FullMethodName
+	if !storeAvailable() && (writes == nil || read) {
+		return nil, status.Error(codes.Unavailable, "database unavailable")
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	resp, err = handler(ctx, req)
+	return resp, grpcStatus(err)
+}
+
+// grpcStreamInterceptor checks the tenant before a streaming call and maps
+// the error it ends with to a gRPC status.
+func grpcStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer recoverGRPC(info.FullMethod, &err)
+	if err := checkGRPCTenant(ss.Context()); err != nil {
+		return err
+	}
+	return grpcStatus(handler(srv, ss))
+}
+
+func checkGRPCTenant(ctx context.Context) error {
+	if tenantMode != tenantModeOff && !validTenant.MatchString(tenantFrom(ctx)) {
+		return status.Error(codes.InvalidArgument, "missing or invalid tenant")
+	}
+	return nil
+}
+
+// recoverGRPC answers a call whose handler panicked with INTERNAL, like
+// the recoverer middleware answers 500.
+func recoverGRPC(method string, err *error) {
+	if v := recover(); v != nil {
+		slog.Error("gRPC handler panicked", "method", method, "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+		*err = status.Error(codes.Internal, "internal server error")
+	}
+}
+
+// grpcStatus returns err as a gRPC status, mapping store errors like
+// storeErr does for REST.
+func grpcStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch k := classify(err); k {
+	case kindNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case kindValidation:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case kindUnsupported:
+		return status.Error(codes.Unimplemented, err.Error())
+	case kindConflict:
+		return status.Error(codes.AlreadyExists, k.detail)
+	case kindTimeout:
+		return status.Error(codes.DeadlineExceeded, k.detail)
+	case kindBreakerOpen, kindUnavailable:
+		return status.Error(codes.Unavailable, k.detail)
+	case kindCanceled:
+		return status.Error(codes.Canceled, k.detail)
+	default:
+		slog.Error("gRPC store call failed", "error_code", k.code, "error", err)
+		return status.Error(codes.Internal, k.detail)
+	}
+}
+
+// todoService implements todov1.TodoServiceServer on top of repo.
+type todoService struct {
+	todov1.UnimplementedTodoServiceServer
+	stopping <-chan struct{}
+}
+
+// grpcTodoID parses the id field of a request.
+func grpcTodoID(id string) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, status.Error(codes.InvalidArgument, "invalid id")
+	}
+	return objID, nil
+}
+
+// grpcTimestamp returns t as a google.protobuf.Timestamp, leaving out the
+// zero time.
+func grpcTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// protoTodo returns t as a todo.v1.Todo.
+func protoTodo(t todo) *todov1.Todo {
+	pt := &todov1.Todo{
+		Id:          t.ID,
+		Title:       t.Title,
+		Completed:   t.Completed == "true",
+		CreatedAt:   grpcTimestamp(t.CreatedAt),
+		Description: t.Description,
+		List:        t.List,
+		Labels:      t.Labels,
+		Priority:    int32(t.Priority),
+		Source:      t.Source,
+		Column:      t.Column,
+		Position:    t.Position,
+		DueToday:    t.DueToday,
+		Overdue:     t.Overdue,
+	}
+	if t.DueAt != nil {
+		pt.DueAt = timestamppb.New(*t.DueAt)
+	}
+	for _, a := range t.Attachments {
+		pt.Attachments = append(pt.Attachments, &todov1.Attachment{
+			Id:          a.ID,
+			Name:        a.Name,
+			ContentType: a.ContentType,
+			Size:        a.Size,
+			CreatedAt:   grpcTimestamp(a.CreatedAt),
+		})
+	}
+	return pt
+}
+
+// ListTodos lists the todos in pages in creation order, the page token
+// being the ID of the last todo of the previous page.
+func (s *todoService) ListTodos(ctx context.Context, req *todov1.ListTodosRequest) (*todov1.ListTodosResponse, error) {
+	pageSize := int(req.PageSize)
+	switch {
+	case pageSize < 0:
+		return nil, status.Error(codes.InvalidArgument, "page_size must not be negative")
+	case pageSize == 0:
+		pageSize = defaultGRPCPageSize
+	case pageSize > maxTodoPageSize:
+		pageSize = maxTodoPageSize
+	}
+	var after primitive.ObjectID
+	if req.PageToken != "" {
+		var err error
+		if after, err = primitive.ObjectIDFromHex(req.PageToken); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+		}
+	}
+	q := listQuery{Archived: req.Archived, List: req.List}
+	f := pageFilter{Completed: req.Completed, Label: req.Label}
+	if req.CreatedAfter != nil {
+		f.CreatedAfter = req.CreatedAfter.AsTime()
+	}
+
+	todos, more, err := listTodoPage(ctx, q, f, after, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	resp := &todov1.ListTodosResponse{}
+	loc := preferencesOrDefault(ctx).location()
+	for _, tm := range todos {
+		t := toTodo(tm)
+		dueFlags(&t, loc)
+		resp.Todos = append(resp.Todos, protoTodo(t))
+	}
+	if more {
+		resp.NextPageToken = todos[len(todos)-1].ID.Hex()
+	}
+	return resp, nil
+}
+
+func (s *todoService) GetTodo(ctx context.Context, req *todov1.GetTodoRequest) (*todov1.Todo, error) {
+	objID, err := grpcTodoID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	tm, err := repo.Get(ctx, objID)
+	if err != nil {
+		return nil, err
+	}
+	t := toTodo(tm)
+	dueFlags(&t, preferencesOrDefault(ctx).location())
+	return protoTodo(t), nil
+}
+
+// CreateTodo creates a todo like POST /todo. The todo is returned as it
+// will be stored when the create is queued too.
+func (s *todoService) CreateTodo(ctx context.Context, req *todov1.CreateTodoRequest) (*todov1.CreateTodoResponse, error) {
+	t := todo{
+		Title:       req.Title,
+		Description: req.Description,
+		List:        req.List,
+		Labels:      req.Labels,
+		Priority:    int(req.Priority),
+	}
+	if req.DueAt != nil {
+		due := req.DueAt.AsTime()
+		t.DueAt = &due
+	}
+	if msg := validateNewTodo(t); msg != "" {
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+	if t.List == "" {
+		t.List = preferencesOrDefault(ctx).DefaultList
+	}
+	tm := newTodoModel(t)
+	queued, err := saveNewTodo(ctx, tm)
+	if err != nil {
+		return nil, err
+	}
+	return &todov1.CreateTodoResponse{Todo: protoTodo(toTodo(tm)), Queued: queued}, nil
+}
+
+// UpdateTodo updates a todo like PUT /todo/{id}. The todo is read back
+// unless the update was queued.
+func (s *todoService) UpdateTodo(ctx context.Context, req *todov1.UpdateTodoRequest) (*todov1.UpdateTodoResponse, error) {
+	objID, err := grpcTodoID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if msg := validateTitle(req.Title); msg != "" {
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+
+	resp := &todov1.UpdateTodoResponse{}
+	if req.Upsert {
+		tm := todoModel{ID: objID, Title: req.Title, Completed: req.Completed, CreatedAt: time.Now()}
+		resp.Queued, err = bufferOr(bufferedWrite{Op: bufferedUpsert, Tenant: tenantFrom(ctx), ID: objID, Todo: &tm}, func() (err error) {
+			resp.Created, err = repo.Upsert(ctx, tm)
+			return err
+		})
+	} else {
+		resp.Queued, err = updateTodoFields(ctx, objID, bson.M{"title": req.Title, "completed": req.Completed})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Queued {
+		tm, err := repo.Get(ctx, objID)
+		if err != nil {
+			return nil, err
+		}
+		t := toTodo(tm)
+		dueFlags(&t, preferencesOrDefault(ctx).location())
+		resp.Todo = protoTodo(t)
+	}
+	return resp, nil
+}
+
+func (s *todoService) DeleteTodo(ctx context.Context, req *todov1.DeleteTodoRequest) (*todov1.DeleteTodoResponse, error) {
+	objID, err := grpcTodoID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	queued, err := removeTodo(ctx, objID)
+	if err != nil {
+		return nil, err
+	}
+	return &todov1.DeleteTodoResponse{Queued: queued}, nil
+}
+
+// WatchTodos streams the changes of the tenant made through this instance,
+// like /ws. A watcher falling behind gets ABORTED and should list the
+// todos again before watching anew.
+func (s *todoService) WatchTodos(_ *todov1.WatchTodosRequest, stream grpc.ServerStreamingServer[todov1.TodoEvent]) error {
+	ctx := stream.Context()
+	events, _, cancel := changes.subscribe(tenantFrom(ctx), 0)
+	defer cancel()
+
+	// The headers tell the client that the watch is subscribed.
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.stopping:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case c, ok := <-events:
+			if !ok {
+				return status.Error(codes.Aborted, "watcher fell behind")
+			}
+			err := stream.Send(&todov1.TodoEvent{
+				Id:         c.Event.ID,
+				Type:       c.Event.Type,
+				OccurredAt: grpcTimestamp(c.Event.OccurredAt),
+				Todo:       protoTodo(c.Event.Todo),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
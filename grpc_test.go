@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	todov1 "github.com/qasim-invodev/todo/proto/todo/v1"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// memStore keeps the todos of a test in memory. The methods it does not
+// implement panic.
+type memStore struct {
+	todoStore
+	mu      sync.Mutex
+	todos   []todoModel
+	tenants []string
+}
+
+func (s *memStore) Each(ctx context.Context, _ listQuery, fn func(todoModel) error) error {
+	s.mu.Lock()
+	todos := append([]todoModel(nil), s.todos...)
+	s.tenants = append(s.tenants, tenantFrom(ctx))
+	s.mu.Unlock()
+	for _, tm := range todos {
+		if err := fn(tm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memStore) Get(_ context.Context, id primitive.ObjectID) (todoModel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tm := range s.todos {
+		if tm.ID == id {
+			return tm, nil
+		}
+	}
+	return todoModel{}, errNotFound
+}
+
+func (s *memStore) Create(_ context.Context, tm todoModel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.todos = append(s.todos, tm)
+	return nil
+}
+
+func (s *memStore) Update(_ context.Context, id primitive.ObjectID, fields bson.M) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, tm := range s.todos {
+		if tm.ID != id {
+			continue
+		}
+		if title, ok := fields["title"].(string); ok {
+			s.todos[i].Title = title
+		}
+		if completed, ok := fields["completed"].(bool); ok {
+			s.todos[i].Completed = completed
+		}
+		return nil
+	}
+	return errNotFound
+}
+
+func (s *memStore) Upsert(ctx context.Context, tm todoModel) (bool, error) {
+	if err := s.Update(ctx, tm.ID, bson.M{"title": tm.Title, "completed": tm.Completed}); err != errNotFound {
+		return false, err
+	}
+	return true, s.Create(ctx, tm)
+}
+
+func (s *memStore) Delete(_ context.Context, id primitive.ObjectID) (todoModel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, tm := range s.todos {
+		if tm.ID == id {
+			s.todos = append(s.todos[:i], s.todos[i+1:]...)
+			return tm, nil
+		}
+	}
+	return todoModel{}, errNotFound
+}
+
+func testTodos() []todoModel {
+	created := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	var todos []todoModel
+	for i, title := range []string{"Tea", "Milk", "Bread"} {
+		id, _ := primitive.ObjectIDFromHex("66320000000000000000000" + string(rune('1'+i)))
+		todos = append(todos, todoModel{ID: id, Title: title, Completed: i == 1, CreatedAt: created, Labels: []string{"shop"}})
+	}
+	todos[0].Priority, todos[0].Labels = 1, nil
+	return todos
+}
+
+// grpcTestClient serves the gRPC server from store over cleartext HTTP/2,
+// as grpcServer does, and returns a client of it. Closing stopping shuts
+// the watches down.
+func grpcTestClient(t *testing.T, store todoStore) (client todov1.TodoServiceClient, stopping chan struct{}) {
+	prevRepo := repo
+	repo = store
+	t.Cleanup(func() { repo = prevRepo })
+
+	stopping = make(chan struct{})
+	srv := httptest.NewServer(h2c.NewHandler(grpcHandler(newGRPCServer(stopping)), &http2.Server{}))
+	t.Cleanup(srv.Close)
+	conn, err := grpc.NewClient(srv.Listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return todov1.NewTodoServiceClient(conn), stopping
+}
+
+func wantCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != code {
+		t.Errorf("got %v (%v), want %v", got, err, code)
+	}
+}
+
+func TestGRPCTodoService(t *testing.T) {
+	client, _ := grpcTestClient(t, &memStore{todos: testTodos()})
+	ctx := context.Background()
+
+	page, err := client.ListTodos(ctx, &todov1.ListTodosRequest{PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Todos) != 2 || page.Todos[0].Title != "Tea" || page.Todos[0].Priority != 1 || page.NextPageToken != "663200000000000000000002" {
+		t.Fatalf("first page %v", page)
+	}
+	open := false
+	page, err = client.ListTodos(ctx, &todov1.ListTodosRequest{PageToken: page.NextPageToken, Completed: &open})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Todos) != 1 || page.Todos[0].Title != "Bread" || page.NextPageToken != "" {
+		t.Fatalf("second page %v", page)
+	}
+	page, err = client.ListTodos(ctx, &todov1.ListTodosRequest{Label: "shop", CreatedAfter: timestamppb.New(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Todos) != 2 || page.Todos[0].Title != "Milk" || !page.Todos[0].Completed {
+		t.Fatalf("labelled todos %v", page)
+	}
+
+	created, err := client.CreateTodo(ctx, &todov1.CreateTodoRequest{Title: "Jam", Labels: []string{"shop"}, Priority: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Queued || created.Todo.Id == "" || created.Todo.CreatedAt == nil {
+		t.Fatalf("created %v", created)
+	}
+	got, err := client.GetTodo(ctx, &todov1.GetTodoRequest{Id: created.Todo.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Jam" || got.Priority != 2 || len(got.Labels) != 1 {
+		t.Errorf("got %v", got)
+	}
+
+	updated, err := client.UpdateTodo(ctx, &todov1.UpdateTodoRequest{Id: created.Todo.Id, Title: "Jam jar", Completed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Created || updated.Todo.Title != "Jam jar" || !updated.Todo.Completed {
+		t.Errorf("updated %v", updated)
+	}
+	upserted, err := client.UpdateTodo(ctx, &todov1.UpdateTodoRequest{Id: "663200000000000000000009", Title: "Eggs", Upsert: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !upserted.Created || upserted.Todo.Title != "Eggs" {
+		t.Errorf("upserted %v", upserted)
+	}
+
+	if _, err := client.DeleteTodo(ctx, &todov1.DeleteTodoRequest{Id: created.Todo.Id}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.GetTodo(ctx, &todov1.GetTodoRequest{Id: created.Todo.Id})
+	wantCode(t, err, codes.NotFound)
+	_, err = client.DeleteTodo(ctx, &todov1.DeleteTodoRequest{Id: created.Todo.Id})
+	wantCode(t, err, codes.NotFound)
+}
+
+func TestGRPCInvalidArguments(t *testing.T) {
+	client, _ := grpcTestClient(t, &memStore{})
+	ctx := context.Background()
+
+	_, err := client.GetTodo(ctx, &todov1.GetTodoRequest{Id: "not-an-id"})
+	wantCode(t, err, codes.InvalidArgument)
+	_, err = client.ListTodos(ctx, &todov1.ListTodosRequest{PageToken: "nope"})
+	wantCode(t, err, codes.InvalidArgument)
+	_, err = client.ListTodos(ctx, &todov1.ListTodosRequest{PageSize: -1})
+	wantCode(t, err, codes.InvalidArgument)
+	_, err = client.CreateTodo(ctx, &todov1.CreateTodoRequest{})
+	wantCode(t, err, codes.InvalidArgument)
+	_, err = client.UpdateTodo(ctx, &todov1.UpdateTodoRequest{Id: "663200000000000000000001"})
+	wantCode(t, err, codes.InvalidArgument)
+}
+
+func TestGRPCUnavailable(t *testing.T) {
+	client, _ := grpcTestClient(t, mongoRepository{})
+	_, err := client.GetTodo(context.Background(), &todov1.GetTodoRequest{Id: "663200000000000000000001"})
+	wantCode(t, err, codes.Unavailable)
+}
+
+func TestGRPCTenant(t *testing.T) {
+	prevMode := tenantMode
+	tenantMode = tenantModeHeader
+	t.Cleanup(func() { tenantMode = prevMode })
+	store := &memStore{todos: testTodos()}
+	client, _ := grpcTestClient(t, store)
+
+	_, err := client.ListTodos(context.Background(), &todov1.ListTodosRequest{})
+	wantCode(t, err, codes.InvalidArgument)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-tenant-id", "Acme")
+	if _, err := client.ListTodos(ctx, &todov1.ListTodosRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.tenants) != 1 || store.tenants[0] != "acme" {
+		t.Errorf("listed for tenants %q, want acme", store.tenants)
+	}
+}
+
+func TestGRPCWatchTodos(t *testing.T) {
+	client, stopping := grpcTestClient(t, &memStore{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.WatchTodos(ctx, &todov1.WatchTodosRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The headers are sent once the watch is subscribed.
+	if _, err := stream.Header(); err != nil {
+		t.Fatal(err)
+	}
+	changes.publish(todoEvent{ID: "ev1", Type: eventTodoCreated, OccurredAt: time.Now(), Todo: todo{ID: "t1", Title: "Tea"}})
+	ev, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != eventTodoCreated || ev.Todo.Title != "Tea" || ev.OccurredAt == nil {
+		t.Errorf("got %v, want %s of Tea", ev, eventTodoCreated)
+	}
+
+	close(stopping)
+	_, err = stream.Recv()
+	wantCode(t, err, codes.Unavailable)
+}
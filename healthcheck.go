@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const healthcheckTimeout = 5 * time.Second
+
+// healthcheck asks the server running with the same configuration for
+// /readyz, on the admin listener if it has one, and returns the exit code
+// of -healthcheck: 0 when it answers 200, 1 otherwise. It only loads the
+// settings locating the server, so it runs next to it without touching
+// the write buffer or MongoDB.
+func healthcheck() int {
+	err := loadServerConfig()
+	if err == nil {
+		err = loadTLSConfig()
+	}
+	if err == nil {
+		err = loadAdminConfig()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		return 1
+	}
+
+	addr, scheme := listenAddr, "http"
+	if adminAddr != "" {
+		addr = adminAddr
+	} else if tlsEnabled() {
+		scheme = "https"
+	}
+	host, port, _ := net.SplitHostPort(addr)
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	c := &http.Client{
+		Timeout: healthcheckTimeout,
+		// The certificate is for the public name, not the address the
+		// server is reached at from inside its container.
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	resp, err := c.Get(scheme + "://" + net.JoinHostPort(host, port) + "/readyz")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unhealthy:", err)
+		return 1
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, "unhealthy:", resp.Status)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// HTTP/2 settings, see loadHTTP2Config.
+var (
+	// http2Enabled negotiates HTTP/2 with TLS clients.
+	http2Enabled = true
+	// h2cEnabled accepts HTTP/2 without TLS from trusted proxies.
+	h2cEnabled bool
+)
+
+// loadHTTP2Config reads TODO_HTTP2, false to only speak HTTP/1.1 over TLS,
+// and TODO_H2C, true to accept cleartext HTTP/2 from the proxies listed in
+// TODO_TRUSTED_PROXIES when TLS is terminated in front of the server.
+func loadHTTP2Config() error {
+	for _, b := range []struct {
+		env string
+		v   *bool
+	}{
+		{"TODO_HTTP2", &http2Enabled},
+		{"TODO_H2C", &h2cEnabled},
+	} {
+		if v := os.Getenv(b.env); v != "" {
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", b.env, v)
+			}
+			*b.v = parsed
+		}
+	}
+	if h2cEnabled && tlsEnabled() {
+		return errors.New("TODO_H2C cannot be used with TLS, which negotiates HTTP/2 itself")
+	}
+	if s := rateLimits.Load(); h2cEnabled && (s == nil || len(s.proxies) == 0) {
+		return errors.New("TODO_H2C requires TODO_TRUSTED_PROXIES")
+	}
+	return nil
+}
+
+// withH2C serves cleartext HTTP/2 on h, when enabled, to trusted proxies
+// only: other clients are answered over HTTP/1.1, or refused when they
+// start with the HTTP/2 preface.
+func withH2C(h http.Handler) http.Handler {
+	if !h2cEnabled {
+		return h
+	}
+	h2 := h2c.NewHandler(h, &http2.Server{IdleTimeout: idleTimeout})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		preface := r.Method == "PRI" && r.URL.Path == "*" && r.ProtoMajor == 2
+		upgrade := strings.EqualFold(r.Header.Get("Upgrade"), "h2c")
+		if !preface && !upgrade {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if fromTrustedProxy(r) {
+			h2.ServeHTTP(w, r)
+			return
+		}
+		if preface {
+			http.Error(w, "cleartext HTTP/2 is only accepted from trusted proxies", http.StatusForbidden)
+			return
+		}
+		r.Header.Del("Upgrade")
+		r.Header.Del("HTTP2-Settings")
+		h.ServeHTTP(w, r)
+	})
+}
+
+// fromTrustedProxy reports whether r comes straight from one of the
+// TODO_TRUSTED_PROXIES.
+func fromTrustedProxy(r *http.Request) bool {
+	s := rateLimits.Load()
+	if s == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && trustedProxy(s.proxies, addr)
+}
+
+// disableHTTP2 keeps TLS clients of srv on HTTP/1.1 unless HTTP/2 is
+// enabled. It is called once srv.TLSConfig is set.
+func disableHTTP2(srv *http.Server) {
+	if http2Enabled {
+		return
+	}
+	srv.TLSConfig.NextProtos = slices.DeleteFunc(slices.Clone(srv.TLSConfig.NextProtos), func(p string) bool { return p == "h2" })
+	srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+}
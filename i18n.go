@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/message/catalog"
+)
+
+// locales are the languages of the UI and API messages, English first as
+// the default. Messages are written in English in the code and templates,
+// and translated by their English text.
+var locales = []language.Tag{language.English, language.German}
+
+var (
+	localeMatcher = language.NewMatcher(locales)
+	// translations are those of every locale but English, by English
+	// message.
+	translations = map[language.Tag]map[string]string{
+		language.German: germanMessages,
+	}
+	messages = catalog.NewBuilder(catalog.Fallback(language.English))
+)
+
+func init() {
+	for tag, msgs := range translations {
+		for key, msg := range msgs {
+			messages.SetString(tag, key, msg)
+		}
+	}
+}
+
+// locale translates messages to one of locales.
+type locale struct {
+	tag     language.Tag
+	printer *message.Printer
+}
+
+func newLocale(tag language.Tag) locale {
+	return locale{tag: tag, printer: message.NewPrinter(tag, message.Catalog(messages))}
+}
+
+// T returns the translation of the English message key, formatted with
+// args like fmt.Sprintf. Messages without one stay in English. Templates
+// call it as {{.T "New todo"}}.
+func (l locale) T(key string, args ...any) string {
+	return l.printer.Sprintf(key, args...)
+}
+
+// lookup returns the translation of the English message s, or s. Unlike
+// T, s is not a format, so it can be any text.
+func (l locale) lookup(s string) string {
+	if t, ok := translations[l.tag][s]; ok {
+		return t
+	}
+	return s
+}
+
+// Lang returns the BCP 47 code of the locale, for the lang of pages.
+func (l locale) Lang() string {
+	return l.tag.String()
+}
+
+// matchLocale returns the locale for pref, a language code of the user's
+// preferences, or when it is empty for the Accept-Language header of r.
+func matchLocale(r *http.Request, pref string) locale {
+	var tags []language.Tag
+	if pref != "" {
+		tags = []language.Tag{language.Make(pref)}
+	} else {
+		tags, _, _ = language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	}
+	_, i, _ := localeMatcher.Match(tags...)
+	return newLocale(locales[i])
+}
+
+// supportedLanguage reports whether code names one of locales exactly.
+func supportedLanguage(code string) bool {
+	for _, tag := range locales {
+		if tag.String() == code {
+			return true
+		}
+	}
+	return false
+}
+
+// localizeMiddleware translates the message and error fields of the JSON
+// objects answered to the language of the Accept-Language header. Other
+// fields, such as the stable error codes, and other answers pass through
+// untouched. It runs outside cacheMiddleware, so cached answers are kept
+// in English and translated for every client.
+func localizeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Language")
+		l := matchLocale(r, "")
+		if l.tag == language.English {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&localeWriter{ResponseWriter: w, locale: l}, r)
+	})
+}
+
+// localeWriter translates the JSON object answers written through it, see
+// localizeMiddleware.
+type localeWriter struct {
+	http.ResponseWriter
+	locale locale
+	wrote  bool
+}
+
+func (w *localeWriter) WriteHeader(status int) {
+	if !w.wrote && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		// The body changes, a length set by the handler would be wrong.
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Language", w.locale.Lang())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *localeWriter) Write(b []byte) (int, error) {
+	first := !w.wrote
+	w.wrote = true
+	if !first || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		return w.ResponseWriter.Write(b)
+	}
+	if _, err := w.ResponseWriter.Write(translateFields(b, w.locale)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// translateFields translates the string message and error fields of the
+// JSON object body.
+func translateFields(body []byte, l locale) []byte {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil || fields == nil {
+		return body
+	}
+	changed := false
+	for _, k := range []string{"message", "error"} {
+		var s string
+		if json.Unmarshal(fields[k], &s) != nil {
+			continue
+		}
+		if t := l.lookup(s); t != s {
+			fields[k], _ = json.Marshal(t)
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+func (w *localeWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *localeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("response does not support hijacking")
+}
+
+func (w *localeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type pageLocaleKey struct{}
+
+// pageLocale returns the locale of the page request of ctx, see
+// withPagePreferences.
+func pageLocale(ctx context.Context) locale {
+	l, ok := ctx.Value(pageLocaleKey{}).(locale)
+	if !ok {
+		return newLocale(language.English)
+	}
+	return l
+}
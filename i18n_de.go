@@ -0,0 +1,171 @@
+package main
+
+// germanMessages translate the messages of the API and the pages to German,
+// by English message. Fields passed to T keep their verbs.
+var germanMessages = map[string]string{
+	// API
+	"invalid request":                               "ungültige Anfrage",
+	"invalid id":                                    "ungültige ID",
+	"missing or invalid tenant":                     "fehlender oder ungültiger Mandant",
+	"request is too large":                          "die Anfrage ist zu groß",
+	"request body too large":                        "der Inhalt der Anfrage ist zu groß",
+	"todo created successfully":                     "Aufgabe erfolgreich erstellt",
+	"todo updated successfully":                     "Aufgabe erfolgreich aktualisiert",
+	"todo deleted successfully":                     "Aufgabe erfolgreich gelöscht",
+	"todo queued until the database is reachable":   "Aufgabe bis zur Erreichbarkeit der Datenbank vorgemerkt",
+	"update queued until the database is reachable": "Änderung bis zur Erreichbarkeit der Datenbank vorgemerkt",
+	"delete queued until the database is reachable": "Löschen bis zur Erreichbarkeit der Datenbank vorgemerkt",
+	"could not fetch todos":                         "Aufgaben konnten nicht abgerufen werden",
+	"could not fetch todo":                          "Aufgabe konnte nicht abgerufen werden",
+	"could not create todo":                         "Aufgabe konnte nicht erstellt werden",
+	"could not update todo":                         "Aufgabe konnte nicht aktualisiert werden",
+	"could not delete todo":                         "Aufgabe konnte nicht gelöscht werden",
+	"could not save todo":                           "Aufgabe konnte nicht gespeichert werden",
+	"could not search todos":                        "Aufgaben konnten nicht durchsucht werden",
+	"could not compute stats":                       "Statistiken konnten nicht berechnet werden",
+	"could not fetch preferences":                   "Einstellungen konnten nicht abgerufen werden",
+	"could not save preferences":                    "Einstellungen konnten nicht gespeichert werden",
+	"preferences require the mongo store":           "Einstellungen erfordern den Mongo-Speicher",
+	"database unavailable":                          "Datenbank nicht verfügbar",
+	"rate limit exceeded, slow down":                "Anfragelimit überschritten, bitte langsamer",
+	"the server is overloaded, try again shortly":   "der Server ist überlastet, bitte gleich erneut versuchen",
+	"the request took too long":                     "die Anfrage hat zu lange gedauert",
+	"internal server error":                         "interner Serverfehler",
+	"unknown timezone":                              "unbekannte Zeitzone",
+	"unknown time zone":                             "unbekannte Zeitzone",
+	"theme must be light, dark or auto":             "theme muss light, dark oder auto sein",
+	"date_format must be iso, us, eu or long":       "date_format muss iso, us, eu oder long sein",
+	"week_start must be monday or sunday":           "week_start muss monday oder sunday sein",
+	"default_list is too long":                      "default_list ist zu lang",
+	"language must be en, de or empty":              "language muss en, de oder leer sein",
+
+	// Validation
+	"title is required":                          "der Titel ist erforderlich",
+	"title is too long":                          "der Titel ist zu lang",
+	"description is too long":                    "die Beschreibung ist zu lang",
+	"list is too long":                           "die Liste ist zu lang",
+	"too many labels":                            "zu viele Labels",
+	"labels must be between 1 and 50 characters": "Labels müssen zwischen 1 und 50 Zeichen lang sein",
+	"priority must be between 1 and 4":           "die Priorität muss zwischen 1 und 4 liegen",
+	"status must be open or done":                "der Status muss open oder done sein",
+	"due must be a date like 2024-06-01":         "die Fälligkeit muss ein Datum wie 2024-06-01 sein",
+
+	// Errors of the store, see errorKind
+	"database temporarily unavailable":            "Datenbank vorübergehend nicht verfügbar",
+	"the database took too long to answer":        "die Datenbank hat zu lange für eine Antwort gebraucht",
+	"the database failed to complete the request": "die Datenbank konnte die Anfrage nicht abschließen",
+	"id already in use":                           "ID bereits vergeben",
+	"the request was canceled":                    "die Anfrage wurde abgebrochen",
+
+	// Error values
+	"bad request":         "ungültige Anfrage",
+	"not found":           "nicht gefunden",
+	"conflict":            "Konflikt",
+	"not implemented":     "nicht implementiert",
+	"service unavailable": "Dienst nicht verfügbar",
+	"gateway timeout":     "Zeitüberschreitung",
+	"too many requests":   "zu viele Anfragen",
+	"unauthorized":        "nicht autorisiert",
+	"under maintenance":   "Wartungsarbeiten",
+
+	// Pages
+	"Daily Todo Lists":        "Tägliche Aufgabenlisten",
+	"Add your todo":           "Aufgabe hinzufügen",
+	"Todo":                    "Aufgabe",
+	"Todos":                   "Aufgaben",
+	"Search titles":           "Titel durchsuchen",
+	"Any status":              "Jeder Status",
+	"Open":                    "Offen",
+	"Done":                    "Erledigt",
+	"Any list":                "Jede Liste",
+	"Any label":               "Jedes Label",
+	"Any priority":            "Jede Priorität",
+	"Filter":                  "Filtern",
+	"Title":                   "Titel",
+	"List":                    "Liste",
+	"Labels":                  "Labels",
+	"Priority":                "Priorität",
+	"Due":                     "Fällig",
+	"No todos.":               "Keine Aufgaben.",
+	"Previous":                "Zurück",
+	"Next":                    "Weiter",
+	"Page %d of %d, %d todos": "Seite %d von %d, %d Aufgaben",
+	"New todo":                "Neue Aufgabe",
+	"Description":             "Beschreibung",
+	"Labels, comma separated": "Labels, durch Kommas getrennt",
+	"No priority":             "Keine Priorität",
+	"Add":                     "Hinzufügen",
+	"Edit":                    "Bearbeiten",
+	"Delete":                  "Löschen",
+	"Delete this todo?":       "Diese Aufgabe löschen?",
+	"Save":                    "Speichern",
+	"Cancel":                  "Abbrechen",
+	"overdue":                 "überfällig",
+	"today":                   "heute",
+	"Todo created.":           "Aufgabe erstellt.",
+	"Todo queued until the database is reachable.": "Aufgabe bis zur Erreichbarkeit der Datenbank vorgemerkt.",
+	"Could not fetch todos: %s":                    "Aufgaben konnten nicht abgerufen werden: %s",
+	"Could not create the todo: %s":                "Die Aufgabe konnte nicht erstellt werden: %s",
+	"Could not create the todo":                    "Die Aufgabe konnte nicht erstellt werden",
+	"Could not fetch the todo":                     "Die Aufgabe konnte nicht abgerufen werden",
+	"Could not update the todo":                    "Die Aufgabe konnte nicht aktualisiert werden",
+	"Could not delete the todo":                    "Die Aufgabe konnte nicht gelöscht werden",
+	"The form could not be read.":                  "Das Formular konnte nicht gelesen werden.",
+	"Invalid todo id.":                             "Ungültige Aufgaben-ID.",
+
+	// Dashboard
+	"Admin": "Verwaltung",
+	"As of %s UTC, refreshed every 30 seconds.": "Stand %s UTC, alle 30 Sekunden aktualisiert.",
+	"Could not load the %s: %s":                 "%s konnten nicht geladen werden: %s",
+	"todo counts":                               "Aufgabenzahlen",
+	"webhook deliveries":                        "Webhook-Zustellungen",
+	"Database":                                  "Datenbank",
+	"Store":                                     "Speicher",
+	"Status":                                    "Status",
+	"available":                                 "verfügbar",
+	"unavailable":                               "nicht verfügbar",
+	"Ping":                                      "Ping",
+	"Circuit breaker":                           "Circuit Breaker",
+	"Buffered writes":                           "Gepufferte Schreibvorgänge",
+	"Tenant":                                    "Mandant",
+	"Overdue":                                   "Überfällig",
+	"All":                                       "Alle",
+	"(no tenant)":                               "(kein Mandant)",
+	"Recent activity":                           "Letzte Aktivität",
+	"Time":                                      "Zeit",
+	"Event":                                     "Ereignis",
+	"No changes since the server started.":      "Keine Änderungen seit dem Start des Servers.",
+	"Webhooks":                                  "Webhooks",
+	"%d webhooks, deliveries kept: %d pending, %d delivered, %d failed.": "%d Webhooks, aufbewahrte Zustellungen: %d ausstehend, %d zugestellt, %d fehlgeschlagen.",
+	"Attempts":   "Versuche",
+	"Last error": "Letzter Fehler",
+
+	// Boards
+	"could not fetch board":                        "Board konnte nicht abgerufen werden",
+	"could not move todo":                          "Aufgabe konnte nicht verschoben werden",
+	"todo moved successfully":                      "Aufgabe erfolgreich verschoben",
+	"todo not found in this list":                  "Aufgabe in dieser Liste nicht gefunden",
+	"move queued until the database is reachable":  "Verschieben bis zur Erreichbarkeit der Datenbank vorgemerkt",
+	"Move queued until the database is reachable.": "Verschieben bis zur Erreichbarkeit der Datenbank vorgemerkt.",
+	"Back to the todos":                            "Zurück zu den Aufgaben",
+	"Move to %s":                                   "Nach %s verschieben",
+	"Move up":                                      "Nach oben",
+	"Move down":                                    "Nach unten",
+	"Could not fetch the board: %s":                "Das Board konnte nicht abgerufen werden: %s",
+	"Could not move the todo: %s":                  "Die Aufgabe konnte nicht verschoben werden: %s",
+	"Unknown column or place.":                     "Unbekannte Spalte oder Stelle.",
+
+	// PDF export
+	"could not export list": "Liste konnte nicht exportiert werden",
+	"list has no todos":     "Liste enthält keine Aufgaben",
+	"Exported %s, %d todos": "Exportiert am %s, %d Aufgaben",
+	"Due %s":                "Fällig am %s",
+	"Page %d of %d":         "Seite %d von %d",
+
+	// Offline
+	"You are offline. This page has not been opened here before, so there is no copy of it.": "Keine Verbindung. Diese Seite wurde hier noch nicht geöffnet, daher gibt es keine Kopie von ihr.",
+	"Try again": "Erneut versuchen",
+
+	// Timeline
+	"could not fetch timeline": "Zeitleiste konnte nicht abgerufen werden",
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+func TestMatchLocale(t *testing.T) {
+	tests := []struct {
+		accept, pref string
+		want         string
+	}{
+		{"", "", "en"},
+		{"de-DE,de;q=0.9,en;q=0.8", "", "de"},
+		{"fr-FR,fr;q=0.9", "", "en"},
+		{"fr;q=0.9,de-AT;q=0.5", "", "de"},
+		{"de", "en", "en"},
+		{"", "de", "de"},
+		{"not a language", "", "en"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Language", tt.accept)
+		if got := matchLocale(r, tt.pref).Lang(); got != tt.want {
+			t.Errorf("matchLocale(%q, %q) = %s, want %s", tt.accept, tt.pref, got, tt.want)
+		}
+	}
+}
+
+// TestGermanMessagesKeepVerbs checks translations format the same
+// arguments as their English messages.
+func TestGermanMessagesKeepVerbs(t *testing.T) {
+	verb := regexp.MustCompile(`%[-+# 0]*[0-9]*(\.[0-9]+)?[a-zA-Z%]`)
+	for en, de := range germanMessages {
+		if !slices.Equal(verb.FindAllString(en, -1), verb.FindAllString(de, -1)) {
+			t.Errorf("%q translates to %q with other verbs", en, de)
+		}
+	}
+}
+
+func TestLocalizeMiddleware(t *testing.T) {
+	prevRnd := rnd
+	rnd = renderer.New()
+	t.Cleanup(func() { rnd = prevRnd })
+
+	h := localizeMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid id", "error": "not translated", "code": "invalid id"})
+	}))
+	serve := func(accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/todo/x", nil)
+		r.Header.Set("Accept-Language", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("de")
+	if got, want := w.Body.String(), `{"code":"invalid id","error":"not translated","message":"ungültige ID"}`; got != want {
+		t.Errorf("German body = %s, want %s", got, want)
+	}
+	if w.Code != http.StatusBadRequest || w.Header().Get("Content-Language") != "de" || w.Header().Get("Vary") != "Accept-Language" {
+		t.Errorf("German answer %d with headers %v", w.Code, w.Header())
+	}
+
+	w = serve("en-GB")
+	if got, want := w.Body.String(), `{"code":"invalid id","error":"not translated","message":"invalid id"}`; got != want {
+		t.Errorf("English body = %s, want %s", got, want)
+	}
+	if w.Header().Get("Content-Language") != "" {
+		t.Errorf("English answer has Content-Language %q", w.Header().Get("Content-Language"))
+	}
+}
@@ -0,0 +1,579 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	icalSubscriptionsCollectionName = "ical_subscriptions"
+
+	icalTimeout                   = 30 * time.Second
+	icalSyncInterval              = time.Hour
+	icalSyncLease                 = 5 * time.Minute
+	maxICalSubscriptionsPerTenant = 20
+)
+
+// icalSubscription is a remote calendar whose entries are imported again
+// every sync interval. Calendar URLs often embed a private token, so the
+// URL is stored encrypted.
+type icalSubscription struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	TenantID   string             `bson:"tenant_id" json:"-"`
+	URL        string             `bson:"url" json:"-"`
+	List       string             `bson:"list,omitempty" json:"list,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	NextSyncAt time.Time          `bson:"next_sync_at" json:"next_sync_at"`
+	LeaseUntil time.Time          `bson:"lease_until" json:"-"`
+	LastReport *importReport      `bson:"last_report,omitempty" json:"last_report,omitempty"`
+	LastError  string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+}
+
+func icalSubscriptionsCollection() *mongo.Collection {
+	return db.Collection(icalSubscriptionsCollectionName, writeCollectionOpts)
+}
+
+func ensureICalIndexes(ctx context.Context) error {
+	_, err := icalSubscriptionsCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}}},
+		{Keys: bson.D{{Key: "next_sync_at", Value: 1}}},
+	})
+	return err
+}
+
+// icalComponent is a VTODO or VEVENT with its properties by name.
+type icalComponent struct {
+	Kind  string
+	Props map[string][]icalProp
+}
+
+type icalProp struct {
+	Params map[string]string
+	Value  string
+}
+
+func (c icalComponent) get(name string) (icalProp, bool) {
+	if ps := c.Props[name]; len(ps) > 0 {
+		return ps[0], true
+	}
+	return icalProp{}, false
+}
+
+func (c icalComponent) text(name string) string {
+	p, _ := c.get(name)
+	return icalUnescape(p.Value)
+}
+
+// parseICal reads the VTODO and VEVENT components of an iCalendar
+// document, see RFC 5545, and the X-WR-CALNAME of the calendar.
+func parseICal(data []byte) (name string, comps []icalComponent, err error) {
+	var lines []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64<<10), maxImportBodySize)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		// Folded lines continue with a space or a tab.
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", nil, err
+	}
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return "", nil, errors.New("not an iCalendar document")
+	}
+
+	var (
+		cur   *icalComponent
+		depth int
+	)
+	for _, line := range lines {
+		head, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Split(head, ";")
+		prop := strings.ToUpper(fields[0])
+		switch prop {
+		case "BEGIN":
+			kind := strings.ToUpper(value)
+			if cur == nil && (kind == "VTODO" || kind == "VEVENT") {
+				cur = &icalComponent{Kind: kind, Props: map[string][]icalProp{}}
+			} else if cur != nil {
+				// Nested components, like VALARM, are skipped.
+				depth++
+			}
+			continue
+		case "END":
+			if cur != nil && depth > 0 {
+				depth--
+			} else if cur != nil {
+				comps = append(comps, *cur)
+				cur = nil
+			}
+			continue
+		}
+		if cur == nil {
+			if prop == "X-WR-CALNAME" {
+				name = icalUnescape(value)
+			}
+			continue
+		}
+		if depth > 0 {
+			continue
+		}
+		p := icalProp{Params: map[string]string{}, Value: value}
+		for _, f := range fields[1:] {
+			if k, v, ok := strings.Cut(f, "="); ok {
+				p.Params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+			}
+		}
+		cur.Props[prop] = append(cur.Props[prop], p)
+	}
+	return name, comps, nil
+}
+
+var icalUnescaper = strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+
+func icalUnescape(s string) string {
+	return icalUnescaper.Replace(s)
+}
+
+// icalTime parses a DATE or DATE-TIME value. Floating times are taken in
+// the zone of TZID, or UTC without one.
+func icalTime(p icalProp) (time.Time, error) {
+	loc := time.UTC
+	if tz := p.Params["TZID"]; tz != "" {
+		l, err := time.LoadLocation(tz)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unknown time zone %q", tz)
+		}
+		loc = l
+	}
+	v := strings.TrimSpace(p.Value)
+	switch {
+	case strings.HasSuffix(v, "Z"):
+		return time.Parse("20060102T150405Z", v)
+	case len(v) == len("20060102"):
+		return time.ParseInLocation("20060102", v, loc)
+	default:
+		return time.ParseInLocation("20060102T150405", v, loc)
+	}
+}
+
+// mapICal turns VTODOs and upcoming VEVENTs into todos of list, or of the
+// calendar name when list is empty. The UID is kept as the source, so
+// importing the calendar again only adds new entries. Events that are over
+// and cancelled entries are skipped.
+func mapICal(data []byte, list string) (*importReport, []todoModel, error) {
+	name, comps, err := parseICal(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if list == "" {
+		list = truncate(strings.TrimSpace(name), maxListLength)
+	}
+	rep := &importReport{Source: "ical"}
+	if list != "" {
+		rep.Lists = append(rep.Lists, list)
+	}
+
+	now := time.Now()
+	var todos []todoModel
+	for _, c := range comps {
+		rep.Found++
+		uid := c.text("UID")
+		if uid == "" {
+			rep.Skipped++
+			rep.warnf("%s without UID skipped", c.Kind)
+			continue
+		}
+		status := strings.ToUpper(c.text("STATUS"))
+		if status == "CANCELLED" {
+			rep.Skipped++
+			continue
+		}
+		title := strings.TrimSpace(c.text("SUMMARY"))
+		if title == "" {
+			rep.Skipped++
+			rep.warnf("%s %s: empty summary, skipped", c.Kind, uid)
+			continue
+		}
+		if len([]rune(title)) > maxTitleLength {
+			rep.warnf("%s %s: summary truncated to %d characters", c.Kind, uid, maxTitleLength)
+			title = truncate(title, maxTitleLength)
+		}
+
+		tm := todoModel{
+			ID:          primitive.NewObjectID(),
+			Title:       title,
+			Description: strings.TrimSpace(c.text("DESCRIPTION")),
+			CreatedAt:   now,
+			List:        list,
+			Source:      "ical:" + uid,
+		}
+		if p, ok := c.get("CREATED"); ok {
+			if t, err := icalTime(p); err == nil {
+				tm.CreatedAt = t
+			}
+		}
+
+		// A todo is due at DUE, an event when it starts.
+		dueProp := "DUE"
+		if c.Kind == "VEVENT" {
+			dueProp = "DTSTART"
+			end, ok := c.get("DTEND")
+			if !ok {
+				end, ok = c.get("DTSTART")
+			}
+			if t, err := icalTime(end); ok && err == nil && t.Before(now) {
+				rep.Skipped++
+				continue
+			}
+		}
+		if p, ok := c.get(dueProp); ok {
+			if due, err := icalTime(p); err == nil {
+				tm.DueAt = &due
+			} else {
+				rep.warnf("%s %s: %v, due date dropped", c.Kind, uid, err)
+			}
+		}
+		if _, ok := c.get("RRULE"); ok {
+			rep.warnf("%s %s: recurrence is not supported, only the first occurrence was kept", c.Kind, uid)
+		}
+
+		tm.Completed = status == "COMPLETED"
+		if _, ok := c.get("COMPLETED"); ok {
+			tm.Completed = true
+		}
+		// iCalendar priorities run from 1, highest, to 9, lowest, with 0
+		// for none.
+		switch p, _ := strconv.Atoi(c.text("PRIORITY")); {
+		case p >= 1 && p <= 4:
+			tm.Priority = 1
+		case p == 5:
+			tm.Priority = 2
+		case p >= 6 && p <= 9:
+			tm.Priority = 3
+		}
+		for _, cat := range c.Props["CATEGORIES"] {
+			for _, l := range splitICalList(cat.Value) {
+				if len(tm.Labels) == maxLabels {
+					break
+				}
+				if l = truncate(strings.TrimSpace(l), maxLabelLength); l != "" {
+					tm.Labels = append(tm.Labels, l)
+				}
+			}
+		}
+		todos = append(todos, tm)
+	}
+	return rep, todos, nil
+}
+
+// splitICalList splits a comma separated value, honoring escaped commas.
+func splitICalList(v string) []string {
+	var (
+		out []string
+		cur strings.Builder
+	)
+	for i := 0; i < len(v); i++ {
+		switch {
+		case v[i] == '\\' && i+1 < len(v):
+			cur.WriteByte(v[i])
+			cur.WriteByte(v[i+1])
+			i++
+		case v[i] == ',':
+			out = append(out, icalUnescape(cur.String()))
+			cur.Reset()
+		default:
+			cur.WriteByte(v[i])
+		}
+	}
+	return append(out, icalUnescape(cur.String()))
+}
+
+// icalURL checks a calendar URL, accepting webcal:// for https://. It
+// must resolve to public addresses, see checkOutboundURL.
+func icalURL(ctx context.Context, raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "webcal" {
+		u.Scheme = "https"
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", errors.New("url must be an absolute http, https or webcal URL")
+	}
+	if err := checkOutboundURL(ctx, u.String()); err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// fetchICal downloads a calendar through outboundClient, so neither the
+// URL nor its redirects reach a non-public address.
+func fetchICal(ctx context.Context, calendarURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, icalTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, calendarURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/calendar")
+	resp, err := outboundClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxImportBodySize))
+}
+
+// importICal serves POST /import/ical with an iCalendar document, or
+// {"url": "..."} to fetch one. ?list= sets the list of the todos, which
+// defaults to the calendar name. ?dry_run=true only returns the report.
+func importICal(w http.ResponseWriter, r *http.Request) {
+	dry, ok := dryRun(w, r)
+	if !ok {
+		return
+	}
+	list := r.URL.Query().Get("list")
+	if msg := validateDetails(list, nil, 0); msg != "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": msg, "error": "bad request"})
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBodySize))
+	if err != nil {
+		rnd.JSON(w, http.StatusRequestEntityTooLarge, renderer.M{"message": "import is too large", "error": err.Error()})
+		return
+	}
+
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		var req struct {
+			URL string `json:"url"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid request", "error": err.Error()})
+			return
+		}
+		calendarURL, err := icalURL(r.Context(), req.URL)
+		if err != nil {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid url", "error": err.Error()})
+			return
+		}
+		if body, err = fetchICal(r.Context(), calendarURL); err != nil {
+			rnd.JSON(w, http.StatusBadGateway, renderer.M{"message": "could not fetch the calendar", "error": err.Error()})
+			return
+		}
+	}
+
+	rep, todos, err := mapICal(body, list)
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid iCalendar document", "error": err.Error()})
+		return
+	}
+	if len(todos) > maxImportTodos {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": fmt.Sprintf("at most %d entries can be imported at once", maxImportTodos), "error": "bad request"})
+		return
+	}
+	rep.DryRun = dry
+	finishImport(w, r, rep, todos)
+}
+
+func requireICalSubscriptions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !usingMongo() {
+			rnd.JSON(w, http.StatusNotImplemented, renderer.M{"message": "calendar subscriptions require the mongo store", "error": "not implemented"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func fetchICalSubscriptions(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	cursor, err := icalSubscriptionsCollection().Find(ctx, bson.M{"tenant_id": tenantFrom(ctx)})
+	if err != nil {
+		storeErr(w, r, "could not fetch calendar subscriptions", err)
+		return
+	}
+	subs := []icalSubscription{}
+	if err := cursor.All(ctx, &subs); err != nil {
+		storeErr(w, r, "could not fetch calendar subscriptions", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": subs})
+}
+
+// createICalSubscription subscribes to {"url", "list"} and imports the
+// calendar right away. It is imported again every hour after that.
+func createICalSubscription(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL  string `json:"url"`
+		List string `json:"list"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid request", "error": err.Error()})
+		return
+	}
+	calendarURL, err := icalURL(r.Context(), req.URL)
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid url", "error": err.Error()})
+		return
+	}
+	if msg := validateDetails(req.List, nil, 0); msg != "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": msg, "error": "bad request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	n, err := icalSubscriptionsCollection().CountDocuments(ctx, bson.M{"tenant_id": tenantFrom(ctx)})
+	if err == nil && n >= maxICalSubscriptionsPerTenant {
+		rnd.JSON(w, http.StatusConflict, renderer.M{"message": "calendar subscription limit reached", "error": "conflict"})
+		return
+	}
+	now := time.Now()
+	s := icalSubscription{
+		ID:         primitive.NewObjectID(),
+		TenantID:   tenantFrom(ctx),
+		List:       req.List,
+		CreatedAt:  now,
+		NextSyncAt: now.Add(icalSyncInterval),
+		LeaseUntil: now.Add(icalSyncLease),
+	}
+	if err == nil {
+		s.URL, err = encryptField(s.ID, calendarURL)
+	}
+	if err == nil {
+		_, err = icalSubscriptionsCollection().InsertOne(ctx, s)
+	}
+	if err != nil {
+		storeErr(w, r, "could not create calendar subscription", err)
+		return
+	}
+	s = syncICalSubscription(ctx, s)
+	rnd.JSON(w, http.StatusCreated, renderer.M{"data": s})
+}
+
+// deleteICalSubscription unsubscribes. The imported todos stay.
+func deleteICalSubscription(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	res, err := icalSubscriptionsCollection().DeleteOne(ctx, bson.M{"_id": id, "tenant_id": tenantFrom(ctx)})
+	if err == nil && res.DeletedCount == 0 {
+		err = errNotFound
+	}
+	if err != nil {
+		storeErr(w, r, "could not delete calendar subscription", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"message": "calendar subscription deleted successfully"})
+}
+
+// syncICal imports the subscribed calendars that are due. Subscriptions
+// are claimed with a lease so only one instance imports a calendar at a
+// time. It is scheduled every minute.
+func syncICal(ctx context.Context) error {
+	for dbConnected.Load() {
+		now := time.Now()
+		var s icalSubscription
+		err := icalSubscriptionsCollection().FindOneAndUpdate(ctx,
+			bson.M{"next_sync_at": bson.M{"$lte": now}, "lease_until": bson.M{"$lte": now}},
+			bson.M{"$set": bson.M{"lease_until": now.Add(icalSyncLease)}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&s)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not claim subscription: %w", err)
+		}
+		if s = syncICalSubscription(ctx, s); s.LastError != "" {
+			slog.Warn("import failed", "component", "ical", "subscription", s.ID.Hex(), "error", s.LastError)
+		}
+	}
+	return nil
+}
+
+// syncICalSubscription imports the calendar of a claimed subscription,
+// stores the report on it and releases it until the next sync.
+func syncICalSubscription(ctx context.Context, s icalSubscription) icalSubscription {
+	ctx, cancel := context.WithTimeout(withTenant(ctx, s.TenantID), time.Minute)
+	defer cancel()
+
+	rep, err := importICalSubscription(ctx, s)
+	s.LastReport, s.LastError = rep, ""
+	if err != nil {
+		s.LastError = err.Error()
+	}
+	s.NextSyncAt = time.Now().Add(icalSyncInterval)
+	s.LeaseUntil = time.Time{}
+	_, err = icalSubscriptionsCollection().UpdateByID(ctx, s.ID, bson.M{"$set": bson.M{
+		"last_report":  s.LastReport,
+		"last_error":   s.LastError,
+		"next_sync_at": s.NextSyncAt,
+		"lease_until":  s.LeaseUntil,
+	}})
+	if err != nil {
+		slog.Error("could not store import report", "component", "ical", "subscription", s.ID.Hex(), "error", err)
+	}
+	return s
+}
+
+func importICalSubscription(ctx context.Context, s icalSubscription) (*importReport, error) {
+	calendarURL, err := decryptField(s.ID, s.URL)
+	if err != nil {
+		return nil, err
+	}
+	data, err := fetchICal(ctx, calendarURL)
+	if err != nil {
+		return nil, err
+	}
+	rep, todos, err := mapICal(data, s.List)
+	if err != nil {
+		return nil, err
+	}
+	if len(todos) > maxImportTodos {
+		return rep, fmt.Errorf("at most %d entries can be imported at once", maxImportTodos)
+	}
+	if todos, err = dropImported(ctx, rep, todos); err != nil {
+		return rep, err
+	}
+	return rep, createImported(ctx, rep, todos)
+}
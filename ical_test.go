@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestICalURL(t *testing.T) {
+	allowOutbound(t)
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"webcal://93.184.216.34/cal.ics", "https://93.184.216.34/cal.ics"},
+		{"https://93.184.216.34/cal.ics", "https://93.184.216.34/cal.ics"},
+		{"http://93.184.216.34/cal.ics", "http://93.184.216.34/cal.ics"},
+		{"webcal://127.0.0.1/cal.ics", ""},
+		{"http://169.254.169.254/latest/meta-data/", ""},
+		{"http://[fd00::1]/cal.ics", ""},
+		{"file:///etc/passwd", ""},
+		{"cal.ics", ""},
+	}
+	for _, tt := range tests {
+		got, err := icalURL(context.Background(), tt.raw)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("icalURL(%q) = %q, want an error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("icalURL(%q) = %q, %v, want %q", tt.raw, got, err, tt.want)
+		}
+	}
+}
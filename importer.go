@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+)
+
+const (
+	maxImportBodySize = 20 << 20
+	maxImportTodos    = 10000
+)
+
+// Import settings, see loadImportConfig.
+var (
+	// importBatchSize is how many todos an import inserts at once.
+	importBatchSize = 500
+	// importConcurrency is how many batches of an import are inserted at
+	// the same time.
+	importConcurrency = 4
+)
+
+// loadImportConfig reads TODO_IMPORT_BATCH_SIZE, the todos an import
+// inserts at once, and TODO_IMPORT_CONCURRENCY, how many of those batches
+// run at the same time.
+func loadImportConfig() error {
+	importBatchSize, importConcurrency = 500, 4
+	if v := os.Getenv("TODO_IMPORT_BATCH_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxImportTodos {
+			return fmt.Errorf("invalid TODO_IMPORT_BATCH_SIZE %q, must be a number of todos up to %d", v, maxImportTodos)
+		}
+		importBatchSize = n
+	}
+	if v := os.Getenv("TODO_IMPORT_CONCURRENCY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid TODO_IMPORT_CONCURRENCY %q, must be a positive number", v)
+		}
+		importConcurrency = n
+	}
+	return nil
+}
+
+// importReport describes what an import did, or would do in a dry run.
+type importReport struct {
+	Source   string   `json:"source"`
+	DryRun   bool     `json:"dry_run"`
+	Lists    []string `json:"lists"`
+	Found    int      `json:"found"`
+	Imported int      `json:"imported"`
+	Skipped  int      `json:"skipped"`
+	// Existing counts todos left out because an earlier import of the
+	// same source already created them.
+	Existing int      `json:"existing"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+func (rep *importReport) warnf(format string, args ...interface{}) {
+	rep.Warnings = append(rep.Warnings, fmt.Sprintf(format, args...))
+}
+
+func importHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(requireStore)
+	rg.Use(tenantMiddleware)
+	rg.Post("/todoist", importTodoist)
+	rg.Post("/trello", importTrello)
+	rg.Post("/ical", importICal)
+	rg.With(requireICalSubscriptions).Get("/ical/subscriptions", fetchICalSubscriptions)
+	rg.With(requireICalSubscriptions).Post("/ical/subscriptions", createICalSubscription)
+	rg.With(requireICalSubscriptions).Delete("/ical/subscriptions/{id}", deleteICalSubscription)
+	return rg
+}
+
+// dryRun reports whether the request only asks for the import report.
+func dryRun(w http.ResponseWriter, r *http.Request) (dry, ok bool) {
+	v := r.URL.Query().Get("dry_run")
+	if v == "" {
+		return false, true
+	}
+	dry, err := strconv.ParseBool(v)
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "dry_run must be true or false", "error": err.Error()})
+		return false, false
+	}
+	return dry, true
+}
+
+// finishImport stores the mapped todos unless rep is a dry run and answers
+// with the report. Todos whose source an earlier import already created are
+// left out, so running an import again only adds what is new.
+func finishImport(w http.ResponseWriter, r *http.Request, rep *importReport, todos []todoModel) {
+	if rep.Lists == nil {
+		rep.Lists = []string{}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	todos, err := dropImported(ctx, rep, todos)
+	if err != nil {
+		storeErr(w, r, "could not check for earlier imports", err)
+		return
+	}
+
+	if rep.DryRun {
+		rep.Imported = len(todos)
+		rnd.JSON(w, http.StatusOK, renderer.M{"data": rep})
+		return
+	}
+
+	if err := createImported(ctx, rep, todos); err != nil {
+		storeErr(w, r, "import failed after "+strconv.Itoa(rep.Imported)+" todos", err)
+		return
+	}
+	rnd.JSON(w, http.StatusCreated, renderer.M{"data": rep})
+}
+
+// dropImported leaves out the todos whose source an earlier import of the
+// tenant of ctx already created, counting them as existing.
+func dropImported(ctx context.Context, rep *importReport, todos []todoModel) ([]todoModel, error) {
+	seen, err := importedSources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fresh := todos[:0]
+	for _, tm := range todos {
+		if seen[tm.Source] {
+			rep.Existing++
+			continue
+		}
+		fresh = append(fresh, tm)
+	}
+	return fresh, nil
+}
+
+// createImported stores todos in batches of importBatchSize, up to
+// importConcurrency of them at a time, counting them as imported. The first
+// batch to fail stops the others, so the todos counted are the ones stored.
+func createImported(ctx context.Context, rep *importReport, todos []todoModel) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	slots := make(chan struct{}, importConcurrency)
+	for start := 0; start < len(todos); start += importBatchSize {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		batch := todos[start:min(start+importBatchSize, len(todos))]
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if err := repo.CreateMany(ctx, batch); err != nil {
+				cancel(err)
+				return
+			}
+			mu.Lock()
+			rep.Imported += len(batch)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return context.Cause(ctx)
+}
+
+// importedSources returns the sources of the todos of the tenant of ctx,
+// archived ones included when the store keeps an archive.
+func importedSources(ctx context.Context) (map[string]bool, error) {
+	todos, err := repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	archived, err := repo.ListArchived(ctx)
+	if err != nil && !errors.Is(err, errUnsupported) {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, tm := range append(todos, archived...) {
+		if tm.Source != "" {
+			seen[tm.Source] = true
+		}
+	}
+	return seen, nil
+}
+
+// truncate shortens s to at most n characters.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
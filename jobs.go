@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	jobsCollectionName = "jobs"
+
+	jobPollInterval = time.Second
+	// jobLease is how long a claimed job is left to its worker before
+	// another may run it. Jobs get jobTimeout, which leaves time to record
+	// the outcome.
+	jobLease      = 5 * time.Minute
+	jobTimeout    = 4 * time.Minute
+	jobMaxBackoff = time.Hour
+	jobRetention  = 7 * 24 * time.Hour
+	jobsPage      = 50
+
+	jobPending = "pending"
+	jobDone    = "done"
+	jobDead    = "dead"
+)
+
+// Job queue settings, see loadJobConfig.
+var (
+	// jobWorkers is how many jobs an instance runs at the same time.
+	jobWorkers = 4
+	// jobMaxAttempts is after how many failed attempts a job is dead.
+	jobMaxAttempts = 5
+
+	jobRuns = newCounterVec("todo_jobs_total",
+		"Job attempts by kind and result: done, retried, dead or interrupted.", "kind", "result")
+)
+
+// loadJobConfig reads TODO_JOB_WORKERS, how many background jobs an
+// instance runs at the same time, and TODO_JOB_MAX_ATTEMPTS, after how
+// many failed attempts a job is left dead for an operator to requeue.
+func loadJobConfig() error {
+	jobWorkers, jobMaxAttempts = 4, 5
+	if v := os.Getenv("TODO_JOB_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid TODO_JOB_WORKERS %q, must be a positive number", v)
+		}
+		jobWorkers = n
+	}
+	if v := os.Getenv("TODO_JOB_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid TODO_JOB_MAX_ATTEMPTS %q, must be a positive number", v)
+		}
+		jobMaxAttempts = n
+	}
+	return nil
+}
+
+// job is a unit of background work of a registered kind. Key, when set,
+// makes enqueueing idempotent: a kind has at most one job of a key.
+type job struct {
+	ID            primitive.ObjectID `bson:"_id" json:"id"`
+	Kind          string             `bson:"kind" json:"kind"`
+	Key           string             `bson:"key,omitempty" json:"key,omitempty"`
+	TenantID      string             `bson:"tenant_id,omitempty" json:"tenant_id,omitempty"`
+	Payload       json.RawMessage    `bson:"payload,omitempty" json:"payload,omitempty"`
+	Status        string             `bson:"status" json:"status"`
+	Attempts      int                `bson:"attempts" json:"attempts"`
+	NextAttemptAt time.Time          `bson:"next_attempt_at" json:"next_attempt_at"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	FinishedAt    *time.Time         `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+	LastError     string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+}
+
+// jobHandler runs a job. Its context carries the tenant of the job. It may
+// run more than once for the same job, and returns an error for the job
+// to be retried.
+type jobHandler func(ctx context.Context, j job) error
+
+var (
+	jobHandlersMu sync.RWMutex
+	jobHandlers   = map[string]jobHandler{}
+)
+
+// registerJob makes this instance run the jobs of kind with run.
+func registerJob(kind string, run jobHandler) {
+	jobHandlersMu.Lock()
+	defer jobHandlersMu.Unlock()
+	jobHandlers[kind] = run
+}
+
+// registeredJobs returns the kinds of the registered jobs.
+func registeredJobs() []string {
+	jobHandlersMu.RLock()
+	defer jobHandlersMu.RUnlock()
+	kinds := make([]string, 0, len(jobHandlers))
+	for kind := range jobHandlers {
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+func jobHandlerFor(kind string) jobHandler {
+	jobHandlersMu.RLock()
+	defer jobHandlersMu.RUnlock()
+	return jobHandlers[kind]
+}
+
+// jobsEnabled reports whether jobs can be queued, which needs MongoDB.
+func jobsEnabled() bool {
+	return usingMongo()
+}
+
+func jobsCollection() *mongo.Collection {
+	return db.Collection(jobsCollectionName, writeCollectionOpts)
+}
+
+// ensureJobIndexes creates the indexes for polling and idempotent
+// enqueueing, and expires the jobs done or dead, which frees their key.
+func ensureJobIndexes(ctx context.Context) error {
+	if !jobsEnabled() {
+		return nil
+	}
+	_, err := jobsCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "kind", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"key": bson.M{"$exists": true}}),
+		},
+		{
+			Keys:    bson.D{{Key: "finished_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(jobRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+// enqueueJob queues a job of kind for the tenant of ctx, with payload as
+// JSON. A job of the same kind and key queued before is left as it is.
+func enqueueJob(ctx context.Context, kind, key string, payload interface{}) error {
+	var raw json.RawMessage
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		raw = b
+	}
+	now := time.Now()
+	_, err := jobsCollection().InsertOne(ctx, job{
+		ID:            primitive.NewObjectID(),
+		Kind:          kind,
+		Key:           key,
+		TenantID:      tenantFrom(ctx),
+		Payload:       raw,
+		Status:        jobPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// jobQueue is where jobs wait to be run: the jobs collection, or a fake
+// in tests.
+type jobQueue interface {
+	// claim leases the next due job of kinds for jobLease, or returns
+	// mongo.ErrNoDocuments.
+	claim(ctx context.Context, kinds []string) (job, error)
+	// record sets the fields of job id, ending the lease of its attempt.
+	record(ctx context.Context, id primitive.ObjectID, set bson.M) error
+}
+
+var jobStore jobQueue = mongoJobQueue{}
+
+type mongoJobQueue struct{}
+
+func (mongoJobQueue) claim(ctx context.Context, kinds []string) (job, error) {
+	now := time.Now()
+	var j job
+	err := jobsCollection().FindOneAndUpdate(ctx,
+		bson.M{"status": jobPending, "kind": bson.M{"$in": kinds}, "next_attempt_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"next_attempt_at": now.Add(jobLease)}},
+		options.FindOneAndUpdate().SetSort(bson.M{"next_attempt_at": 1}).SetReturnDocument(options.After),
+	).Decode(&j)
+	return j, err
+}
+
+func (mongoJobQueue) record(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	_, err := jobsCollection().UpdateByID(ctx, id, bson.M{"$set": set})
+	return err
+}
+
+// runJobWorkers runs the jobs of the registered kinds until ctx is done,
+// up to jobWorkers at a time. Like webhook deliveries, jobs are claimed
+// with a lease, so several instances share the queue. It returns once the
+// jobs being run are done.
+func runJobWorkers(ctx context.Context) {
+	kinds := registeredJobs()
+	if !jobsEnabled() || len(kinds) == 0 {
+		return
+	}
+	workJobs(ctx, jobStore, kinds)
+}
+
+func workJobs(ctx context.Context, q jobQueue, kinds []string) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	slots := make(chan struct{}, jobWorkers)
+	for {
+		for ctx.Err() == nil && dbConnected.Load() {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				continue
+			}
+			j, err := q.claim(ctx, kinds)
+			if err != nil {
+				<-slots
+				if !errors.Is(err, mongo.ErrNoDocuments) && ctx.Err() == nil {
+					slog.Error("could not claim job", "component", "jobs", "error", err)
+				}
+				break
+			}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				runJob(ctx, q, j)
+			}()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runJob runs j and records the outcome, see jobOutcome.
+func runJob(ctx context.Context, q jobQueue, j job) {
+	err := callJob(ctx, j)
+	set, result := jobOutcome(j, err, ctx.Err() != nil, time.Now())
+	switch result {
+	case jobDead:
+		slog.Error("giving up on job", "component", "jobs", "job", j.ID.Hex(), "kind", j.Kind, "attempts", j.Attempts+1, "error", err)
+	case "retried":
+		slog.Warn("job failed, retrying", "component", "jobs", "job", j.ID.Hex(), "kind", j.Kind, "attempts", j.Attempts+1, "error", err)
+	case "interrupted":
+		slog.Info("job interrupted, releasing it", "component", "jobs", "job", j.ID.Hex(), "kind", j.Kind)
+	}
+	jobRuns.inc(j.Kind, result)
+	if err := q.record(context.WithoutCancel(ctx), j.ID, set); err != nil {
+		slog.Error("could not record job", "component", "jobs", "job", j.ID.Hex(), "error", err)
+	}
+}
+
+// jobOutcome returns the fields to set on j once an attempt ended with
+// err, and the result counted. The job is done, or retried with
+// exponential backoff until jobMaxAttempts, after which it is dead and
+// expires like a job done. An attempt that failed because the instance is
+// stopping is not counted: the job is released for another instance.
+func jobOutcome(j job, err error, stopping bool, now time.Time) (bson.M, string) {
+	switch {
+	case err == nil:
+		return bson.M{"attempts": j.Attempts + 1, "status": jobDone, "finished_at": now}, jobDone
+	case stopping:
+		return bson.M{"next_attempt_at": now}, "interrupted"
+	case j.Attempts+1 >= jobMaxAttempts:
+		return bson.M{"attempts": j.Attempts + 1, "status": jobDead, "finished_at": now, "last_error": err.Error()}, jobDead
+	default:
+		delay := min(time.Second<<min(j.Attempts+1, 12), jobMaxBackoff)
+		return bson.M{
+			"attempts":        j.Attempts + 1,
+			"next_attempt_at": now.Add(delay/2 + rand.N(delay/2)),
+			"last_error":      err.Error(),
+		}, "retried"
+	}
+}
+
+// callJob runs the handler of j, turning a panic into an error so it is
+// retried like any failure.
+func callJob(ctx context.Context, j job) (err error) {
+	run := jobHandlerFor(j.Kind)
+	if run == nil {
+		return fmt.Errorf("no handler for jobs of kind %q", j.Kind)
+	}
+	ctx, cancel := context.WithTimeout(withTenant(ctx, j.TenantID), jobTimeout)
+	defer cancel()
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("job panicked: %v", v)
+		}
+	}()
+	return run(ctx, j)
+}
+
+// adminJobHandlers serves /admin/jobs, under the dashboard and its token:
+// listing jobs, and requeueing or deleting one, mostly the dead ones.
+func adminJobHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Use(requireJobs)
+	r.Get("/", fetchJobs)
+	r.Get("/{id}", fetchJob)
+	r.Post("/{id}/requeue", requeueJob)
+	r.Delete("/{id}", deleteJob)
+	return r
+}
+
+func requireJobs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !jobsEnabled() {
+			rnd.JSON(w, http.StatusNotImplemented, renderer.M{"message": "jobs require the mongo store", "error": "not implemented"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fetchJobs serves GET /admin/jobs, the latest jobs, filtered by ?status=
+// and ?kind=.
+func fetchJobs(w http.ResponseWriter, r *http.Request) {
+	filter := bson.M{}
+	switch status := r.URL.Query().Get("status"); status {
+	case "":
+	case jobPending, jobDone, jobDead:
+		filter["status"] = status
+	default:
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "status must be pending, done or dead", "error": "bad request"})
+		return
+	}
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		filter["kind"] = kind
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	cursor, err := jobsCollection().Find(ctx, filter, options.Find().SetSort(bson.M{"_id": -1}).SetLimit(jobsPage))
+	if err != nil {
+		storeErr(w, r, "could not fetch jobs", err)
+		return
+	}
+	jobs := []job{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		storeErr(w, r, "could not fetch jobs", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": jobs})
+}
+
+func fetchJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	var j job
+	err := jobsCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&j)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = errNotFound
+	}
+	if err != nil {
+		storeErr(w, r, "could not fetch job", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": j})
+}
+
+// requeueJob serves POST /admin/jobs/{id}/requeue, running a dead or done
+// job again with its attempts reset.
+func requeueJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	var j job
+	err := jobsCollection().FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": bson.M{"$in": bson.A{jobDead, jobDone}}},
+		bson.M{
+			"$set":   bson.M{"status": jobPending, "attempts": 0, "next_attempt_at": time.Now()},
+			"$unset": bson.M{"finished_at": "", "last_error": ""},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&j)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		n, err := jobsCollection().CountDocuments(ctx, bson.M{"_id": id})
+		if err != nil {
+			storeErr(w, r, "could not requeue job", err)
+			return
+		}
+		if n > 0 {
+			rnd.JSON(w, http.StatusConflict, renderer.M{"message": "job is pending already", "error": "conflict"})
+			return
+		}
+		err = errNotFound
+	}
+	if err != nil {
+		storeErr(w, r, "could not requeue job", err)
+		return
+	}
+	slog.Info("requeued job", "component", "jobs", "job", j.ID.Hex(), "kind", j.Kind)
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": j})
+}
+
+func deleteJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	res, err := jobsCollection().DeleteOne(ctx, bson.M{"_id": id})
+	if err == nil && res.DeletedCount == 0 {
+		err = errNotFound
+	}
+	if err != nil {
+		storeErr(w, r, "could not delete job", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"message": "job deleted"})
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestJobOutcome(t *testing.T) {
+	defer func(n int) { jobMaxAttempts = n }(jobMaxAttempts)
+	jobMaxAttempts = 3
+	now := time.Now()
+	failed := errors.New("failed")
+
+	tests := []struct {
+		name     string
+		attempts int
+		err      error
+		stopping bool
+		result   string
+		status   string
+		counted  bool
+	}{
+		{"done", 0, nil, false, jobDone, jobDone, true},
+		{"done while stopping", 1, nil, true, jobDone, jobDone, true},
+		{"first failure", 0, failed, false, "retried", "", true},
+		{"failure before the last", 1, failed, false, "retried", "", true},
+		{"last failure", 2, failed, false, jobDead, jobDead, true},
+		{"stopping", 0, context.Canceled, true, "interrupted", "", false},
+		{"stopping at the last attempt", 2, context.Canceled, true, "interrupted", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, result := jobOutcome(job{Attempts: tt.attempts}, tt.err, tt.stopping, now)
+			if result != tt.result {
+				t.Errorf("result %q, want %q", result, tt.result)
+			}
+			if status, _ := set["status"].(string); status != tt.status {
+				t.Errorf("status %q, want %q", status, tt.status)
+			}
+			attempts, counted := set["attempts"]
+			if counted != tt.counted || counted && attempts != tt.attempts+1 {
+				t.Errorf("attempts set to %v, want counted %v", attempts, tt.counted)
+			}
+			_, finished := set["finished_at"]
+			if want := tt.status != ""; finished != want {
+				t.Errorf("finished_at set %v, want %v", finished, want)
+			}
+			if tt.result == "retried" || tt.result == "interrupted" {
+				next, _ := set["next_attempt_at"].(time.Time)
+				if next.Before(now) || next.After(now.Add(jobMaxBackoff)) {
+					t.Errorf("next attempt at %v, want from now to now+%v", next, jobMaxBackoff)
+				}
+			}
+		})
+	}
+}
+
+func TestJobOutcomeBackoff(t *testing.T) {
+	defer func(n int) { jobMaxAttempts = n }(jobMaxAttempts)
+	jobMaxAttempts = 100
+	now := time.Now()
+	for attempts := 0; attempts < 20; attempts++ {
+		set, _ := jobOutcome(job{Attempts: attempts}, errors.New("failed"), false, now)
+		delay := set["next_attempt_at"].(time.Time).Sub(now)
+		// Twice the delay each attempt up to jobMaxBackoff, half of it
+		// jitter.
+		full := min(time.Second<<(attempts+1), jobMaxBackoff)
+		if delay < full/2 || delay >= full {
+			t.Errorf("attempt %d: delay %v, want from %v to %v", attempts+1, delay, full/2, full)
+		}
+	}
+}
+
+// fakeJobQueue is a jobQueue in memory.
+type fakeJobQueue struct {
+	mu   sync.Mutex
+	jobs map[primitive.ObjectID]*job
+}
+
+func newFakeJobQueue(jobs ...job) *fakeJobQueue {
+	q := &fakeJobQueue{jobs: map[primitive.ObjectID]*job{}}
+	for _, j := range jobs {
+		j.ID, j.Status = primitive.NewObjectID(), jobPending
+		q.jobs[j.ID] = &j
+	}
+	return q
+}
+
+func (q *fakeJobQueue) claim(_ context.Context, kinds []string) (job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	for _, j := range q.jobs {
+		if j.Status == jobPending && !j.NextAttemptAt.After(now) && slices.Contains(kinds, j.Kind) {
+			j.NextAttemptAt = now.Add(jobLease)
+			return *j, nil
+		}
+	}
+	return job{}, mongo.ErrNoDocuments
+}
+
+func (q *fakeJobQueue) record(_ context.Context, id primitive.ObjectID, set bson.M) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j := q.jobs[id]
+	for k, v := range set {
+		switch k {
+		case "status":
+			j.Status = v.(string)
+		case "attempts":
+			j.Attempts = v.(int)
+		case "next_attempt_at":
+			j.NextAttemptAt = v.(time.Time)
+		case "finished_at":
+			at := v.(time.Time)
+			j.FinishedAt = &at
+		case "last_error":
+			j.LastError = v.(string)
+		}
+	}
+	return nil
+}
+
+// byKind returns a copy of the job of kind.
+func (q *fakeJobQueue) byKind(kind string) job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.jobs {
+		if j.Kind == kind {
+			return *j
+		}
+	}
+	return job{}
+}
+
+// startJobWorkers runs workJobs on q until the test ends, and returns the
+// function stopping them early.
+func startJobWorkers(t *testing.T, q jobQueue, handlers map[string]jobHandler) (stop func()) {
+	t.Helper()
+	prevWorkers, prevConnected := jobWorkers, dbConnected.Load()
+	jobWorkers = 2
+	dbConnected.Store(true)
+	var kinds []string
+	for kind, run := range handlers {
+		registerJob(kind, run)
+		kinds = append(kinds, kind)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		workJobs(ctx, q, kinds)
+		close(done)
+	}()
+	stop = func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(func() {
+		stop()
+		jobWorkers = prevWorkers
+		dbConnected.Store(prevConnected)
+		jobHandlersMu.Lock()
+		for kind := range handlers {
+			delete(jobHandlers, kind)
+		}
+		jobHandlersMu.Unlock()
+	})
+	return stop
+}
+
+// waitJob waits until the job of kind satisfies ok.
+func waitJob(t *testing.T, q *fakeJobQueue, kind string, ok func(j job) bool) job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		j := q.byKind(kind)
+		if ok(j) {
+			return j
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s stuck at %+v", kind, j)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestJobWorkers runs jobs to each of their outcomes. Run it with -race.
+func TestJobWorkers(t *testing.T) {
+	defer func(n int) { jobMaxAttempts = n }(jobMaxAttempts)
+	jobMaxAttempts = 2
+
+	q := newFakeJobQueue(
+		job{Kind: "test-ok", TenantID: "acme"},
+		job{Kind: "test-failing"},
+		job{Kind: "test-failing-last", Attempts: 1},
+		job{Kind: "test-panic", Attempts: 1},
+	)
+	var tenant sync.Map
+	startJobWorkers(t, q, map[string]jobHandler{
+		"test-ok": func(ctx context.Context, j job) error {
+			tenant.Store(j.ID, tenantFrom(ctx))
+			return nil
+		},
+		"test-failing":      func(context.Context, job) error { return errors.New("refused") },
+		"test-failing-last": func(context.Context, job) error { return errors.New("refused") },
+		"test-panic":        func(context.Context, job) error { panic("boom") },
+	})
+
+	finished := func(j job) bool { return j.FinishedAt != nil }
+	if j := waitJob(t, q, "test-ok", finished); j.Status != jobDone || j.Attempts != 1 {
+		t.Errorf("test-ok: %s after %d attempts, want done after 1", j.Status, j.Attempts)
+	} else if v, _ := tenant.Load(j.ID); v != "acme" {
+		t.Errorf("test-ok ran for tenant %v, want acme", v)
+	}
+	j := waitJob(t, q, "test-failing", func(j job) bool { return j.Attempts == 1 })
+	if j.Status != jobPending || j.LastError != "refused" || j.FinishedAt != nil || !j.NextAttemptAt.After(time.Now()) {
+		t.Errorf("test-failing: %+v, want pending with an error and a later attempt", j)
+	}
+	if j := waitJob(t, q, "test-failing-last", finished); j.Status != jobDead || j.Attempts != 2 {
+		t.Errorf("test-failing-last: %s after %d attempts, want dead after 2", j.Status, j.Attempts)
+	}
+	if j := waitJob(t, q, "test-panic", finished); j.Status != jobDead || !strings.Contains(j.LastError, "boom") {
+		t.Errorf("test-panic: %s with error %q, want dead with the panic", j.Status, j.LastError)
+	}
+}
+
+// TestJobWorkersStopping stops the workers while a job runs, which must
+// release it without counting the attempt.
+func TestJobWorkersStopping(t *testing.T) {
+	q := newFakeJobQueue(job{Kind: "test-slow", Attempts: 1})
+	started := make(chan struct{})
+	stop := startJobWorkers(t, q, map[string]jobHandler{
+		"test-slow": func(ctx context.Context, j job) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+
+	<-started
+	stop()
+	j := q.byKind("test-slow")
+	if j.Status != jobPending || j.Attempts != 1 || j.LastError != "" || j.NextAttemptAt.After(time.Now()) {
+		t.Errorf("after stopping: %+v, want pending, due now, with its 1 attempt", j)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+)
+
+// maxValidatedBody is the largest request body validateBody reads.
+const maxValidatedBody = 1 << 20
+
+// bodySchemas are the JSON Schemas of the request bodies, by name. They
+// are built from the same limits as todoSchema, on each use as some depend
+// on the configuration, and served at /schemas/{name} for API specs to
+// reference.
+var bodySchemas = map[string]func() map[string]any{
+	"todo-create":       todoCreateSchema,
+	"todo-update":       todoUpdateSchema,
+	"attachment-create": attachmentCreateSchema,
+}
+
+func todoCreateSchema() map[string]any {
+	return map[string]any{
+		"type":     "object",
+		"required": []any{"title"},
+		"properties": map[string]any{
+			"title":       titleSchema(),
+			"description": map[string]any{"type": "string", "maxLength": maxDescriptionLength},
+			"list":        map[string]any{"type": "string", "maxLength": maxListLength},
+			"labels": map[string]any{
+				"type":     "array",
+				"maxItems": maxLabels,
+				"items":    map[string]any{"type": "string", "minLength": 1, "maxLength": maxLabelLength},
+			},
+			// 0 means none.
+			"priority": map[string]any{"type": "integer", "minimum": 0, "maximum": maxPriority},
+			"due_at":   map[string]any{"type": []any{"string", "null"}, "format": "date-time"},
+			"depends_on": map[string]any{
+				"type":     "array",
+				"maxItems": maxDependencies,
+				"items":    map[string]any{"type": "string", "minLength": 24, "maxLength": 24},
+			},
+			"start_date": map[string]any{"type": []any{"string", "null"}, "format": "date-time"},
+			"recurrence": map[string]any{"type": "string", "maxLength": maxRecurrenceLength},
+		},
+	}
+}
+
+func todoUpdateSchema() map[string]any {
+	return map[string]any{
+		"type":     "object",
+		"required": []any{"title"},
+		"properties": map[string]any{
+			"title":     titleSchema(),
+			"completed": map[string]any{"type": "string", "enum": []any{"true", "false", ""}},
+		},
+	}
+}
+
+func titleSchema() map[string]any {
+	return map[string]any{"type": "string", "minLength": 1, "maxLength": maxTitleLength}
+}
+
+func attachmentCreateSchema() map[string]any {
+	return map[string]any{
+		"type":     "object",
+		"required": []any{"name", "size"},
+		"properties": map[string]any{
+			"name":         map[string]any{"type": "string", "minLength": 1, "maxLength": 255},
+			"content_type": map[string]any{"type": "string"},
+			"size":         map[string]any{"type": "integer", "minimum": 1, "maximum": maxAttachmentSize},
+		},
+	}
+}
+
+// schemaError is a part of a request body not matching its schema. Path
+// is a JSON Pointer to it, "" for the whole body.
+type schemaError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e schemaError) String() string {
+	if e.Path == "" {
+		return "request body " + e.Message
+	}
+	return e.Path + " " + e.Message
+}
+
+// validateBody answers 422 with the list of problems when the JSON body
+// does not match the schema of that name, and 400 when it is not JSON, so
+// handlers only get bodies they can use as they are.
+func validateBody(name string) func(http.Handler) http.Handler {
+	schema, ok := bodySchemas[name]
+	if !ok {
+		panic("unknown body schema " + name)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxValidatedBody))
+			if err != nil {
+				rnd.JSON(w, http.StatusRequestEntityTooLarge, renderer.M{"message": "request body too large", "error": err.Error()})
+				return
+			}
+			var v any
+			if err := json.Unmarshal(body, &v); err != nil {
+				rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid request", "error": err.Error()})
+				return
+			}
+			if errs := matchSchema(schema(), v, ""); len(errs) > 0 {
+				rnd.JSON(w, http.StatusUnprocessableEntity, renderer.M{
+					"message": errs[0].String(),
+					"error":   "unprocessable entity",
+					"code":    kindValidation.code,
+					"errors":  errs,
+				})
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// schemaHandler serves the body schema named in the path.
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+	schema, ok := bodySchemas[chi.URLParam(r, "name")]
+	if !ok {
+		rnd.JSON(w, http.StatusNotFound, renderer.M{"message": "no such schema", "error": "not found"})
+		return
+	}
+	s := schema()
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	rnd.JSON(w, http.StatusOK, s)
+}
+
+// matchSchema checks v, decoded by encoding/json, against the keywords of
+// schema this server uses: type, enum, format date-time, the string
+// lengths, the number bounds, properties, required, additionalProperties,
+// items and maxItems. It returns every problem found.
+func matchSchema(schema map[string]any, v any, path string) []schemaError {
+	fail := func(format string, args ...any) []schemaError {
+		return []schemaError{{Path: path, Message: fmt.Sprintf(format, args...)}}
+	}
+	if t, ok := schema["type"]; ok {
+		types, ok := t.([]any)
+		if !ok {
+			types = []any{t}
+		}
+		if !slices.ContainsFunc(types, func(t any) bool { return hasType(v, t.(string)) }) {
+			return fail("must be of type %s", typeNames(types))
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, v) {
+		return fail("must be one of %s", typeNames(enum))
+	}
+
+	var errs []schemaError
+	switch v := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(v)
+		if min, ok := schema["minLength"].(int); ok && n < min {
+			if min == 1 {
+				errs = append(errs, fail("must not be empty")...)
+			} else {
+				errs = append(errs, fail("must be at least %d characters", min)...)
+			}
+		}
+		if max, ok := schema["maxLength"].(int); ok && n > max {
+			errs = append(errs, fail("must be at most %d characters", max)...)
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				errs = append(errs, fail("must be an RFC 3339 date-time")...)
+			}
+		}
+	case float64:
+		if min, ok := number(schema["minimum"]); ok && v < min {
+			errs = append(errs, fail("must be at least %s", formatFloat(min))...)
+		}
+		if max, ok := number(schema["maximum"]); ok && v > max {
+			errs = append(errs, fail("must be at most %s", formatFloat(max))...)
+		}
+	case []any:
+		if max, ok := schema["maxItems"].(int); ok && len(v) > max {
+			errs = append(errs, fail("must have at most %d items", max)...)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				errs = append(errs, matchSchema(items, item, path+"/"+strconv.Itoa(i))...)
+			}
+		}
+	case map[string]any:
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := v[name.(string)]; !ok {
+				errs = append(errs, schemaError{Path: path + "/" + name.(string), Message: "is required"})
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		names := make([]string, 0, len(properties))
+		for name := range properties {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			if pv, ok := v[name]; ok {
+				errs = append(errs, matchSchema(properties[name].(map[string]any), pv, path+"/"+name)...)
+			}
+		}
+		if additional, ok := schema["additionalProperties"]; ok {
+			extra := make([]string, 0, len(v))
+			for name := range v {
+				if _, ok := properties[name]; !ok {
+					extra = append(extra, name)
+				}
+			}
+			slices.Sort(extra)
+			for _, name := range extra {
+				switch additional := additional.(type) {
+				case bool:
+					if !additional {
+						errs = append(errs, schemaError{Path: path + "/" + name, Message: "is not allowed"})
+					}
+				case map[string]any:
+					errs = append(errs, matchSchema(additional, v[name], path+"/"+name)...)
+				}
+			}
+		}
+	}
+	return errs
+}
+
+func hasType(v any, t string) bool {
+	switch v := v.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case float64:
+		return t == "number" || t == "integer" && v == math.Trunc(v)
+	case []any:
+		return t == "array"
+	case map[string]any:
+		return t == "object"
+	}
+	return false
+}
+
+func typeNames(values []any) string {
+	var b bytes.Buffer
+	for i, v := range values {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%q", v)
+	}
+	return b.String()
+}
+
+// number returns a bound of the schema, given as an int or int64.
+func number(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
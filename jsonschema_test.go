@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+func TestMatchSchema(t *testing.T) {
+	schema := map[string]any{
+		"type":                 "object",
+		"required":             []any{"title"},
+		"additionalProperties": false,
+		"properties": map[string]any{
+			"title": map[string]any{"type": "string", "minLength": 1, "maxLength": 5},
+			"state": map[string]any{"type": "string", "enum": []any{"open", "done"}},
+			"count": map[string]any{"type": "integer", "minimum": 0, "maximum": int64(10)},
+			"due":   map[string]any{"type": []any{"string", "null"}, "format": "date-time"},
+			"tags": map[string]any{
+				"type":     "array",
+				"maxItems": 2,
+				"items":    map[string]any{"type": "string", "minLength": 1},
+			},
+			"owner": map[string]any{
+				"type":                 "object",
+				"required":             []any{"name"},
+				"properties":           map[string]any{"name": map[string]any{"type": "string", "minLength": 2}},
+				"additionalProperties": map[string]any{"type": "string"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name string
+		body string
+		errs []schemaError
+	}{
+		{"valid", `{"title":"héllo","state":"open","count":10,"due":"2024-05-01T09:00:00Z","tags":["a"],"owner":{"name":"ann","team":"ops"}}`, nil},
+		{"only required", `{"title":"a"}`, nil},
+		{"null allowed", `{"title":"a","due":null}`, nil},
+		{"not an object", `[]`, []schemaError{{"", `must be of type "object"`}}},
+		{"required", `{}`, []schemaError{{"/title", "is required"}}},
+		{"type mismatch", `{"title":1}`, []schemaError{{"/title", `must be of type "string"`}}},
+		{"not an integer", `{"title":"a","count":1.5}`, []schemaError{{"/count", `must be of type "integer"`}}},
+		{"union type", `{"title":"a","due":false}`, []schemaError{{"/due", `must be of type "string", "null"`}}},
+		{"format", `{"title":"a","due":"tomorrow"}`, []schemaError{{"/due", "must be an RFC 3339 date-time"}}},
+		{"enum", `{"title":"a","state":"closed"}`, []schemaError{{"/state", `must be one of "open", "done"`}}},
+		{"empty", `{"title":""}`, []schemaError{{"/title", "must not be empty"}}},
+		{"too long", `{"title":"héllos"}`, []schemaError{{"/title", "must be at most 5 characters"}}},
+		{"below minimum", `{"title":"a","count":-1}`, []schemaError{{"/count", "must be at least 0"}}},
+		{"above maximum", `{"title":"a","count":11}`, []schemaError{{"/count", "must be at most 10"}}},
+		{"items", `{"title":"a","tags":["",2]}`, []schemaError{
+			{"/tags/0", "must not be empty"},
+			{"/tags/1", `must be of type "string"`},
+		}},
+		{"max items", `{"title":"a","tags":["a","b","c"]}`, []schemaError{{"/tags", "must have at most 2 items"}}},
+		{"additional properties", `{"title":"a","zzz":1,"id":2}`, []schemaError{
+			{"/id", "is not allowed"},
+			{"/zzz", "is not allowed"},
+		}},
+		{"nested object", `{"title":"a","owner":{"name":"a","team":3}}`, []schemaError{
+			{"/owner/name", "must be at least 2 characters"},
+			{"/owner/team", `must be of type "string"`},
+		}},
+		{"nested required", `{"title":"a","owner":{}}`, []schemaError{{"/owner/name", "is required"}}},
+		{"every problem", `{"title":"","state":"x","count":"1"}`, []schemaError{
+			{"/count", `must be of type "integer"`},
+			{"/state", `must be one of "open", "done"`},
+			{"/title", "must not be empty"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v any
+			if err := json.Unmarshal([]byte(tt.body), &v); err != nil {
+				t.Fatal(err)
+			}
+			if errs := matchSchema(schema, v, ""); !slices.Equal(errs, tt.errs) {
+				t.Errorf("errors %v, want %v", errs, tt.errs)
+			}
+		})
+	}
+}
+
+func TestValidateBody(t *testing.T) {
+	prevRnd := rnd
+	rnd = renderer.New()
+	t.Cleanup(func() { rnd = prevRnd })
+
+	handler := validateBody("todo-create")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   string
+	}{
+		{"valid", `{"title":"Buy milk","labels":["home"]}`, http.StatusOK, `{"title":"Buy milk","labels":["home"]}`},
+		{"not JSON", `{"title":`, http.StatusBadRequest, `"message":"invalid request"`},
+		{"invalid", `{"priority":9}`, http.StatusUnprocessableEntity,
+			`"errors":[{"path":"/title","message":"is required"},{"path":"/priority","message":"must be at most 4"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/todo", strings.NewReader(tt.body)))
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("%d %s, want %d with %s", w.Code, w.Body, tt.status, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// logLevel is the level of the default logger, which can be reloaded,
+// see reloadConfig.
+var logLevel slog.LevelVar
+
+// loadLogLevel reads TODO_LOG_LEVEL: debug, info, warn or error.
+func loadLogLevel() (func(), error) {
+	var level slog.Level
+	if v := os.Getenv("TODO_LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			return nil, fmt.Errorf("invalid TODO_LOG_LEVEL %q", v)
+		}
+	}
+	return func() { logLevel.Set(level) }, nil
+}
+
+// loadLogConfig sets up the default slog logger from TODO_LOG_LEVEL, see
+// loadLogLevel, TODO_LOG_FORMAT (json or text) and TODO_LOG_OUTPUT
+// (stderr, stdout or a file path). Output of the log package goes through
+// it too.
+func loadLogConfig() error {
+	if err := applyNow(loadLogLevel)(); err != nil {
+		return err
+	}
+
+	var out io.Writer
+	switch v := envOr("TODO_LOG_OUTPUT", "stderr"); v {
+	case "stderr":
+		out = os.Stderr
+	case "stdout":
+		out = os.Stdout
+	default:
+		f, err := os.OpenFile(v, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+
+	opts := &slog.HandlerOptions{Level: &logLevel}
+	switch v := envOr("TODO_LOG_FORMAT", "json"); v {
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(out, opts)))
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(out, opts)))
+	default:
+		return fmt.Errorf("invalid TODO_LOG_FORMAT %q, must be json or text", v)
+	}
+	return nil
+}
+
+// fatal logs msg as an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// requestLog collects the fields of the access log line that are only
+// known deeper in the handler chain.
+type requestLog struct {
+	tenant    string
+	errorCode string
+	err       error
+}
+
+type requestLogKey struct{}
+
+// noteTenant records the tenant of the request for its access log line.
+func noteTenant(ctx context.Context, tenant string) {
+	if l, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		l.tenant = tenant
+	}
+}
+
+// noteError records the code and the error a request failed with for its
+// access log line, as the response only carries a description of it.
+func noteError(ctx context.Context, code string, err error) {
+	if l, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		l.errorCode, l.err = code, err
+	}
+}
+
+// accessLogSampling decides which successful requests get an access log
+// line, see loadAccessLogSettings.
+var accessLogSampling atomic.Pointer[samplingPolicy]
+
+// loadAccessLogSettings reads TODO_ACCESS_LOG_SAMPLE, the share of 1xx,
+// 2xx and 3xx responses that are logged, from 0 to 1, and
+// TODO_ACCESS_LOG_SAMPLE_ROUTES, shares of path prefixes overriding it.
+// Errors are always logged.
+func loadAccessLogSettings() (func(), error) {
+	p, err := loadSamplingPolicy("TODO_ACCESS_LOG_SAMPLE", "TODO_ACCESS_LOG_SAMPLE_ROUTES")
+	if err != nil {
+		return nil, err
+	}
+	return func() { accessLogSampling.Store(p) }, nil
+}
+
+// redacted replaces the values of headers and query parameters that may
+// hold credentials. Request bodies are never logged, only their size.
+const redacted = "[REDACTED]"
+
+// sensitiveName reports whether a header or query parameter name looks
+// like it carries a credential.
+func sensitiveName(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"auth", "cookie", "token", "secret", "signature", "password", "key", "code"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestLogger writes an access log line per request with its ID, see
+// requestIDMiddleware. Server errors are logged at error level, client
+// errors at warn, both with the request headers and query, credentials
+// redacted. Other responses are sampled by TODO_ACCESS_LOG_SAMPLE and
+// TODO_ACCESS_LOG_SAMPLE_ROUTES.
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := &requestLog{}
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), requestLogKey{}, l)))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status < 400 {
+			if ratio := accessLogSampling.Load().ratioFor(r.URL.Path); ratio < 1 && mathrand.Float64() >= ratio {
+				return
+			}
+		}
+		attrs := []slog.Attr{
+			slog.String("request_id", requestID(r.Context())),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.String("remote", r.RemoteAddr),
+		}
+		if l.tenant != "" {
+			attrs = append(attrs, slog.String("tenant", l.tenant))
+		}
+		if l.errorCode != "" {
+			attrs = append(attrs, slog.String("error_code", l.errorCode), slog.Any("error", l.err))
+		}
+		if s := spanFrom(r.Context()); s != nil {
+			attrs = append(attrs, slog.String("trace_id", hex.EncodeToString(s.traceID[:])))
+		}
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		if status >= 400 {
+			attrs = append(attrs, requestDetails(r)...)
+		}
+		slog.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+// requestDetails returns the query, headers and body size of r for the
+// access log, with credentials redacted.
+func requestDetails(r *http.Request) []slog.Attr {
+	var attrs []slog.Attr
+	if q := r.URL.Query(); len(q) > 0 {
+		attrs = append(attrs, slog.Group("query", redactedValues(q)...))
+	}
+	attrs = append(attrs, slog.Group("headers", redactedValues(r.Header)...))
+	if r.ContentLength > 0 {
+		attrs = append(attrs, slog.Int64("request_bytes", r.ContentLength))
+	}
+	return attrs
+}
+
+func redactedValues(values map[string][]string) []any {
+	attrs := make([]any, 0, len(values))
+	for k, v := range values {
+		value := strings.Join(v, ", ")
+		if sensitiveName(k) {
+			value = redacted
+		}
+		attrs = append(attrs, slog.String(k, value))
+	}
+	return attrs
+}
@@ -3,104 +3,261 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
+	"github.com/qasim-invodev/todo/api"
+	"github.com/qasim-invodev/todo/tui"
 	"github.com/thedevsaddam/renderer"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var rnd *renderer.Render
 var db *mongo.Database
 var client *mongo.Client
 
-const (
-	hostName       string = "mongodb://127.0.0.1:27017"
-	dbName         string = "demo_todo"
-	collectionName string = "todo"
-	port           string = ":9000"
-)
-
 type (
 	todoModel struct {
-		ID        primitive.ObjectID `bson:"_id,omitempty"`
-		Title     string             `bson:"title"`
-		Completed bool               `bson:"completed"`
-		CreatedAt time.Time          `bson:"createAt"`
+		ID          primitive.ObjectID `bson:"_id,omitempty"`
+		Title       string             `bson:"title"`
+		Description string             `bson:"description,omitempty"`
+		Completed   bool               `bson:"completed"`
+		CreatedAt   time.Time          `bson:"createAt"`
+		TenantID    string             `bson:"tenant_id,omitempty"`
+		List        string             `bson:"list,omitempty"`
+		Labels      []string           `bson:"labels,omitempty"`
+		Priority    int                `bson:"priority,omitempty"`
+		DueAt       *time.Time         `bson:"due_at,omitempty"`
+		Source      string             `bson:"source,omitempty"`
+		Attachments []attachment       `bson:"attachments,omitempty"`
+		// Column and Position place the todo on the board of its list,
+		// see board.go.
+		Column   string `bson:"column,omitempty"`
+		Position int64  `bson:"position,omitempty"`
+		// StartDate and DependsOn place the todo on the timeline of its
+		// list, see timeline.go.
+		StartDate *time.Time           `bson:"start_date,omitempty"`
+		DependsOn []primitive.ObjectID `bson:"depends_on,omitempty"`
+		// Recurrence repeats the todo once completed, and NextID is the
+		// todo repeating it while it is being created, see recurring.go.
+		Recurrence string              `bson:"recurrence,omitempty"`
+		NextID     *primitive.ObjectID `bson:"next_id,omitempty"`
 	}
 
-	todo struct {
-		ID        string    `json:"id"`
-		Title     string    `json:"title"`
-		Completed string    `json:"completed"`
-		CreatedAt time.Time `json:"created_at"`
-	}
+	// todo is the API form of a todo, shared with clients.
+	todo = api.Todo
 )
 
-func init() {
+// setup loads the configuration and creates the clients. It runs after
+// flag parsing so flags can override the environment.
+func setup() {
 	rnd = renderer.New()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	if err := loadConfig(); err != nil {
+		fatal("invalid configuration", "error", err)
+	}
+	registerPublishers()
+	registerJobs()
+	scheduleTasks()
+	if err := openWriteBuffer(); err != nil {
+		fatal("failed to open write buffer", "error", err)
+	}
+
+	if !usingMongo() {
+		return
+	}
 
-	// Create a MongoDB client
+	// Create a MongoDB client. Connecting happens in the background, see
+	// monitorMongo, so the server can start while MongoDB is still down.
 	var err error
-	client, err = mongo.Connect(ctx, options.Client().ApplyURI(hostName))
+	opts, err := mongoClientOptions()
+	if err != nil {
+		fatal("invalid MongoDB configuration", "error", err)
+	}
+	client, err = mongo.Connect(context.Background(), opts)
 	if err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v\n", err)
+		fatal("failed to connect to MongoDB", "error", err)
 	}
+	db = client.Database(dbName)
+}
 
-	// Verify the connection
-	if err = client.Ping(ctx, nil); err != nil {
-		log.Fatalf("Failed to ping MongoDB: %v\n", err)
+// loadConfig reads the configuration of every part of the server and
+// returns the first problem.
+func loadConfig() error {
+	for _, c := range []struct {
+		name string
+		load func() error
+	}{
+		{"log", loadLogConfig},
+		{"access log", applyNow(loadAccessLogSettings)},
+		{"error tracker", loadErrorTrackerConfig},
+		{"server", loadServerConfig},
+		{"assets", loadAssetsConfig},
+		{"well-known", loadWellKnownConfig},
+		{"systemd", loadSystemdConfig},
+		{"upgrade", loadUpgradeConfig},
+		{"TLS", loadTLSConfig},
+		{"timeout", loadTimeoutConfig},
+		{"rate limit", applyNow(loadRateLimitSettings)},
+		{"load shedding", applyNow(loadSheddingSettings)},
+		{"CORS", applyNow(loadCORSSettings)},
+		{"HTTP/2", loadHTTP2Config},
+		{"outbound", loadOutboundConfig},
+		{"webhook", applyNow(loadWebhookSettings)},
+		{"maintenance", applyNow(loadMaintenanceSettings)},
+		{"cache", loadCacheConfig},
+		{"read cache", loadReadCacheConfig},
+		{"stats", loadStatsConfig},
+		{"circuit breaker", loadBreakerConfig},
+		{"store", loadStore},
+		{"MongoDB", loadCollectionOptions},
+		{"slow query", applyNow(loadSlowQuerySettings)},
+		{"tenant", loadTenantConfig},
+		{"shard", loadShardConfig},
+		{"encryption", loadEncryptionKey},
+		{"attachment", loadAttachmentConfig},
+		{"import", loadImportConfig},
+		{"outbox", loadOutboxConfig},
+		{"jobs", loadJobConfig},
+		{"scheduler", loadSchedulerConfig},
+		{"Slack", loadSlackConfig},
+		{"push", loadPushConfig},
+		{"GitHub hook", loadGitHubHookConfig},
+		{"Telegram", loadTelegramConfig},
+		{"email", loadEmailConfig},
+		{"digest", loadDigestConfig},
+		{"Google Tasks", loadGoogleConfig},
+		{"MQTT", loadMQTTConfig},
+		{"event bus", loadEventBusConfig},
+		{"search", loadSearchConfig},
+		{"archive", loadArchiveConfig},
+		{"tracing", loadTracingConfig},
+		{"trace sampling", applyNow(loadTraceSamplingSettings)},
+		{"metrics", loadMetricsConfig},
+		{"gauges", loadGaugesConfig},
+		{"board", loadBoardConfig},
+		{"admin", loadAdminConfig},
+		{"gRPC", loadGRPCConfig},
+		{"debug", loadDebugConfig},
+	} {
+		if err := c.load(); err != nil {
+			return fmt.Errorf("invalid %s configuration: %w", c.name, err)
+		}
 	}
+	return nil
+}
 
-	log.Println("Successfully connected to MongoDB")
-	db = client.Database(dbName)
+// registerPublishers registers the publishers of the enabled features
+// with the outbox.
+func registerPublishers() {
+	if webhooksEnabled() {
+		registerPublisher(webhookPublisher{})
+	}
+	if githubSyncEnabled() {
+		registerPublisher(githubPublisher{})
+	}
+	if slackNotificationsEnabled() {
+		registerPublisher(slackPublisher{})
+	}
+	if teamsEnabled() && usingMongo() {
+		registerPublisher(teamsPublisher{})
+	}
+	if pushEnabled() {
+		registerPublisher(pushPublisher{})
+	}
+	if googleSyncEnabled() {
+		registerPublisher(googlePublisher{})
+	}
 }
 
-func homeHandler(w http.ResponseWriter, r *http.Request) {
-	err := rnd.Template(w, http.StatusOK, []string{"static/home.tpl"}, nil)
-	checkErr(err)
+// registerJobs registers the handlers of the background jobs of the
+// enabled features.
+func registerJobs() {
+	if digestsEnabled() {
+		registerJob(digestJob, runDigestJob)
+	}
 }
 
 func fetchTodos(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	archived := false
+	if v := r.URL.Query().Get("archive"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "archive must be true or false", "error": err.Error()})
+			return
+		}
+		archived = b
+	}
+	// view=summary reads only the fields of summaryProjection from the
+	// store.
+	view := r.URL.Query().Get("view")
+	if view != "" && view != "full" && view != "summary" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "view must be full or summary", "error": "bad request"})
+		return
+	}
+	if view == "summary" && archived {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "view=summary is not supported for archived todos", "error": "bad request"})
+		return
+	}
+
+	w.Header().Add("Vary", "Accept")
+	streamTodos(w, r, listQuery{Archived: archived, Summary: view == "summary"}, wantsNDJSON(r))
+}
 
-	collection := db.Collection(collectionName)
-	cursor, err := collection.Find(ctx, bson.M{})
+// fetchTodo serves GET /todo/{id}, the todo with the due flags of
+// GET /todo.
+func fetchTodo(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
 	if err != nil {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "could not fetch todos", "error": err.Error()})
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid id", "error": "bad request"})
 		return
 	}
-	defer cursor.Close(ctx)
 
-	var todos []todoModel
-	if err := cursor.All(ctx, &todos); err != nil {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "could not decode todos", "error": err.Error()})
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	tm, err := repo.Get(ctx, id)
+	if err != nil {
+		storeErr(w, r, "could not fetch todo", err)
 		return
 	}
+	t := toTodo(tm)
+	dueFlags(&t, preferencesOrDefault(ctx).location())
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": t})
+}
 
-	todoList := []todo{}
-	for _, t := range todos {
-		todoList = append(todoList, todo{
-			ID:        t.ID.Hex(),
-			Title:     t.Title,
-			Completed: strconv.FormatBool(t.Completed),
-			CreatedAt: t.CreatedAt,
-		})
+// toTodo converts a stored todo with decrypted fields to its API form.
+func toTodo(t todoModel) todo {
+	return todo{
+		ID:          t.ID.Hex(),
+		Title:       t.Title,
+		Description: t.Description,
+		Completed:   strconv.FormatBool(t.Completed),
+		CreatedAt:   t.CreatedAt,
+		List:        t.List,
+		Labels:      t.Labels,
+		Priority:    t.Priority,
+		DueAt:       t.DueAt,
+		StartDate:   t.StartDate,
+		DependsOn:   hexIDs(t.DependsOn),
+		Recurrence:  t.Recurrence,
+		Source:      t.Source,
+		Attachments: t.Attachments,
+		Column:      t.Column,
+		Position:    t.Position,
 	}
-	rnd.JSON(w, http.StatusOK, renderer.M{"data": todoList})
 }
 
 func createTodo(w http.ResponseWriter, r *http.Request) {
@@ -110,29 +267,82 @@ func createTodo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if t.Title == "" {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "title is required", "error": "bad request"})
+	// The body schema cannot tell ids or the order of dates apart.
+	if msg := validateNewTodo(t); msg != "" {
+		rnd.JSON(w, http.StatusUnprocessableEntity, renderer.M{"message": msg, "error": "unprocessable entity", "code": kindValidation.code})
 		return
 	}
 
-	tm := todoModel{
-		ID:        primitive.NewObjectID(),
-		Title:     t.Title,
-		Completed: false,
-		CreatedAt: time.Now(),
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
 	defer cancel()
 
-	collection := db.Collection(collectionName)
-	res, err := collection.InsertOne(ctx, tm)
+	// A create queued without the preferences gets the default list when
+	// it is replayed, see applyBufferedWrite.
+	if t.List == "" {
+		t.List = preferencesOrDefault(ctx).DefaultList
+	}
+	tm := newTodoModel(t)
+
+	queued, err := saveNewTodo(ctx, tm)
 	if err != nil {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "could not create todo", "error": err.Error()})
+		storeErr(w, r, "could not create todo", err)
+		return
+	}
+	if queued {
+		rnd.JSON(w, http.StatusAccepted, renderer.M{"message": "todo queued until the database is reachable", "todo_id": tm.ID})
 		return
 	}
 
-	rnd.JSON(w, http.StatusCreated, renderer.M{"message": "todo created successfully", "todo_id": res.InsertedID})
+	rnd.JSON(w, http.StatusCreated, renderer.M{"message": "todo created successfully", "todo_id": tm.ID})
+}
+
+// newTodoModel returns a new open todo with the fields of t a client may
+// set when creating it.
+func newTodoModel(t todo) todoModel {
+	return todoModel{
+		ID:          primitive.NewObjectID(),
+		Title:       t.Title,
+		Description: t.Description,
+		Completed:   false,
+		CreatedAt:   time.Now(),
+		List:        t.List,
+		Labels:      t.Labels,
+		Priority:    t.Priority,
+		DueAt:       t.DueAt,
+		StartDate:   t.StartDate,
+		DependsOn:   objectIDs(t.DependsOn),
+		Recurrence:  t.Recurrence,
+	}
+}
+
+// hexIDs returns ids as hex strings, or nil without any.
+func hexIDs(ids []primitive.ObjectID) []string {
+	if len(ids) == 0 {
+		return nil
+	}
+	hex := make([]string, len(ids))
+	for i, id := range ids {
+		hex[i] = id.Hex()
+	}
+	return hex
+}
+
+// objectIDs parses ids, checked by validateNewTodo, skipping invalid ones.
+func objectIDs(ids []string) []primitive.ObjectID {
+	var objIDs []primitive.ObjectID
+	for _, id := range ids {
+		if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+			objIDs = append(objIDs, objID)
+		}
+	}
+	return objIDs
+}
+
+// saveNewTodo creates tm, or queues it while the database is unreachable.
+func saveNewTodo(ctx context.Context, tm todoModel) (queued bool, err error) {
+	return bufferOr(bufferedWrite{Op: bufferedCreate, Tenant: tenantFrom(ctx), ID: tm.ID, Todo: &tm}, func() error {
+		return repo.Create(ctx, tm)
+	})
 }
 
 func deleteTodo(w http.ResponseWriter, r *http.Request) {
@@ -142,14 +352,17 @@ func deleteTodo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
 	defer cancel()
 
-	collection := db.Collection(collectionName)
 	objID, _ := primitive.ObjectIDFromHex(id)
-	res, err := collection.DeleteOne(ctx, bson.M{"_id": objID})
-	if err != nil || res.DeletedCount == 0 {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "could not delete todo", "error": err.Error()})
+	queued, err := removeTodo(ctx, objID)
+	if err != nil {
+		storeErr(w, r, "could not delete todo", err)
+		return
+	}
+	if queued {
+		rnd.JSON(w, http.StatusAccepted, renderer.M{"message": "delete queued until the database is reachable"})
 		return
 	}
 
@@ -169,68 +382,286 @@ func updateTodo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	completed := t.Completed == "true"
+	upsert := false
+	if v := r.URL.Query().Get("upsert"); v != "" {
+		var err error
+		if upsert, err = strconv.ParseBool(v); err != nil {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "upsert must be true or false", "error": err.Error()})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
 	defer cancel()
 
-	collection := db.Collection(collectionName)
 	objID, _ := primitive.ObjectIDFromHex(id)
-	update := bson.M{"$set": bson.M{"title": t.Title, "completed": t.Completed}}
-	res, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, update)
-	if err != nil || res.MatchedCount == 0 {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "could not update todo", "error": err.Error()})
+	if upsert {
+		upsertTodo(ctx, w, r, todoModel{ID: objID, Title: t.Title, Completed: completed, CreatedAt: time.Now()})
+		return
+	}
+	queued, err := updateTodoFields(ctx, objID, bson.M{"title": t.Title, "completed": completed})
+	if err != nil {
+		storeErr(w, r, "could not update todo", err)
+		return
+	}
+	if queued {
+		rnd.JSON(w, http.StatusAccepted, renderer.M{"message": "update queued until the database is reachable"})
 		return
 	}
 
 	rnd.JSON(w, http.StatusOK, renderer.M{"message": "todo updated successfully"})
 }
 
+// removeTodo deletes the todo of id with its attachments, or queues the
+// delete while the database is unreachable.
+func removeTodo(ctx context.Context, id primitive.ObjectID) (queued bool, err error) {
+	var deleted todoModel
+	queued, err = bufferOr(bufferedWrite{Op: bufferedDelete, Tenant: tenantFrom(ctx), ID: id}, func() (err error) {
+		deleted, err = repo.Delete(ctx, id)
+		return err
+	})
+	if err == nil && !queued {
+		deleteBlobs(deleted.Attachments)
+	}
+	return queued, err
+}
+
+// updateTodoFields sets fields of the todo of id, or queues the update
+// while the database is unreachable. The store gets a copy of fields, as it
+// encrypts the title in place.
+func updateTodoFields(ctx context.Context, id primitive.ObjectID, fields bson.M) (queued bool, err error) {
+	return bufferOr(bufferedWrite{Op: bufferedUpdate, Tenant: tenantFrom(ctx), ID: id, Fields: fields}, func() error {
+		return repo.Update(ctx, id, maps.Clone(fields))
+	})
+}
+
+// upsertTodo creates or updates tm for PUT /todo/{id}?upsert=true, letting
+// offline clients create todos under IDs they generated themselves.
+func upsertTodo(ctx context.Context, w http.ResponseWriter, r *http.Request, tm todoModel) {
+	var created bool
+	queued, err := bufferOr(bufferedWrite{Op: bufferedUpsert, Tenant: tenantFrom(ctx), ID: tm.ID, Todo: &tm}, func() (err error) {
+		created, err = repo.Upsert(ctx, tm)
+		return err
+	})
+	if err != nil {
+		storeErr(w, r, "could not save todo", err)
+		return
+	}
+	if queued {
+		rnd.JSON(w, http.StatusAccepted, renderer.M{"message": "todo queued until the database is reachable", "todo_id": tm.ID})
+		return
+	}
+	if created {
+		rnd.JSON(w, http.StatusCreated, renderer.M{"message": "todo created successfully", "todo_id": tm.ID})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"message": "todo updated successfully"})
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		if len(os.Args) < 3 || os.Args[2] != "validate" {
+			fmt.Fprintln(os.Stderr, errUsage)
+			os.Exit(2)
+		}
+		os.Exit(validateConfig(os.Args[3:]))
+	}
+
+	seed := flag.Int("seed", 0, "insert `n` sample todos and exit")
+	seedDays := flag.Int("seed-days", 30, "spread seeded todos over the last `days` days")
+	seedTenants := flag.String("seed-tenant", "", "comma-separated `tenants` to spread seeded todos over when tenancy is enabled")
+	shardReport := flag.Bool("shard-report", false, "print which queries are targeted with TODO_SHARD_KEY and exit")
+	localTUI := flag.Bool("tui", false, "manage todos in a terminal UI working directly on the store, then exit")
+	tuiTenant := flag.String("tui-tenant", "", "`tenant` the terminal UI works on when tenancy is enabled")
+	healthy := flag.Bool("healthcheck", false, "check the running server is ready through /readyz, exiting 0 if so and 1 otherwise")
+	configFile := configFlags(flag.CommandLine)
+	flag.Parse()
+	if err := applyConfigFile(*configFile); err != nil {
+		fatal("invalid config file", "error", err)
+	}
+	if *healthy {
+		os.Exit(healthcheck())
+	}
+	setup()
+
+	if *shardReport {
+		writeShardReport(os.Stdout)
+		return
+	}
+
+	if *seed > 0 {
+		tenants, err := parseSeedTenants(*seedTenants)
+		if err != nil {
+			fatal("invalid -seed-tenant", "error", err)
+		}
+		if shardKey != "" && len(tenants) == 0 {
+			fatal("-seed-tenant is required with TODO_SHARD_KEY")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		if usingMongo() {
+			if err := waitForMongo(ctx); err != nil {
+				fatal("failed to connect to MongoDB", "error", err)
+			}
+		}
+		if err := seedTodos(ctx, *seed, *seedDays, tenants); err != nil {
+			fatal("failed to seed todos", "error", err)
+		}
+		return
+	}
+
+	if *localTUI {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if *tuiTenant != "" {
+			ctx = withTenant(ctx, *tuiTenant)
+		}
+		if usingMongo() {
+			if err := waitForMongo(ctx); err != nil {
+				fatal("failed to connect to MongoDB", "error", err)
+			}
+		}
+		if err := tui.Run(ctx, repoStore{}); err != nil {
+			fatal("terminal UI failed", "error", err)
+		}
+		return
+	}
+
 	stopChan := make(chan os.Signal, 1)
-	signal.Notify(stopChan, os.Interrupt)
+	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM, syscall.SIGUSR2)
+
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	startWorker(bgCtx, monitorMongo)
+	startWorker(bgCtx, runOutboxDispatcher)
+	startWorker(bgCtx, runWriteBufferReplay)
+	startWorker(bgCtx, runSearchSync)
+	startWorker(bgCtx, runWebhookDispatcher)
+	startWorker(bgCtx, runJobWorkers)
+	startWorker(bgCtx, runScheduler)
+	startWorker(bgCtx, runTelegramPoller)
+	startWorker(bgCtx, runTraceExporter)
+	startWorker(bgCtx, runTodoGauges)
+	startWorker(bgCtx, runReloader)
+	startWorker(bgCtx, runStaticWatcher)
+	startWorker(bgCtx, runSystemdWatchdog)
+
+	gatewayStopping := make(chan struct{})
+	gateway, err := gatewayHandlers(gatewayStopping)
+	if err != nil {
+		fatal("failed to set up the gRPC gateway", "error", err)
+	}
+
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	r.Use(metricsMiddleware)
+	r.Use(tracingMiddleware)
+	r.Use(requestIDMiddleware)
+	r.Use(requestLogger)
+	r.Use(recoverer)
+	r.Use(localizeMiddleware)
+	r.Use(corsMiddleware)
+	r.Use(rateLimitMiddleware)
+	r.Use(loadShedMiddleware)
+	r.Use(maintenanceMiddleware)
+	r.Use(timeoutMiddleware)
 	r.Get("/", homeHandler)
+	r.Get("/healthz", healthzHandler)
+	r.Get("/readyz", readyzHandler)
+	r.Get("/version", versionHandler)
+	r.Get("/schemas/{name}", schemaHandler)
+	r.Get("/favicon.ico", faviconHandler)
+	r.Get("/robots.txt", robotsTxtHandler)
+	r.Get("/.well-known/security.txt", securityTxtHandler)
+	r.Get("/manifest.webmanifest", manifestHandler)
+	r.Get("/sw.js", serviceWorkerHandler)
+	r.Get("/offline", offlineHandler)
+	if devMode {
+		r.Get("/dev/reload", devReloadHandler)
+	}
+	mountAdmin(r)
+	r.With(tenantMiddleware).Get("/ws", wsHandler)
 	r.Mount("/todo", todoHandlers())
-
-	srv := &http.Server{
-		Addr:         port,
-		Handler:      r,
-		ReadTimeout:  60 * time.Second,
-		WriteTimeout: 60 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	if appBundled() {
+		r.Mount("/app", appHandler())
 	}
+	r.Mount("/assets", assetsHandler())
+	r.Mount("/web", webHandlers())
+	r.Mount("/lists", listHandlers())
+	r.Mount("/me", meHandlers())
+	r.With(requireStore, tenantMiddleware).Post("/rpc", serveRPC)
+	r.With(tenantMiddleware).Mount("/v1", gateway)
+	r.Mount("/graphql", graphQLHandlers())
+	r.Mount("/webhooks", webhookHandlers())
+	r.Mount("/zapier", zapierHandlers())
+	r.Mount("/import", importHandlers())
+	r.Mount("/github", githubHandlers())
+	r.Mount("/hooks/github", githubHookHandlers())
+	r.Mount("/google", googleHandlers())
+	r.Mount("/email", emailHandlers())
+	r.Mount("/digests", digestHandlers())
+	r.Mount("/teams", teamsHandlers())
+	r.Mount("/push", pushHandlers())
+	r.With(requireStore).Post("/slack/commands", slackCommand)
+	r.With(requireStore).Post("/telegram/webhook", telegramWebhook)
+	r.With(requireStore, tenantMiddleware).Post("/telegram/pairing", createTelegramPairing)
+
+	srv := limitSlowClients(&http.Server{
+		Addr:         listenAddr,
+		Handler:      withH2C(r),
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		IdleTimeout:  idleTimeout,
+	})
+	srv.SetKeepAlivesEnabled(keepAlives)
+	srv.RegisterOnShutdown(func() { close(gatewayStopping) })
 	go func() {
-		log.Println("listening on port: ", port)
-		if err := srv.ListenAndServe(); err != nil {
-			log.Printf("listen:%s\n", err)
+		if err := listen(srv); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("listen failed", "error", err)
 		}
 	}()
+	redirect := redirectServer()
+	if redirect != nil {
+		go serveRedirect(redirect)
+	}
+	debug := debugServer()
+	if debug != nil {
+		go serveDebug(debug)
+	}
+	admin := adminServer()
+	if admin != nil {
+		go serveAdmin(admin)
+	}
+	grpcSrv := grpcServer()
+	if grpcSrv != nil {
+		go serveGRPC(grpcSrv)
+	}
 
-	<-stopChan
-	log.Println("shutting down server...")
-	if client != nil {
-		client.Disconnect(context.Background())
-		log.Println("Closed MongoDB connection")
+	upgraded := awaitStop(stopChan)
+	slog.Info("shutting down server")
+	if !upgraded {
+		notifySystemd("STOPPING=1")
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	srv.Shutdown(ctx)
-	defer cancel()
-	log.Println("server gracefully stopped")
+	shutdown(stopBackground, srv, redirect, debug, admin, grpcSrv)
+	slog.Info("server gracefully stopped")
 }
 
 func todoHandlers() http.Handler {
 	rg := chi.NewRouter()
+	rg.Use(tenantMiddleware)
+	// EventSource gives up on non-200 answers, so the stream stays open
+	// while the store is down.
+	rg.Get("/events", streamEvents)
 	rg.Group(func(r chi.Router) {
-		r.Get("/", fetchTodos)
-		r.Post("/", createTodo)
-		r.Put("/{id}", updateTodo)
+		r.Use(requireStore)
+		r.Use(cacheMiddleware)
+		r.With(deprecated("string-completed")).Get("/", fetchTodos)
+		r.Get("/stats", fetchStats)
+		r.With(deprecated("string-completed")).Get("/search", searchTodos)
+		r.With(deprecated("string-completed")).Get("/{id}", fetchTodo)
+		r.With(validateBody("todo-create")).Post("/", createTodo)
+		r.With(deprecated("string-completed"), validateBody("todo-update")).Put("/{id}", updateTodo)
 		r.Delete("/{id}", deleteTodo)
+		attachmentRoutes(r)
 	})
 	return rg
 }
-
-func checkErr(err error) {
-	if err != nil {
-		log.Fatal(err)
-	}
-}
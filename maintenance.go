@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// maintenanceSettings are read by loadMaintenanceSettings, can be
+// reloaded, see reloadConfig, and toggled at /debug/maintenance.
+type maintenanceSettings struct {
+	enabled    bool
+	allowReads bool
+	retryAfter time.Duration
+	message    string
+}
+
+var maintenance atomic.Pointer[maintenanceSettings]
+
+// loadMaintenanceSettings reads TODO_MAINTENANCE, which answers writes
+// with 503, TODO_MAINTENANCE_READS, false to refuse reads too,
+// TODO_MAINTENANCE_RETRY_AFTER and TODO_MAINTENANCE_MESSAGE.
+func loadMaintenanceSettings() (func(), error) {
+	m := &maintenanceSettings{allowReads: true, retryAfter: 5 * time.Minute}
+	for _, b := range []struct {
+		env string
+		v   *bool
+	}{
+		{"TODO_MAINTENANCE", &m.enabled},
+		{"TODO_MAINTENANCE_READS", &m.allowReads},
+	} {
+		if v := os.Getenv(b.env); v != "" {
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q", b.env, v)
+			}
+			*b.v = parsed
+		}
+	}
+	if v := os.Getenv("TODO_MAINTENANCE_RETRY_AFTER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid TODO_MAINTENANCE_RETRY_AFTER %q, must be a duration like 10m", v)
+		}
+		m.retryAfter = d
+	}
+	m.message = os.Getenv("TODO_MAINTENANCE_MESSAGE")
+	return func() { maintenance.Store(m) }, nil
+}
+
+// maintenanceMiddleware answers 503 with Retry-After to writes while
+// maintenance mode is on, and to reads too unless they are allowed.
+// Probes, metrics, the version and the debug endpoints stay up so the
+// mode can be turned off again.
+func maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := maintenance.Load()
+		if m == nil || !m.enabled || maintenanceExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if m.allowReads {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		message := m.message
+		if message == "" {
+			message = "the service is under maintenance, try again later"
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(m.retryAfter.Seconds())))
+		rnd.JSON(w, http.StatusServiceUnavailable, renderer.M{"message": message, "error": "under maintenance"})
+	})
+}
+
+func maintenanceExempt(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/healthz", "/readyz", "/metrics", "/version":
+		return true
+	}
+	return strings.HasPrefix(r.URL.Path, "/debug/")
+}
+
+// maintenanceHandler shows the maintenance mode, or sets it from
+// {"enabled", "allow_reads", "retry_after", "message"} on PUT. Fields
+// left out keep their value. The change lasts until the next reload.
+func maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	m := *maintenance.Load()
+	if r.Method == http.MethodPut {
+		var body struct {
+			Enabled    *bool   `json:"enabled"`
+			AllowReads *bool   `json:"allow_reads"`
+			RetryAfter *string `json:"retry_after"`
+			Message    *string `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid maintenance settings", "error": err.Error()})
+			return
+		}
+		if body.RetryAfter != nil {
+			d, err := time.ParseDuration(*body.RetryAfter)
+			if err != nil || d < time.Second {
+				rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid maintenance settings", "error": "retry_after must be a duration like 10m"})
+				return
+			}
+			m.retryAfter = d
+		}
+		if body.Enabled != nil {
+			m.enabled = *body.Enabled
+		}
+		if body.AllowReads != nil {
+			m.allowReads = *body.AllowReads
+		}
+		if body.Message != nil {
+			m.message = *body.Message
+		}
+		maintenance.Store(&m)
+		slog.Info("maintenance mode changed", "enabled", m.enabled, "allow_reads", m.allowReads)
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"enabled":     m.enabled,
+		"allow_reads": m.allowReads,
+		"retry_after": m.retryAfter.String(),
+		"message":     m.message,
+	})
+}
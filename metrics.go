@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// defaultBuckets are the upper bounds of the latency histograms in
+// seconds, the Prometheus client defaults.
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	// metricsToken, when set, is the bearer token /metrics requires.
+	metricsToken string
+
+	httpRequests = newCounterVec("todo_http_requests_total",
+		"HTTP requests by method, route and status.", "method", "route", "status")
+	httpDuration = newHistogramVec("todo_http_request_duration_seconds",
+		"Latency of HTTP requests by method, route and status.", "method", "route", "status")
+	httpInFlight atomic.Int64
+
+	mongoDuration = newHistogramVec("todo_mongo_command_duration_seconds",
+		"Latency of MongoDB commands by command and outcome.", "command", "outcome")
+)
+
+func loadMetricsConfig() error {
+	metricsToken = os.Getenv("TODO_METRICS_TOKEN")
+	return nil
+}
+
+// series is one labeled value of a metric. Counters only use count.
+type series struct {
+	labels  []string
+	count   float64
+	sum     float64
+	buckets []uint64
+}
+
+type metricVec struct {
+	name   string
+	help   string
+	labels []string
+	bounds []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type (
+	counterVec   struct{ metricVec }
+	histogramVec struct{ metricVec }
+)
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{metricVec{name: name, help: help, labels: labels, series: map[string]*series{}}}
+}
+
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	return &histogramVec{metricVec{name: name, help: help, labels: labels, bounds: defaultBuckets, series: map[string]*series{}}}
+}
+
+// get returns the series of values, creating it. The caller holds mu.
+func (m *metricVec) get(values []string) *series {
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labels: values}
+		if m.bounds != nil {
+			s.buckets = make([]uint64, len(m.bounds))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (c *counterVec) inc(values ...string) {
+	c.mu.Lock()
+	c.get(values).count++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) add(n float64, values ...string) {
+	c.mu.Lock()
+	c.get(values).count += n
+	c.mu.Unlock()
+}
+
+func (h *histogramVec) observe(d time.Duration, values ...string) {
+	v := d.Seconds()
+	h.mu.Lock()
+	s := h.get(values)
+	s.count++
+	s.sum += v
+	for i, b := range h.bounds {
+		if v <= b {
+			s.buckets[i]++
+		}
+	}
+	h.mu.Unlock()
+}
+
+func (m *metricVec) labelPairs(values []string, extra ...string) string {
+	var pairs []string
+	for i, l := range m.labels {
+		pairs = append(pairs, l+`="`+escapeLabel(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+extra[i+1]+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sorted returns the series ordered by labels, for a stable output.
+func (m *metricVec) sorted() []series {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]series, 0, len(m.series))
+	for _, s := range m.series {
+		c := *s
+		c.buckets = slices.Clone(s.buckets)
+		out = append(out, c)
+	}
+	slices.SortFunc(out, func(a, b series) int {
+		return slices.Compare(a.labels, b.labels)
+	})
+	return out
+}
+
+func (c *counterVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, s := range c.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(s.labels), formatFloat(s.count))
+	}
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, s := range h.sorted() {
+		for i, b := range h.bounds {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.labels, "le", formatFloat(b)), s.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %s\n", h.name, h.labelPairs(s.labels, "le", "+Inf"), formatFloat(s.count))
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %s\n", h.name, h.labelPairs(s.labels), formatFloat(s.count))
+	}
+}
+
+func writeGauge(w io.Writer, name, help string, v int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, v)
+}
+
+// metricsMiddleware counts requests and their latency by the route
+// pattern that matched, so IDs do not become labels. Requests no route
+// matched are counted under "unmatched".
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpInFlight.Add(1)
+		defer httpInFlight.Add(-1)
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			if p := rctx.RoutePattern(); p != "" {
+				route = p
+			}
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		code := strconv.Itoa(status)
+		httpRequests.inc(r.Method, route, code)
+		httpDuration.observe(time.Since(start), r.Method, route, code)
+	})
+}
+
+// mongoMonitor times every MongoDB command, traces those of traced
+// requests and logs the slow ones.
+func mongoMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			traceMongoStarted(ctx, e)
+			slowQueryStarted(e)
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			mongoDuration.observe(e.Duration, e.CommandName, "success")
+			traceMongoFinished(e.RequestID, "")
+			slowQueryFinished(ctx, e.CommandFinishedEvent, "success")
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			mongoDuration.observe(e.Duration, e.CommandName, "failure")
+			traceMongoFinished(e.RequestID, e.Failure)
+			slowQueryFinished(ctx, e.CommandFinishedEvent, "failure")
+		},
+	}
+}
+
+// metricsHandler serves the metrics in the Prometheus text format. The
+// todo gauges are only reported with the mongo store while it is
+// reachable.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if metricsToken != "" {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(metricsToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	httpRequests.write(w)
+	httpDuration.write(w)
+	writeGauge(w, "todo_http_requests_in_flight", "HTTP requests being served.", httpInFlight.Load())
+	if maxInFlight.Load() > 0 {
+		fmt.Fprintf(w, "# HELP todo_http_requests_shed_total Requests answered 503 because too many were in flight.\n# TYPE todo_http_requests_shed_total counter\ntodo_http_requests_shed_total %d\n", shedRequests.Load())
+	}
+	if cache != nil {
+		cacheRequests.write(w)
+	}
+	if readCache != nil {
+		readCacheRequests.write(w)
+		readCacheEvictions.write(w)
+		writeGauge(w, "todo_read_cache_entries", "Todos in the read cache.", int64(readCache.len()))
+	}
+	if statsCacheTTL > 0 {
+		statsCacheRequests.write(w)
+	}
+	if webhooksEnabled() {
+		writeGauge(w, "todo_webhook_deliveries_in_flight", "Webhook deliveries being sent.", webhooksInFlight.Load())
+	}
+	if jobsEnabled() {
+		jobRuns.write(w)
+	}
+	writeTaskMetrics(w)
+	if breakerThreshold > 0 {
+		writeGauge(w, "todo_store_breaker_state", "State of the store circuit breaker: 0 closed, 1 open, 2 half-open.", int64(breaker.current()))
+		breakerTransitions.write(w)
+	}
+	if !usingMongo() {
+		return
+	}
+	mongoDuration.write(w)
+	ttlDeleted.write(w)
+	if slowQueryThreshold.Load() > 0 {
+		slowQueries.write(w)
+	}
+	connected := int64(0)
+	if dbConnected.Load() {
+		connected = 1
+	}
+	writeGauge(w, "todo_mongo_up", "Whether MongoDB is reachable.", connected)
+	if connected == 0 {
+		return
+	}
+	writeTodoGauges(w)
+}
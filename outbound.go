@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// outboundTimeout bounds a whole request to a user URL, redirects
+	// included. Callers may set shorter deadlines.
+	outboundTimeout = 30 * time.Second
+	// outboundMaxRedirects is how many redirects a request to a user URL
+	// follows.
+	outboundMaxRedirects = 5
+)
+
+var (
+	errNonPublicAddress = errors.New("url must point to a public address")
+
+	// outboundAllowed are the non-public networks user URLs may reach
+	// anyway, see loadOutboundConfig.
+	outboundAllowed []netip.Prefix
+
+	// nonPublicPrefixes are the special-purpose networks of RFC 6890 and
+	// its updates, beyond those net/netip classifies.
+	nonPublicPrefixes = []netip.Prefix{
+		netip.MustParsePrefix("0.0.0.0/8"),
+		netip.MustParsePrefix("100.64.0.0/10"),
+		netip.MustParsePrefix("192.0.0.0/24"),
+		netip.MustParsePrefix("192.0.2.0/24"),
+		netip.MustParsePrefix("198.18.0.0/15"),
+		netip.MustParsePrefix("198.51.100.0/24"),
+		netip.MustParsePrefix("203.0.113.0/24"),
+		netip.MustParsePrefix("240.0.0.0/4"),
+		netip.MustParsePrefix("64:ff9b::/96"),
+		netip.MustParsePrefix("64:ff9b:1::/48"),
+		netip.MustParsePrefix("100::/64"),
+		netip.MustParsePrefix("2001::/23"),
+		netip.MustParsePrefix("2001:db8::/32"),
+		netip.MustParsePrefix("2002::/16"),
+	}
+)
+
+// outboundClient sends the requests to URLs given by users, webhooks and
+// calendars. It only connects to public addresses, checked once the name
+// is resolved so that no DNS answer can point it at the host, the private
+// network or a cloud metadata service, and follows at most
+// outboundMaxRedirects, each checked the same. It ignores the proxy of the
+// environment, which would be the only address checked.
+var outboundClient = &http.Client{
+	Timeout: outboundTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   dialPublicOnly,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: outboundTimeout,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) > outboundMaxRedirects {
+			return fmt.Errorf("stopped after %d redirects", outboundMaxRedirects)
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+// loadOutboundConfig reads TODO_OUTBOUND_ALLOW, networks such as
+// "10.1.0.0/16,fd00::/8" that webhooks and calendars may reach although
+// they are not public, for receivers on the private network.
+func loadOutboundConfig() error {
+	outboundAllowed = nil
+	for _, v := range strings.Split(os.Getenv("TODO_OUTBOUND_ALLOW"), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return fmt.Errorf("invalid network %q in TODO_OUTBOUND_ALLOW, expected a CIDR like 10.1.0.0/16", v)
+		}
+		outboundAllowed = append(outboundAllowed, p.Masked())
+	}
+	return nil
+}
+
+// publicAddr reports whether user URLs may reach addr.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range outboundAllowed {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, p := range nonPublicPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublicOnly is the net.Dialer Control of outboundClient, called with
+// the resolved address of every connection.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicAddr(ap.Addr()) {
+		return fmt.Errorf("%w, %s is not", errNonPublicAddress, ap.Addr())
+	}
+	return nil
+}
+
+// checkOutboundURL checks that raw is an absolute http or https URL whose
+// host resolves to public addresses only, so URLs to internal services
+// are refused when they are registered. outboundClient checks again on
+// every connection, as the DNS answer may have changed by then.
+func checkOutboundURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if addr, err := netip.ParseAddr(u.Hostname()); err == nil {
+		if !publicAddr(addr) {
+			return errNonPublicAddress
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("url host %s could not be resolved", u.Hostname())
+	}
+	for _, addr := range addrs {
+		if !publicAddr(addr) {
+			return errNonPublicAddress
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+		{"2001:db8::1", false},
+	}
+	for _, tt := range tests {
+		if got := publicAddr(netip.MustParseAddr(tt.addr)); got != tt.public {
+			t.Errorf("publicAddr(%s) = %v, want %v", tt.addr, got, tt.public)
+		}
+	}
+}
+
+// allowOutbound lets user URLs reach prefixes until the test ends.
+func allowOutbound(t *testing.T, prefixes ...string) {
+	prev := outboundAllowed
+	outboundAllowed = nil
+	for _, p := range prefixes {
+		outboundAllowed = append(outboundAllowed, netip.MustParsePrefix(p))
+	}
+	t.Cleanup(func() { outboundAllowed = prev })
+}
+
+func TestCheckOutboundURL(t *testing.T) {
+	allowOutbound(t, "10.9.0.0/16")
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://93.184.216.34/hook", true},
+		{"http://10.9.1.1:8080/hook", true},
+		{"https://127.0.0.1/hook", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://[::1]:9000/", false},
+		{"http://10.1.1.1/", false},
+		{"http://localhost:9000/", false},
+		{"ftp://93.184.216.34/", false},
+		{"/relative", false},
+		{"https://", false},
+	}
+	for _, tt := range tests {
+		if err := checkOutboundURL(context.Background(), tt.url); (err == nil) != tt.ok {
+			t.Errorf("checkOutboundURL(%q) = %v, want ok %v", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestOutboundClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/internal":
+			http.Redirect(w, r, "http://127.0.0.2:"+strings.Split(r.Host, ":")[1]+"/", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer srv.Close()
+	get := func(path string) error {
+		resp, err := outboundClient.Get(srv.URL + path)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	allowOutbound(t)
+	if err := get("/"); !errors.Is(err, errNonPublicAddress) {
+		t.Errorf("loopback: got %v, want %v", err, errNonPublicAddress)
+	}
+
+	allowOutbound(t, "127.0.0.1/32")
+	if err := get("/"); err != nil {
+		t.Errorf("allowed network: %v", err)
+	}
+	if err := get("/internal"); !errors.Is(err, errNonPublicAddress) {
+		t.Errorf("redirect to a non-public address: got %v, want %v", err, errNonPublicAddress)
+	}
+	if err := get("/loop"); err == nil || !strings.Contains(err.Error(), "redirects") {
+		t.Errorf("redirect loop: got %v, want the redirects to stop", err)
+	}
+}
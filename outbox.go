@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Event types emitted for todo mutations.
+const (
+	eventTodoCreated = "todo.created"
+	eventTodoUpdated = "todo.updated"
+	eventTodoDeleted = "todo.deleted"
+)
+
+const (
+	outboxCollectionName = "outbox"
+	outboxPollInterval   = time.Second
+	outboxLease          = 30 * time.Second
+	outboxMaxAttempts    = 20
+	outboxMaxBackoff     = time.Hour
+	outboxRetention      = 7 * 24 * time.Hour
+
+	outboxPending   = "pending"
+	outboxDelivered = "delivered"
+	outboxFailed    = "failed"
+)
+
+type (
+	// todoEvent is what publishers receive. ID is stable across redeliveries
+	// so consumers can discard duplicates.
+	todoEvent struct {
+		ID         string    `json:"id"`
+		Type       string    `json:"type"`
+		TenantID   string    `json:"tenant_id,omitempty"`
+		OccurredAt time.Time `json:"occurred_at"`
+		Todo       todo      `json:"todo"`
+	}
+
+	// outboxRecord is an event as stored in the outbox collection. The todo
+	// is kept as stored, so encrypted fields stay encrypted at rest.
+	outboxRecord struct {
+		ID            primitive.ObjectID `bson:"_id"`
+		Type          string             `bson:"type"`
+		TenantID      string             `bson:"tenant_id,omitempty"`
+		Todo          todoModel          `bson:"todo"`
+		OccurredAt    time.Time          `bson:"occurred_at"`
+		Status        string             `bson:"status"`
+		Attempts      int                `bson:"attempts"`
+		NextAttemptAt time.Time          `bson:"next_attempt_at"`
+		DeliveredTo   []string           `bson:"delivered_to,omitempty"`
+		DeliveredAt   *time.Time         `bson:"delivered_at,omitempty"`
+		LastError     string             `bson:"last_error,omitempty"`
+	}
+
+	// eventPublisher delivers events to a downstream system. Publish may be
+	// called more than once for the same event.
+	eventPublisher interface {
+		Name() string
+		Publish(ctx context.Context, ev todoEvent) error
+	}
+)
+
+var (
+	// outboxEnabled is set by TODO_OUTBOX. Transactions need a replica set
+	// or sharded cluster.
+	outboxEnabled bool
+
+	publishersMu sync.RWMutex
+	publishers   []eventPublisher
+)
+
+func loadOutboxConfig() error {
+	v := os.Getenv("TODO_OUTBOX")
+	if v == "" {
+		return nil
+	}
+	enabled, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("invalid TODO_OUTBOX %q", v)
+	}
+	if enabled && !usingMongo() {
+		return errors.New("TODO_OUTBOX requires the mongo store")
+	}
+	outboxEnabled = enabled
+	return nil
+}
+
+// registerPublisher adds p to the publishers events are dispatched to.
+func registerPublisher(p eventPublisher) {
+	publishersMu.Lock()
+	defer publishersMu.Unlock()
+	publishers = append(publishers, p)
+}
+
+func outboxCollection() *mongo.Collection {
+	return db.Collection(outboxCollectionName, writeCollectionOpts)
+}
+
+// inTx runs fn in a transaction when the outbox is enabled, so the events
+// fn records commit or roll back together with the mutation.
+func inTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !outboxEnabled {
+		return fn(ctx)
+	}
+	sess, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(ctx)
+
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// recordEvent writes an event for tm to the outbox. It must be called from
+// within inTx.
+func recordEvent(ctx context.Context, eventType string, tm todoModel) error {
+	if !outboxEnabled {
+		return nil
+	}
+	now := time.Now()
+	_, err := outboxCollection().InsertOne(ctx, outboxRecord{
+		ID:            primitive.NewObjectID(),
+		Type:          eventType,
+		TenantID:      tm.TenantID,
+		Todo:          tm,
+		OccurredAt:    now,
+		Status:        outboxPending,
+		NextAttemptAt: now,
+	})
+	return err
+}
+
+// ensureOutboxIndexes creates the index the dispatcher polls on and a TTL
+// index expiring delivered events.
+func ensureOutboxIndexes(ctx context.Context) error {
+	if !outboxEnabled {
+		return nil
+	}
+	_, err := outboxCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "delivered_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(outboxRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+// runOutboxDispatcher delivers pending events until ctx is done. Events are
+// claimed with a lease, so several instances can dispatch concurrently
+// without publishing the same event at the same time.
+func runOutboxDispatcher(ctx context.Context) {
+	if !outboxEnabled {
+		return
+	}
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		dispatchEvents(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchEvents delivers the events that are due until none is left or
+// ctx is done.
+func dispatchEvents(ctx context.Context) {
+	for ctx.Err() == nil {
+		rec, err := claimEvent(ctx)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("could not claim event", "component", "outbox", "error", err)
+			}
+			return
+		}
+		deliverEvent(ctx, rec)
+	}
+}
+
+func claimEvent(ctx context.Context) (outboxRecord, error) {
+	now := time.Now()
+	var rec outboxRecord
+	err := outboxCollection().FindOneAndUpdate(ctx,
+		bson.M{"status": outboxPending, "next_attempt_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"next_attempt_at": now.Add(outboxLease)}},
+		options.FindOneAndUpdate().SetSort(bson.M{"_id": 1}).SetReturnDocument(options.After),
+	).Decode(&rec)
+	return rec, err
+}
+
+// deliverEvent publishes rec to every publisher that has not received it
+// yet, all at the same time so a slow one does not hold up the others, and
+// records the outcome.
+func deliverEvent(ctx context.Context, rec outboxRecord) {
+	ev, err := newTodoEvent(rec)
+	if err != nil {
+		failEvent(ctx, rec, nil, err)
+		return
+	}
+
+	publishersMu.RLock()
+	targets := append([]eventPublisher(nil), publishers...)
+	publishersMu.RUnlock()
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		delivered = []string{}
+		errs      []error
+	)
+	for _, p := range targets {
+		if slices.Contains(rec.DeliveredTo, p.Name()) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.Publish(ctx, ev)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+				return
+			}
+			delivered = append(delivered, p.Name())
+		}()
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		failEvent(ctx, rec, delivered, errors.Join(errs...))
+		return
+	}
+	_, err = outboxCollection().UpdateByID(ctx, rec.ID, bson.M{
+		"$set":      bson.M{"status": outboxDelivered, "delivered_at": time.Now()},
+		"$addToSet": bson.M{"delivered_to": bson.M{"$each": delivered}},
+	})
+	if err != nil {
+		slog.Error("could not mark event delivered", "component", "outbox", "event", rec.ID.Hex(), "error", err)
+	}
+}
+
+// failEvent schedules the next attempt of rec with exponential backoff, or
+// marks it failed once outboxMaxAttempts is reached.
+func failEvent(ctx context.Context, rec outboxRecord, delivered []string, cause error) {
+	attempts := rec.Attempts + 1
+	set := bson.M{"attempts": attempts, "last_error": cause.Error()}
+	if attempts >= outboxMaxAttempts {
+		set["status"] = outboxFailed
+		slog.Error("giving up on event", "component", "outbox", "event", rec.ID.Hex(), "attempts", attempts, "error", cause)
+	} else {
+		delay := time.Second << min(attempts, 12)
+		delay = min(delay, outboxMaxBackoff)
+		set["next_attempt_at"] = time.Now().Add(delay/2 + rand.N(delay/2))
+	}
+
+	update := bson.M{"$set": set}
+	if len(delivered) > 0 {
+		update["$addToSet"] = bson.M{"delivered_to": bson.M{"$each": delivered}}
+	}
+	if _, err := outboxCollection().UpdateByID(ctx, rec.ID, update); err != nil {
+		slog.Error("could not reschedule event", "component", "outbox", "event", rec.ID.Hex(), "error", err)
+	}
+}
+
+func newTodoEvent(rec outboxRecord) (todoEvent, error) {
+	tm := rec.Todo
+	if err := decryptContent(&tm); err != nil {
+		return todoEvent{}, err
+	}
+	return todoEvent{
+		ID:         rec.ID.Hex(),
+		Type:       rec.Type,
+		TenantID:   rec.TenantID,
+		OccurredAt: rec.OccurredAt,
+		Todo:       toTodo(tm),
+	}, nil
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	// maxFormBody bounds the create form, which holds at most a
+	// description and a few short fields.
+	maxFormBody = 64 << 10
+)
+
+// todoFilter selects todos like the filters of todocli list: all of its
+// set fields must match.
+type todoFilter struct {
+	// Status is "open", "done" or "" for both.
+	Status   string
+	List     string
+	Label    string
+	Priority int
+	// Q is text contained in the title, ignoring case.
+	Q string
+}
+
+// parseTodoFilter reads a filter from the query parameters of the same
+// names, and returns a message describing the first invalid one.
+func parseTodoFilter(q url.Values) (todoFilter, string) {
+	f := todoFilter{Status: q.Get("status"), List: q.Get("list"), Label: q.Get("label"), Q: q.Get("q")}
+	if f.Status != "" && f.Status != "open" && f.Status != "done" {
+		return f, "status must be open or done"
+	}
+	if v := q.Get("priority"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil || p < 0 || p > maxPriority {
+			return f, "priority must be between 1 and 4"
+		}
+		f.Priority = p
+	}
+	return f, ""
+}
+
+func (f todoFilter) match(t todoModel) bool {
+	return (f.Status == "" || t.Completed == (f.Status == "done")) &&
+		(f.List == "" || t.List == f.List) &&
+		(f.Label == "" || slices.Contains(t.Labels, f.Label)) &&
+		(f.Priority == 0 || t.Priority == f.Priority) &&
+		(f.Q == "" || strings.Contains(strings.ToLower(t.Title), strings.ToLower(f.Q)))
+}
+
+// query returns the query parameters of f, for links keeping it.
+func (f todoFilter) query() url.Values {
+	q := url.Values{}
+	for k, v := range map[string]string{"status": f.Status, "list": f.List, "label": f.Label, "q": f.Q} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	if f.Priority != 0 {
+		q.Set("priority", strconv.Itoa(f.Priority))
+	}
+	return q
+}
+
+// todosPage is the data of todos.tpl.
+type todosPage struct {
+	locale
+	Todos  []todoRow
+	Prefs  preferences
+	Filter todoFilter
+	// Lists and Labels are those of all todos, for the filter choices.
+	Lists  []string
+	Labels []string
+	Total  int
+	Page   int
+	Pages  int
+	// PrevURL and NextURL link the neighbouring pages, "" at the ends.
+	PrevURL string
+	NextURL string
+	// Form holds what was sent when creating a todo failed.
+	Form todoForm
+	// Error and Message are in English, translated when rendered.
+	Error   string
+	Message string
+}
+
+// Priorities are the choices of the priority fields.
+func (todosPage) Priorities() []int {
+	ps := make([]int, maxPriority)
+	for i := range ps {
+		ps[i] = i + 1
+	}
+	return ps
+}
+
+// todoRow is a todo as the rows of todos.tpl show it, with the
+// preferences and language of the user of ctx.
+type todoRow struct {
+	todo
+	locale
+	prefs preferences
+}
+
+func newTodoRow(ctx context.Context, tm todoModel) todoRow {
+	prefs := pagePreferences(ctx)
+	t := toTodo(tm)
+	dueFlags(&t, prefs.location())
+	return todoRow{t, pageLocale(ctx), prefs}
+}
+
+// Due returns the due date in the timezone and date format of the user.
+func (t todoRow) Due() string {
+	if t.DueAt == nil {
+		return ""
+	}
+	return t.DueAt.In(t.prefs.location()).Format(t.prefs.dateLayout())
+}
+
+// todoForm is the create form, as sent.
+type todoForm struct {
+	Title       string
+	Description string
+	List        string
+	Labels      string
+	Priority    string
+	Due         string
+	// Completed is the checkbox of a row, "true" when checked.
+	Completed string
+}
+
+// webHandlers serves the todo list rendered on the server, paged and
+// filtered, and the form creating todos, through the same store as the
+// JSON API, and the boards of the lists. With JavaScript, HTMX then works
+// on single rows, see fragmentRoutes.
+func webHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Use(tenantMiddleware)
+	r.Use(requireStore)
+	r.Use(withPagePreferences)
+	r.Get("/", todosPageHandler)
+	r.Post("/", createTodoPage)
+	r.Get("/lists/{id}/board", boardPageHandler)
+	r.Post("/lists/{id}/board/move", moveOnBoardPage)
+	fragmentRoutes(r)
+	return r
+}
+
+type pagePreferencesKey struct{}
+
+// withPagePreferences loads the preferences of the tenant for the pages,
+// and the locale of their language or of Accept-Language. The defaults
+// are used when that fails, so a page can still tell why the store is
+// unavailable.
+func withPagePreferences(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+		prefs, err := loadPreferences(ctx, tenantFrom(ctx))
+		cancel()
+		if err != nil {
+			slog.Warn("could not load preferences", "component", "web", "error", err)
+			prefs = defaultPreferences()
+		}
+		ctx = context.WithValue(r.Context(), pagePreferencesKey{}, prefs)
+		ctx = context.WithValue(ctx, pageLocaleKey{}, matchLocale(r, prefs.Language))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// pagePreferences returns the preferences loaded by withPagePreferences.
+func pagePreferences(ctx context.Context) preferences {
+	prefs, ok := ctx.Value(pagePreferencesKey{}).(preferences)
+	if !ok {
+		return defaultPreferences()
+	}
+	return prefs
+}
+
+func todosPageHandler(w http.ResponseWriter, r *http.Request) {
+	p := todosPage{Form: todoForm{List: pagePreferences(r.Context()).DefaultList}}
+	switch {
+	case r.URL.Query().Get("created") != "":
+		p.Message = "Todo created."
+	case r.URL.Query().Get("queued") != "":
+		p.Message = "Todo queued until the database is reachable."
+	}
+	renderTodosPage(w, r, http.StatusOK, p)
+}
+
+// renderTodosPage fills p with the page of todos the query of r asks for
+// and renders it, with status unless that fails.
+func renderTodosPage(w http.ResponseWriter, r *http.Request, status int, p todosPage) {
+	q := r.URL.Query()
+	f, msg := parseTodoFilter(q)
+	p.Filter = f
+	p.Prefs = pagePreferences(r.Context())
+	p.locale = pageLocale(r.Context())
+	p.Error, p.Message = p.lookup(p.Error), p.lookup(p.Message)
+	if msg != "" {
+		p.Error = p.lookup(msg)
+		renderPage(w, http.StatusBadRequest, "todos.tpl", p)
+		return
+	}
+	size := defaultPageSize
+	if v, err := strconv.Atoi(q.Get("per_page")); err == nil && v > 0 {
+		size = min(v, maxPageSize)
+	}
+	p.Page = 1
+	if v, err := strconv.Atoi(q.Get("page")); err == nil && v > 0 {
+		p.Page = v
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+	todos, err := repo.List(ctx)
+	if err != nil {
+		k := classify(err)
+		noteError(r.Context(), k.code, err)
+		p.Error = p.T("Could not fetch todos: %s", p.lookup(k.describe(err)))
+		renderPage(w, k.status, "todos.tpl", p)
+		return
+	}
+	slices.SortStableFunc(todos, func(a, b todoModel) int { return a.CreatedAt.Compare(b.CreatedAt) })
+
+	var shown []todoModel
+	for _, t := range todos {
+		if t.List != "" && !slices.Contains(p.Lists, t.List) {
+			p.Lists = append(p.Lists, t.List)
+		}
+		for _, l := range t.Labels {
+			if !slices.Contains(p.Labels, l) {
+				p.Labels = append(p.Labels, l)
+			}
+		}
+		if f.match(t) {
+			shown = append(shown, t)
+		}
+	}
+	slices.Sort(p.Lists)
+	slices.Sort(p.Labels)
+
+	p.Total = len(shown)
+	p.Pages = max(1, (p.Total+size-1)/size)
+	p.Page = min(p.Page, p.Pages)
+	for _, t := range shown[(p.Page-1)*size : min(p.Page*size, p.Total)] {
+		p.Todos = append(p.Todos, newTodoRow(r.Context(), t))
+	}
+	link := func(page int) string {
+		lq := f.query()
+		lq.Set("page", strconv.Itoa(page))
+		if size != defaultPageSize {
+			lq.Set("per_page", strconv.Itoa(size))
+		}
+		return "?" + lq.Encode()
+	}
+	if p.Page > 1 {
+		p.PrevURL = link(p.Page - 1)
+	}
+	if p.Page < p.Pages {
+		p.NextURL = link(p.Page + 1)
+	}
+	renderPage(w, status, "todos.tpl", p)
+}
+
+// createTodoPage creates a todo from the form and goes back to the list,
+// or renders the list again with the problem and what was sent.
+func createTodoPage(w http.ResponseWriter, r *http.Request) {
+	form, err := readTodoForm(w, r)
+	if err != nil {
+		renderTodosPage(w, r, http.StatusBadRequest, todosPage{Error: "The form could not be read."})
+		return
+	}
+	prefs := pagePreferences(r.Context())
+	t, msg := form.todo(prefs)
+	if msg == "" {
+		msg = validateNewTodo(t)
+	}
+	if msg != "" {
+		renderTodosPage(w, r, http.StatusUnprocessableEntity, todosPage{Form: form, Error: msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+	queued, err := saveNewTodo(ctx, newTodoModel(t))
+	if err != nil {
+		k := classify(err)
+		noteError(r.Context(), k.code, err)
+		l := pageLocale(r.Context())
+		renderTodosPage(w, r, k.status, todosPage{Form: form, Error: l.T("Could not create the todo: %s", l.lookup(k.describe(err)))})
+		return
+	}
+	if queued {
+		http.Redirect(w, r, r.URL.Path+"?queued=1", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, r.URL.Path+"?created=1", http.StatusSeeOther)
+}
+
+// readTodoForm reads the todo fields of the form of r, bounding its size.
+func readTodoForm(w http.ResponseWriter, r *http.Request) (todoForm, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBody)
+	if err := r.ParseForm(); err != nil {
+		return todoForm{}, err
+	}
+	return todoForm{
+		Title:       strings.TrimSpace(r.PostForm.Get("title")),
+		Description: r.PostForm.Get("description"),
+		List:        strings.TrimSpace(r.PostForm.Get("list")),
+		Labels:      r.PostForm.Get("labels"),
+		Priority:    r.PostForm.Get("priority"),
+		Due:         r.PostForm.Get("due"),
+		Completed:   r.PostForm.Get("completed"),
+	}, nil
+}
+
+// todo converts the form, returning a message when a field cannot be. The
+// due date is midnight in the timezone of prefs, and todos without a list
+// get the default one.
+func (f todoForm) todo(prefs preferences) (todo, string) {
+	t := todo{Title: f.Title, Description: f.Description, List: f.List}
+	if t.List == "" {
+		t.List = prefs.DefaultList
+	}
+	for _, l := range strings.Split(f.Labels, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			t.Labels = append(t.Labels, l)
+		}
+	}
+	if f.Priority != "" {
+		p, err := strconv.Atoi(f.Priority)
+		if err != nil {
+			return t, "priority must be between 1 and 4"
+		}
+		t.Priority = p
+	}
+	if f.Due != "" {
+		due, err := time.ParseInLocation("2006-01-02", f.Due, prefs.location())
+		if err != nil {
+			return t, "due must be a date like 2024-06-01"
+		}
+		t.DueAt = &due
+	}
+	return t, ""
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/thedevsaddam/renderer"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// The pages are A4, in points.
+const (
+	pdfWidth  = 595.28
+	pdfHeight = 841.89
+	pdfMargin = 56
+)
+
+// helveticaWidths are the widths of the printable ASCII characters in
+// Helvetica, in thousandths of the font size. Other characters are counted
+// as wide as a digit.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// pdfWriter lays out text top to bottom on pages, using the standard
+// Helvetica fonts every PDF reader has, so nothing is embedded. Text is
+// encoded as WinAnsi: characters outside of it print as "?".
+type pdfWriter struct {
+	pages []*bytes.Buffer
+	// y is where the next line goes on the last page, from the bottom.
+	y float64
+}
+
+// pdfFont is a font resource of the pages: F1 is Helvetica and F2
+// Helvetica-Bold.
+type pdfFont string
+
+const (
+	pdfRegular pdfFont = "F1"
+	pdfBold    pdfFont = "F2"
+)
+
+func (p *pdfWriter) newPage() {
+	p.pages = append(p.pages, &bytes.Buffer{})
+	p.y = pdfHeight - pdfMargin
+}
+
+// need starts a new page unless height fits above the bottom margin.
+func (p *pdfWriter) need(height float64) {
+	if len(p.pages) == 0 || p.y-height < pdfMargin {
+		p.newPage()
+	}
+}
+
+// winAnsi encodes s for the fonts of the pages.
+func winAnsi(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if c, ok := charmap.Windows1252.EncodeRune(r); ok {
+			b = append(b, c)
+		} else {
+			b = append(b, '?')
+		}
+	}
+	return b
+}
+
+// textWidth returns the width of s in points.
+func textWidth(s string, font pdfFont, size float64) float64 {
+	w := 0
+	for _, c := range winAnsi(s) {
+		if c >= 32 && c < 127 {
+			w += helveticaWidths[c-32]
+		} else {
+			w += 556
+		}
+	}
+	if font == pdfBold {
+		// Bold is about a tenth wider, counting it so keeps lines inside
+		// the margins.
+		w += w / 10
+	}
+	return float64(w) * size / 1000
+}
+
+// wrap breaks s into lines no wider than width, at spaces, or inside words
+// longer than a line. Line breaks of s are kept.
+func wrap(s string, font pdfFont, size, width float64) []string {
+	var lines []string
+	for _, para := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		line := ""
+		for _, word := range strings.Fields(para) {
+			candidate := strings.TrimPrefix(line+" "+word, " ")
+			if textWidth(candidate, font, size) <= width {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+			line = ""
+			for _, r := range word {
+				if line != "" && textWidth(line+string(r), font, size) > width {
+					lines = append(lines, line)
+					line = ""
+				}
+				line += string(r)
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// text writes s with its baseline at x, y of the last page, in gray from 0
+// for black to 1 for white.
+func (p *pdfWriter) text(x, y float64, font pdfFont, size, gray float64, s string) {
+	writeText(p.pages[len(p.pages)-1], x, y, font, size, gray, s)
+}
+
+func writeText(page *bytes.Buffer, x, y float64, font pdfFont, size, gray float64, s string) {
+	fmt.Fprintf(page, "%.2f g BT /%s %.1f Tf %.2f %.2f Td (", gray, font, size, x, y)
+	for _, c := range winAnsi(s) {
+		if c == '(' || c == ')' || c == '\\' {
+			page.WriteByte('\\')
+		}
+		page.WriteByte(c)
+	}
+	page.WriteString(") Tj ET\n")
+}
+
+// lines writes lines below each other from the current position, moving
+// it down.
+func (p *pdfWriter) lines(x float64, font pdfFont, size, gray float64, lines []string) {
+	for _, l := range lines {
+		p.need(size * 1.3)
+		p.y -= size * 1.3
+		p.text(x, p.y+size*0.3, font, size, gray, l)
+	}
+}
+
+// checkbox draws a square of size points with its bottom left corner at x,
+// y, ticked when checked.
+func (p *pdfWriter) checkbox(x, y, size float64, checked bool) {
+	page := p.pages[len(p.pages)-1]
+	fmt.Fprintf(page, "0 G 0.8 w %.2f %.2f %.2f %.2f re S\n", x, y, size, size)
+	if checked {
+		fmt.Fprintf(page, "1.2 w %.2f %.2f m %.2f %.2f l %.2f %.2f l S\n",
+			x+size*0.2, y+size*0.5, x+size*0.42, y+size*0.22, x+size*0.85, y+size*0.82)
+	}
+}
+
+// bytes returns the document titled title, with footer(n, total) written
+// at the bottom of every page.
+func (p *pdfWriter) bytes(title string, footer func(n, total int) string) []byte {
+	for i, page := range p.pages {
+		s := footer(i+1, len(p.pages))
+		writeText(page, pdfWidth-pdfMargin-textWidth(s, pdfRegular, 9), pdfMargin/2, pdfRegular, 9, 0.5, s)
+	}
+
+	var b bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	// The binary comment tells transfer tools the file is not text.
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	kids := make([]string, len(p.pages))
+	for i := range p.pages {
+		kids[i] = strconv.Itoa(5+2*i) + " 0 R"
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range p.pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfWidth, pdfHeight, 6+2*i))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.Bytes()))
+	}
+	obj(fmt.Sprintf("<< /Title %s /Producer (todo) /CreationDate (D:%s) >>", pdfTextString(title), time.Now().UTC().Format("20060102150405Z")))
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, len(offsets), xref)
+	return b.Bytes()
+}
+
+// pdfTextString encodes s as a PDF text string, UTF-16 with a byte order
+// mark, for the document information.
+func pdfTextString(s string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+// listPDF lays out the todos of list as a checklist: a checkbox and the
+// title of each, its due date, priority and labels, and its description as
+// notes. Dates are in the timezone and format of prefs.
+func listPDF(list string, todos []todoModel, prefs preferences, l locale) []byte {
+	const (
+		box    = 10
+		indent = pdfMargin + box + 8
+	)
+	width := pdfWidth - indent - pdfMargin
+	loc := prefs.location()
+
+	var p pdfWriter
+	p.lines(pdfMargin, pdfBold, 18, 0, wrap(list, pdfBold, 18, pdfWidth-2*pdfMargin))
+	now := time.Now().In(loc)
+	p.lines(pdfMargin, pdfRegular, 9, 0.4, []string{l.T("Exported %s, %d todos", now.Format(prefs.dateLayout()+" 15:04"), len(todos))})
+	p.y -= 12
+
+	for _, t := range todos {
+		title := wrap(t.Title, pdfRegular, 11, width)
+		// A todo starts on a new page rather than leave its title alone.
+		p.need(float64(len(title))*11*1.3 + 12)
+		p.checkbox(pdfMargin, p.y-11*1.3+1, box, t.Completed)
+		gray := 0.0
+		if t.Completed {
+			gray = 0.45
+		}
+		p.lines(indent, pdfRegular, 11, gray, title)
+
+		var meta []string
+		if t.DueAt != nil {
+			meta = append(meta, l.T("Due %s", t.DueAt.In(loc).Format(prefs.dateLayout())))
+		}
+		if t.Priority != 0 {
+			meta = append(meta, "P"+strconv.Itoa(t.Priority))
+		}
+		if len(t.Labels) > 0 {
+			meta = append(meta, strings.Join(t.Labels, ", "))
+		}
+		if len(meta) > 0 {
+			p.lines(indent, pdfRegular, 9, 0.4, wrap(strings.Join(meta, " · "), pdfRegular, 9, width))
+		}
+		if t.Description != "" {
+			p.lines(indent, pdfRegular, 9, 0.2, wrap(t.Description, pdfRegular, 9, width))
+		}
+		p.y -= 8
+	}
+	return p.bytes(list, func(n, total int) string { return l.T("Page %d of %d", n, total) })
+}
+
+// exportListPDF serves GET /lists/{id}/export.pdf, the todos of a list as
+// a printable checklist, in the order of its board.
+func exportListPDF(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	prefs, err := loadPreferences(ctx, tenantFrom(ctx))
+	if err != nil {
+		storeErr(w, r, "could not export list", err)
+		return
+	}
+	list := listParam(r)
+	board, err := loadBoard(ctx, list)
+	if err != nil {
+		storeErr(w, r, "could not export list", err)
+		return
+	}
+	todos := slices.Concat(board...)
+	if len(todos) == 0 {
+		rnd.JSON(w, http.StatusNotFound, renderer.M{"message": "list has no todos", "error": "not found", "code": kindNotFound.code})
+		return
+	}
+	doc := listPDF(list, todos, prefs, matchLocale(r, prefs.Language))
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": list + ".pdf"}))
+	w.Header().Set("Content-Length", strconv.Itoa(len(doc)))
+	w.Write(doc)
+}
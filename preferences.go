@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const preferencesCollectionName = "preferences"
+
+// dateFormats are the date formats users choose from, by name.
+var dateFormats = map[string]string{
+	"iso":  "2006-01-02",
+	"us":   "01/02/2006",
+	"eu":   "02.01.2006",
+	"long": "Jan 2, 2006",
+}
+
+type (
+	// preferences are the settings of the user of a tenant. The web UI
+	// uses all of them, the API the timezone and default list.
+	preferences struct {
+		TenantID string `bson:"_id" json:"-"`
+		// Theme is "light", "dark" or "auto", following the browser.
+		Theme    string `bson:"theme" json:"theme"`
+		Timezone string `bson:"timezone" json:"timezone"`
+		// DateFormat is a key of dateFormats.
+		DateFormat string `bson:"date_format" json:"date_format"`
+		// WeekStart is "monday" or "sunday", for the calendars of clients.
+		WeekStart string `bson:"week_start" json:"week_start"`
+		// DefaultList is the list of todos created without one.
+		DefaultList string `bson:"default_list" json:"default_list"`
+		// Language is "en" or "de", "" to follow the browser.
+		Language      string                  `bson:"language" json:"language"`
+		Notifications notificationPreferences `bson:"notifications" json:"notifications"`
+		UpdatedAt     *time.Time              `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+	}
+
+	// notificationPreferences turn the notifications of the user on or
+	// off as a whole, whatever their own settings.
+	notificationPreferences struct {
+		// Push covers Web Push and FCM, see /push/preferences for the
+		// events notified.
+		Push bool `bson:"push" json:"push"`
+		// Digest covers the digests mailed to the tenant's recipients.
+		Digest bool `bson:"digest" json:"digest"`
+	}
+)
+
+// defaultPreferences apply until a tenant saves its own.
+func defaultPreferences() preferences {
+	return preferences{
+		Theme:         "auto",
+		Timezone:      "UTC",
+		DateFormat:    "iso",
+		WeekStart:     "monday",
+		Notifications: notificationPreferences{Push: true, Digest: true},
+	}
+}
+
+// validate returns a message describing the first invalid field.
+func (p preferences) validate() string {
+	if !slices.Contains([]string{"light", "dark", "auto"}, p.Theme) {
+		return "theme must be light, dark or auto"
+	}
+	if _, err := time.LoadLocation(p.Timezone); err != nil || p.Timezone == "" {
+		return "unknown timezone"
+	}
+	if _, ok := dateFormats[p.DateFormat]; !ok {
+		return "date_format must be iso, us, eu or long"
+	}
+	if p.WeekStart != "monday" && p.WeekStart != "sunday" {
+		return "week_start must be monday or sunday"
+	}
+	if utf8.RuneCountInString(p.DefaultList) > maxListLength {
+		return "default_list is too long"
+	}
+	if p.Language != "" && !supportedLanguage(p.Language) {
+		return "language must be en, de or empty"
+	}
+	return ""
+}
+
+// location returns the time zone of p, UTC if it is unknown.
+func (p preferences) location() *time.Location {
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// dateLayout returns the time layout of the date format of p.
+func (p preferences) dateLayout() string {
+	if layout, ok := dateFormats[p.DateFormat]; ok {
+		return layout
+	}
+	return dateFormats["iso"]
+}
+
+func preferencesCollection() *mongo.Collection {
+	return db.Collection(preferencesCollectionName, writeCollectionOpts)
+}
+
+// loadPreferences returns the preferences of tenant, the defaults when it
+// saved none. Only the mongo store keeps preferences, other stores always
+// get the defaults.
+func loadPreferences(ctx context.Context, tenant string) (preferences, error) {
+	p := defaultPreferences()
+	if !usingMongo() {
+		return p, nil
+	}
+	err := preferencesCollection().FindOne(ctx, bson.M{"_id": tenant}).Decode(&p)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return p, nil
+	}
+	return p, err
+}
+
+// preferencesOrDefault returns the preferences of the tenant of ctx, the
+// defaults while the store is unavailable or reading them fails, so a todo
+// read or a write queued during an outage does not depend on them.
+func preferencesOrDefault(ctx context.Context) preferences {
+	if !storeAvailable() {
+		return defaultPreferences()
+	}
+	p, err := loadPreferences(ctx, tenantFrom(ctx))
+	if err != nil {
+		slog.Warn("could not load preferences, using the defaults", "component", "preferences", "request_id", requestID(ctx), "error", err)
+		return defaultPreferences()
+	}
+	return p
+}
+
+// dueFlags tells whether t is open and due today, or overdue, in loc.
+func dueFlags(t *todo, loc *time.Location) {
+	if t.DueAt == nil || t.Completed == "true" {
+		return
+	}
+	now := time.Now().In(loc)
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	t.Overdue = t.DueAt.Before(startOfDay)
+	t.DueToday = !t.Overdue && t.DueAt.Before(startOfDay.AddDate(0, 0, 1))
+}
+
+func meHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Use(tenantMiddleware)
+	r.Use(requireStore)
+	r.Get("/preferences", fetchPreferences)
+	r.Put("/preferences", savePreferences)
+	return r
+}
+
+func fetchPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	p, err := loadPreferences(ctx, tenantFrom(ctx))
+	if err != nil {
+		storeErr(w, r, "could not fetch preferences", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": p})
+}
+
+// savePreferences updates the preferences of the tenant with the fields of
+// the body, the others keep their value.
+func savePreferences(w http.ResponseWriter, r *http.Request) {
+	if !usingMongo() {
+		rnd.JSON(w, http.StatusNotImplemented, renderer.M{"message": "preferences require the mongo store", "error": "not implemented"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	p, err := loadPreferences(ctx, tenantFrom(ctx))
+	if err != nil {
+		storeErr(w, r, "could not save preferences", err)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid request", "error": err.Error()})
+		return
+	}
+	if msg := p.validate(); msg != "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": msg, "error": "bad request"})
+		return
+	}
+	now := time.Now()
+	p.TenantID, p.UpdatedAt = tenantFrom(ctx), &now
+	_, err = preferencesCollection().ReplaceOne(ctx, bson.M{"_id": p.TenantID}, p, options.Replace().SetUpsert(true))
+	if err != nil {
+		storeErr(w, r, "could not save preferences", err)
+		return
+	}
+	// Responses of /todo depend on the timezone.
+	invalidateCache(ctx, p.TenantID)
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": p})
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	pushSubscriptionsCollectionName = "push_subscriptions"
+	pushPreferencesCollectionName   = "push_preferences"
+	pushNotifiedCollectionName      = "push_notified"
+
+	pushTypeWeb = "webpush"
+	pushTypeFCM = "fcm"
+
+	pushEventReminder  = "reminder"
+	pushEventCreated   = "created"
+	pushEventCompleted = "completed"
+
+	maxPushSubscriptionsPerTenant = 50
+	pushReminderInterval          = time.Minute
+	// pushReminderWindow bounds how long after its due date a reminder is
+	// still sent, so subscribing does not remind of every todo ever due.
+	pushReminderWindow = 24 * time.Hour
+	pushNotifiedTTL    = 90 * 24 * time.Hour
+)
+
+var (
+	pushEvents = []string{pushEventReminder, pushEventCreated, pushEventCompleted}
+	// defaultPushEvents apply until a tenant saves its preferences.
+	defaultPushEvents = []string{pushEventReminder}
+
+	vapid *vapidKey
+	fcm   *fcmClient
+)
+
+// loadPushConfig enables Web Push with TODO_VAPID_PRIVATE_KEY and
+// TODO_VAPID_SUBJECT, and FCM with TODO_FCM_CREDENTIALS, the path of a
+// service account key. Either can be used alone.
+func loadPushConfig() error {
+	var (
+		k   *vapidKey
+		c   *fcmClient
+		err error
+	)
+	if key := os.Getenv("TODO_VAPID_PRIVATE_KEY"); key != "" {
+		subject := os.Getenv("TODO_VAPID_SUBJECT")
+		if !strings.HasPrefix(subject, "mailto:") && !strings.HasPrefix(subject, "https://") {
+			return errors.New("TODO_VAPID_PRIVATE_KEY requires TODO_VAPID_SUBJECT, a mailto: or https: URL")
+		}
+		if k, err = parseVAPIDKey(key, subject); err != nil {
+			return fmt.Errorf("invalid TODO_VAPID_PRIVATE_KEY: %w", err)
+		}
+	}
+	if path := os.Getenv("TODO_FCM_CREDENTIALS"); path != "" {
+		if c, err = newFCMClient(path); err != nil {
+			return fmt.Errorf("invalid TODO_FCM_CREDENTIALS: %w", err)
+		}
+	}
+	if k == nil && c == nil {
+		return nil
+	}
+	if !usingMongo() {
+		return errors.New("push notifications require the mongo store")
+	}
+	if !outboxEnabled {
+		return errors.New("push notifications require TODO_OUTBOX")
+	}
+	vapid, fcm = k, c
+	return nil
+}
+
+func pushEnabled() bool {
+	return vapid != nil || fcm != nil
+}
+
+type (
+	// pushSubscription is a browser subscribed with Web Push or an app
+	// registered with FCM. Endpoint is the push service URL or the FCM
+	// registration token.
+	pushSubscription struct {
+		ID          primitive.ObjectID `bson:"_id" json:"id"`
+		TenantID    string             `bson:"tenant_id" json:"-"`
+		Type        string             `bson:"type" json:"type"`
+		Endpoint    string             `bson:"endpoint" json:"-"`
+		P256dh      string             `bson:"p256dh,omitempty" json:"-"`
+		Auth        string             `bson:"auth,omitempty" json:"-"`
+		UserAgent   string             `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+		CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+		LastError   string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+		LastErrorAt *time.Time         `bson:"last_error_at,omitempty" json:"last_error_at,omitempty"`
+	}
+
+	// pushPreferences selects the events a tenant is notified of.
+	pushPreferences struct {
+		TenantID  string    `bson:"_id" json:"-"`
+		Events    []string  `bson:"events" json:"events"`
+		UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+	}
+
+	// pushMessage is the notification shown, and the JSON payload a service
+	// worker receives.
+	pushMessage struct {
+		Title  string `json:"title"`
+		Body   string `json:"body"`
+		TodoID string `json:"todo_id"`
+		Event  string `json:"event"`
+	}
+)
+
+func pushSubscriptionsCollection() *mongo.Collection {
+	return db.Collection(pushSubscriptionsCollectionName, writeCollectionOpts)
+}
+
+func pushPreferencesCollection() *mongo.Collection {
+	return db.Collection(pushPreferencesCollectionName, writeCollectionOpts)
+}
+
+func pushNotifiedCollection() *mongo.Collection {
+	return db.Collection(pushNotifiedCollectionName, writeCollectionOpts)
+}
+
+// ensurePushIndexes makes endpoints unique, indexes tenant lookups,
+// expires notification records and indexes due dates for reminders.
+func ensurePushIndexes(ctx context.Context) error {
+	if !pushEnabled() {
+		return nil
+	}
+	_, err := pushSubscriptionsCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "endpoint", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = pushNotifiedCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(pushNotifiedTTL.Seconds())),
+	})
+	if err != nil {
+		return err
+	}
+	return ensureDueIndex(ctx)
+}
+
+// pushWants reports whether tenant is notified of event, and did not turn
+// push notifications off in its preferences.
+func pushWants(ctx context.Context, tenant, event string) (bool, error) {
+	if prefs, err := loadPreferences(ctx, tenant); err != nil || !prefs.Notifications.Push {
+		return false, err
+	}
+	var p pushPreferences
+	err := pushPreferencesCollection().FindOne(ctx, bson.M{"_id": tenant}).Decode(&p)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return slices.Contains(defaultPushEvents, event), nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(p.Events, event), nil
+}
+
+// notifyPush sends msg to every subscription of tenant once per key.
+// Subscriptions the push service reports gone are removed. The first
+// failure is returned after trying all subscriptions, so a retry only
+// reaches the ones that failed.
+func notifyPush(ctx context.Context, tenant, key string, msg pushMessage) error {
+	cursor, err := pushSubscriptionsCollection().Find(ctx, bson.M{"tenant_id": tenant})
+	if err != nil {
+		return err
+	}
+	var subs []pushSubscription
+	if err := cursor.All(ctx, &subs); err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, s := range subs {
+		k := key + ":" + s.ID.Hex()
+		_, err := pushNotifiedCollection().InsertOne(ctx, bson.M{"_id": k, "at": time.Now()})
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err == nil {
+			err = sendPush(ctx, s, msg)
+		}
+		switch {
+		case errors.Is(err, errPushGone):
+			pushSubscriptionsCollection().DeleteOne(ctx, bson.M{"_id": s.ID})
+		case err != nil:
+			pushNotifiedCollection().DeleteOne(ctx, bson.M{"_id": k})
+			pushSubscriptionsCollection().UpdateByID(ctx, s.ID, bson.M{"$set": bson.M{"last_error": err.Error(), "last_error_at": time.Now()}})
+			if firstErr == nil {
+				firstErr = fmt.Errorf("push subscription %s: %w", s.ID.Hex(), err)
+			}
+		}
+	}
+	return firstErr
+}
+
+func sendPush(ctx context.Context, s pushSubscription, msg pushMessage) error {
+	switch s.Type {
+	case pushTypeWeb:
+		if vapid == nil {
+			return errors.New("web push is not configured")
+		}
+		auth, err := decryptField(s.ID, s.Auth)
+		if err != nil {
+			return err
+		}
+		p256dh, err := base64.RawURLEncoding.DecodeString(s.P256dh)
+		if err != nil {
+			return err
+		}
+		secret, err := base64.RawURLEncoding.DecodeString(auth)
+		if err != nil {
+			return err
+		}
+		payload, _ := json.Marshal(msg)
+		return sendWebPush(ctx, vapid, s.Endpoint, p256dh, secret, payload)
+	case pushTypeFCM:
+		if fcm == nil {
+			return errors.New("FCM is not configured")
+		}
+		return fcm.send(ctx, s.Endpoint, msg.Title, msg.Body, map[string]string{"todo_id": msg.TodoID, "event": msg.Event})
+	}
+	return fmt.Errorf("unknown subscription type %q", s.Type)
+}
+
+// pushPublisher notifies of created and completed todos. Reopening a todo
+// allows its next completion to notify again.
+type pushPublisher struct{}
+
+func (pushPublisher) Name() string { return "push" }
+
+func (pushPublisher) Publish(ctx context.Context, ev todoEvent) error {
+	var event, title string
+	switch {
+	case ev.Type == eventTodoCreated:
+		event, title = pushEventCreated, "New todo"
+	case ev.Type == eventTodoUpdated && ev.Todo.Completed == "true":
+		event, title = pushEventCompleted, "Completed"
+	case ev.Type == eventTodoUpdated:
+		_, err := pushNotifiedCollection().DeleteMany(ctx, bson.M{"_id": bson.M{"$regex": "^completed:" + ev.Todo.ID + ":"}})
+		return err
+	default:
+		return nil
+	}
+	if ok, err := pushWants(ctx, ev.TenantID, event); err != nil || !ok {
+		return err
+	}
+	return notifyPush(ctx, ev.TenantID, event+":"+ev.Todo.ID, pushMessage{
+		Title:  title,
+		Body:   ev.Todo.Title,
+		TodoID: ev.Todo.ID,
+		Event:  event,
+	})
+}
+
+// sendPushReminders reminds the tenants who want reminders of their open
+// todos as they become due. It is scheduled every pushReminderInterval.
+func sendPushReminders(ctx context.Context) error {
+	if !dbConnected.Load() {
+		return nil
+	}
+	tenants, err := pushSubscriptionsCollection().Distinct(ctx, "tenant_id", bson.M{})
+	if err != nil {
+		return err
+	}
+	for _, t := range tenants {
+		tenant, _ := t.(string)
+		ok, err := pushWants(ctx, tenant, pushEventReminder)
+		if err == nil && ok {
+			err = notifyPushReminders(withTenant(ctx, tenant))
+		}
+		if err != nil {
+			slog.Error("reminders not sent", "component", "push", "tenant", tenant, "error", err)
+		}
+	}
+	return nil
+}
+
+// notifyPushReminders reminds the tenant of ctx of the todos that became
+// due within pushReminderWindow, once per due date.
+func notifyPushReminders(ctx context.Context) error {
+	now := time.Now()
+	cursor, err := readCollection().Find(ctx, scoped(ctx, bson.M{
+		"completed": false,
+		"due_at":    bson.M{"$lte": now, "$gt": now.Add(-pushReminderWindow)},
+	}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var tm todoModel
+		if err := cursor.Decode(&tm); err != nil {
+			return err
+		}
+		title, err := decryptField(tm.ID, tm.Title)
+		if err != nil {
+			return err
+		}
+		key := "reminder:" + tm.ID.Hex() + ":" + strconv.FormatInt(tm.DueAt.Unix(), 10)
+		err = notifyPush(ctx, tenantFrom(ctx), key, pushMessage{
+			Title:  "Due now",
+			Body:   title,
+			TodoID: tm.ID.Hex(),
+			Event:  pushEventReminder,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func pushHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(requireStore)
+	rg.Use(requirePush)
+	rg.Get("/vapid-key", fetchVAPIDKey)
+	rg.Group(func(r chi.Router) {
+		r.Use(tenantMiddleware)
+		r.Get("/subscriptions", fetchPushSubscriptions)
+		r.Post("/subscriptions", subscribePush)
+		r.Delete("/subscriptions/{id}", unsubscribePush)
+		r.Get("/preferences", fetchPushPreferences)
+		r.Put("/preferences", savePushPreferences)
+	})
+	return rg
+}
+
+func requirePush(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !pushEnabled() {
+			rnd.JSON(w, http.StatusNotImplemented, renderer.M{"message": "push notifications are not configured", "error": "not implemented"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fetchVAPIDKey returns the application server key browsers subscribe
+// with.
+func fetchVAPIDKey(w http.ResponseWriter, r *http.Request) {
+	if vapid == nil {
+		rnd.JSON(w, http.StatusNotImplemented, renderer.M{"message": "web push is not configured", "error": "not implemented"})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"public_key": vapid.publicKey()})
+}
+
+func fetchPushSubscriptions(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	cursor, err := pushSubscriptionsCollection().Find(ctx, bson.M{"tenant_id": tenantFrom(ctx)})
+	if err != nil {
+		storeErr(w, r, "could not fetch push subscriptions", err)
+		return
+	}
+	subs := []pushSubscription{}
+	if err := cursor.All(ctx, &subs); err != nil {
+		storeErr(w, r, "could not fetch push subscriptions", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": subs})
+}
+
+// subscribePush registers a browser from the JSON of its PushSubscription,
+// {"endpoint", "keys": {"p256dh", "auth"}}, or an app from {"type": "fcm",
+// "token"}. Registering an endpoint again replaces it, also when it moved
+// to another tenant.
+func subscribePush(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Type     string `json:"type"`
+		Endpoint string `json:"endpoint"`
+		Token    string `json:"token"`
+		Keys     struct {
+			P256dh string `json:"p256dh"`
+			Auth   string `json:"auth"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid request", "error": err.Error()})
+		return
+	}
+	if req.Type == "" {
+		req.Type = pushTypeWeb
+	}
+
+	s := pushSubscription{
+		ID:        primitive.NewObjectID(),
+		Type:      req.Type,
+		UserAgent: truncate(r.UserAgent(), 255),
+		CreatedAt: time.Now(),
+	}
+	switch req.Type {
+	case pushTypeWeb:
+		if vapid == nil {
+			rnd.JSON(w, http.StatusNotImplemented, renderer.M{"message": "web push is not configured", "error": "not implemented"})
+			return
+		}
+		if msg := validWebPushSubscription(r.Context(), req.Endpoint, req.Keys.P256dh, req.Keys.Auth); msg != "" {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": msg, "error": "bad request"})
+			return
+		}
+		auth, err := encryptField(s.ID, strings.TrimRight(req.Keys.Auth, "="))
+		if err != nil {
+			rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "could not subscribe", "error": err.Error()})
+			return
+		}
+		s.Endpoint, s.P256dh, s.Auth = req.Endpoint, strings.TrimRight(req.Keys.P256dh, "="), auth
+	case pushTypeFCM:
+		if fcm == nil {
+			rnd.JSON(w, http.StatusNotImplemented, renderer.M{"message": "FCM is not configured", "error": "not implemented"})
+			return
+		}
+		if req.Token == "" || len(req.Token) > 4096 {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "token is required", "error": "bad request"})
+			return
+		}
+		s.Endpoint = req.Token
+	default:
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "type must be webpush or fcm", "error": "bad request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+	s.TenantID = tenantFrom(ctx)
+
+	n, err := pushSubscriptionsCollection().CountDocuments(ctx, bson.M{"tenant_id": s.TenantID, "endpoint": bson.M{"$ne": s.Endpoint}})
+	if err == nil && n >= maxPushSubscriptionsPerTenant {
+		rnd.JSON(w, http.StatusConflict, renderer.M{"message": "push subscription limit reached", "error": "conflict"})
+		return
+	}
+	if err == nil {
+		_, err = pushSubscriptionsCollection().DeleteOne(ctx, bson.M{"endpoint": s.Endpoint})
+	}
+	if err == nil {
+		_, err = pushSubscriptionsCollection().InsertOne(ctx, s)
+	}
+	if err != nil {
+		storeErr(w, r, "could not subscribe", err)
+		return
+	}
+	rnd.JSON(w, http.StatusCreated, renderer.M{"data": s})
+}
+
+// validWebPushSubscription checks the endpoint and the base64url encoded
+// keys of a browser subscription. The endpoint must resolve to public
+// addresses, see checkOutboundURL.
+func validWebPushSubscription(ctx context.Context, endpoint, p256dh, auth string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return "endpoint must be an absolute https URL"
+	}
+	if err := checkOutboundURL(ctx, endpoint); err != nil {
+		return "endpoint " + strings.TrimPrefix(err.Error(), "url ")
+	}
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(p256dh, "="))
+	if err != nil || len(key) != 65 {
+		return "keys.p256dh must be a base64url encoded P-256 public key"
+	}
+	secret, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(auth, "="))
+	if err != nil || len(secret) != 16 {
+		return "keys.auth must be a base64url encoded 16-byte secret"
+	}
+	return ""
+}
+
+func unsubscribePush(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	res, err := pushSubscriptionsCollection().DeleteOne(ctx, bson.M{"_id": id, "tenant_id": tenantFrom(ctx)})
+	if err == nil && res.DeletedCount == 0 {
+		err = errNotFound
+	}
+	if err != nil {
+		storeErr(w, r, "could not unsubscribe", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"message": "unsubscribed successfully"})
+}
+
+func fetchPushPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	p := pushPreferences{Events: defaultPushEvents}
+	err := pushPreferencesCollection().FindOne(ctx, bson.M{"_id": tenantFrom(ctx)}).Decode(&p)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		storeErr(w, r, "could not fetch push preferences", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": p})
+}
+
+// savePushPreferences replaces the events the tenant is notified of:
+// reminder when a todo becomes due, created and completed. An empty list
+// turns notifications off.
+func savePushPreferences(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Events []string `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid request", "error": err.Error()})
+		return
+	}
+	if req.Events == nil {
+		req.Events = []string{}
+	}
+	for _, e := range req.Events {
+		if !slices.Contains(pushEvents, e) {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "events must be one of " + strings.Join(pushEvents, ", "), "error": "bad request"})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	p := pushPreferences{TenantID: tenantFrom(ctx), Events: req.Events, UpdatedAt: time.Now()}
+	_, err := pushPreferencesCollection().ReplaceOne(ctx, bson.M{"_id": p.TenantID}, p, options.Replace().SetUpsert(true))
+	if err != nil {
+		storeErr(w, r, "could not save push preferences", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": p})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValidWebPushSubscription(t *testing.T) {
+	allowOutbound(t)
+	// A P-256 public key and auth secret of RFC 8291 section 5.
+	const p256dh, auth = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4", "BTBZMqHH6r4Tts7J_aSIgg"
+	tests := []struct {
+		name     string
+		endpoint string
+		problem  string
+	}{
+		{"public address", "https://93.184.216.34/push/abc", ""},
+		{"http", "http://93.184.216.34/push/abc", "https URL"},
+		{"metadata service", "https://169.254.169.254/latest/meta-data/", "public address"},
+		{"private network", "https://10.0.0.5/push", "public address"},
+		{"loopback", "https://[::1]:9000/push", "public address"},
+		{"localhost", "https://localhost/push", "public address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := validWebPushSubscription(context.Background(), tt.endpoint, p256dh, auth)
+			if tt.problem == "" && msg != "" || !strings.Contains(msg, tt.problem) {
+				t.Errorf("got %q, want a problem with %q", msg, tt.problem)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+)
+
+// pwaOffline is the page the service worker answers with for the pages it
+// has no copy of while the server is unreachable.
+const pwaOffline = "/offline"
+
+// pwaAssets are the files of assets/ the service worker caches when it is
+// installed, with the offline page.
+var pwaAssets = []string{"todos.css", "todos.js", "board.css", "pwa.js", "icon-192.png", "icon-512.png"}
+
+type (
+	// webManifest is the web app manifest of the pages, which makes them
+	// installable.
+	webManifest struct {
+		Name            string         `json:"name"`
+		ShortName       string         `json:"short_name"`
+		StartURL        string         `json:"start_url"`
+		Scope           string         `json:"scope"`
+		Display         string         `json:"display"`
+		BackgroundColor string         `json:"background_color"`
+		ThemeColor      string         `json:"theme_color"`
+		Lang            string         `json:"lang"`
+		Icons           []manifestIcon `json:"icons"`
+	}
+
+	manifestIcon struct {
+		Src     string `json:"src"`
+		Sizes   string `json:"sizes"`
+		Type    string `json:"type"`
+		Purpose string `json:"purpose"`
+	}
+)
+
+// manifestHandler serves /manifest.webmanifest, in the language of
+// Accept-Language.
+func manifestHandler(w http.ResponseWriter, r *http.Request) {
+	l := matchLocale(r, "")
+	m := webManifest{
+		Name:            l.lookup("Todos"),
+		ShortName:       l.lookup("Todos"),
+		StartURL:        "/web/",
+		Scope:           "/web/",
+		Display:         "standalone",
+		BackgroundColor: "#ffffff",
+		ThemeColor:      "#007bff",
+		Lang:            l.Lang(),
+		Icons:           []manifestIcon{},
+	}
+	for _, size := range []int{192, 512} {
+		src, err := assetPath(fmt.Sprintf("icon-%d.png", size))
+		if err != nil {
+			continue
+		}
+		// The check mark is within the safe zone of maskable icons.
+		m.Icons = append(m.Icons, manifestIcon{Src: src, Sizes: fmt.Sprintf("%dx%d", size, size), Type: "image/png", Purpose: "any maskable"})
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/manifest+json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Vary", "Accept-Language")
+	w.Write(b)
+}
+
+// serviceWorkerHandler serves /sw.js, sw.js of staticFS after the URLs it
+// caches on install and the name of its cache, which changes with them.
+// Browsers look for a new worker on every visit, so it is revalidated.
+func serviceWorkerHandler(w http.ResponseWriter, r *http.Request) {
+	script, err := fs.ReadFile(staticFS, "sw.js")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	shell := []string{pwaOffline}
+	for _, name := range pwaAssets {
+		p, err := assetPath(name)
+		if err != nil {
+			slog.Warn("service worker asset missing", "asset", name, "error", err)
+			continue
+		}
+		shell = append(shell, p)
+	}
+	urls, _ := json.Marshal(shell)
+	sum := sha256.Sum256(append(urls, script...))
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "const SHELL = %s;\nconst OFFLINE = %q;\nconst CACHE = %q;\n\n", urls, pwaOffline, "todo-"+hex.EncodeToString(sum[:6]))
+	w.Write(script)
+}
+
+// offlineHandler serves the page shown by the service worker offline. It
+// is cached once, so it holds nothing of a tenant.
+func offlineHandler(w http.ResponseWriter, r *http.Request) {
+	renderPage(w, http.StatusOK, "offline.tpl", matchLocale(r, ""))
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// rateLimitIdle is how long a client's bucket is kept after its last
+// request. A full bucket holds no state worth keeping.
+const rateLimitIdle = 10 * time.Minute
+
+// rateLimitSettings are read by loadRateLimitSettings and can be
+// reloaded, see reloadConfig.
+type rateLimitSettings struct {
+	rate    float64
+	burst   float64
+	proxies []netip.Prefix
+}
+
+var (
+	rateLimits atomic.Pointer[rateLimitSettings]
+	limiter    = rateLimiter{buckets: map[string]*tokenBucket{}}
+	// rateLimitNow is the clock of rateLimitMiddleware, swapped by tests.
+	rateLimitNow = time.Now
+)
+
+// loadRateLimitSettings reads TODO_RATE_LIMIT, the requests per second a
+// client may make on average, and TODO_RATE_BURST, how many it may make
+// at once, twice the rate by default. TODO_TRUSTED_PROXIES lists the
+// CIDRs of reverse proxies whose X-Forwarded-For is believed.
+func loadRateLimitSettings() (func(), error) {
+	s := &rateLimitSettings{}
+	if v := os.Getenv("TODO_RATE_LIMIT"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("invalid TODO_RATE_LIMIT %q, must be requests per second", v)
+		}
+		s.rate = f
+	}
+	s.burst = math.Max(1, math.Ceil(2*s.rate))
+	if v := os.Getenv("TODO_RATE_BURST"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid TODO_RATE_BURST %q, must be a positive number of requests", v)
+		}
+		s.burst = float64(n)
+	}
+	for _, v := range strings.Split(os.Getenv("TODO_TRUSTED_PROXIES"), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(v)
+			if aerr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", v, err)
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		s.proxies = append(s.proxies, p.Masked())
+	}
+	return func() { rateLimits.Store(s) }, nil
+}
+
+// tokenBucket holds up to burst tokens, refilled at rate per second. A
+// request takes one.
+type tokenBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+// allow takes a token from the bucket of key. When it is empty, it
+// returns how long until the next token.
+func (l *rateLimiter) allow(s *rateLimitSettings, key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) > rateLimitIdle {
+		for k, b := range l.buckets {
+			if now.Sub(b.at) > rateLimitIdle {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: s.burst, at: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(s.burst, b.tokens+now.Sub(b.at).Seconds()*s.rate)
+	b.at = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / s.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rateLimitMiddleware answers 429 to clients going over the rate limit.
+// The API has no authentication, so clients are told apart by IP. Health
+// probes and metrics scrapes are not limited.
+func rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := rateLimits.Load()
+		switch {
+		case s == nil || s.rate <= 0:
+		case r.URL.Path == "/healthz", r.URL.Path == "/readyz", r.URL.Path == "/metrics":
+		default:
+			ok, wait := limiter.allow(s, clientIP(r), rateLimitNow())
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				rnd.JSON(w, http.StatusTooManyRequests, renderer.M{"message": "rate limit exceeded, slow down", "error": "too many requests"})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the address of the client. Behind trusted proxies it
+// is the last X-Forwarded-For hop not added by one of them. IPv4-mapped
+// IPv6 addresses are given as IPv4 so a client has a single bucket.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	var proxies []netip.Prefix
+	if s := rateLimits.Load(); s != nil {
+		proxies = s.proxies
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	if !trustedProxy(proxies, addr) {
+		return addr.Unmap().String()
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		if !trustedProxy(proxies, hop) {
+			return hop.Unmap().String()
+		}
+		addr = hop
+	}
+	return addr.Unmap().String()
+}
+
+func trustedProxy(proxies []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range proxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
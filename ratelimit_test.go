@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+func useRateLimits(t *testing.T, s *rateLimitSettings) {
+	t.Helper()
+	prev := rateLimits.Load()
+	rateLimits.Store(s)
+	t.Cleanup(func() { rateLimits.Store(prev) })
+}
+
+func TestClientIP(t *testing.T) {
+	useRateLimits(t, &rateLimitSettings{proxies: []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "198.51.100.2:4000", nil, "198.51.100.2"},
+		{"no port", "198.51.100.2", nil, "198.51.100.2"},
+		{"untrusted peer", "198.51.100.2:4000", []string{"203.0.113.9"}, "198.51.100.2"},
+		{"trusted proxy", "10.0.0.1:4000", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"spoofed leading hops", "10.0.0.1:4000", []string{"6.6.6.6, 7.7.7.7, 203.0.113.9"}, "203.0.113.9"},
+		{"proxy chain", "10.0.0.1:4000", []string{"6.6.6.6, 203.0.113.9, 10.0.0.7"}, "203.0.113.9"},
+		{"several headers", "10.0.0.1:4000", []string{"6.6.6.6, 203.0.113.9", "10.0.0.7"}, "203.0.113.9"},
+		{"invalid hop", "10.0.0.1:4000", []string{"203.0.113.9, junk, 10.0.0.7"}, "10.0.0.7"},
+		{"only proxies", "10.0.0.1:4000", []string{"10.0.0.9, 10.0.0.7"}, "10.0.0.9"},
+		{"proxy without header", "10.0.0.1:4000", nil, "10.0.0.1"},
+		{"IPv6 client", "[2001:db9::1]:4000", []string{"203.0.113.9"}, "2001:db9::1"},
+		{"IPv6 proxy", "[2001:db8::1]:4000", []string{"2001:db9::5"}, "2001:db9::5"},
+		{"IPv4-mapped proxy", "[::ffff:10.0.0.1]:4000", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"IPv4-mapped hop", "10.0.0.1:4000", []string{"::ffff:203.0.113.9"}, "203.0.113.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/todo", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	s := &rateLimitSettings{rate: 0.5, burst: 2}
+	l := rateLimiter{buckets: map[string]*tokenBucket{}}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		after time.Duration
+		key   string
+		ok    bool
+		wait  time.Duration
+	}{
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, 2 * time.Second},
+		// Other clients have buckets of their own.
+		{0, "b", true, 0},
+		{500 * time.Millisecond, "a", false, 1500 * time.Millisecond},
+		{2 * time.Second, "a", true, 0},
+		{2 * time.Second, "a", false, 2 * time.Second},
+		// A bucket never holds more than burst tokens.
+		{5 * time.Minute, "a", true, 0},
+		{5 * time.Minute, "a", true, 0},
+		{5 * time.Minute, "a", false, 2 * time.Second},
+		{time.Hour, "a", true, 0},
+	}
+	for i, st := range steps {
+		ok, wait := l.allow(s, st.key, start.Add(st.after))
+		if ok != st.ok || wait != st.wait {
+			t.Errorf("step %d: allow = %v, %v, want %v, %v", i, ok, wait, st.ok, st.wait)
+		}
+	}
+	// b was idle for an hour and swept, a was just used.
+	if _, ok := l.buckets["b"]; ok || len(l.buckets) != 1 {
+		t.Errorf("buckets after the sweep: %v", l.buckets)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	useRateLimits(t, &rateLimitSettings{rate: 0.4, burst: 1})
+	prevRnd, prevLimiter, prevNow := rnd, limiter.buckets, rateLimitNow
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rnd, limiter.buckets, rateLimitNow = renderer.New(), map[string]*tokenBucket{}, func() time.Time { return now }
+	t.Cleanup(func() { rnd, limiter.buckets, rateLimitNow = prevRnd, prevLimiter, prevNow })
+
+	handler := rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = "198.51.100.2:4000"
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := get("/todo"); w.Code != http.StatusOK {
+		t.Fatalf("first request: %d", w.Code)
+	}
+	// A token every 2.5s, rounded up.
+	if w := get("/todo"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3" {
+		t.Errorf("second request: %d, Retry-After %q, want 429 and 3", w.Code, w.Header().Get("Retry-After"))
+	}
+	now = now.Add(2 * time.Second)
+	if w := get("/todo"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("2s later: %d, Retry-After %q, want 429 and 1", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := get("/healthz"); w.Code != http.StatusOK {
+		t.Errorf("health probe: %d, want 200", w.Code)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if w := get("/todo"); w.Code != http.StatusOK {
+		t.Errorf("once refilled: %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Read cache settings, see loadReadCacheConfig.
+var (
+	// readCache is nil when single todos are not cached.
+	readCache *todoLRU
+
+	readCacheRequests = newCounterVec("todo_read_cache_requests_total",
+		"Single todo reads by result: hit or miss.", "result")
+	readCacheEvictions = newCounterVec("todo_read_cache_evictions_total",
+		"Todos dropped from the read cache by reason: capacity, expired or write.", "reason")
+)
+
+// loadReadCacheConfig reads TODO_READ_CACHE_SIZE, how many todos read one
+// at a time are kept in memory, none when unset, and TODO_READ_CACHE_TTL,
+// how long, 10s by default.
+func loadReadCacheConfig() error {
+	readCache = nil
+	v := os.Getenv("TODO_READ_CACHE_SIZE")
+	if v == "" {
+		return nil
+	}
+	size, err := strconv.Atoi(v)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid TODO_READ_CACHE_SIZE %q, must be a number of todos", v)
+	}
+	ttl := 10 * time.Second
+	if v := os.Getenv("TODO_READ_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid TODO_READ_CACHE_TTL %q, must be a positive duration like 10s", v)
+		}
+		ttl = d
+	}
+	if size > 0 {
+		readCache = newTodoLRU(size, ttl)
+	}
+	return nil
+}
+
+// todoLRU keeps the todos read last, up to size, each for ttl.
+type todoLRU struct {
+	size int
+	ttl  time.Duration
+
+	mu sync.Mutex
+	// order holds the *lruEntry values, the most recently used first.
+	order   *list.List
+	entries map[string]*list.Element
+	// writes counts the calls to forget, so a todo read before a write
+	// that ended while it was read is not kept, see add.
+	writes uint64
+}
+
+type lruEntry struct {
+	key     string
+	todo    todoModel
+	expires time.Time
+}
+
+func newTodoLRU(size int, ttl time.Duration) *todoLRU {
+	return &todoLRU{size: size, ttl: ttl, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// lruKey is the key of the todo id of the tenant of ctx.
+func lruKey(ctx context.Context, id primitive.ObjectID) string {
+	return tenantFrom(ctx) + "/" + id.Hex()
+}
+
+func (c *todoLRU) get(key string) (todoModel, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return todoModel{}, false
+	}
+	e := el.Value.(*lruEntry)
+	if time.Now().After(e.expires) {
+		c.remove(el, "expired")
+		return todoModel{}, false
+	}
+	c.order.MoveToFront(el)
+	return cloneTodo(e.todo), true
+}
+
+// reading returns what add needs to know a todo read from now on is
+// still current.
+func (c *todoLRU) reading() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writes
+}
+
+// add keeps tm under key, unless a todo was written since reading
+// returned writes.
+func (c *todoLRU) add(key string, tm todoModel, writes uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.writes != writes {
+		return
+	}
+	if el, ok := c.entries[key]; ok {
+		el.Value = &lruEntry{key: key, todo: cloneTodo(tm), expires: time.Now().Add(c.ttl)}
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, todo: cloneTodo(tm), expires: time.Now().Add(c.ttl)})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back(), "capacity")
+	}
+}
+
+// forget drops the todo of key, after a write to it.
+func (c *todoLRU) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes++
+	if el, ok := c.entries[key]; ok {
+		c.remove(el, "write")
+	}
+}
+
+// remove drops el. The caller holds mu.
+func (c *todoLRU) remove(el *list.Element, reason string) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*lruEntry).key)
+	readCacheEvictions.inc(reason)
+}
+
+func (c *todoLRU) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// cloneTodo copies the slices and pointers of tm, so a caller changing the
+// todo it got does not change the cached one.
+func cloneTodo(tm todoModel) todoModel {
+	tm.Labels = slices.Clone(tm.Labels)
+	tm.Attachments = slices.Clone(tm.Attachments)
+	if tm.DueAt != nil {
+		due := *tm.DueAt
+		tm.DueAt = &due
+	}
+	if tm.StartDate != nil {
+		start := *tm.StartDate
+		tm.StartDate = &start
+	}
+	tm.DependsOn = slices.Clone(tm.DependsOn)
+	if tm.NextID != nil {
+		next := *tm.NextID
+		tm.NextID = &next
+	}
+	return tm
+}
+
+// readCachingStore answers Get from readCache, and drops the todos its
+// mutations touch, whether they succeeded or not, as a failed call may
+// still have written. Changes made by other instances, or by the archiver,
+// show once the cached todo expires.
+type readCachingStore struct {
+	todoStore
+}
+
+func (s readCachingStore) unwrap() todoStore { return s.todoStore }
+
+func (s readCachingStore) Get(ctx context.Context, id primitive.ObjectID) (todoModel, error) {
+	key := lruKey(ctx, id)
+	if tm, ok := readCache.get(key); ok {
+		readCacheRequests.inc("hit")
+		return tm, nil
+	}
+	readCacheRequests.inc("miss")
+	writes := readCache.reading()
+	tm, err := s.todoStore.Get(ctx, id)
+	if err == nil {
+		readCache.add(key, tm, writes)
+	}
+	return tm, err
+}
+
+func (s readCachingStore) Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error {
+	defer readCache.forget(lruKey(ctx, id))
+	return s.todoStore.Update(ctx, id, fields)
+}
+
+func (s readCachingStore) Move(ctx context.Context, id primitive.ObjectID, p boardPlace) ([]primitive.ObjectID, error) {
+	defer readCache.forget(lruKey(ctx, id))
+	moved, err := s.todoStore.Move(ctx, id, p)
+	for _, m := range moved {
+		readCache.forget(lruKey(ctx, m))
+	}
+	return moved, err
+}
+
+func (s readCachingStore) Upsert(ctx context.Context, tm todoModel) (bool, error) {
+	defer readCache.forget(lruKey(ctx, tm.ID))
+	return s.todoStore.Upsert(ctx, tm)
+}
+
+func (s readCachingStore) Delete(ctx context.Context, id primitive.ObjectID) (todoModel, error) {
+	defer readCache.forget(lruKey(ctx, id))
+	return s.todoStore.Delete(ctx, id)
+}
+
+func (s readCachingStore) AddAttachment(ctx context.Context, id primitive.ObjectID, a attachment) error {
+	defer readCache.forget(lruKey(ctx, id))
+	return s.todoStore.AddAttachment(ctx, id, a)
+}
+
+func (s readCachingStore) RemoveAttachment(ctx context.Context, id primitive.ObjectID, aid string) (attachment, error) {
+	defer readCache.forget(lruKey(ctx, id))
+	return s.todoStore.RemoveAttachment(ctx, id, aid)
+}
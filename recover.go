@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/thedevsaddam/renderer"
+)
+
+const sentryTimeout = 5 * time.Second
+
+// Error tracker settings, see loadErrorTrackerConfig.
+var (
+	// sentryStoreURL and sentryKey are derived from TODO_SENTRY_DSN.
+	sentryStoreURL string
+	sentryKey      string
+	sentryEnv      string
+	sentryRelease  string
+	sentryClient   = &http.Client{Timeout: sentryTimeout}
+)
+
+// loadErrorTrackerConfig reads TODO_SENTRY_DSN, the DSN of a Sentry or
+// GlitchTip project handler panics and server errors are reported to,
+// TODO_SENTRY_ENVIRONMENT and TODO_SENTRY_RELEASE, which defaults to the
+// version of the binary, or its commit for development builds.
+func loadErrorTrackerConfig() error {
+	sentryStoreURL, sentryKey = "", ""
+	sentryEnv = envOr("TODO_SENTRY_ENVIRONMENT", "production")
+	v, c, _ := buildInfo()
+	if v == "dev" {
+		v = c
+	}
+	sentryRelease = envOr("TODO_SENTRY_RELEASE", v)
+	dsn := os.Getenv("TODO_SENTRY_DSN")
+	if dsn == "" {
+		return nil
+	}
+	// https://<key>@<host>/<project>, possibly under a path prefix.
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User == nil || u.User.Username() == "" {
+		return fmt.Errorf("invalid TODO_SENTRY_DSN, expected https://key@host/project")
+	}
+	i := strings.LastIndex(u.Path, "/")
+	project := u.Path[i+1:]
+	if project == "" {
+		return fmt.Errorf("invalid TODO_SENTRY_DSN, the project is missing")
+	}
+	sentryKey = u.User.Username()
+	sentryStoreURL = (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path[:i] + "/api/" + project + "/store/"}).String()
+	return nil
+}
+
+func errorTrackerEnabled() bool {
+	return sentryStoreURL != ""
+}
+
+// recoverer turns a panic in a handler into a 500 with the usual error
+// envelope instead of a dropped connection. The panic is logged with its
+// stack and reported to the error tracker when one is configured, as are
+// the other server errors handlers answer, except 503 which is expected
+// while MongoDB is unreachable.
+func recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		var body *bytes.Buffer
+		if errorTrackerEnabled() {
+			body = &bytes.Buffer{}
+			ww.Tee(&limitedBuffer{buf: body, max: 4 << 10})
+		}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// Used by handlers to abort the response on purpose.
+				panic(v)
+			}
+			stack := debug.Stack()
+			slog.Error("handler panicked",
+				"request_id", requestID(r.Context()),
+				"method", r.Method,
+				"path", r.URL.Path,
+				"panic", fmt.Sprint(v),
+				"stack", string(stack),
+			)
+			if errorTrackerEnabled() {
+				go sendSentryEvent(panicEvent(r, v, callers()))
+			}
+			if ww.Status() != 0 {
+				// Part of the response is out, all we can do is cut it.
+				panic(http.ErrAbortHandler)
+			}
+			rnd.JSON(ww, http.StatusInternalServerError, renderer.M{"message": "internal server error", "error": "internal server error"})
+		}()
+		next.ServeHTTP(ww, r)
+
+		if status := ww.Status(); body != nil && status >= 500 && status != http.StatusServiceUnavailable {
+			go sendSentryEvent(serverErrorEvent(r, status, body.Bytes()))
+		}
+	})
+}
+
+// limitedBuffer keeps the first max bytes written to it.
+type limitedBuffer struct {
+	buf *bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if n := b.max - b.buf.Len(); n > 0 {
+		b.buf.Write(p[:min(n, len(p))])
+	}
+	return len(p), nil
+}
+
+// callers returns the stack of the panicking goroutine, skipping the
+// runtime and recoverer frames.
+func callers() []runtime.Frame {
+	pc := make([]uintptr, 64)
+	n := runtime.Callers(3, pc)
+	frames := runtime.CallersFrames(pc[:n])
+	var out []runtime.Frame
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, "runtime.") {
+			out = append(out, f)
+		}
+		if !more {
+			return out
+		}
+	}
+}
+
+// panicEvent returns the Sentry event of a panic.
+func panicEvent(r *http.Request, v any, stack []runtime.Frame) map[string]any {
+	// Sentry lists frames from the outermost call.
+	frames := make([]map[string]any, 0, len(stack))
+	for i := len(stack) - 1; i >= 0; i-- {
+		f := stack[i]
+		frames = append(frames, map[string]any{
+			"function": f.Function,
+			"filename": f.File,
+			"lineno":   f.Line,
+			"in_app":   strings.HasPrefix(f.Function, "main.") || strings.HasPrefix(f.Function, "github.com/qasim-invodev/todo"),
+		})
+	}
+	event := sentryEvent(r, "fatal")
+	event["exception"] = []map[string]any{{
+		"type":       fmt.Sprintf("%T", v),
+		"value":      fmt.Sprint(v),
+		"stacktrace": map[string]any{"frames": frames},
+		"mechanism":  map[string]any{"type": "recoverer", "handled": false},
+	}}
+	return event
+}
+
+// serverErrorEvent returns the Sentry event of a server error a handler
+// answered, with the message and error of its envelope.
+func serverErrorEvent(r *http.Request, status int, body []byte) map[string]any {
+	var envelope struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	json.Unmarshal(body, &envelope)
+	message := fmt.Sprintf("%d %s", status, http.StatusText(status))
+	if envelope.Message != "" {
+		message = envelope.Message
+		if envelope.Error != "" {
+			message += ": " + envelope.Error
+		}
+	}
+	event := sentryEvent(r, "error")
+	event["message"] = map[string]any{"formatted": message}
+	event["fingerprint"] = []string{r.Method, routePattern(r), strconv.Itoa(status), envelope.Message}
+	event["tags"].(map[string]string)["status_code"] = strconv.Itoa(status)
+	return event
+}
+
+// routePattern returns the chi route r matched, or its path.
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		return rctx.RoutePattern()
+	}
+	return r.URL.Path
+}
+
+// sentryEvent returns an event of the given level with the context of r:
+// the route, the request with credentials redacted, the request ID and
+// the tenant. It must be built while r is being served, the route is
+// gone after.
+func sentryEvent(r *http.Request, level string) map[string]any {
+	id := make([]byte, 16)
+	rand.Read(id)
+	host, _ := os.Hostname()
+
+	headers := map[string]string{}
+	for k, v := range r.Header {
+		headers[k] = strings.Join(v, ", ")
+		if sensitiveName(k) {
+			headers[k] = redacted
+		}
+	}
+	query := r.URL.Query()
+	for k := range query {
+		if sensitiveName(k) {
+			query[k] = []string{redacted}
+		}
+	}
+	tags := map[string]string{
+		"request_id": requestID(r.Context()),
+		"route":      routePattern(r),
+	}
+	if l, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok && l.tenant != "" {
+		tags["tenant"] = l.tenant
+	}
+	event := map[string]any{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+		"level":       level,
+		"platform":    "go",
+		"logger":      "todo",
+		"server_name": host,
+		"environment": sentryEnv,
+		"transaction": r.Method + " " + routePattern(r),
+		"request": map[string]any{
+			"method":       r.Method,
+			"url":          r.URL.Path,
+			"query_string": query.Encode(),
+			"headers":      headers,
+		},
+		"tags": tags,
+	}
+	if sentryRelease != "" {
+		event["release"] = sentryRelease
+	}
+	return event
+}
+
+// sendSentryEvent posts event to the store endpoint of the project.
+func sendSentryEvent(event map[string]any) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("could not encode error report", "component", "sentry", "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sentryTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sentryStoreURL, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("could not report error", "component", "sentry", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=todo/1.0, sentry_key="+sentryKey)
+	resp, err := sentryClient.Do(req)
+	if err != nil {
+		slog.Error("could not report error", "component", "sentry", "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		slog.Error("could not report error", "component", "sentry", "status", resp.StatusCode)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	recurringInterval  = time.Minute
+	recurringBatchSize = 100
+)
+
+// ensureRecurringIndexes creates the index the recurring job scans, holding
+// only the todos with a recurrence.
+func ensureRecurringIndexes(ctx context.Context) error {
+	_, err := writeCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "completed", Value: 1}},
+		Options: options.Index().SetPartialFilterExpression(bson.M{"recurrence": bson.M{"$exists": true}}),
+	})
+	return err
+}
+
+// repeatCompleted creates the next occurrence of every completed todo with
+// a recurrence. It is scheduled every recurringInterval.
+func repeatCompleted(ctx context.Context) error {
+	if !storeAvailable() {
+		return nil
+	}
+	n, err := repeatTodos(ctx, time.Now())
+	if n > 0 {
+		slog.Info("repeated recurring todos", "component", "recurring", "count", n)
+	}
+	return err
+}
+
+// repeatTodos repeats the completed recurring todos in batches. Each todo
+// first claims the ID of its occurrence in next_id, so that an instance
+// interrupted before the recurrence moved on leaves the next run to create
+// the same todo again, which the insert then rejects, rather than a second
+// one.
+func repeatTodos(ctx context.Context, now time.Time) (int, error) {
+	filter := bson.M{"completed": true, "recurrence": bson.M{"$exists": true}}
+	repeated := 0
+	for {
+		var batch []todoModel
+		err := withRetry(ctx, func(ctx context.Context) error {
+			cursor, err := writeCollection().Find(ctx, filter, options.Find().SetLimit(recurringBatchSize))
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+
+			batch = nil
+			return cursor.All(ctx, &batch)
+		})
+		if err != nil || len(batch) == 0 {
+			return repeated, err
+		}
+		for _, tm := range batch {
+			if err := repeatTodo(withTenant(ctx, tm.TenantID), tm, now); err != nil {
+				return repeated, err
+			}
+			repeated++
+		}
+		if len(batch) < recurringBatchSize {
+			return repeated, nil
+		}
+	}
+}
+
+// repeatTodo creates the occurrence of tm, see nextOccurrence, and moves
+// the recurrence over to it. A recurrence that no longer matches is
+// dropped.
+func repeatTodo(ctx context.Context, tm todoModel, now time.Time) error {
+	if err := decryptContent(&tm); err != nil {
+		return err
+	}
+	occurrence, ok := nextOccurrence(tm, now)
+	if !ok {
+		slog.Warn("dropped a recurrence that never matches", "component", "recurring", "todo", tm.ID.Hex(), "recurrence", tm.Recurrence)
+		return endRecurrence(ctx, tm.ID)
+	}
+
+	if tm.NextID != nil {
+		occurrence.ID = *tm.NextID
+	} else {
+		occurrence.ID = primitive.NewObjectID()
+		var claimed bool
+		err := withRetry(ctx, func(ctx context.Context) error {
+			res, err := writeCollection().UpdateOne(ctx,
+				scoped(ctx, bson.M{"_id": tm.ID, "next_id": bson.M{"$exists": false}}),
+				bson.M{"$set": bson.M{"next_id": occurrence.ID}},
+			)
+			if err != nil {
+				return err
+			}
+			claimed = res.ModifiedCount == 1
+			return nil
+		})
+		// Another instance claimed it first, and creates the occurrence.
+		if err != nil || !claimed {
+			return err
+		}
+	}
+	if err := repo.Create(ctx, occurrence); err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	return endRecurrence(ctx, tm.ID)
+}
+
+// nextOccurrence returns the copy of the completed todo tm due at the next
+// time its recurrence matches after now, or after its due time when that
+// is later. The start keeps its distance to the due time. ok is false
+// when the recurrence never matches.
+func nextOccurrence(tm todoModel, now time.Time) (occurrence todoModel, ok bool) {
+	spec, err := parseCron(tm.Recurrence)
+	if err != nil {
+		return todoModel{}, false
+	}
+	from := now
+	if tm.DueAt != nil && tm.DueAt.After(now) {
+		from = *tm.DueAt
+	}
+	due := spec.next(from.UTC())
+	if due.IsZero() {
+		return todoModel{}, false
+	}
+	occurrence = todoModel{
+		Title:       tm.Title,
+		Description: tm.Description,
+		CreatedAt:   now,
+		List:        tm.List,
+		Labels:      tm.Labels,
+		Priority:    tm.Priority,
+		DueAt:       &due,
+		Recurrence:  tm.Recurrence,
+	}
+	if tm.StartDate != nil && tm.DueAt != nil {
+		start := due.Add(tm.StartDate.Sub(*tm.DueAt))
+		occurrence.StartDate = &start
+	}
+	return occurrence, true
+}
+
+// endRecurrence removes the recurrence of the todo of id.
+func endRecurrence(ctx context.Context, id primitive.ObjectID) error {
+	err := withRetry(ctx, func(ctx context.Context) error {
+		_, err := writeCollection().UpdateOne(ctx, scoped(ctx, bson.M{"_id": id}), bson.M{"$unset": bson.M{"recurrence": "", "next_id": ""}})
+		return err
+	})
+	invalidateCache(ctx, tenantFrom(ctx))
+	return err
+}
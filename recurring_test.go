@@ -0,0 +1,64 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextOccurrence(t *testing.T) {
+	at := func(d, h int) *time.Time {
+		t := time.Date(2024, 5, d, h, 0, 0, 0, time.UTC)
+		return &t
+	}
+	// 2024-05-06 is a Monday.
+	now := *at(8, 12)
+	tests := []struct {
+		name      string
+		tm        todoModel
+		due       *time.Time
+		start     *time.Time
+		repeating bool
+	}{
+		{"done late", todoModel{DueAt: at(6, 9)}, at(13, 9), nil, true},
+		{"done early", todoModel{DueAt: at(13, 9)}, at(20, 9), nil, true},
+		{"no due date", todoModel{}, at(13, 9), nil, true},
+		{"start kept before due", todoModel{DueAt: at(6, 9), StartDate: at(4, 9)}, at(13, 9), at(11, 9), true},
+	}
+	for _, tt := range tests {
+		tt.tm.Title, tt.tm.List, tt.tm.Labels, tt.tm.Priority = "Report", "work", []string{"weekly"}, 2
+		tt.tm.Recurrence, tt.tm.Completed = "0 9 * * 1", true
+		got, ok := nextOccurrence(tt.tm, now)
+		if !ok || !got.DueAt.Equal(*tt.due) || (tt.start == nil) != (got.StartDate == nil) || (tt.start != nil && !got.StartDate.Equal(*tt.start)) {
+			t.Errorf("%s: got %+v", tt.name, got)
+			continue
+		}
+		if got.Title != "Report" || got.List != "work" || !slices.Equal(got.Labels, []string{"weekly"}) || got.Priority != 2 ||
+			got.Completed || got.Recurrence != "0 9 * * 1" || !got.CreatedAt.Equal(now) {
+			t.Errorf("%s: copied %+v", tt.name, got)
+		}
+	}
+
+	if _, ok := nextOccurrence(todoModel{Recurrence: "0 0 30 2 *"}, now); ok {
+		t.Error("February 30th repeated")
+	}
+}
+
+func TestValidateRecurrence(t *testing.T) {
+	tests := []struct {
+		recurrence string
+		want       string
+	}{
+		{"", ""},
+		{"0 9 * * 1-5", ""},
+		{"every monday", "recurrence must be a 5-field cron expression"},
+		{"0 9 * * 8", "recurrence must be a 5-field cron expression"},
+		{"0 9 * * " + strings.Repeat("1,", maxRecurrenceLength/2) + "1", "recurrence is too long"},
+	}
+	for _, tt := range tests {
+		if got := validateNewTodo(todo{Title: "a", Recurrence: tt.recurrence}); got != tt.want {
+			t.Errorf("recurrence %q: got %q, want %q", tt.recurrence, got, tt.want)
+		}
+	}
+}
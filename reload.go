@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// reloadable are the parts of the configuration that can change while
+// the server runs. Each load validates its settings and returns a
+// function applying them, so a reload with an invalid value changes
+// nothing.
+var reloadable = []struct {
+	name string
+	load func() (func(), error)
+}{
+	{"log", loadLogLevel},
+	{"access log", loadAccessLogSettings},
+	{"trace sampling", loadTraceSamplingSettings},
+	{"slow query", loadSlowQuerySettings},
+	{"rate limit", loadRateLimitSettings},
+	{"load shedding", loadSheddingSettings},
+	{"CORS", loadCORSSettings},
+	{"webhook", loadWebhookSettings},
+	{"maintenance", loadMaintenanceSettings},
+}
+
+// reloadMu serializes reloads from SIGHUP and the endpoint.
+var reloadMu sync.Mutex
+
+// applyNow turns a reloadable load into a loader of loadConfig.
+func applyNow(load func() (func(), error)) func() error {
+	return func() error {
+		apply, err := load()
+		if err != nil {
+			return err
+		}
+		apply()
+		return nil
+	}
+}
+
+// reloadConfig reads the config file again and applies the reloadable
+// settings. The variables set by flags and the environment keep taking
+// precedence; the rest of the configuration needs a restart.
+func reloadConfig() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	if err := reapplyConfigFile(); err != nil {
+		return err
+	}
+	applies := make([]func(), 0, len(reloadable))
+	for _, r := range reloadable {
+		apply, err := r.load()
+		if err != nil {
+			return fmt.Errorf("invalid %s configuration: %w", r.name, err)
+		}
+		applies = append(applies, apply)
+	}
+	for _, apply := range applies {
+		apply()
+	}
+	return nil
+}
+
+// runReloader reloads the configuration on SIGHUP until ctx is done.
+func runReloader(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		if err := reloadConfig(); err != nil {
+			slog.Error("could not reload configuration, keeping the current one", "error", err)
+			continue
+		}
+		slog.Info("reloaded configuration", "file", configPath)
+	}
+}
+
+// reloadHandler reloads the configuration like SIGHUP does. It is served
+// with the debug endpoints.
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if err := reloadConfig(); err != nil {
+		slog.Error("could not reload configuration, keeping the current one", "error", err)
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "could not reload configuration", "error": err.Error()})
+		return
+	}
+	slog.Info("reloaded configuration", "file", configPath)
+	rnd.JSON(w, http.StatusOK, renderer.M{"message": "configuration reloaded"})
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// errNotFound is returned when an update or delete matches no todo.
+	errNotFound = errors.New("todo not found")
+	// errUnsupported is returned by stores lacking an optional capability.
+	errUnsupported = errors.New("not supported by the configured store")
+)
+
+// todoStore is the storage used by the handlers. Implementations scope every
+// call to the tenant of ctx and encrypt fields when encryption is enabled.
+type todoStore interface {
+	List(ctx context.Context) ([]todoModel, error)
+	// Get returns the todo with the given id, or errNotFound.
+	Get(ctx context.Context, id primitive.ObjectID) (todoModel, error)
+	ListArchived(ctx context.Context) ([]todoModel, error)
+	// Each calls fn with every todo q selects as it is read, so they are
+	// never all held at once. It stops at the first error of fn. With
+	// q.Summary, todos have only their ID, title, completion, due date and
+	// creation time, which the store reads without the rest.
+	Each(ctx context.Context, q listQuery, fn func(todoModel) error) error
+	// Move places the todo id at p on the board of its list, see
+	// moveTodo, and returns the todos whose position it changed, id
+	// included. It is one write of the store, publishing one event for id.
+	Move(ctx context.Context, id primitive.ObjectID, p boardPlace) (moved []primitive.ObjectID, err error)
+	Create(ctx context.Context, tm todoModel) error
+	CreateMany(ctx context.Context, tms []todoModel) error
+	Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error
+	Upsert(ctx context.Context, tm todoModel) (created bool, err error)
+	Delete(ctx context.Context, id primitive.ObjectID) (todoModel, error)
+	Stats(ctx context.Context, since time.Time, loc *time.Location) (todoStats, error)
+	AddAttachment(ctx context.Context, id primitive.ObjectID, a attachment) error
+	Attachment(ctx context.Context, id primitive.ObjectID, aid string) (attachment, error)
+	RemoveAttachment(ctx context.Context, id primitive.ObjectID, aid string) (attachment, error)
+}
+
+// repo is selected by TODO_STORE, see loadStore.
+var repo todoStore = mongoRepository{}
+
+// loadStore selects the store backend: mongo (default) or dynamodb.
+func loadStore() error {
+	switch backend := os.Getenv("TODO_STORE"); backend {
+	case "", "mongo":
+		repo = mongoRepository{}
+	case "dynamodb":
+		d, err := newDynamoRepository()
+		if err != nil {
+			return err
+		}
+		repo = d
+	default:
+		return fmt.Errorf("unknown store %q", backend)
+	}
+	if breakerThreshold > 0 {
+		repo = breakerStore{repo}
+	}
+	if cache != nil || statsCacheTTL > 0 {
+		repo = cachingStore{repo}
+	}
+	if readCache != nil {
+		repo = readCachingStore{repo}
+	}
+	return nil
+}
+
+// usingMongo reports whether todos are stored in MongoDB. Transactions,
+// schema validation and indexes only apply then.
+func usingMongo() bool {
+	store := repo
+	for {
+		w, ok := store.(interface{ unwrap() todoStore })
+		if !ok {
+			break
+		}
+		store = w.unwrap()
+	}
+	_, ok := store.(mongoRepository)
+	return ok
+}
+
+// mongoRepository holds the Mongo calls made by the handlers. Every call is
+// retried on transient errors, see withRetry, and mutations record their
+// event in the outbox in the same transaction, see inTx.
+type mongoRepository struct{}
+
+// List returns all todos.
+func (mongoRepository) List(ctx context.Context) ([]todoModel, error) {
+	return listTodos(ctx, readCollection())
+}
+
+// listTodos returns all todos of coll with decrypted titles.
+func listTodos(ctx context.Context, coll *mongo.Collection) ([]todoModel, error) {
+	var todos []todoModel
+	err := withRetry(ctx, func(ctx context.Context) error {
+		opts := findOptions()
+		if id := requestID(ctx); id != "" {
+			opts.SetComment(id)
+		}
+		cursor, err := coll.Find(ctx, scoped(ctx, bson.M{}), opts)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+
+		todos = nil
+		return cursor.All(ctx, &todos)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range todos {
+		if err = decryptContent(&todos[i]); err != nil {
+			return nil, err
+		}
+	}
+	return todos, nil
+}
+
+// Get returns the todo with the given id.
+func (mongoRepository) Get(ctx context.Context, id primitive.ObjectID) (todoModel, error) {
+	var tm todoModel
+	opts := options.FindOne()
+	if rid := requestID(ctx); rid != "" {
+		opts.SetComment(rid)
+	}
+	err := withRetry(ctx, func(ctx context.Context) error {
+		return readCollection().FindOne(ctx, scoped(ctx, bson.M{"_id": id}), opts).Decode(&tm)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return todoModel{}, errNotFound
+	}
+	if err != nil {
+		return todoModel{}, err
+	}
+	if err = decryptContent(&tm); err != nil {
+		return todoModel{}, err
+	}
+	return tm, nil
+}
+
+// summaryProjection is the projection of listQuery.Summary, _id being
+// included by default.
+var summaryProjection = bson.M{"title": 1, "completed": 1, "due_at": 1, "createAt": 1}
+
+// Each calls fn with every todo q selects, decoding them one at a time.
+func (mongoRepository) Each(ctx context.Context, q listQuery, fn func(todoModel) error) error {
+	coll, filter, projection := readCollection(), bson.M{}, bson.M(nil)
+	if q.Archived {
+		coll = db.Collection(collectionName+"_archive", readCollectionOpts)
+	}
+	if q.Summary {
+		projection = summaryProjection
+	}
+	if q.List != nil {
+		filter["list"] = listFilter(*q.List)
+	}
+	return eachTodo(ctx, coll, filter, projection, fn)
+}
+
+// eachTodo calls fn with every todo of coll matching filter with decrypted
+// titles, like listTodos. Only opening the cursor is retried, as fn may
+// have used the todos read before a failure.
+func eachTodo(ctx context.Context, coll *mongo.Collection, filter, projection bson.M, fn func(todoModel) error) error {
+	var cursor *mongo.Cursor
+	err := withRetry(ctx, func(ctx context.Context) error {
+		opts := findOptions()
+		if projection != nil {
+			opts.SetProjection(projection)
+		}
+		if id := requestID(ctx); id != "" {
+			opts.SetComment(id)
+		}
+		var err error
+		cursor, err = coll.Find(ctx, scoped(ctx, filter), opts)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var tm todoModel
+		if err := cursor.Decode(&tm); err != nil {
+			return err
+		}
+		if err = decryptContent(&tm); err != nil {
+			return err
+		}
+		if err := fn(tm); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// Create inserts a todo. The ID is generated client side, so a retried
+// attempt first looks for the todo an earlier attempt may have inserted,
+// with its event, before failing to answer. A duplicate key inside the
+// transaction would abort it instead.
+func (mongoRepository) Create(ctx context.Context, tm todoModel) error {
+	tm.TenantID = tenantFrom(ctx)
+	if err := encryptContent(&tm); err != nil {
+		return err
+	}
+
+	attempt := 0
+	err := withRetry(ctx, func(ctx context.Context) error {
+		attempt++
+		return inTx(ctx, func(ctx context.Context) error {
+			if attempt > 1 {
+				inserted, err := insertedTodos(ctx, []primitive.ObjectID{tm.ID})
+				if err != nil || inserted[tm.ID] {
+					return err
+				}
+			}
+			_, err := writeCollection().InsertOne(ctx, tm, options.InsertOne().SetComment(mongoComment(ctx)))
+			if err != nil {
+				return err
+			}
+			return recordEvent(ctx, eventTodoCreated, tm)
+		})
+	})
+	if err == nil {
+		notifyChange(ctx, eventTodoCreated, tm)
+	}
+	return err
+}
+
+// CreateMany inserts todos in a single unordered batch, so the server may
+// write them in parallel. As with Create, a retried attempt inserts and
+// records events for only the todos no earlier attempt inserted.
+func (mongoRepository) CreateMany(ctx context.Context, tms []todoModel) error {
+	stored := make([]todoModel, len(tms))
+	ids := make([]primitive.ObjectID, len(tms))
+	for i, tm := range tms {
+		tm.TenantID = tenantFrom(ctx)
+		if err := encryptContent(&tm); err != nil {
+			return err
+		}
+		stored[i], ids[i] = tm, tm.ID
+	}
+
+	attempt := 0
+	err := withRetry(ctx, func(ctx context.Context) error {
+		attempt++
+		return inTx(ctx, func(ctx context.Context) error {
+			pending := stored
+			if attempt > 1 {
+				inserted, err := insertedTodos(ctx, ids)
+				if err != nil {
+					return err
+				}
+				pending = slices.DeleteFunc(slices.Clone(stored), func(tm todoModel) bool { return inserted[tm.ID] })
+				if len(pending) == 0 {
+					return nil
+				}
+			}
+			docs := make([]interface{}, len(pending))
+			for i, tm := range pending {
+				docs[i] = tm
+			}
+			_, err := writeCollection().InsertMany(ctx, docs, options.InsertMany().SetOrdered(false).SetComment(mongoComment(ctx)))
+			if err != nil {
+				return err
+			}
+			for _, tm := range pending {
+				if err := recordEvent(ctx, eventTodoCreated, tm); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	for _, tm := range stored {
+		notifyChange(ctx, eventTodoCreated, tm)
+	}
+	return nil
+}
+
+// insertedTodos returns which of ids are todos of the tenant already.
+func insertedTodos(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	if id := requestID(ctx); id != "" {
+		opts.SetComment(id)
+	}
+	cursor, err := writeCollection().Find(ctx, scoped(ctx, bson.M{"_id": bson.M{"$in": ids}}), opts)
+	if err != nil {
+		return nil, err
+	}
+	var found []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	inserted := make(map[primitive.ObjectID]bool, len(found))
+	for _, f := range found {
+		inserted[f.ID] = true
+	}
+	return inserted, nil
+}
+
+// Update applies fields to the todo with the given id.
+func (mongoRepository) Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error {
+	if err := encryptUpdate(id, fields); err != nil {
+		return err
+	}
+
+	var tm todoModel
+	err := withRetry(ctx, func(ctx context.Context) error {
+		return inTx(ctx, func(ctx context.Context) error {
+			err := writeCollection().FindOneAndUpdate(ctx,
+				scoped(ctx, bson.M{"_id": id}),
+				bson.M{"$set": fields},
+				options.FindOneAndUpdate().SetReturnDocument(options.After).SetComment(mongoComment(ctx)),
+			).Decode(&tm)
+			if err != nil {
+				return err
+			}
+			return recordEvent(ctx, eventTodoUpdated, tm)
+		})
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return errNotFound
+	}
+	if err == nil {
+		notifyChange(ctx, eventTodoUpdated, tm)
+	}
+	return err
+}
+
+// Upsert sets the title and completed state of the todo with tm's ID,
+// creating it from tm if it does not exist. created reports which happened.
+func (mongoRepository) Upsert(ctx context.Context, tm todoModel) (created bool, err error) {
+	tm.TenantID = tenantFrom(ctx)
+	if tm.Title, err = encryptField(tm.ID, tm.Title); err != nil {
+		return false, err
+	}
+	onInsert := bson.M{"createAt": tm.CreatedAt}
+	if tm.TenantID != "" {
+		onInsert["tenant_id"] = tm.TenantID
+	}
+
+	var (
+		eventType string
+		changed   todoModel
+	)
+	err = withRetry(ctx, func(ctx context.Context) error {
+		return inTx(ctx, func(ctx context.Context) error {
+			var before todoModel
+			err := writeCollection().FindOneAndUpdate(ctx,
+				scoped(ctx, bson.M{"_id": tm.ID}),
+				bson.M{"$set": bson.M{"title": tm.Title, "completed": tm.Completed}, "$setOnInsert": onInsert},
+				options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before).SetComment(mongoComment(ctx)),
+			).Decode(&before)
+			created = errors.Is(err, mongo.ErrNoDocuments)
+			if err != nil && !created {
+				return err
+			}
+			if created {
+				eventType, changed = eventTodoCreated, tm
+			} else {
+				before.Title, before.Completed = tm.Title, tm.Completed
+				eventType, changed = eventTodoUpdated, before
+			}
+			return recordEvent(ctx, eventType, changed)
+		})
+	})
+	if err == nil {
+		notifyChange(ctx, eventType, changed)
+	}
+	return created, err
+}
+
+// Delete removes the todo with the given id and returns it.
+func (mongoRepository) Delete(ctx context.Context, id primitive.ObjectID) (todoModel, error) {
+	var tm todoModel
+	err := withRetry(ctx, func(ctx context.Context) error {
+		return inTx(ctx, func(ctx context.Context) error {
+			err := writeCollection().FindOneAndDelete(ctx, scoped(ctx, bson.M{"_id": id}),
+				options.FindOneAndDelete().SetComment(mongoComment(ctx)),
+			).Decode(&tm)
+			if err != nil {
+				return err
+			}
+			return recordEvent(ctx, eventTodoDeleted, tm)
+		})
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return todoModel{}, errNotFound
+	}
+	if err == nil {
+		notifyChange(ctx, eventTodoDeleted, tm)
+	}
+	return tm, err
+}
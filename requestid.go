@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// maxRequestIDLen bounds the request IDs taken from clients, which end up
+// in logs and MongoDB comments.
+const maxRequestIDLen = 64
+
+// requestIDMiddleware gives every request an ID, taken from X-Request-Id
+// when the client sent a usable one and generated otherwise. The ID is
+// echoed in the response and added to JSON error envelopes as request_id,
+// so a failure reported by a client can be found in the logs.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !validRequestID(r.Header.Get(middleware.RequestIDHeader)) {
+			r.Header.Del(middleware.RequestIDHeader)
+		}
+		middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := requestID(r.Context())
+			w.Header().Set(middleware.RequestIDHeader, id)
+			next.ServeHTTP(&envelopeWriter{ResponseWriter: w, id: id}, r)
+		})).ServeHTTP(w, r)
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:/", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID of the request ctx belongs to, or "".
+func requestID(ctx context.Context) string {
+	return middleware.GetReqID(ctx)
+}
+
+// mongoComment returns the request ID of ctx to set as the comment of a
+// MongoDB operation, so it shows in the profiler and slow query log. It
+// is nil outside requests, which leaves the comment unset.
+func mongoComment(ctx context.Context) interface{} {
+	if id := requestID(ctx); id != "" {
+		return id
+	}
+	return nil
+}
+
+// envelopeWriter adds request_id to the JSON error envelopes written
+// through it: objects with an error field sent with a 4xx or 5xx status.
+// Other responses pass through untouched.
+type envelopeWriter struct {
+	http.ResponseWriter
+	id     string
+	status int
+	wrote  bool
+}
+
+func (w *envelopeWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	if status >= 400 && !w.wrote && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		// The body grows, a length set by the handler would be wrong.
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *envelopeWriter) Write(b []byte) (int, error) {
+	first := !w.wrote
+	w.wrote = true
+	if !first || w.status < 400 || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		return w.ResponseWriter.Write(b)
+	}
+	if _, err := w.ResponseWriter.Write(withRequestID(b, w.id)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// withRequestID inserts request_id at the start of the JSON object body
+// when it has an error field and no request_id yet.
+func withRequestID(body []byte, id string) []byte {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil || fields["error"] == nil || fields["request_id"] != nil {
+		return body
+	}
+	i := bytes.IndexByte(body, '{') + 1
+	out := make([]byte, 0, len(body)+len(id)+20)
+	out = append(out, body[:i]...)
+	out = append(out, `"request_id":`...)
+	out = strconv.AppendQuote(out, id)
+	out = append(out, ',')
+	return append(out, body[i:]...)
+}
+
+func (w *envelopeWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *envelopeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("response does not support hijacking")
+}
+
+func (w *envelopeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
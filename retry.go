@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+const (
+	maxRetries     = 3
+	retryBaseDelay = 50 * time.Millisecond
+	retryMaxDelay  = 1 * time.Second
+)
+
+// Server error codes that indicate a failover or a node going away rather
+// than a problem with the request itself.
+var transientErrorCodes = []int{
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	189,   // PrimarySteppedDown
+	9001,  // SocketException
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// isTransient reports whether err is worth retrying: network errors,
+// primary elections and errors the server labels as retryable. Failing to
+// select a server counts even when the context ran out while waiting, as it
+// means no suitable node was reachable.
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var sse topology.ServerSelectionError
+	if errors.As(err, &sse) || errors.Is(err, mongo.ErrClientDisconnected) {
+		return true
+	}
+	var te interface{ Transient() bool }
+	if errors.As(err, &te) {
+		return te.Transient()
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if mongo.IsNetworkError(err) {
+		return true
+	}
+
+	var se mongo.ServerError
+	if !errors.As(err, &se) {
+		return false
+	}
+	if se.HasErrorLabel("RetryableWriteError") || se.HasErrorLabel("TransientTransactionError") {
+		return true
+	}
+	for _, code := range transientErrorCodes {
+		if se.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// withRetry calls op until it succeeds, fails with a non-transient error,
+// runs out of attempts or ctx is done. Attempts are spaced with full jitter
+// exponential backoff.
+func withRetry(ctx context.Context, op func(ctx context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		err := op(ctx)
+		if err == nil || attempt == maxRetries || !isTransient(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff(attempt)):
+		}
+	}
+}
+
+// backoff returns a random delay in [0, min(retryMaxDelay, retryBaseDelay*2^attempt)).
+func backoff(attempt int) time.Duration {
+	d := retryBaseDelay << attempt
+	if d <= 0 || d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+	return rand.N(d)
+}
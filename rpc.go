@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// JSON-RPC 2.0 error codes. The server errors from -32001 mirror the
+// statuses storeErr answers REST requests with.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcNotFound       = -32001
+	rpcConflict       = -32002
+	rpcUnavailable    = -32003
+	rpcUnsupported    = -32004
+
+	maxRPCBody  = 1 << 20
+	maxRPCBatch = 100
+)
+
+type (
+	rpcRequest struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Method  string          `json:"method"`
+		Params  json.RawMessage `json:"params"`
+		// ID is nil for notifications, which get no response.
+		ID json.RawMessage `json:"id"`
+	}
+
+	rpcResponse struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Result  any             `json:"result,omitempty"`
+		Error   *rpcError       `json:"error,omitempty"`
+		ID      json.RawMessage `json:"id"`
+	}
+
+	rpcError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+)
+
+func (e *rpcError) Error() string { return e.Message }
+
+// rpcMethods are the procedures served at /rpc. Params are always passed
+// by name.
+var rpcMethods = map[string]func(ctx context.Context, params json.RawMessage) (any, error){
+	"todo.list":   rpcListTodos,
+	"todo.create": rpcCreateTodo,
+	"todo.update": rpcUpdateTodo,
+	"todo.delete": rpcDeleteTodo,
+}
+
+// serveRPC serves the todo operations over JSON-RPC 2.0. A batch is an
+// array of requests answered with an array of responses in any order;
+// when it only holds notifications the answer is 204 without a body.
+func serveRPC(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRPCBody))
+	if err != nil {
+		rnd.JSON(w, http.StatusRequestEntityTooLarge, renderer.M{"message": "request is too large", "error": err.Error()})
+		return
+	}
+	body = bytes.TrimSpace(body)
+
+	if len(body) == 0 || body[0] != '[' {
+		if resp := handleRPC(r.Context(), body); resp != nil {
+			rnd.JSON(w, http.StatusOK, resp)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		rnd.JSON(w, http.StatusOK, rpcFailure(nil, rpcParseError, "parse error"))
+		return
+	}
+	switch {
+	case len(batch) == 0:
+		rnd.JSON(w, http.StatusOK, rpcFailure(nil, rpcInvalidRequest, "empty batch"))
+		return
+	case len(batch) > maxRPCBatch:
+		rnd.JSON(w, http.StatusOK, rpcFailure(nil, rpcInvalidRequest, "batch is too large"))
+		return
+	}
+	responses := []*rpcResponse{}
+	for _, raw := range batch {
+		if resp := handleRPC(r.Context(), raw); resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, responses)
+}
+
+// handleRPC runs one request and returns its response, or nil for a
+// notification.
+func handleRPC(ctx context.Context, raw json.RawMessage) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return rpcFailure(nil, rpcParseError, "parse error")
+		}
+		return rpcFailure(nil, rpcInvalidRequest, "invalid request")
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcFailure(req.ID, rpcInvalidRequest, "invalid request")
+	}
+
+	method, ok := rpcMethods[req.Method]
+	var (
+		result any
+		err    error
+	)
+	switch {
+	case !ok:
+		err = &rpcError{Code: rpcMethodNotFound, Message: "method not found"}
+	case len(req.Params) > 0 && req.Params[0] != '{' && !bytes.Equal(req.Params, []byte("null")):
+		err = &rpcError{Code: rpcInvalidParams, Message: "params must be an object"}
+	default:
+		ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+		result, err = method(ctx, req.Params)
+	}
+	// Notifications are not answered, not even with errors.
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		var rerr *rpcError
+		if !errors.As(err, &rerr) {
+			rerr = rpcStoreError(err)
+		}
+		return &rpcResponse{JSONRPC: "2.0", Error: rerr, ID: req.ID}
+	}
+	return &rpcResponse{JSONRPC: "2.0", Result: result, ID: req.ID}
+}
+
+func rpcFailure(id json.RawMessage, code int, message string) *rpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: code, Message: message}, ID: id}
+}
+
+// rpcStoreError maps store errors like storeErr does for REST.
+func rpcStoreError(err error) *rpcError {
+	switch k := classify(err); k {
+	case kindNotFound:
+		return &rpcError{Code: rpcNotFound, Message: err.Error()}
+	case kindValidation:
+		return &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	case kindUnsupported:
+		return &rpcError{Code: rpcUnsupported, Message: err.Error()}
+	case kindConflict:
+		return &rpcError{Code: rpcConflict, Message: k.detail}
+	case kindBreakerOpen, kindUnavailable:
+		return &rpcError{Code: rpcUnavailable, Message: k.detail}
+	default:
+		slog.Error("rpc store call failed", "error_code", k.code, "error", err)
+		return &rpcError{Code: rpcInternalError, Message: k.detail}
+	}
+}
+
+// rpcParams decodes params into v. Absent params leave v as is.
+func rpcParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+func rpcTodoID(id string) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, &rpcError{Code: rpcInvalidParams, Message: "invalid id"}
+	}
+	return objID, nil
+}
+
+// rpcListTodos takes {"archived"} and returns the todos.
+func rpcListTodos(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		Archived bool `json:"archived"`
+	}
+	if err := rpcParams(params, &p); err != nil {
+		return nil, err
+	}
+	list := repo.List
+	if p.Archived {
+		list = repo.ListArchived
+	}
+	todos, err := list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	todoList := []todo{}
+	for _, t := range todos {
+		todoList = append(todoList, toTodo(t))
+	}
+	return todoList, nil
+}
+
+// rpcCreateTodo takes the fields of POST /todo and returns {"id",
+// "queued"}.
+func rpcCreateTodo(ctx context.Context, params json.RawMessage) (any, error) {
+	var t todo
+	if err := rpcParams(params, &t); err != nil {
+		return nil, err
+	}
+	if msg := validateNewTodo(t); msg != "" {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: msg}
+	}
+	tm := newTodoModel(t)
+	queued, err := saveNewTodo(ctx, tm)
+	if err != nil {
+		return nil, err
+	}
+	return renderer.M{"id": tm.ID, "queued": queued}, nil
+}
+
+// rpcUpdateTodo takes {"id", "title", "completed"} like PUT /todo/{id}
+// and returns {"queued"}.
+func rpcUpdateTodo(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		ID        string `json:"id"`
+		Title     string `json:"title"`
+		Completed bool   `json:"completed"`
+	}
+	if err := rpcParams(params, &p); err != nil {
+		return nil, err
+	}
+	id, err := rpcTodoID(p.ID)
+	if err != nil {
+		return nil, err
+	}
+	if msg := validateTitle(p.Title); msg != "" {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: msg}
+	}
+	queued, err := updateTodoFields(ctx, id, bson.M{"title": p.Title, "completed": p.Completed})
+	if err != nil {
+		return nil, err
+	}
+	return renderer.M{"queued": queued}, nil
+}
+
+// rpcDeleteTodo takes {"id"} and returns {"queued"}.
+func rpcDeleteTodo(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		ID string `json:"id"`
+	}
+	if err := rpcParams(params, &p); err != nil {
+		return nil, err
+	}
+	id, err := rpcTodoID(p.ID)
+	if err != nil {
+		return nil, err
+	}
+	queued, err := removeTodo(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return renderer.M{"queued": queued}, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// samplingPolicy is the share of requests recorded, by path.
+type samplingPolicy struct {
+	ratio float64
+	// routes override ratio under their prefix, ordered from the longest.
+	routes []routeRatio
+}
+
+type routeRatio struct {
+	prefix string
+	ratio  float64
+}
+
+// ratioFor returns the share of requests to path that are recorded.
+func (p *samplingPolicy) ratioFor(path string) float64 {
+	for _, r := range p.routes {
+		if underPrefix(path, r.prefix) {
+			return r.ratio
+		}
+	}
+	return p.ratio
+}
+
+// loadSamplingPolicy reads a policy from the ratio variable, from 0 to 1,
+// and the routes variable, ratios of path prefixes overriding it such as
+// "/healthz=0,/todo/search=0.5".
+func loadSamplingPolicy(ratioVar, routesVar string) (*samplingPolicy, error) {
+	p := &samplingPolicy{ratio: 1}
+	if v := os.Getenv(ratioVar); v != "" {
+		ratio, err := parseRatio(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q, must be between 0 and 1", ratioVar, v)
+		}
+		p.ratio = ratio
+	}
+	for _, entry := range strings.Split(os.Getenv(routesVar), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		prefix, v, ok := strings.Cut(entry, "=")
+		prefix = strings.TrimRight(strings.TrimSpace(prefix), "/")
+		ratio, err := parseRatio(strings.TrimSpace(v))
+		if !ok || !strings.HasPrefix(prefix, "/") || err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q, expected /path=ratio", routesVar, entry)
+		}
+		p.routes = append(p.routes, routeRatio{prefix: prefix, ratio: ratio})
+	}
+	slices.SortStableFunc(p.routes, func(a, b routeRatio) int { return len(b.prefix) - len(a.prefix) })
+	return p, nil
+}
+
+func parseRatio(v string) (float64, error) {
+	f, err := strconv.ParseFloat(v, 64)
+	if err == nil && (f < 0 || f > 1) {
+		err = strconv.ErrRange
+	}
+	return f, err
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+)
+
+// Names of the scheduled tasks, as used by TODO_SCHEDULES, /admin/tasks
+// and the metrics.
+const (
+	taskArchive       = "archive"
+	taskPushReminders = "push-reminders"
+	taskSlackOverdue  = "slack-overdue"
+	taskTeamsOverdue  = "teams-overdue"
+	taskDigests       = "digests"
+	taskICalSync      = "ical-sync"
+	taskGoogleSync    = "google-sync"
+	taskRecurring     = "recurring-todos"
+	taskTTLCleanup    = "ttl-cleanup"
+)
+
+var scheduledTaskNames = []string{taskArchive, taskPushReminders, taskSlackOverdue, taskTeamsOverdue, taskDigests, taskICalSync, taskGoogleSync, taskRecurring, taskTTLCleanup}
+
+var (
+	// taskIntervals override the intervals of tasks, and taskCrons run
+	// them on a cron schedule instead, see loadSchedulerConfig. Zero turns
+	// a task off.
+	taskIntervals map[string]time.Duration
+	taskCrons     map[string]*cronSpec
+
+	// tasks are the scheduled tasks, see scheduleTasks.
+	tasks []*scheduledTask
+
+	taskRuns = newCounterVec("todo_scheduled_task_runs_total",
+		"Runs of scheduled tasks by task and result: ok or error.", "task", "result")
+)
+
+// loadSchedulerConfig reads TODO_SCHEDULES, schedules overriding those of
+// the scheduled tasks: an interval, or a 5-field cron expression in UTC,
+// such as "archive=30m,digests=0 7 * * 1-5". 0 turns a task off. Commas
+// of cron lists, as in "digests=0 7 * * 1,3", belong to the entry before.
+func loadSchedulerConfig() error {
+	taskIntervals, taskCrons = map[string]time.Duration{}, map[string]*cronSpec{}
+	var entries []string
+	for _, part := range strings.Split(os.Getenv("TODO_SCHEDULES"), ",") {
+		if n := len(entries); n > 0 && part != "" && !strings.Contains(part, "=") {
+			entries[n-1] += "," + part
+			continue
+		}
+		entries = append(entries, part)
+	}
+	for _, entry := range entries {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, v, ok := strings.Cut(entry, "=")
+		name, v = strings.TrimSpace(name), strings.TrimSpace(v)
+		if !ok {
+			return fmt.Errorf("invalid schedule %q, expected task=duration or task=cron expression", entry)
+		}
+		if !slices.Contains(scheduledTaskNames, name) {
+			return fmt.Errorf("unknown task %q in TODO_SCHEDULES, must be one of %s", name, strings.Join(scheduledTaskNames, ", "))
+		}
+		if strings.Contains(v, " ") {
+			spec, err := parseCron(v)
+			if err != nil {
+				return fmt.Errorf("invalid schedule %q: %w", entry, err)
+			}
+			taskCrons[name] = spec
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || (d > 0 && d < time.Second) {
+			return fmt.Errorf("invalid schedule %q, expected task=duration of at least 1s", entry)
+		}
+		taskIntervals[name] = d
+	}
+	return nil
+}
+
+// scheduleTasks schedules the recurring work of the enabled features.
+func scheduleTasks() {
+	tasks = nil
+	if archiveAfter > 0 {
+		scheduleTask(taskArchive, archiveInterval, archiveOld)
+	}
+	if pushEnabled() {
+		scheduleTask(taskPushReminders, pushReminderInterval, sendPushReminders)
+	}
+	if slackNotificationsEnabled() {
+		scheduleTask(taskSlackOverdue, slackOverdueInterval, notifyOverdue)
+	}
+	if teamsEnabled() && usingMongo() {
+		scheduleTask(taskTeamsOverdue, teamsOverdueInterval, scanTeamsOverdue)
+	}
+	if digestsEnabled() {
+		scheduleTask(taskDigests, digestInterval, queueDigests)
+	}
+	if usingMongo() {
+		scheduleTask(taskICalSync, time.Minute, syncICal)
+		scheduleTask(taskRecurring, recurringInterval, repeatCompleted)
+		scheduleTask(taskTTLCleanup, ttlCleanupInterval, cleanupExpired)
+	}
+	if googleSyncEnabled() {
+		scheduleTask(taskGoogleSync, time.Minute, syncGoogleLinks)
+	}
+}
+
+// scheduledTask is work run every interval, or at the times of a cron
+// expression, and on demand from /admin/tasks. A task never runs twice at
+// the same time in a process.
+type scheduledTask struct {
+	name  string
+	every time.Duration
+	cron  *cronSpec
+	run   func(ctx context.Context) error
+	// trigger asks for a run before the interval is over.
+	trigger chan struct{}
+
+	mu        sync.Mutex
+	running   bool
+	lastStart time.Time
+	lastEnd   time.Time
+	lastOK    time.Time
+	lastError string
+	next      time.Time
+}
+
+// scheduleTask schedules run every every, unless TODO_SCHEDULES overrides
+// it.
+func scheduleTask(name string, every time.Duration, run func(ctx context.Context) error) {
+	t := &scheduledTask{name: name, every: every, run: run, trigger: make(chan struct{}, 1)}
+	if spec, ok := taskCrons[name]; ok {
+		t.every, t.cron = 0, spec
+	} else if d, ok := taskIntervals[name]; ok {
+		if d == 0 {
+			return
+		}
+		t.every = d
+	}
+	tasks = append(tasks, t)
+}
+
+func findTask(name string) *scheduledTask {
+	for _, t := range tasks {
+		if t.name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// runScheduler runs every task right away, then each interval after its
+// last run ended, until ctx is done. Tasks on a cron schedule run at its
+// times instead. It returns once the tasks running are done.
+func runScheduler(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, t := range tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.loop(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (t *scheduledTask) loop(ctx context.Context) {
+	var timer *time.Timer
+	if t.cron == nil {
+		timer = time.NewTimer(0)
+	} else {
+		timer = time.NewTimer(t.wait())
+	}
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-t.trigger:
+			timer.Stop()
+		}
+		t.runOnce(ctx)
+		timer.Reset(t.wait())
+	}
+}
+
+// wait records when t runs next and returns how long until then. A cron
+// schedule that never matches waits for runs on demand.
+func (t *scheduledTask) wait() time.Duration {
+	now := time.Now()
+	next := now.Add(t.every)
+	if t.cron != nil {
+		next = t.cron.next(now.UTC())
+	}
+	t.mu.Lock()
+	t.next = next
+	t.mu.Unlock()
+	if next.IsZero() {
+		return math.MaxInt64
+	}
+	return next.Sub(now)
+}
+
+func (t *scheduledTask) runOnce(ctx context.Context) {
+	t.mu.Lock()
+	t.running, t.lastStart = true, time.Now()
+	t.mu.Unlock()
+
+	err := t.run(ctx)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running, t.lastEnd = false, time.Now()
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		t.lastError = err.Error()
+		taskRuns.inc(t.name, "error")
+		slog.Error("scheduled task failed", "component", "scheduler", "task", t.name, "duration", t.lastEnd.Sub(t.lastStart), "error", err)
+		return
+	}
+	t.lastOK, t.lastError = t.lastEnd, ""
+	taskRuns.inc(t.name, "ok")
+}
+
+// runNow asks for t to run as soon as it is not running, and reports
+// false when a run was asked for already.
+func (t *scheduledTask) runNow() bool {
+	select {
+	case t.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// taskStatus is a task as listed by GET /admin/tasks.
+type taskStatus struct {
+	Name      string     `json:"name"`
+	Every     string     `json:"every,omitempty"`
+	Cron      string     `json:"cron,omitempty"`
+	Running   bool       `json:"running"`
+	LastStart *time.Time `json:"last_start,omitempty"`
+	LastOK    *time.Time `json:"last_ok,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	Next      *time.Time `json:"next,omitempty"`
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (t *scheduledTask) status() taskStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := taskStatus{
+		Name:      t.name,
+		Running:   t.running,
+		LastStart: optionalTime(t.lastStart),
+		LastOK:    optionalTime(t.lastOK),
+		LastError: t.lastError,
+		Next:      optionalTime(t.next),
+	}
+	if t.cron != nil {
+		s.Cron = t.cron.String()
+	} else {
+		s.Every = t.every.String()
+	}
+	return s
+}
+
+// writeTaskMetrics writes when each task last succeeded and how long its
+// last run took.
+func writeTaskMetrics(w io.Writer) {
+	if len(tasks) == 0 {
+		return
+	}
+	taskRuns.write(w)
+	fmt.Fprintf(w, "# HELP todo_scheduled_task_last_success_timestamp_seconds When scheduled tasks last ran without error.\n# TYPE todo_scheduled_task_last_success_timestamp_seconds gauge\n")
+	for _, t := range tasks {
+		if s := t.status(); s.LastOK != nil {
+			fmt.Fprintf(w, "todo_scheduled_task_last_success_timestamp_seconds{task=\"%s\"} %d\n", t.name, s.LastOK.Unix())
+		}
+	}
+	fmt.Fprintf(w, "# HELP todo_scheduled_task_last_duration_seconds How long the last run of scheduled tasks took.\n# TYPE todo_scheduled_task_last_duration_seconds gauge\n")
+	for _, t := range tasks {
+		t.mu.Lock()
+		start, end := t.lastStart, t.lastEnd
+		t.mu.Unlock()
+		if !end.IsZero() {
+			fmt.Fprintf(w, "todo_scheduled_task_last_duration_seconds{task=\"%s\"} %g\n", t.name, end.Sub(start).Seconds())
+		}
+	}
+}
+
+// adminTaskHandlers serves /admin/tasks, under the dashboard and its
+// token: listing the scheduled tasks of this instance and running one.
+func adminTaskHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", fetchTasks)
+	r.Post("/{name}/run", runTask)
+	return r
+}
+
+func fetchTasks(w http.ResponseWriter, r *http.Request) {
+	list := make([]taskStatus, len(tasks))
+	for i, t := range tasks {
+		list[i] = t.status()
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": list})
+}
+
+// runTask serves POST /admin/tasks/{name}/run, running a task of this
+// instance now instead of at its next interval.
+func runTask(w http.ResponseWriter, r *http.Request) {
+	t := findTask(chi.URLParam(r, "name"))
+	if t == nil {
+		rnd.JSON(w, http.StatusNotFound, renderer.M{"message": "task not scheduled", "error": "not found"})
+		return
+	}
+	if !t.runNow() {
+		rnd.JSON(w, http.StatusAccepted, renderer.M{"message": "task run asked for already"})
+		return
+	}
+	slog.Info("task run asked for", "component", "scheduler", "task", t.name)
+	rnd.JSON(w, http.StatusAccepted, renderer.M{"message": "task will run shortly"})
+}
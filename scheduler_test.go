@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+)
+
+// keepTasks restores the scheduled tasks and their configuration once t
+// is done.
+func keepTasks(t *testing.T) {
+	prevTasks, prevIntervals, prevCrons := tasks, taskIntervals, taskCrons
+	t.Cleanup(func() { tasks, taskIntervals, taskCrons = prevTasks, prevIntervals, prevCrons })
+}
+
+func TestLoadSchedulerConfig(t *testing.T) {
+	keepTasks(t)
+	t.Setenv("TODO_SCHEDULES", " archive=30m, slack-overdue=0 ,digests=0 7 * * 1,3,5")
+	if err := loadSchedulerConfig(); err != nil {
+		t.Fatal(err)
+	}
+	tasks = nil
+	noop := func(context.Context) error { return nil }
+	scheduleTask(taskArchive, time.Hour, noop)
+	scheduleTask(taskSlackOverdue, time.Minute, noop)
+	scheduleTask(taskDigests, time.Minute, noop)
+	scheduleTask(taskICalSync, time.Minute, noop)
+	if len(tasks) != 3 || tasks[0].every != 30*time.Minute || tasks[2].name != taskICalSync || tasks[2].every != time.Minute {
+		t.Errorf("scheduled %+v", tasks)
+	}
+	if s := tasks[1].status(); s.Cron != "0 7 * * 1,3,5" || s.Every != "" {
+		t.Errorf("cron task %+v", s)
+	}
+
+	for _, v := range []string{"archive", "archive=soon", "archive=-1m", "archive=10ms", "cleanup=1m", "digests=0 7 * *", "digests=0 25 * * *", "digests=0 7 * * 1,,3"} {
+		t.Setenv("TODO_SCHEDULES", v)
+		if err := loadSchedulerConfig(); err == nil {
+			t.Errorf("TODO_SCHEDULES=%q was accepted", v)
+		}
+	}
+}
+
+func TestRunScheduler(t *testing.T) {
+	keepTasks(t)
+	taskIntervals = nil
+	tasks = nil
+	var runs atomic.Int32
+	ran := make(chan struct{}, 10)
+	scheduleTask(taskArchive, time.Hour, func(context.Context) error {
+		ran <- struct{}{}
+		if runs.Add(1) == 2 {
+			return errors.New("archive failed")
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runScheduler(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// A task runs right away, then on demand.
+	task := findTask(taskArchive)
+	waitRun(t, ran)
+	waitFor(t, func() bool { return task.status().LastOK != nil })
+	if s := task.status(); s.Every != "1h0m0s" {
+		t.Errorf("after the first run %+v", s)
+	}
+	if !task.runNow() {
+		t.Fatal("runNow refused the first request")
+	}
+	waitRun(t, ran)
+	waitFor(t, func() bool { return task.status().LastError == "archive failed" })
+	if s := task.status(); s.Next == nil || s.Running {
+		t.Errorf("after the failed run %+v", s)
+	}
+	task.runNow()
+	waitRun(t, ran)
+	waitFor(t, func() bool { return task.status().LastError == "" })
+}
+
+func TestCronTaskWait(t *testing.T) {
+	keepTasks(t)
+	taskIntervals, taskCrons = nil, map[string]*cronSpec{}
+	tasks = nil
+	taskCrons[taskDigests], _ = parseCron("* * * * *")
+	taskCrons[taskArchive], _ = parseCron("0 0 30 2 *")
+	scheduleTask(taskDigests, time.Hour, func(context.Context) error { return nil })
+	scheduleTask(taskArchive, time.Hour, func(context.Context) error { return nil })
+
+	// A cron task waits for the next minute rather than running at once.
+	every := findTask(taskDigests)
+	if d := every.wait(); d <= 0 || d > time.Minute {
+		t.Errorf("waits %s for the next minute", d)
+	}
+	if s := every.status(); s.Next == nil || s.Next.Second() != 0 || !s.Next.After(time.Now()) {
+		t.Errorf("next run %+v", s)
+	}
+	never := findTask(taskArchive)
+	if d := never.wait(); d != math.MaxInt64 {
+		t.Errorf("February 30th waits %s", d)
+	}
+	if s := never.status(); s.Next != nil {
+		t.Errorf("February 30th runs next at %v", s.Next)
+	}
+}
+
+func waitRun(t *testing.T, ran <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the task did not run")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+	}
+}
+
+func TestRunTask(t *testing.T) {
+	keepTasks(t)
+	prevRnd := rnd
+	rnd = renderer.New()
+	t.Cleanup(func() { rnd = prevRnd })
+	taskIntervals = nil
+	tasks = nil
+	scheduleTask(taskDigests, time.Minute, func(context.Context) error { return nil })
+
+	r := chi.NewRouter()
+	r.Mount("/admin/tasks", adminTaskHandlers())
+	post := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		return w
+	}
+
+	if w := post("/admin/tasks/archive/run"); w.Code != http.StatusNotFound {
+		t.Errorf("unscheduled task: got %d, want 404", w.Code)
+	}
+	if w := post("/admin/tasks/digests/run"); w.Code != http.StatusAccepted || !strings.Contains(w.Body.String(), "shortly") {
+		t.Errorf("run: got %d %s", w.Code, w.Body)
+	}
+	if w := post("/admin/tasks/digests/run"); w.Code != http.StatusAccepted || !strings.Contains(w.Body.String(), "already") {
+		t.Errorf("second run: got %d %s", w.Code, w.Body)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/tasks/", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"name":"digests"`) {
+		t.Errorf("list: got %d %s", w.Code, w.Body)
+	}
+}
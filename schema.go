@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxTitleLength is the longest title the API accepts, in characters.
+const maxTitleLength = 500
+
+// Limits of the optional todo details.
+const (
+	maxListLength  = 100
+	maxLabels      = 20
+	maxLabelLength = 50
+	// Priorities run from 1, the most urgent, to 4. 0 means none.
+	maxPriority = 4
+
+	maxDescriptionLength = 10000
+	maxDependencies      = 50
+	maxRecurrenceLength  = 100
+)
+
+// validateTitle checks a title and returns a message describing the
+// problem, or "".
+func validateTitle(title string) string {
+	if title == "" {
+		return "title is required"
+	}
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		return "title is too long"
+	}
+	return ""
+}
+
+// validateNewTodo checks the fields a todo is created with.
+func validateNewTodo(t todo) string {
+	if msg := validateTitle(t.Title); msg != "" {
+		return msg
+	}
+	if utf8.RuneCountInString(t.Description) > maxDescriptionLength {
+		return "description is too long"
+	}
+	if t.StartDate != nil && t.DueAt != nil && t.StartDate.After(*t.DueAt) {
+		return "start_date must not be after due_at"
+	}
+	if len(t.DependsOn) > maxDependencies {
+		return "too many dependencies"
+	}
+	for _, id := range t.DependsOn {
+		if !primitive.IsValidObjectID(id) {
+			return "depends_on must hold todo ids"
+		}
+	}
+	if t.Recurrence != "" {
+		if len(t.Recurrence) > maxRecurrenceLength {
+			return "recurrence is too long"
+		}
+		if _, err := parseCron(t.Recurrence); err != nil {
+			return "recurrence must be a 5-field cron expression"
+		}
+	}
+	return validateDetails(t.List, t.Labels, t.Priority)
+}
+
+// validateDetails checks the optional list, labels and priority of a todo
+// and returns a message describing the first problem, or "".
+func validateDetails(list string, labels []string, priority int) string {
+	if utf8.RuneCountInString(list) > maxListLength {
+		return "list is too long"
+	}
+	if len(labels) > maxLabels {
+		return "too many labels"
+	}
+	for _, l := range labels {
+		if l == "" || utf8.RuneCountInString(l) > maxLabelLength {
+			return "labels must be between 1 and 50 characters"
+		}
+	}
+	if priority < 0 || priority > maxPriority {
+		return "priority must be between 1 and 4"
+	}
+	return ""
+}
+
+// maxStoredTitleLength leaves room for an encrypted title: up to four
+// bytes per character plus nonce and tag, base64 encoded and prefixed.
+var maxStoredTitleLength = len(encPrefix) + base64.StdEncoding.EncodedLen(4*maxTitleLength+28)
+
+// maxStoredDescriptionLength leaves room for an encrypted description
+// likewise.
+var maxStoredDescriptionLength = len(encPrefix) + base64.StdEncoding.EncodedLen(4*maxDescriptionLength+28)
+
+// todoSchema is the $jsonSchema every document in the todo collection must
+// satisfy, whichever tool writes it.
+func todoSchema() bson.M {
+	return bson.M{
+		"bsonType": "object",
+		"required": bson.A{"title", "completed", "createAt"},
+		"properties": bson.M{
+			"_id":         bson.M{"bsonType": "objectId"},
+			"title":       bson.M{"bsonType": "string", "minLength": 1, "maxLength": maxStoredTitleLength},
+			"completed":   bson.M{"bsonType": "bool"},
+			"createAt":    bson.M{"bsonType": "date"},
+			"tenant_id":   bson.M{"bsonType": "string"},
+			"description": bson.M{"bsonType": "string", "maxLength": maxStoredDescriptionLength},
+			"list":        bson.M{"bsonType": "string", "maxLength": maxListLength},
+			"labels": bson.M{
+				"bsonType": "array",
+				"maxItems": maxLabels,
+				"items":    bson.M{"bsonType": "string", "minLength": 1, "maxLength": maxLabelLength},
+			},
+			"priority": bson.M{"bsonType": "int", "minimum": 1, "maximum": maxPriority},
+			"due_at":   bson.M{"bsonType": "date"},
+			"source":   bson.M{"bsonType": "string"},
+			"column":   bson.M{"bsonType": "string", "maxLength": maxListLength},
+			"position": bson.M{"bsonType": bson.A{"int", "long"}},
+			"attachments": bson.M{
+				"bsonType": "array",
+				"maxItems": maxAttachmentsPerTodo,
+				"items": bson.M{
+					"bsonType": "object",
+					"required": bson.A{"id", "name", "size", "key"},
+					"properties": bson.M{
+						"name": bson.M{"bsonType": "string"},
+						"size": bson.M{"bsonType": "long"},
+						"key":  bson.M{"bsonType": "string"},
+					},
+				},
+			},
+			"start_date": bson.M{"bsonType": "date"},
+			"depends_on": bson.M{
+				"bsonType": "array",
+				"maxItems": maxDependencies,
+				"items":    bson.M{"bsonType": "objectId"},
+			},
+			"recurrence": bson.M{"bsonType": "string", "maxLength": maxRecurrenceLength},
+			"next_id":    bson.M{"bsonType": "objectId"},
+		},
+	}
+}
+
+// Codes of the MongoDB errors applySchemaValidation handles.
+const (
+	mongoUnauthorized      = 13
+	mongoNamespaceNotFound = 26
+)
+
+// applySchemaValidation installs todoSchema on the todo collection,
+// creating the collection if needed. TODO_SCHEMA_VALIDATION selects the
+// validation level: strict (default), moderate, or off to leave the
+// collection untouched. Installing it takes the collMod action, and
+// createCollection for a new collection; a user without them gets a
+// warning and the collection is left as it is, validated by the API only.
+func applySchemaValidation(ctx context.Context) error {
+	level := os.Getenv("TODO_SCHEMA_VALIDATION")
+	switch level {
+	case "":
+		level = "strict"
+	case "strict", "moderate":
+	case "off":
+		return nil
+	default:
+		return fmt.Errorf("invalid schema validation level %q", level)
+	}
+
+	validator := bson.M{"$jsonSchema": todoSchema()}
+	err := db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: collectionName},
+		{Key: "validator", Value: validator},
+		{Key: "validationLevel", Value: level},
+		{Key: "validationAction", Value: "error"},
+	}).Err()
+
+	var ce mongo.CommandError
+	if errors.As(err, &ce) && ce.HasErrorCode(mongoNamespaceNotFound) {
+		err = db.CreateCollection(ctx, collectionName, options.CreateCollection().
+			SetValidator(validator).
+			SetValidationLevel(level).
+			SetValidationAction("error"))
+	}
+	return unauthorizedAsWarning(err)
+}
+
+// unauthorizedAsWarning logs err and returns nil when the user may not
+// change the todo collection, as the API works without its validator.
+func unauthorizedAsWarning(err error) error {
+	var ce mongo.CommandError
+	if !errors.As(err, &ce) || !ce.HasErrorCode(mongoUnauthorized) {
+		return err
+	}
+	slog.Warn("not allowed to apply the todo schema validator, grant collMod on the todo collection or set TODO_SCHEMA_VALIDATION=off",
+		"collection", collectionName, "error", err)
+	return nil
+}
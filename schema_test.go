@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestUnauthorizedAsWarning(t *testing.T) {
+	unauthorized := mongo.CommandError{Code: mongoUnauthorized, Name: "Unauthorized", Message: "not authorized on demo_todo to execute command { collMod: todo }"}
+	if err := unauthorizedAsWarning(fmt.Errorf("collMod: %w", unauthorized)); err != nil {
+		t.Errorf("a missing collMod privilege failed with %v", err)
+	}
+	for _, err := range []error{
+		fmt.Errorf("collMod: %w", mongo.CommandError{Code: 2, Name: "BadValue"}),
+		errors.New("connection refused"),
+	} {
+		if got := unauthorizedAsWarning(err); !errors.Is(got, err) {
+			t.Errorf("%v became %v", err, got)
+		}
+	}
+	if err := unauthorizedAsWarning(nil); err != nil {
+		t.Errorf("no error became %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const seedBatchSize = 500
+
+var (
+	seedVerbs = []string{
+		"Buy", "Call", "Email", "Review", "Fix", "Schedule", "Pay", "Clean",
+		"Book", "Renew", "Water", "Return", "Prepare", "Update", "Plan", "Pick up",
+	}
+	seedObjects = []string{
+		"groceries", "the dentist", "mom", "quarterly report", "leaky faucet",
+		"car service", "electricity bill", "the garage", "flights to Lisbon",
+		"passport", "the plants", "library books", "slides for Monday",
+		"resume", "weekend trip", "dry cleaning", "pull request #42",
+		"insurance claim", "team offsite", "birthday present for Sam",
+	}
+	seedSuffixes = []string{
+		"", "", "", " today", " before Friday", " this weekend", " tomorrow morning", " ASAP",
+	}
+	// An empty list leaves some todos in the inbox.
+	seedLists  = []string{"", "Home", "Work", "Errands", "Health", "Side project"}
+	seedLabels = []string{
+		"urgent", "waiting", "quick", "phone", "computer", "finance", "family", "shopping", "someday",
+	}
+)
+
+// seedTodos inserts n generated todos spread over the last days days, and
+// over tenants when given. Roughly a third of them are marked completed;
+// most have a list, some labels, a priority or a due date.
+func seedTodos(ctx context.Context, n, days int, tenants []string) error {
+	if len(tenants) == 0 {
+		tenants = []string{tenantFrom(ctx)}
+	}
+	now := time.Now()
+	span := time.Duration(days) * 24 * time.Hour
+
+	batches := make(map[string][]todoModel, len(tenants))
+	inserted := 0
+	flush := func(tenant string) error {
+		batch := batches[tenant]
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := repo.CreateMany(withTenant(ctx, tenant), batch); err != nil {
+			return fmt.Errorf("inserted %d of %d todos: %w", inserted, n, err)
+		}
+		inserted += len(batch)
+		batches[tenant] = batch[:0]
+		return nil
+	}
+
+	for i := 0; i < n; i++ {
+		createdAt := now
+		if span > 0 {
+			createdAt = now.Add(-rand.N(span))
+		}
+		tenant := tenants[rand.IntN(len(tenants))]
+		batches[tenant] = append(batches[tenant], seedTodo(createdAt))
+		if len(batches[tenant]) == seedBatchSize {
+			if err := flush(tenant); err != nil {
+				return err
+			}
+		}
+	}
+	for _, tenant := range tenants {
+		if err := flush(tenant); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("seeded todos", "count", inserted, "tenants", len(tenants))
+	return nil
+}
+
+// seedTodo generates a todo created at createdAt. Due dates fall on the
+// hour within three weeks of creation, so older open todos are overdue.
+func seedTodo(createdAt time.Time) todoModel {
+	tm := todoModel{
+		ID:        primitive.NewObjectIDFromTimestamp(createdAt),
+		Title:     seedTitle(),
+		Completed: rand.IntN(3) == 0,
+		CreatedAt: createdAt,
+		List:      seedLists[rand.IntN(len(seedLists))],
+	}
+	for _, i := range rand.Perm(len(seedLabels))[:rand.IntN(4)] {
+		tm.Labels = append(tm.Labels, seedLabels[i])
+	}
+	if rand.IntN(2) == 0 {
+		tm.Priority = 1 + rand.IntN(maxPriority)
+	}
+	if rand.IntN(5) < 2 {
+		due := createdAt.Add(time.Hour + rand.N(21*24*time.Hour)).Truncate(time.Hour)
+		tm.DueAt = &due
+	}
+	return tm
+}
+
+// parseSeedTenants reads the comma-separated tenants of -seed-tenant.
+func parseSeedTenants(v string) ([]string, error) {
+	var tenants []string
+	for _, t := range strings.Split(v, ",") {
+		if t = strings.TrimSpace(t); t == "" {
+			continue
+		}
+		if !validTenant.MatchString(t) {
+			return nil, fmt.Errorf("invalid tenant %q", t)
+		}
+		if !slices.Contains(tenants, t) {
+			tenants = append(tenants, t)
+		}
+	}
+	return tenants, nil
+}
+
+func seedTitle() string {
+	return seedVerbs[rand.IntN(len(seedVerbs))] + " " +
+		seedObjects[rand.IntN(len(seedObjects))] +
+		seedSuffixes[rand.IntN(len(seedSuffixes))]
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// seedStore records the todos created in each tenant.
+type seedStore struct {
+	todoStore
+	created map[string][]todoModel
+}
+
+func (s seedStore) CreateMany(ctx context.Context, tms []todoModel) error {
+	tenant := tenantFrom(ctx)
+	s.created[tenant] = append(s.created[tenant], tms...)
+	return nil
+}
+
+func TestSeedTodos(t *testing.T) {
+	store := seedStore{created: map[string][]todoModel{}}
+	prevRepo := repo
+	repo = store
+	t.Cleanup(func() { repo = prevRepo })
+
+	const n = 2*seedBatchSize + 7
+	if err := seedTodos(context.Background(), n, 30, []string{"acme", "globex"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.created) != 2 || len(store.created["acme"])+len(store.created["globex"]) != n {
+		t.Fatalf("created %d in acme and %d in globex, want %d in all", len(store.created["acme"]), len(store.created["globex"]), n)
+	}
+
+	var withList, withLabels, withPriority, withDue int
+	earliest := time.Now().Add(-30 * 24 * time.Hour)
+	for _, tm := range append(store.created["acme"], store.created["globex"]...) {
+		if msg := validateDetails(tm.List, tm.Labels, tm.Priority); msg != "" || validateTitle(tm.Title) != "" {
+			t.Fatalf("invalid todo %+v: %s", tm, msg)
+		}
+		if tm.CreatedAt.Before(earliest) || tm.CreatedAt.After(time.Now()) || tm.ID.Timestamp().Unix() != tm.CreatedAt.Unix() {
+			t.Fatalf("todo %s created at %s", tm.ID.Hex(), tm.CreatedAt)
+		}
+		if tm.DueAt != nil && !tm.DueAt.After(tm.CreatedAt.Truncate(time.Hour)) {
+			t.Fatalf("todo created at %s due at %s", tm.CreatedAt, tm.DueAt)
+		}
+		if tm.List != "" {
+			withList++
+		}
+		if len(tm.Labels) > 0 {
+			withLabels++
+		}
+		if tm.Priority > 0 {
+			withPriority++
+		}
+		if tm.DueAt != nil {
+			withDue++
+		}
+	}
+	for name, count := range map[string]int{"a list": withList, "labels": withLabels, "a priority": withPriority, "a due date": withDue} {
+		if count == 0 || count == n {
+			t.Errorf("%d of %d todos have %s", count, n, name)
+		}
+	}
+}
+
+func TestParseSeedTenants(t *testing.T) {
+	tenants, err := parseSeedTenants(" acme, globex,,acme")
+	if err != nil || len(tenants) != 2 || tenants[0] != "acme" || tenants[1] != "globex" {
+		t.Errorf("tenants %q, %v", tenants, err)
+	}
+	if _, err := parseSeedTenants("acme,Not Valid"); err == nil {
+		t.Error("an invalid tenant was accepted")
+	}
+}
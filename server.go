@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// HTTP server settings, see loadServerConfig.
+var (
+	listenAddr      = ":9000"
+	readTimeout     = 60 * time.Second
+	writeTimeout    = 60 * time.Second
+	idleTimeout     = 60 * time.Second
+	shutdownTimeout = 5 * time.Second
+	// drainTimeout bounds flushing the background work once the server
+	// stopped taking requests.
+	drainTimeout = 10 * time.Second
+	// requestTimeout bounds the store calls of a request.
+	requestTimeout = 5 * time.Second
+	// readHeaderTimeout bounds reading the headers of a request, so a
+	// client trickling them in does not hold a connection for the whole
+	// read timeout.
+	readHeaderTimeout = 10 * time.Second
+	// maxHeaderBytes bounds the headers of a request, well below the 1 MB
+	// of net/http.
+	maxHeaderBytes = 64 << 10
+	// keepAlives lets clients send several requests over a connection.
+	keepAlives = true
+	// tcpKeepAlive is the period of the TCP keep-alive probes of accepted
+	// connections, negative when they are off.
+	tcpKeepAlive = 15 * time.Second
+)
+
+// loadServerConfig reads the listen address from TODO_ADDR, or the port
+// alone from TODO_PORT, the timeouts from TODO_READ_HEADER_TIMEOUT,
+// TODO_READ_TIMEOUT, TODO_WRITE_TIMEOUT, TODO_IDLE_TIMEOUT,
+// TODO_SHUTDOWN_TIMEOUT, TODO_DRAIN_TIMEOUT and TODO_REQUEST_TIMEOUT, and
+// the connection settings from TODO_MAX_HEADER_BYTES, TODO_KEEP_ALIVES and
+// TODO_TCP_KEEPALIVE. Unset, each keeps its default.
+func loadServerConfig() error {
+	if addr := os.Getenv("TODO_ADDR"); addr != "" {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("invalid TODO_ADDR %q: %w", addr, err)
+		}
+		listenAddr = addr
+	} else if port := os.Getenv("TODO_PORT"); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid TODO_PORT %q", port)
+		}
+		listenAddr = ":" + port
+	}
+
+	for _, t := range []struct {
+		env string
+		d   *time.Duration
+	}{
+		{"TODO_READ_HEADER_TIMEOUT", &readHeaderTimeout},
+		{"TODO_READ_TIMEOUT", &readTimeout},
+		{"TODO_WRITE_TIMEOUT", &writeTimeout},
+		{"TODO_IDLE_TIMEOUT", &idleTimeout},
+		{"TODO_SHUTDOWN_TIMEOUT", &shutdownTimeout},
+		{"TODO_DRAIN_TIMEOUT", &drainTimeout},
+		{"TODO_REQUEST_TIMEOUT", &requestTimeout},
+	} {
+		v := os.Getenv(t.env)
+		if v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s %q, must be a positive duration like 30s", t.env, v)
+		}
+		*t.d = d
+	}
+	if requestTimeout > writeTimeout {
+		return fmt.Errorf("TODO_REQUEST_TIMEOUT must not exceed TODO_WRITE_TIMEOUT")
+	}
+	if readHeaderTimeout > readTimeout {
+		return fmt.Errorf("TODO_READ_HEADER_TIMEOUT must not exceed TODO_READ_TIMEOUT")
+	}
+
+	if v := os.Getenv("TODO_MAX_HEADER_BYTES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 4<<10 || n > 1<<20 {
+			return fmt.Errorf("invalid TODO_MAX_HEADER_BYTES %q, must be between 4096 and 1048576", v)
+		}
+		maxHeaderBytes = n
+	}
+	if v := os.Getenv("TODO_KEEP_ALIVES"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid TODO_KEEP_ALIVES %q, must be true or false", v)
+		}
+		keepAlives = b
+	}
+	if v := os.Getenv("TODO_TCP_KEEPALIVE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid TODO_TCP_KEEPALIVE %q, must be a duration like 30s, or 0 to turn probes off", v)
+		}
+		if tcpKeepAlive = d; d == 0 {
+			tcpKeepAlive = -1
+		}
+	}
+	return nil
+}
+
+// limitSlowClients bounds how long srv waits for the headers of a request
+// and how large they may be.
+func limitSlowClients(srv *http.Server) *http.Server {
+	srv.ReadHeaderTimeout = readHeaderTimeout
+	srv.MaxHeaderBytes = maxHeaderBytes
+	return srv
+}
@@ -0,0 +1,171 @@
+package main
+
+//go:generate sh -c "TODO_TENANT_MODE=header TODO_SHARD_KEY=hashed_tenant go run . -shard-report > SHARDING.md"
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Shard key strategies for the todo collection. Both lead with tenant_id,
+// which scoped adds to every filter, so requests of one tenant are routed
+// to the shards owning it instead of being broadcast.
+var shardKeys = map[string]bson.D{
+	// hashed_tenant spreads tenants evenly over the shards.
+	"hashed_tenant": {{Key: "tenant_id", Value: "hashed"}},
+	// tenant keeps each tenant in contiguous chunks, which allows splitting
+	// large tenants by _id.
+	"tenant": {{Key: "tenant_id", Value: 1}, {Key: "_id", Value: 1}},
+}
+
+// shardKey is the TODO_SHARD_KEY strategy, "" when the collection is not
+// sharded by this server.
+var shardKey string
+
+// loadShardConfig reads TODO_SHARD_KEY. Sharding requires tenancy, without
+// it queries carry no shard key and every one of them is scatter-gather.
+func loadShardConfig() error {
+	shardKey = os.Getenv("TODO_SHARD_KEY")
+	if shardKey == "" {
+		return nil
+	}
+	if _, ok := shardKeys[shardKey]; !ok {
+		return fmt.Errorf("invalid shard key %q", shardKey)
+	}
+	if !usingMongo() {
+		return fmt.Errorf("TODO_SHARD_KEY requires the mongo store")
+	}
+	if tenantMode == tenantModeOff {
+		return fmt.Errorf("TODO_SHARD_KEY requires TODO_TENANT_MODE")
+	}
+	return nil
+}
+
+// shardCollection creates the shard key index and shards the todo
+// collection. Sharding an already sharded collection with the same key is
+// a no-op, so this runs on every start.
+func shardCollection(ctx context.Context) error {
+	if shardKey == "" {
+		return nil
+	}
+	keys := shardKeys[shardKey]
+	if _, err := writeCollection().Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys}); err != nil {
+		return err
+	}
+	return client.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "shardCollection", Value: dbName + "." + collectionName},
+		{Key: "key", Value: keys},
+	}).Err()
+}
+
+// queryShape describes the filter of one query against the todo
+// collection, named after the function sending it. todoQueries is the
+// source of SHARDING.md, and TestTodoQueries fails when it misses a query
+// of the source or lists one the source no longer sends.
+type queryShape struct {
+	Operation string
+	Command   string
+	// Filter lists the equality fields of the filter. Tenant scoped
+	// queries get tenant_id from scoped when tenancy is enabled.
+	Filter []string
+	Scoped bool
+}
+
+var todoQueries = []queryShape{
+	{"listTodos", "find", nil, true},
+	{"Get", "find", []string{"_id"}, true},
+	{"eachTodo", "find", nil, true},
+	{"Create", "insert", nil, false},
+	{"CreateMany", "insert", nil, false},
+	{"insertedTodos", "find", []string{"_id"}, true},
+	{"Update", "findAndModify", []string{"_id"}, true},
+	{"Upsert", "findAndModify", []string{"_id"}, true},
+	{"Delete", "findAndModify", []string{"_id"}, true},
+	{"Stats", "aggregate", nil, true},
+	{"Move", "find", []string{"_id", "list"}, true},
+	{"Move", "find", []string{"list"}, true},
+	{"Move", "update", []string{"_id"}, true},
+	{"AddAttachment", "update", []string{"_id"}, true},
+	{"Attachment", "find", []string{"_id"}, true},
+	{"RemoveAttachment", "findAndModify", []string{"_id", "attachments.id"}, true},
+	{"archiveTodos", "find", []string{"completed"}, false},
+	{"archiveTodos", "delete", []string{"_id", "completed"}, false},
+	{"repeatTodos", "find", []string{"completed"}, false},
+	{"repeatTodo", "update", []string{"_id"}, true},
+	{"endRecurrence", "update", []string{"_id"}, true},
+	{"syncSearch", "aggregate", nil, false},
+	{"backfillSearch", "find", nil, false},
+	{"notifyOverdue", "find", []string{"completed"}, false},
+	{"notifyTeamsOverdue", "find", []string{"completed"}, true},
+	{"notifyPushReminders", "find", []string{"completed"}, true},
+	{"countTodos", "aggregate", nil, false},
+}
+
+// targeted reports whether mongos can route q to the shards owning the
+// key instead of broadcasting it. Inserts are always routed by the shard
+// key of the document.
+func (q queryShape) targeted(key bson.D) bool {
+	if q.Command == "insert" {
+		return true
+	}
+	fields := q.fields()
+	for _, k := range key {
+		if !slices.Contains(fields, k.Key) {
+			return false
+		}
+	}
+	return true
+}
+
+func (q queryShape) fields() []string {
+	fields := q.Filter
+	if q.Scoped && tenantMode != tenantModeOff {
+		fields = append(fields[:len(fields):len(fields)], "tenant_id")
+	}
+	return fields
+}
+
+// writeShardReport documents which todo queries are targeted and which are
+// scatter-gather with the configured shard key. The _id of the "tenant"
+// strategy is a range suffix, a tenant_id equality is enough to target it.
+func writeShardReport(w io.Writer) {
+	key := shardKeys[shardKey]
+	names := make([]string, len(key))
+	for i, k := range key {
+		names[i] = fmt.Sprintf("%s: %v", k.Key, k.Value)
+	}
+	if shardKey == "tenant" {
+		key = key[:1]
+	}
+
+	fmt.Fprintln(w, "# Sharding")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Generated by `go generate`, do not edit.")
+	fmt.Fprintln(w)
+	if shardKey == "" {
+		fmt.Fprintln(w, "The todo collection is not sharded.")
+		return
+	}
+	fmt.Fprintf(w, "Shard key strategy `%s`: `{%s}`.\n\n", shardKey, strings.Join(names, ", "))
+	fmt.Fprintln(w, "| Operation | Command | Filter | Routing |")
+	fmt.Fprintln(w, "| --- | --- | --- | --- |")
+	for _, q := range todoQueries {
+		filter, routing := "-", "scatter-gather"
+		if fields := q.fields(); len(fields) > 0 {
+			filter = "`" + strings.Join(fields, "`, `") + "`"
+		}
+		if q.targeted(key) {
+			routing = "targeted"
+		}
+		fmt.Fprintf(w, "| %s | %s | %s | %s |\n", q.Operation, q.Command, filter, routing)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Collections other than the todo collection are not sharded.")
+}
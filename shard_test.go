@@ -0,0 +1,230 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// queryCommands are the commands of the collection methods sending a
+// query, by method name.
+var queryCommands = map[string]string{
+	"Find":              "find",
+	"FindOne":           "find",
+	"FindOneAndUpdate":  "findAndModify",
+	"FindOneAndDelete":  "findAndModify",
+	"FindOneAndReplace": "findAndModify",
+	"InsertOne":         "insert",
+	"InsertMany":        "insert",
+	"UpdateOne":         "update",
+	"UpdateMany":        "update",
+	"ReplaceOne":        "update",
+	"BulkWrite":         "update",
+	"DeleteOne":         "delete",
+	"DeleteMany":        "delete",
+	"CountDocuments":    "aggregate",
+	"Aggregate":         "aggregate",
+	"Watch":             "aggregate",
+}
+
+// foundQuery is a query on the todo collection found in the source.
+type foundQuery struct {
+	queryShape
+	// Known is false when the filter is not a literal, so only the
+	// operation and command can be compared.
+	Known bool
+	Pos   token.Position
+}
+
+// todoCollectionQueries returns the queries the package sends to the todo
+// collection: calls of the query methods on readCollection(),
+// writeCollection(), a variable set to one of them, or a *mongo.Collection
+// parameter. A query is named after the function it is in.
+func todoCollectionQueries(t *testing.T) []foundQuery {
+	t.Helper()
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	var found []foundQuery
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil {
+				continue
+			}
+			colls := collectionVars(fn)
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				sel, ok := call.Fun.(*ast.SelectorExpr)
+				if !ok {
+					return true
+				}
+				command, ok := queryCommands[sel.Sel.Name]
+				if !ok || !isTodoCollection(sel.X, colls) {
+					return true
+				}
+				q := foundQuery{queryShape: queryShape{Operation: fn.Name.Name, Command: command}, Pos: fset.Position(call.Pos())}
+				switch command {
+				case "insert":
+					q.Known = true
+				case "find", "findAndModify", "update", "delete":
+					if sel.Sel.Name != "BulkWrite" && len(call.Args) > 1 {
+						q.Filter, q.Scoped, q.Known = literalFilter(call.Args[1])
+					}
+				}
+				found = append(found, q)
+				return true
+			})
+		}
+	}
+	return found
+}
+
+// collectionVars returns the names of fn standing for the todo
+// collection.
+func collectionVars(fn *ast.FuncDecl) []string {
+	var names []string
+	for _, p := range fn.Type.Params.List {
+		if star, ok := p.Type.(*ast.StarExpr); ok && exprString(star.X) == "mongo.Collection" {
+			for _, n := range p.Names {
+				names = append(names, n.Name)
+			}
+		}
+	}
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		if as, ok := n.(*ast.AssignStmt); ok && len(as.Lhs) == len(as.Rhs) {
+			for i, rhs := range as.Rhs {
+				if id, ok := as.Lhs[i].(*ast.Ident); ok && isTodoCollection(rhs, nil) {
+					names = append(names, id.Name)
+				}
+			}
+		}
+		return true
+	})
+	return names
+}
+
+func isTodoCollection(x ast.Expr, vars []string) bool {
+	switch x := x.(type) {
+	case *ast.CallExpr:
+		f := exprString(x.Fun)
+		return f == "readCollection" || f == "writeCollection"
+	case *ast.Ident:
+		return slices.Contains(vars, x.Name)
+	}
+	return false
+}
+
+// literalFilter returns the equality fields of a filter written as
+// bson.M{...}, possibly wrapped in scoped, or false when it is built
+// elsewhere.
+func literalFilter(x ast.Expr) (fields []string, isScoped, ok bool) {
+	if call, isCall := x.(*ast.CallExpr); isCall && exprString(call.Fun) == "scoped" && len(call.Args) == 2 {
+		x, isScoped = call.Args[1], true
+	}
+	lit, isLit := x.(*ast.CompositeLit)
+	if !isLit || exprString(lit.Type) != "bson.M" {
+		return nil, false, false
+	}
+	for _, el := range lit.Elts {
+		kv, isKV := el.(*ast.KeyValueExpr)
+		if !isKV {
+			return nil, false, false
+		}
+		key, isKey := kv.Key.(*ast.BasicLit)
+		if !isKey || key.Kind != token.STRING {
+			return nil, false, false
+		}
+		name, err := strconv.Unquote(key.Value)
+		if err != nil {
+			return nil, false, false
+		}
+		if equality(kv.Value) {
+			fields = append(fields, name)
+		}
+	}
+	return fields, isScoped, true
+}
+
+// equality reports whether the filter value x selects by equality, which
+// mongos can route on: a value, or $eq and $in only.
+func equality(x ast.Expr) bool {
+	lit, ok := x.(*ast.CompositeLit)
+	if !ok || exprString(lit.Type) != "bson.M" {
+		return true
+	}
+	for _, el := range lit.Elts {
+		kv, ok := el.(*ast.KeyValueExpr)
+		if !ok {
+			return false
+		}
+		if key, ok := kv.Key.(*ast.BasicLit); !ok || (key.Value != `"$eq"` && key.Value != `"$in"`) {
+			return false
+		}
+	}
+	return true
+}
+
+func exprString(x ast.Expr) string {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return x.Name
+	case *ast.SelectorExpr:
+		return exprString(x.X) + "." + x.Sel.Name
+	}
+	return ""
+}
+
+// matches reports whether the documented query s describes q.
+func (s queryShape) matches(q foundQuery) bool {
+	if s.Operation != q.Operation || s.Command != q.Command {
+		return false
+	}
+	if !q.Known {
+		return true
+	}
+	return s.Scoped == q.Scoped && sameFields(s.Filter, q.Filter)
+}
+
+func sameFields(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+// TestTodoQueries checks todoQueries, the source of SHARDING.md, against
+// the queries of the source, both ways.
+func TestTodoQueries(t *testing.T) {
+	found := todoCollectionQueries(t)
+	if len(found) == 0 {
+		t.Fatal("no query of the todo collection found")
+	}
+	for _, q := range found {
+		if !slices.ContainsFunc(todoQueries, func(s queryShape) bool { return s.matches(q) }) {
+			t.Errorf("%s: %s %s on %v (scoped %v) is missing from todoQueries", q.Pos, q.Operation, q.Command, q.Filter, q.Scoped)
+		}
+	}
+	for _, s := range todoQueries {
+		if !slices.ContainsFunc(found, func(q foundQuery) bool { return s.matches(q) }) {
+			t.Errorf("todoQueries lists %s %s on %v (scoped %v), which the source does not send", s.Operation, s.Command, s.Filter, s.Scoped)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+var (
+	// maxInFlight is how many requests are served at once before the
+	// next ones are shed, see loadSheddingSettings. Zero means no limit.
+	maxInFlight atomic.Int64
+	// inFlight counts the requests holding a slot.
+	inFlight atomic.Int64
+
+	shedRequests atomic.Int64
+)
+
+// loadSheddingSettings reads TODO_MAX_IN_FLIGHT, how many requests are
+// served at once before the next ones are answered 503 right away, rather
+// than queueing for the MongoDB connection pool.
+func loadSheddingSettings() (func(), error) {
+	var n int64
+	if v := os.Getenv("TODO_MAX_IN_FLIGHT"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid TODO_MAX_IN_FLIGHT %q, must be a number of requests", v)
+		}
+		n = parsed
+	}
+	return func() { maxInFlight.Store(n) }, nil
+}
+
+// loadShedMiddleware answers 503 with Retry-After once maxInFlight
+// requests are being served. Probes, metrics and the debug endpoints
+// always get through, and streams do not hold a slot as they stay open.
+func loadShedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := maxInFlight.Load()
+		if limit == 0 || shedExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if inFlight.Add(1) > limit {
+			inFlight.Add(-1)
+			shedRequests.Add(1)
+			w.Header().Set("Retry-After", "1")
+			rnd.JSON(w, http.StatusServiceUnavailable, renderer.M{"message": "the server is overloaded, try again shortly", "error": "service unavailable"})
+			return
+		}
+		defer inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func shedExempt(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/healthz", "/readyz", "/metrics", "/version":
+		return true
+	}
+	return slices.ContainsFunc(streamingPrefixes, func(p string) bool {
+		return underPrefix(strings.TrimRight(r.URL.Path, "/"), p)
+	})
+}
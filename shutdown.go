@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// workers tracks the background goroutines so shutdown can wait for them.
+var workers sync.WaitGroup
+
+// startWorker runs run in the background until ctx is done.
+func startWorker(ctx context.Context, run func(context.Context)) {
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		run(ctx)
+	}()
+}
+
+// shutdown stops the server in order: it stops accepting connections and
+// waits for in-flight requests up to shutdownTimeout, stops the background
+// workers and flushes the queues they left up to drainTimeout, then
+// closes the write buffer and the MongoDB connection, which the earlier
+// steps still needed.
+//
+// The other listeners, such as the HTTPS redirect, are shut down along
+// with srv; nil ones are skipped.
+func shutdown(stopBackground context.CancelFunc, srv *http.Server, others ...*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	for _, o := range others {
+		if o != nil {
+			o.Shutdown(ctx)
+		}
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("requests still in flight after the shutdown timeout, closing", "timeout", shutdownTimeout, "error", err)
+		srv.Close()
+	}
+	cancel()
+	slog.Info("stopped serving requests")
+
+	ctx, cancel = context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	stopBackground()
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("background workers still running after the drain timeout", "timeout", drainTimeout)
+	}
+	drainQueues(ctx)
+
+	if writes != nil {
+		if err := writes.Close(); err != nil {
+			slog.Error("could not close the write buffer", "error", err)
+		}
+	}
+	if client != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := client.Disconnect(ctx); err != nil {
+			slog.Error("could not close MongoDB connection", "error", err)
+		} else {
+			slog.Info("closed MongoDB connection")
+		}
+	}
+}
+
+// drainQueues makes a last pass over the work requests queued since the
+// workers last ran: buffered writes, then outbox events, then the webhook
+// deliveries those produce. Whatever is left is picked up on the next
+// start.
+func drainQueues(ctx context.Context) {
+	if ctx.Err() != nil || !storeAvailable() {
+		return
+	}
+	if writes != nil && writes.Len() > 0 {
+		if n, err := replayWrites(ctx); n > 0 || err != nil {
+			slog.Info("replayed writes", "component", "write_buffer", "count", n, "remaining", writes.Len(), "error", err)
+		}
+	}
+	if outboxEnabled {
+		dispatchEvents(ctx)
+	}
+	if webhooksEnabled() {
+		drainDeliveries(ctx)
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		slog.Warn("queues not drained before the drain timeout, the rest is delivered on the next start", "timeout", drainTimeout)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	slackNotifiedCollectionName = "slack_notified"
+
+	slackTimeout         = 10 * time.Second
+	slackOverdueInterval = time.Minute
+	// slackOverdueWindow bounds how long after its due date a todo is
+	// still reported overdue, so enabling notifications does not post
+	// every todo that ever went overdue.
+	slackOverdueWindow = 24 * time.Hour
+	slackNotifiedTTL   = 90 * 24 * time.Hour
+	slackMaxSkew       = 5 * time.Minute
+	slackListLimit     = 20
+)
+
+var (
+	slackWebhookURL    string
+	slackSigningSecret string
+	slackTenant        string
+)
+
+// loadSlackConfig reads TODO_SLACK_WEBHOOK_URL, an incoming webhook that
+// receives notifications, and TODO_SLACK_SIGNING_SECRET, which enables the
+// /todo slash command at POST /slack/commands. Slash commands act on the
+// tenant TODO_SLACK_TENANT.
+func loadSlackConfig() error {
+	slackWebhookURL = os.Getenv("TODO_SLACK_WEBHOOK_URL")
+	slackSigningSecret = os.Getenv("TODO_SLACK_SIGNING_SECRET")
+	slackTenant = os.Getenv("TODO_SLACK_TENANT")
+	if slackWebhookURL != "" {
+		u, err := url.Parse(slackWebhookURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid TODO_SLACK_WEBHOOK_URL")
+		}
+		if !outboxEnabled {
+			return errors.New("TODO_SLACK_WEBHOOK_URL requires TODO_OUTBOX")
+		}
+	}
+	if slackTenant != "" && !validTenant.MatchString(slackTenant) {
+		return fmt.Errorf("invalid TODO_SLACK_TENANT %q", slackTenant)
+	}
+	return nil
+}
+
+func slackNotificationsEnabled() bool {
+	return slackWebhookURL != ""
+}
+
+func slackNotifiedCollection() *mongo.Collection {
+	return db.Collection(slackNotifiedCollectionName, writeCollectionOpts)
+}
+
+// ensureSlackIndexes expires notification records and indexes due dates
+// for the overdue scan.
+func ensureSlackIndexes(ctx context.Context) error {
+	if !slackNotificationsEnabled() {
+		return nil
+	}
+	_, err := slackNotifiedCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(slackNotifiedTTL.Seconds())),
+	})
+	if err != nil {
+		return err
+	}
+	return ensureDueIndex(ctx)
+}
+
+// ensureDueIndex indexes the due dates of open todos, for the scans
+// notifying overdue todos.
+func ensureDueIndex(ctx context.Context) error {
+	_, err := writeCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "due_at", Value: 1}},
+		Options: options.Index().SetPartialFilterExpression(bson.M{"completed": false}),
+	})
+	return err
+}
+
+// slackOnce records that the notification key was posted. It reports false
+// when it already was, which makes redelivered events and overlapping
+// overdue scans post once.
+func slackOnce(ctx context.Context, key string) (bool, error) {
+	_, err := slackNotifiedCollection().InsertOne(ctx, bson.M{"_id": key, "at": time.Now()})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func postSlack(ctx context.Context, text string) error {
+	ctx, cancel := context.WithTimeout(ctx, slackTimeout)
+	defer cancel()
+
+	body, _ := json.Marshal(map[string]string{"text": text})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, slackWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// slackEscape escapes the characters Slack treats as markup.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+func slackTodo(tenant, title string) string {
+	if tenant != "" {
+		return "*" + slackEscape(title) + "* (" + tenant + ")"
+	}
+	return "*" + slackEscape(title) + "*"
+}
+
+// slackPublisher posts created and completed todos. Reopening a todo
+// allows its next completion to be posted again.
+type slackPublisher struct{}
+
+func (slackPublisher) Name() string { return "slack" }
+
+func (slackPublisher) Publish(ctx context.Context, ev todoEvent) error {
+	var key, text string
+	switch {
+	case ev.Type == eventTodoCreated:
+		key, text = "created:"+ev.Todo.ID, "New todo "+slackTodo(ev.TenantID, ev.Todo.Title)
+	case ev.Type == eventTodoUpdated && ev.Todo.Completed == "true":
+		key, text = "completed:"+ev.Todo.ID, ":white_check_mark: Completed "+slackTodo(ev.TenantID, ev.Todo.Title)
+	case ev.Type == eventTodoUpdated:
+		_, err := slackNotifiedCollection().DeleteOne(ctx, bson.M{"_id": "completed:" + ev.Todo.ID})
+		return err
+	default:
+		return nil
+	}
+
+	first, err := slackOnce(ctx, key)
+	if err != nil || !first {
+		return err
+	}
+	if err := postSlack(ctx, text); err != nil {
+		slackNotifiedCollection().DeleteOne(ctx, bson.M{"_id": key})
+		return err
+	}
+	return nil
+}
+
+// notifyOverdue posts open todos as they become overdue. It is scheduled
+// every slackOverdueInterval.
+func notifyOverdue(ctx context.Context) error {
+	if !dbConnected.Load() {
+		return nil
+	}
+	now := time.Now()
+	cursor, err := readCollection().Find(ctx, bson.M{
+		"completed": false,
+		"due_at":    bson.M{"$lte": now, "$gt": now.Add(-slackOverdueWindow)},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var tm todoModel
+		if err := cursor.Decode(&tm); err != nil {
+			return err
+		}
+		key := "overdue:" + tm.ID.Hex() + ":" + strconv.FormatInt(tm.DueAt.Unix(), 10)
+		first, err := slackOnce(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !first {
+			continue
+		}
+		title, err := decryptField(tm.ID, tm.Title)
+		if err == nil {
+			err = postSlack(ctx, ":alarm_clock: Overdue "+slackTodo(tm.TenantID, title))
+		}
+		if err != nil {
+			slackNotifiedCollection().DeleteOne(ctx, bson.M{"_id": key})
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// slackCommand serves the /todo slash command:
+//
+//	/todo add Buy milk   creates a todo
+//	/todo list           lists open todos
+//
+// Requests are verified with the signing secret of the Slack app.
+func slackCommand(w http.ResponseWriter, r *http.Request) {
+	if slackSigningSecret == "" {
+		rnd.JSON(w, http.StatusNotImplemented, renderer.M{"message": "Slack commands are not configured", "error": "not implemented"})
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+	if err != nil {
+		rnd.JSON(w, http.StatusRequestEntityTooLarge, renderer.M{"message": "request is too large", "error": err.Error()})
+		return
+	}
+	if !validSlackSignature(r.Header, body) {
+		rnd.JSON(w, http.StatusUnauthorized, renderer.M{"message": "invalid signature", "error": "unauthorized"})
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid request", "error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(withTenant(r.Context(), slackTenant), 2500*time.Millisecond)
+	defer cancel()
+
+	verb, arg, _ := strings.Cut(strings.TrimSpace(form.Get("text")), " ")
+	arg = strings.TrimSpace(arg)
+	var reply string
+	switch strings.ToLower(verb) {
+	case "add":
+		reply = slackAdd(ctx, arg)
+	case "list":
+		reply = slackList(ctx)
+	default:
+		reply = "Usage: `/todo add <title>` or `/todo list`"
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"response_type": "ephemeral", "text": reply})
+}
+
+func slackAdd(ctx context.Context, title string) string {
+	switch {
+	case title == "":
+		return "Usage: `/todo add <title>`"
+	case len([]rune(title)) > maxTitleLength:
+		return "That title is too long."
+	}
+	tm := todoModel{ID: primitive.NewObjectID(), Title: title, CreatedAt: time.Now()}
+	if err := repo.Create(ctx, tm); err != nil {
+		slog.Error("could not create todo", "component", "slack", "error", err)
+		return "Sorry, the todo could not be created."
+	}
+	return "Added " + slackTodo("", title)
+}
+
+func slackList(ctx context.Context) string {
+	todos, err := repo.List(ctx)
+	if err != nil {
+		slog.Error("could not list todos", "component", "slack", "error", err)
+		return "Sorry, the todos could not be listed."
+	}
+	var b strings.Builder
+	n := 0
+	for _, tm := range todos {
+		if tm.Completed {
+			continue
+		}
+		if n++; n > slackListLimit {
+			fmt.Fprintf(&b, "…and more\n")
+			break
+		}
+		fmt.Fprintf(&b, "• %s\n", slackEscape(tm.Title))
+	}
+	if n == 0 {
+		return "Nothing to do."
+	}
+	return b.String()
+}
+
+// validSlackSignature checks X-Slack-Signature, the hex HMAC-SHA256 of
+// "v0:<timestamp>:<body>", and rejects stale timestamps against replays.
+func validSlackSignature(h http.Header, body []byte) bool {
+	ts, err := strconv.ParseInt(h.Get("X-Slack-Request-Timestamp"), 10, 64)
+	if err != nil {
+		return false
+	}
+	if d := time.Since(time.Unix(ts, 0)); d > slackMaxSkew || d < -slackMaxSkew {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(slackSigningSecret))
+	fmt.Fprintf(mac, "v0:%d:", ts)
+	mac.Write(body)
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(h.Get("X-Slack-Signature")), []byte(want))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// signSlack signs body as Slack does at ts with secret.
+func signSlack(h http.Header, secret string, ts time.Time, body string) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%d:%s", ts.Unix(), body)
+	h.Set("X-Slack-Request-Timestamp", strconv.FormatInt(ts.Unix(), 10))
+	h.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+func TestValidSlackSignature(t *testing.T) {
+	prev := slackSigningSecret
+	slackSigningSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+	t.Cleanup(func() { slackSigningSecret = prev })
+
+	const body = "command=%2Ftodo&text=list"
+	now := time.Now()
+	tests := []struct {
+		name   string
+		secret string
+		at     time.Time
+		body   string
+		valid  bool
+	}{
+		{"signed", slackSigningSecret, now, body, true},
+		{"within skew", slackSigningSecret, now.Add(-slackMaxSkew + time.Minute), body, true},
+		{"other secret", "not-the-secret", now, body, false},
+		{"tampered body", slackSigningSecret, now, "command=%2Ftodo&text=add+x", false},
+		{"stale", slackSigningSecret, now.Add(-slackMaxSkew - time.Minute), body, false},
+		{"future", slackSigningSecret, now.Add(slackMaxSkew + time.Minute), body, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			signSlack(h, tt.secret, tt.at, tt.body)
+			if got := validSlackSignature(h, []byte(body)); got != tt.valid {
+				t.Errorf("validSlackSignature = %v, want %v", got, tt.valid)
+			}
+		})
+	}
+
+	if validSlackSignature(http.Header{"X-Slack-Signature": {"v0=00"}}, []byte(body)) {
+		t.Error("a request without a timestamp was accepted")
+	}
+}
+
+func TestSlackCommand(t *testing.T) {
+	prevSecret, prevRnd := slackSigningSecret, rnd
+	rnd = renderer.New()
+	t.Cleanup(func() { slackSigningSecret, rnd = prevSecret, prevRnd })
+
+	const body = "command=%2Ftodo&text=help"
+	post := func(secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/slack/commands", strings.NewReader(body))
+		signSlack(req.Header, secret, time.Now(), body)
+		w := httptest.NewRecorder()
+		slackCommand(w, req)
+		return w
+	}
+
+	slackSigningSecret = ""
+	if w := post("secret"); w.Code != http.StatusNotImplemented {
+		t.Errorf("unconfigured: got %d, want 501", w.Code)
+	}
+	slackSigningSecret = "secret"
+	if w := post("other"); w.Code != http.StatusUnauthorized {
+		t.Errorf("bad signature: got %d, want 401", w.Code)
+	}
+	if w := post("secret"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Usage") {
+		t.Errorf("signed: got %d %s, want the usage", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+var (
+	// slowQueryThreshold is how long a MongoDB command runs before it is
+	// logged and counted as slow, see loadSlowQuerySettings. Zero turns
+	// this off.
+	slowQueryThreshold atomic.Int64
+	// slowQueryCollections holds the collection of the MongoDB commands in
+	// flight by request ID, as only their start event names it.
+	slowQueryCollections sync.Map
+
+	slowQueries = newCounterVec("todo_mongo_slow_commands_total",
+		"MongoDB commands slower than TODO_SLOW_QUERY_THRESHOLD by command and collection.", "command", "collection")
+)
+
+// loadSlowQuerySettings reads TODO_SLOW_QUERY_THRESHOLD, how long a
+// MongoDB command may run before it is logged, 100ms like the MongoDB
+// profiler by default.
+func loadSlowQuerySettings() (func(), error) {
+	threshold := 100 * time.Millisecond
+	if v := os.Getenv("TODO_SLOW_QUERY_THRESHOLD"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid TODO_SLOW_QUERY_THRESHOLD %q, must be a duration like 250ms", v)
+		}
+		threshold = d
+	}
+	return func() { slowQueryThreshold.Store(int64(threshold)) }, nil
+}
+
+func slowQueryStarted(e *event.CommandStartedEvent) {
+	if slowQueryThreshold.Load() == 0 {
+		return
+	}
+	slowQueryCollections.Store(e.RequestID, commandCollection(e.Command))
+}
+
+// slowQueryFinished logs and counts the command of e when it ran longer
+// than the threshold, with the ID of the request it served.
+func slowQueryFinished(ctx context.Context, e event.CommandFinishedEvent, outcome string) {
+	v, _ := slowQueryCollections.LoadAndDelete(e.RequestID)
+	threshold := time.Duration(slowQueryThreshold.Load())
+	if threshold == 0 || e.Duration < threshold {
+		return
+	}
+	collection, _ := v.(string)
+	slowQueries.inc(e.CommandName, collection)
+	attrs := []any{"component", "mongo", "command", e.CommandName, "collection", collection,
+		"database", e.DatabaseName, "duration", e.Duration, "outcome", outcome}
+	if id := requestID(ctx); id != "" {
+		attrs = append(attrs, "request_id", id)
+	}
+	slog.Warn("slow MongoDB command", attrs...)
+}
+
+// commandCollection returns the collection a command runs on: the value
+// of its first element for most commands, the collection field for
+// getMore. Commands on no collection, and those the driver redacts, have
+// none.
+func commandCollection(cmd bson.Raw) string {
+	elems, err := cmd.Elements()
+	if err != nil || len(elems) == 0 {
+		return ""
+	}
+	if c, ok := elems[0].Value().StringValueOK(); ok {
+		return c
+	}
+	c, _ := cmd.Lookup("collection").StringValueOK()
+	return c
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+const (
+	sseHeartbeat  = 15 * time.Second
+	sseRetryDelay = 3 * time.Second
+)
+
+// streamEvents serves GET /todo/events as a Server-Sent Events stream of
+// the same events /ws pushes. Event IDs are "<epoch>-<seq>". A client
+// reconnecting with Last-Event-ID gets the events it missed, or a reset
+// event telling it to refetch when they are no longer kept.
+func streamEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// The stream outlives the server's write timeout.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "streaming not supported", "error": err.Error()})
+		return
+	}
+
+	var after uint64
+	resumed := false
+	if last := r.Header.Get("Last-Event-ID"); last != "" {
+		resumed = true
+		epoch, seq, ok := strings.Cut(last, "-")
+		if n, err := strconv.ParseUint(seq, 10, 64); ok && err == nil && epoch == changes.epoch {
+			after = n
+		}
+	}
+	events, missed, cancel := changes.subscribe(tenantFrom(r.Context()), after)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetryDelay.Milliseconds())
+	if missed || (resumed && after == 0) {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case c, ok := <-events:
+			if !ok {
+				// Fell behind, the client reconnects with Last-Event-ID.
+				return
+			}
+			data, err := json.Marshal(c.Event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %s-%d\nevent: %s\ndata: %s\n\n", changes.epoch, c.Seq, c.Event.Type, data)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,71 @@
+<!doctype html>
+<html lang="{{.Lang}}">
+  <head>
+    <title>{{.T "Admin"}}</title>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1, shrink-to-fit=no">
+    <meta http-equiv="refresh" content="30">
+    <link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/4.0.0-beta.2/css/bootstrap.min.css" integrity="sha384-PsH8R72JQ3SOdhVi3uxftmaW6Vc51MKb0q5P2rRUpPvrszuE4W1povHYgTpBfshb" crossorigin="anonymous">
+  </head>
+  <body>
+    <div class="container my-4">
+      <h1>{{.T "Admin"}}</h1>
+      <p class="text-muted">{{.T "As of %s UTC, refreshed every 30 seconds." (.At.Format "2006-01-02 15:04:05")}}</p>
+
+      {{range .Errors}}<div class="alert alert-danger">{{.}}</div>{{end}}
+
+      <h2 class="h4 mt-4">{{.T "Database"}}</h2>
+      <table class="table table-sm">
+        <tr><th>{{.T "Store"}}</th><td>{{.Store.Backend}}</td></tr>
+        <tr><th>{{.T "Status"}}</th><td>{{if .Store.Connected}}<span class="badge badge-success">{{.T "available"}}</span>{{else}}<span class="badge badge-danger">{{.T "unavailable"}}</span>{{end}}</td></tr>
+        {{if .Store.Ping}}<tr><th>{{.T "Ping"}}</th><td>{{.Store.Ping}}</td></tr>{{end}}
+        {{with .Store.Breaker}}<tr><th>{{$.T "Circuit breaker"}}</th><td>{{.}}</td></tr>{{end}}
+        {{if ge .Store.BufferedWrites 0}}<tr><th>{{.T "Buffered writes"}}</th><td>{{.Store.BufferedWrites}}</td></tr>{{end}}
+      </table>
+
+      <h2 class="h4 mt-4">{{.T "Todos"}}</h2>
+      <table class="table table-sm">
+        <thead>
+          <tr><th>{{.T "Tenant"}}</th><th class="text-right">{{.T "Open"}}</th><th class="text-right">{{.T "Overdue"}}</th><th class="text-right">{{.T "Done"}}</th></tr>
+        </thead>
+        <tbody>
+          <tr class="font-weight-bold"><td>{{.T "All"}}</td><td class="text-right">{{.Totals.Open}}</td><td class="text-right">{{.Totals.Overdue}}</td><td class="text-right">{{.Totals.Completed}}</td></tr>
+          {{range .Tenants}}
+          <tr><td>{{or .Tenant ($.T "(no tenant)")}}</td><td class="text-right">{{.Open}}</td><td class="text-right">{{.Overdue}}</td><td class="text-right">{{.Completed}}</td></tr>
+          {{end}}
+        </tbody>
+      </table>
+
+      <h2 class="h4 mt-4">{{.T "Recent activity"}}</h2>
+      <table class="table table-sm">
+        <thead>
+          <tr><th>{{.T "Time"}}</th><th>{{.T "Tenant"}}</th><th>{{.T "Event"}}</th><th>{{.T "Title"}}</th></tr>
+        </thead>
+        <tbody>
+          {{range .Activity}}
+          <tr><td class="text-nowrap">{{.OccurredAt.UTC.Format "2006-01-02 15:04:05"}}</td><td>{{.TenantID}}</td><td>{{.Type}}</td><td>{{.Todo.Title}}</td></tr>
+          {{else}}
+          <tr><td colspan="4" class="text-muted">{{.T "No changes since the server started."}}</td></tr>
+          {{end}}
+        </tbody>
+      </table>
+
+      {{with .Webhooks}}
+      <h2 class="h4 mt-4">{{$.T "Webhooks"}}</h2>
+      <p>{{$.T "%d webhooks, deliveries kept: %d pending, %d delivered, %d failed." .Webhooks .Pending .Delivered .Failed}}</p>
+      {{if .Failures}}
+      <table class="table table-sm">
+        <thead>
+          <tr><th>{{$.T "Time"}}</th><th>URL</th><th>{{$.T "Event"}}</th><th>{{$.T "Attempts"}}</th><th>{{$.T "Last error"}}</th></tr>
+        </thead>
+        <tbody>
+          {{range .Failures}}
+          <tr><td class="text-nowrap">{{.At.UTC.Format "2006-01-02 15:04:05"}}</td><td>{{.URL}}</td><td>{{.EventType}}</td><td>{{.Attempts}}</td><td>{{if .StatusCode}}{{.StatusCode}} {{end}}{{.Error}}</td></tr>
+          {{end}}
+        </tbody>
+      </table>
+      {{end}}
+      {{end}}
+    </div>
+  </body>
+</html>
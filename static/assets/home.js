@@ -0,0 +1,73 @@
+var Vue = new Vue({
+  el: '#root',
+  delimiters: ['@{', '}'],
+  data: {
+    showError: false,
+    enableEdit: false,
+    todo: {id: '', title: '', completed: false},
+    todos: []
+  },
+  mounted () {
+    this.$http.get('todo').then(response => {
+      this.todos = response.body.data;
+    });
+  },
+  methods: {
+    addTodo(){
+      if (this.todo.title == ''){
+        this.showError = true;
+      }else{
+        this.showError = false;
+        if(this.enableEdit){
+          this.$http.put('todo/'+this.todo.id, this.todo).then(response => {
+            if(response.status == 200){
+              this.todos[this.todo.todoIndex] = this.todo;
+            }
+          });
+          this.todo = {id: '', title: '', completed: false};
+          this.enableEdit = false;
+        }else{
+          this.$http.post('todo', {title: this.todo.title}).then(response => {
+            if(response.status == 201){
+              this.todos.push({id: response.body.todo_id, title: this.todo.title, completed: false});
+              this.todo = {id: '', title: '', completed: false};
+            }
+          });
+        }
+      }
+    },
+    checkForEnter(event){
+      if (event.key == "Enter") {
+        this.addTodo();
+      }
+    },
+    toggleTodo(todo, todoIndex){
+      var completedToggle;
+      if (todo.completed == true) {
+        completedToggle = false;
+      }else{
+        completedToggle = true;
+      }
+      this.$http.put('todo/'+todo.id, {id: todo.id, title: todo.title, completed: completedToggle}).then(response => {
+        if(response.status == 200){
+          this.todos[todoIndex].completed = completedToggle;
+        }
+      });
+    },
+    editTodo(todo, todoIndex){
+      this.enableEdit = true;
+      this.todo = todo;
+      this.todo.todoIndex = todoIndex;
+    },
+    deleteTodo(todo, todoIndex){
+      if(confirm("Are you sure ?")){
+        this.$http.delete('todo/'+todo.id).then(response => {
+          if(response.status == 200){
+            this.todos.splice(todoIndex, 1);
+            this.todo = {id: '', title: '', completed: false};
+          }
+        });
+      }
+    }
+  }
+});
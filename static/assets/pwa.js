@@ -0,0 +1,7 @@
+// Installs the service worker of the pages, which keeps them available
+// offline, see sw.js.
+if ("serviceWorker" in navigator) {
+  window.addEventListener("load", function () {
+    navigator.serviceWorker.register("/sw.js", { scope: "/web/" });
+  });
+}
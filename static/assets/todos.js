@@ -0,0 +1,10 @@
+// Errors come back as the alerts block, swapped like any answer.
+document.addEventListener("htmx:beforeSwap", function (e) {
+  if (e.detail.xhr.status >= 400) {
+    e.detail.shouldSwap = true;
+    e.detail.isError = false;
+  }
+});
+document.addEventListener("htmx:beforeRequest", function () {
+  document.getElementById("alerts").innerHTML = "";
+});
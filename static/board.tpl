@@ -0,0 +1,63 @@
+<!doctype html>
+<html lang="{{.Lang}}">
+  <head>
+    <title>{{.List}}</title>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1, shrink-to-fit=no">
+    <meta name="theme-color" content="#007bff">
+    <link rel="manifest" href="/manifest.webmanifest">
+    <link rel="icon" href="{{asset "icon-192.png"}}" sizes="192x192">
+    <link rel="apple-touch-icon" href="{{asset "icon-192.png"}}">
+    <link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/4.0.0-beta.2/css/bootstrap.min.css" integrity="sha384-PsH8R72JQ3SOdhVi3uxftmaW6Vc51MKb0q5P2rRUpPvrszuE4W1povHYgTpBfshb" crossorigin="anonymous">
+    <link rel="stylesheet" href="{{asset "todos.css"}}">
+    <link rel="stylesheet" href="{{asset "board.css"}}">
+    <script src="{{asset "pwa.js"}}"></script>
+  </head>
+  <body class="theme-{{.Prefs.Theme}}">
+    {{if eq .Prefs.Theme "auto"}}
+    <script>
+      if (window.matchMedia("(prefers-color-scheme: dark)").matches) {
+        document.body.className = "theme-dark";
+      }
+    </script>
+    {{end}}
+    <div class="container-fluid my-4">
+      <h1>{{.List}}</h1>
+      <p><a href="/web/?list={{.List}}">{{.T "Back to the todos"}}</a></p>
+
+      {{with .Message}}<div class="alert alert-success">{{.}}</div>{{end}}
+      {{with .Error}}<div class="alert alert-danger">{{.}}</div>{{end}}
+
+      <div class="board">
+        {{range .Columns}}
+        <section class="board-column">
+          <h2 class="h5">{{.Name}} <span class="badge badge-secondary">{{len .Cards}}</span></h2>
+          {{range $card := .Cards}}
+          <div class="card mb-2">
+            <div class="card-body p-2">
+              <div {{if eq .Completed "true"}}class="del"{{end}}>{{.Title}}</div>
+              <small class="text-muted">
+                {{if .Priority}}P{{.Priority}}{{end}}
+                {{.Due}}{{if .Overdue}} <span class="badge badge-danger">{{.T "overdue"}}</span>{{else if .DueToday}} <span class="badge badge-warning">{{.T "today"}}</span>{{end}}
+              </small>
+              <div class="board-moves">
+                {{range .Moves}}
+                <form method="post" action="board/move">
+                  <input type="hidden" name="id" value="{{$card.ID}}">
+                  <input type="hidden" name="column" value="{{.Column}}">
+                  <input type="hidden" name="index" value="{{.Index}}">
+                  <button type="submit" class="btn btn-sm btn-outline-secondary" title="{{.Title}}" aria-label="{{.Title}}">{{.Label}}</button>
+                </form>
+                {{end}}
+              </div>
+            </div>
+          </div>
+          {{else}}
+          <p class="text-muted">{{$.T "No todos."}}</p>
+          {{end}}
+        </section>
+        {{end}}
+      </div>
+    </div>
+  </body>
+</html>
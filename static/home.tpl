@@ -1,7 +1,7 @@
 <!doctype html>
-<html lang="en">
+<html lang="{{.Lang}}">
   <head>
-    <title>Todo</title>
+    <title>{{.T "Todo"}}</title>
     <!-- Required meta tags -->
     <meta charset="utf-8">
     <meta name="viewport" content="width=device-width, initial-scale=1, shrink-to-fit=no">
@@ -10,61 +10,7 @@
     <!-- Bootstrap CSS -->
     <link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/4.0.0-beta.2/css/bootstrap.min.css" integrity="sha384-PsH8R72JQ3SOdhVi3uxftmaW6Vc51MKb0q5P2rRUpPvrszuE4W1povHYgTpBfshb" crossorigin="anonymous">
     <link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/font-awesome/4.7.0/css/font-awesome.min.css">
-    <style type="text/css">
-      .del {
-          text-decoration: line-through;
-      }
-      .card{
-        border-radius: 0 !important;
-        border: none;
-      }
-      .card-body{
-        padding: 0 !important;
-      }
-      .todo-title{
-        width: 100%;
-        background: #b88f92;
-        color: #FFF
-        ;
-        font-size: 30px;
-        font-weight: bold;
-        padding: 20px 10px;
-        text-align: center;
-        border-top-left-radius: 5px;
-        border-top-right-radius: 5px;
-      }
-      .custom-input{
-        border-radius: 0 !important;
-        padding: 10px 10px !important;
-        border-bottom: none;
-      }
-      .custom-input:focus, .custom-input:active{
-        box-shadow: none !important;
-      }
-      .custom-button{
-        border-radius: 0 !important;
-        cursor: pointer;
-      }
-      .custom-button:focus, .custom-button:active{
-        box-shadow: none !important;
-      }
-      .list-group li{
-        cursor: pointer;
-        border-radius: 0 !important;
-      }
-      .checked{
-        background: #5e6669;
-        color: #95a5a6;
-      }
-      .error{
-        border: 2px solid #e74c3c !important;
-      }
-      .not-checked{
-        background: #2227c7;
-        color: #FFF;
-        font-weight: bold;
-      }
-    </style>
+    <link rel="stylesheet" href="{{asset "home.css"}}">
   </head>
   <body>
     <div class="container" id="root">
@@ -73,12 +19,12 @@
                 <br><br>
                 <div class="card">
                   <div class="todo-title">
-                    Daily Todo Lists
+                    {{.T "Daily Todo Lists"}}
                   </div>
                   <div class="card-body">
                       <form v-on:submit.prevent>
                         <div class="input-group">
-                          <input type="text" v-model="todo.title" v-on:keyup="checkForEnter($event)" class="form-control custom-input" :class="{ 'error': showError }" placeholder="Add your todo">
+                          <input type="text" v-model="todo.title" v-on:keyup="checkForEnter($event)" class="form-control custom-input" :class="{ 'error': showError }" placeholder="{{.T "Add your todo"}}">
                           <span class="input-group-btn">
                             <button class="btn custom-button" :class="{'btn-success' : !enableEdit, 'btn-warning' : enableEdit}" type="button"  v-on:click="addTodo"><span :class="{'fa fa-plus' : !enableEdit, 'fa fa-edit' : enableEdit}"></span></button>
                           </span>
@@ -104,80 +50,6 @@
     <script src="https://code.jquery.com/jquery-3.2.1.slim.min.js" integrity="sha384-KJ3o2DKtIkvYIK3UENzmM7KCkRr/rE9/Qpg6aAZGJwFDMVNA/GpGFF93hXpG5KkN" crossorigin="anonymous"></script>
     <script src="https://cdnjs.cloudflare.com/ajax/libs/popper.js/1.12.3/umd/popper.min.js" integrity="sha384-vFJXuSJphROIrBnz7yo7oB41mKfc8JzQZiCq4NCceLEaO4IHwicKwpJf9c9IpFgh" crossorigin="anonymous"></script>
     <script src="https://maxcdn.bootstrapcdn.com/bootstrap/4.0.0-beta.2/js/bootstrap.min.js" integrity="sha384-alpBpkh1PFOepccYVYDB4do5UnbKysX5WZXm3XxPqe5iKTfUKjNkCk9SaVuEZflJ" crossorigin="anonymous"></script>
-    <script type="text/javascript">
-      var Vue = new Vue({
-        el: '#root',
-        delimiters: ['@{', '}'],
-        data: {
-          showError: false,
-          enableEdit: false,
-          todo: {id: '', title: '', completed: false},
-          todos: []
-        },
-        mounted () {
-          this.$http.get('todo').then(response => {
-            this.todos = response.body.data;
-          });
-        },
-        methods: {
-          addTodo(){
-            if (this.todo.title == ''){
-              this.showError = true;
-            }else{
-              this.showError = false;
-              if(this.enableEdit){
-                this.$http.put('todo/'+this.todo.id, this.todo).then(response => {
-                  if(response.status == 200){
-                    this.todos[this.todo.todoIndex] = this.todo;
-                  }
-                });
-                this.todo = {id: '', title: '', completed: false};
-                this.enableEdit = false;
-              }else{
-                this.$http.post('todo', {title: this.todo.title}).then(response => {
-                  if(response.status == 201){
-                    this.todos.push({id: response.body.todo_id, title: this.todo.title, completed: false});
-                    this.todo = {id: '', title: '', completed: false};
-                  }
-                });
-              }
-            }
-          },
-          checkForEnter(event){
-            if (event.key == "Enter") {
-              this.addTodo();
-            }
-          },
-          toggleTodo(todo, todoIndex){
-            var completedToggle;
-            if (todo.completed == true) {
-              completedToggle = false;
-            }else{
-              completedToggle = true;
-            }
-            this.$http.put('todo/'+todo.id, {id: todo.id, title: todo.title, completed: completedToggle}).then(response => {
-              if(response.status == 200){
-                this.todos[todoIndex].completed = completedToggle;
-              }
-            });
-          },
-          editTodo(todo, todoIndex){
-            this.enableEdit = true;
-            this.todo = todo;
-            this.todo.todoIndex = todoIndex;
-          },
-          deleteTodo(todo, todoIndex){
-            if(confirm("Are you sure ?")){
-              this.$http.delete('todo/'+todo.id).then(response => {
-                if(response.status == 200){
-                  this.todos.splice(todoIndex, 1);
-                  this.todo = {id: '', title: '', completed: false};
-                }
-              });
-            }
-          }
-        }
-      });
-    </script>
+    <script src="{{asset "home.js"}}"></script>
   </body>
 </html>
\ No newline at end of file
@@ -0,0 +1,18 @@
+<!doctype html>
+<html lang="{{.Lang}}">
+  <head>
+    <title>{{.T "Todos"}}</title>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1, shrink-to-fit=no">
+    <meta name="theme-color" content="#007bff">
+    <link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/4.0.0-beta.2/css/bootstrap.min.css" integrity="sha384-PsH8R72JQ3SOdhVi3uxftmaW6Vc51MKb0q5P2rRUpPvrszuE4W1povHYgTpBfshb" crossorigin="anonymous">
+    <link rel="stylesheet" href="{{asset "todos.css"}}">
+  </head>
+  <body>
+    <div class="container my-4">
+      <h1>{{.T "Todos"}}</h1>
+      <div class="alert alert-warning">{{.T "You are offline. This page has not been opened here before, so there is no copy of it."}}</div>
+      <p><button type="button" class="btn btn-primary" onclick="location.reload()">{{.T "Try again"}}</button></p>
+    </div>
+  </body>
+</html>
@@ -0,0 +1,72 @@
+// Service worker of the pages under /web, see pwa.go. The server defines
+// SHELL, the offline page and the assets of the pages, OFFLINE and CACHE,
+// named after the hashes of SHELL, so a deploy changing an asset installs
+// a new worker, which drops the cache of the old one.
+
+self.addEventListener("install", function (e) {
+  e.waitUntil(caches.open(CACHE).then(function (cache) {
+    return cache.addAll(SHELL);
+  }).then(function () {
+    return self.skipWaiting();
+  }));
+});
+
+self.addEventListener("activate", function (e) {
+  e.waitUntil(caches.keys().then(function (keys) {
+    return Promise.all(keys.filter(function (k) {
+      return k.startsWith("todo-") && k !== CACHE;
+    }).map(function (k) {
+      return caches.delete(k);
+    }));
+  }).then(function () {
+    return self.clients.claim();
+  }));
+});
+
+// fromCache answers with the cached response to req, or fetches and caches
+// it. The URLs it is used for never change their contents.
+function fromCache(req) {
+  return caches.match(req).then(function (res) {
+    return res || fetch(req).then(function (res) {
+      if (res.ok) {
+        var copy = res.clone();
+        caches.open(CACHE).then(function (cache) { cache.put(req, copy); });
+      }
+      return res;
+    });
+  });
+}
+
+self.addEventListener("fetch", function (e) {
+  var req = e.request;
+  if (req.method !== "GET") {
+    return;
+  }
+  var url = new URL(req.url);
+  if (url.origin !== location.origin) {
+    // The stylesheets and scripts of the CDNs, versioned in their URLs.
+    if (req.destination === "style" || req.destination === "script") {
+      e.respondWith(fromCache(req));
+    }
+    return;
+  }
+  if (req.mode === "navigate" && url.pathname.startsWith("/web/")) {
+    // Pages come from the network. The last copy of each is kept for when
+    // the server is unreachable, the offline page for those never seen.
+    e.respondWith(fetch(req).then(function (res) {
+      if (res.ok) {
+        var copy = res.clone();
+        caches.open(CACHE).then(function (cache) { cache.put(req, copy); });
+      }
+      return res;
+    }).catch(function () {
+      return caches.match(req).then(function (res) {
+        return res || caches.match(OFFLINE);
+      });
+    }));
+    return;
+  }
+  if (url.pathname.startsWith("/assets/")) {
+    e.respondWith(fromCache(req));
+  }
+});
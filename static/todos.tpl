@@ -0,0 +1,131 @@
+<!doctype html>
+<html lang="{{.Lang}}">
+  <head>
+    <title>{{.T "Todos"}}</title>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1, shrink-to-fit=no">
+    <meta name="theme-color" content="#007bff">
+    <link rel="manifest" href="/manifest.webmanifest">
+    <link rel="icon" href="{{asset "icon-192.png"}}" sizes="192x192">
+    <link rel="apple-touch-icon" href="{{asset "icon-192.png"}}">
+    <link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/4.0.0-beta.2/css/bootstrap.min.css" integrity="sha384-PsH8R72JQ3SOdhVi3uxftmaW6Vc51MKb0q5P2rRUpPvrszuE4W1povHYgTpBfshb" crossorigin="anonymous">
+    <link rel="stylesheet" href="{{asset "todos.css"}}">
+    <script src="https://unpkg.com/htmx.org@1.9.12"></script>
+    <script src="{{asset "todos.js"}}"></script>
+    <script src="{{asset "pwa.js"}}"></script>
+  </head>
+  <body class="theme-{{.Prefs.Theme}}">
+    {{if eq .Prefs.Theme "auto"}}
+    <script>
+      if (window.matchMedia("(prefers-color-scheme: dark)").matches) {
+        document.body.className = "theme-dark";
+      }
+    </script>
+    {{end}}
+    <div class="container my-4">
+      <h1>{{.T "Todos"}}</h1>
+
+      <div id="alerts">{{template "alerts" .}}</div>
+
+      <form method="get" class="form-inline mb-3">
+        <input type="search" name="q" value="{{.Filter.Q}}" placeholder="{{.T "Search titles"}}" class="form-control mr-2 mb-2">
+        <select name="status" class="form-control mr-2 mb-2">
+          <option value="">{{.T "Any status"}}</option>
+          <option value="open" {{if eq .Filter.Status "open"}}selected{{end}}>{{.T "Open"}}</option>
+          <option value="done" {{if eq .Filter.Status "done"}}selected{{end}}>{{.T "Done"}}</option>
+        </select>
+        <select name="list" class="form-control mr-2 mb-2">
+          <option value="">{{.T "Any list"}}</option>
+          {{range .Lists}}<option {{if eq . $.Filter.List}}selected{{end}}>{{.}}</option>{{end}}
+        </select>
+        <select name="label" class="form-control mr-2 mb-2">
+          <option value="">{{.T "Any label"}}</option>
+          {{range .Labels}}<option {{if eq . $.Filter.Label}}selected{{end}}>{{.}}</option>{{end}}
+        </select>
+        <select name="priority" class="form-control mr-2 mb-2">
+          <option value="">{{.T "Any priority"}}</option>
+          {{range $p := .Priorities}}<option value="{{$p}}" {{if eq $p $.Filter.Priority}}selected{{end}}>P{{$p}}</option>{{end}}
+        </select>
+        <button type="submit" class="btn btn-secondary mb-2">{{.T "Filter"}}</button>
+      </form>
+
+      <table class="table table-sm">
+        <thead>
+          <tr><th></th><th>{{.T "Title"}}</th><th>{{.T "List"}}</th><th>{{.T "Labels"}}</th><th>{{.T "Priority"}}</th><th>{{.T "Due"}}</th><th></th></tr>
+        </thead>
+        <tbody id="todos">
+          {{range .Todos}}{{template "row" .}}{{end}}
+          <tr class="empty"><td colspan="7" class="text-muted">{{.T "No todos."}}</td></tr>
+        </tbody>
+      </table>
+
+      {{if .Pages}}
+      <nav class="d-flex justify-content-between align-items-center mb-4">
+        {{if .PrevURL}}<a href="{{.PrevURL}}" class="btn btn-outline-secondary">{{.T "Previous"}}</a>{{else}}<span></span>{{end}}
+        <span class="text-muted">{{.T "Page %d of %d, %d todos" .Page .Pages .Total}}</span>
+        {{if .NextURL}}<a href="{{.NextURL}}" class="btn btn-outline-secondary">{{.T "Next"}}</a>{{else}}<span></span>{{end}}
+      </nav>
+      {{end}}
+
+      <h2 class="h4">{{.T "New todo"}}</h2>
+      <form method="post" hx-post="/web/todos" hx-target="#todos" hx-swap="beforeend" hx-on::after-request="if (event.detail.successful) this.reset()">
+        <div class="form-group">
+          <input type="text" name="title" value="{{.Form.Title}}" placeholder="{{.T "Title"}}" required class="form-control">
+        </div>
+        <div class="form-group">
+          <textarea name="description" placeholder="{{.T "Description"}}" class="form-control">{{.Form.Description}}</textarea>
+        </div>
+        <div class="form-row">
+          <div class="form-group col-md-3">
+            <input type="text" name="list" value="{{.Form.List}}" placeholder="{{.T "List"}}" class="form-control">
+          </div>
+          <div class="form-group col-md-3">
+            <input type="text" name="labels" value="{{.Form.Labels}}" placeholder="{{.T "Labels, comma separated"}}" class="form-control">
+          </div>
+          <div class="form-group col-md-3">
+            <select name="priority" class="form-control">
+              <option value="">{{.T "No priority"}}</option>
+              {{range $p := .Priorities}}<option value="{{$p}}" {{if eq (print $p) $.Form.Priority}}selected{{end}}>P{{$p}}</option>{{end}}
+            </select>
+          </div>
+          <div class="form-group col-md-3">
+            <input type="date" name="due" value="{{.Form.Due}}" class="form-control">
+          </div>
+        </div>
+        <button type="submit" class="btn btn-primary">{{.T "Add"}}</button>
+      </form>
+    </div>
+  </body>
+</html>
+
+{{define "alerts"}}
+{{with .Message}}<div class="alert alert-success">{{.}}</div>{{end}}
+{{with .Error}}<div class="alert alert-danger">{{.}}</div>{{end}}
+{{end}}
+
+{{define "row"}}
+<tr id="todo-{{.ID}}">
+  <td><input type="checkbox" name="completed" value="true" {{if eq .Completed "true"}}checked{{end}} hx-post="/web/todos/{{.ID}}/toggle" hx-target="closest tr" hx-swap="outerHTML"></td>
+  <td {{if eq .Completed "true"}}class="del"{{end}}>{{.Title}}</td>
+  <td>{{with .List}}<a href="/web/lists/{{path .}}/board">{{.}}</a>{{end}}</td>
+  <td>{{join .Labels ", "}}</td>
+  <td>{{if .Priority}}P{{.Priority}}{{end}}</td>
+  <td class="text-nowrap">{{.Due}}{{if .Overdue}} <span class="badge badge-danger">{{.T "overdue"}}</span>{{else if .DueToday}} <span class="badge badge-warning">{{.T "today"}}</span>{{end}}</td>
+  <td class="text-right text-nowrap">
+    <button type="button" class="btn btn-sm btn-outline-secondary" hx-get="/web/todos/{{.ID}}/edit" hx-target="closest tr" hx-swap="outerHTML">{{.T "Edit"}}</button>
+    <button type="button" class="btn btn-sm btn-outline-danger" hx-delete="/web/todos/{{.ID}}" hx-target="closest tr" hx-swap="outerHTML" hx-confirm="{{.T "Delete this todo?"}}">{{.T "Delete"}}</button>
+  </td>
+</tr>
+{{end}}
+
+{{define "edit-row"}}
+<tr id="todo-{{.ID}}">
+  <td colspan="7">
+    <form class="form-inline" hx-put="/web/todos/{{.ID}}" hx-target="closest tr" hx-swap="outerHTML">
+      <input type="text" name="title" value="{{.Title}}" required autofocus class="form-control form-control-sm mr-2">
+      <button type="submit" class="btn btn-sm btn-primary mr-2">{{.T "Save"}}</button>
+      <button type="button" class="btn btn-sm btn-link" hx-get="/web/todos/{{.ID}}" hx-target="closest tr" hx-swap="outerHTML">{{.T "Cancel"}}</button>
+    </form>
+  </td>
+</tr>
+{{end}}
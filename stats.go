@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultStatsDays = 30
+	maxStatsDays     = 366
+	// maxStatsCached is the most stats kept by statsCache, of all tenants.
+	maxStatsCached = 1000
+)
+
+var (
+	// statsCacheTTL is how long computed stats are reused, see
+	// loadStatsConfig. Zero turns it off.
+	statsCacheTTL = 30 * time.Second
+
+	statsCacheRequests = newCounterVec("todo_stats_cache_requests_total",
+		"Stats requests by result: hit or miss.", "result")
+)
+
+// loadStatsConfig reads TODO_STATS_CACHE_TTL, how long the stats of a
+// tenant are reused before they are computed again, 0 to always compute
+// them.
+func loadStatsConfig() error {
+	statsCacheTTL = 30 * time.Second
+	if v := os.Getenv("TODO_STATS_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid TODO_STATS_CACHE_TTL %q, must be a duration like 30s", v)
+		}
+		statsCacheTTL = d
+	}
+	return nil
+}
+
+type (
+	todoStats struct {
+		Total          int64        `json:"total"`
+		Completed      int64        `json:"completed"`
+		Open           int64        `json:"open"`
+		CompletionRate float64      `json:"completion_rate"`
+		Trend          []dailyCount `json:"trend"`
+	}
+
+	dailyCount struct {
+		Day       string `bson:"_id" json:"day"`
+		Created   int64  `bson:"created" json:"created"`
+		Completed int64  `bson:"completed" json:"completed"`
+	}
+)
+
+// Stats computes totals and a per day creation trend since the given time
+// in a single $facet aggregation. Days are bucketed in loc.
+func (mongoRepository) Stats(ctx context.Context, since time.Time, loc *time.Location) (todoStats, error) {
+	completedCount := bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$completed", true}}, 1, 0}}}
+	pipeline := bson.A{
+		bson.M{"$match": scoped(ctx, bson.M{})},
+		bson.M{"$facet": bson.M{
+			"totals": bson.A{
+				bson.M{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": 1}, "completed": completedCount}},
+			},
+			"trend": bson.A{
+				bson.M{"$match": bson.M{"createAt": bson.M{"$gte": since}}},
+				bson.M{"$group": bson.M{
+					"_id": bson.M{"$dateToString": bson.M{
+						"format":   "%Y-%m-%d",
+						"date":     "$createAt",
+						"timezone": loc.String(),
+					}},
+					"created":   bson.M{"$sum": 1},
+					"completed": completedCount,
+				}},
+				bson.M{"$sort": bson.M{"_id": 1}},
+			},
+		}},
+	}
+
+	var result []struct {
+		Totals []struct {
+			Total     int64 `bson:"total"`
+			Completed int64 `bson:"completed"`
+		} `bson:"totals"`
+		Trend []dailyCount `bson:"trend"`
+	}
+	opts := options.Aggregate()
+	if id := requestID(ctx); id != "" {
+		opts.SetComment(id)
+	}
+	err := withRetry(ctx, func(ctx context.Context) error {
+		cursor, err := readCollection().Aggregate(ctx, pipeline, opts)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+
+		result = nil
+		return cursor.All(ctx, &result)
+	})
+	if err != nil {
+		return todoStats{}, err
+	}
+
+	stats := todoStats{Trend: []dailyCount{}}
+	if len(result) == 0 {
+		return stats, nil
+	}
+	if len(result[0].Totals) > 0 {
+		stats.Total = result[0].Totals[0].Total
+		stats.Completed = result[0].Totals[0].Completed
+		stats.Open = stats.Total - stats.Completed
+	}
+	if stats.Total > 0 {
+		stats.CompletionRate = float64(stats.Completed) / float64(stats.Total)
+	}
+	if result[0].Trend != nil {
+		stats.Trend = result[0].Trend
+	}
+	return stats, nil
+}
+
+// statsCache keeps the stats computed last, by tenant, so a dashboard
+// polling them does not run the aggregation on every refresh. The writes
+// of a tenant through this process drop its stats, see invalidateCache;
+// those of other instances show once they expire.
+var statsCache = struct {
+	mu      sync.Mutex
+	tenants map[string]map[string]cachedStats
+	// writes counts the calls to forgetStats, so stats computed while a
+	// write ended are not kept.
+	writes uint64
+}{tenants: map[string]map[string]cachedStats{}}
+
+type cachedStats struct {
+	stats   todoStats
+	expires time.Time
+}
+
+func statsKey(since time.Time, loc *time.Location) string {
+	return strconv.FormatInt(since.Unix(), 10) + "/" + loc.String()
+}
+
+// cachedStatsFor returns the stats of tenant from since in loc if they
+// were computed less than statsCacheTTL ago, and what storeStats needs to
+// know whether stats computed from now on are still current.
+func cachedStatsFor(tenant string, since time.Time, loc *time.Location) (todoStats, bool, uint64) {
+	statsCache.mu.Lock()
+	defer statsCache.mu.Unlock()
+	c, ok := statsCache.tenants[tenant][statsKey(since, loc)]
+	if !ok || time.Now().After(c.expires) {
+		return todoStats{}, false, statsCache.writes
+	}
+	return c.stats, true, statsCache.writes
+}
+
+// storeStats keeps stats for statsCacheTTL, unless a tenant wrote since
+// cachedStatsFor returned writes. Once maxStatsCached are kept, expired
+// stats are dropped first, and new ones are not kept if none are.
+func storeStats(tenant string, since time.Time, loc *time.Location, stats todoStats, writes uint64) {
+	statsCache.mu.Lock()
+	defer statsCache.mu.Unlock()
+	if statsCache.writes != writes {
+		return
+	}
+	n := 0
+	for _, m := range statsCache.tenants {
+		n += len(m)
+	}
+	if n >= maxStatsCached {
+		now := time.Now()
+		for t, m := range statsCache.tenants {
+			for k, c := range m {
+				if now.After(c.expires) {
+					delete(m, k)
+					n--
+				}
+			}
+			if len(m) == 0 {
+				delete(statsCache.tenants, t)
+			}
+		}
+		if n >= maxStatsCached {
+			return
+		}
+	}
+	m := statsCache.tenants[tenant]
+	if m == nil {
+		m = map[string]cachedStats{}
+		statsCache.tenants[tenant] = m
+	}
+	m[statsKey(since, loc)] = cachedStats{stats: stats, expires: time.Now().Add(statsCacheTTL)}
+}
+
+// statsFor returns the stats of the tenant of ctx from since in loc, from
+// statsCache when they were computed recently enough.
+func statsFor(ctx context.Context, since time.Time, loc *time.Location) (todoStats, error) {
+	if statsCacheTTL == 0 {
+		return repo.Stats(ctx, since, loc)
+	}
+	tenant := tenantFrom(ctx)
+	stats, ok, writes := cachedStatsFor(tenant, since, loc)
+	if ok {
+		statsCacheRequests.inc("hit")
+		return stats, nil
+	}
+	statsCacheRequests.inc("miss")
+	stats, err := repo.Stats(ctx, since, loc)
+	if err == nil {
+		storeStats(tenant, since, loc, stats, writes)
+	}
+	return stats, err
+}
+
+// forgetStats drops the stats of tenant, after it wrote.
+func forgetStats(tenant string) {
+	statsCache.mu.Lock()
+	defer statsCache.mu.Unlock()
+	statsCache.writes++
+	delete(statsCache.tenants, tenant)
+}
+
+// fetchStats serves GET /todo/stats?days=30&tz=Europe/Berlin. Without tz,
+// days are those of the timezone of the user's preferences.
+func fetchStats(w http.ResponseWriter, r *http.Request) {
+	days := defaultStatsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStatsDays {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "days must be between 1 and 366", "error": "bad request"})
+			return
+		}
+		days = n
+	}
+
+	var loc *time.Location
+	if v := r.URL.Query().Get("tz"); v != "" {
+		l, err := statsLocation(v)
+		if err != nil {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "unknown time zone", "error": err.Error()})
+			return
+		}
+		loc = l
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	if loc == nil {
+		prefs, err := loadPreferences(ctx, tenantFrom(ctx))
+		if err != nil {
+			storeErr(w, r, "could not compute stats", err)
+			return
+		}
+		loc = prefs.location()
+	}
+	now := time.Now().In(loc)
+	since := time.Date(now.Year(), now.Month(), now.Day()-days+1, 0, 0, 0, 0, loc)
+
+	stats, err := statsFor(ctx, since, loc)
+	if err != nil {
+		storeErr(w, r, "could not compute stats", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": stats})
+}
+
+// statsLocation loads the IANA time zone name. Local, which LoadLocation
+// takes for the zone of the server, is refused: it would leak where the
+// server runs and change the stats with it.
+func statsLocation(name string) (*time.Location, error) {
+	if name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %s", name)
+	}
+	return time.LoadLocation(name)
+}
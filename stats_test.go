@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// statsStore records the time zone stats are computed in.
+type statsStore struct {
+	memStore
+	loc *time.Location
+}
+
+func (s *statsStore) Stats(_ context.Context, _ time.Time, loc *time.Location) (todoStats, error) {
+	s.loc = loc
+	return todoStats{}, nil
+}
+
+func TestFetchStatsTimezone(t *testing.T) {
+	store := &statsStore{}
+	prevRepo, prevRnd, prevTTL := repo, rnd, statsCacheTTL
+	repo, rnd, statsCacheTTL = store, renderer.New(), 0
+	t.Cleanup(func() { repo, rnd, statsCacheTTL = prevRepo, prevRnd, prevTTL })
+
+	tests := []struct {
+		tz   string
+		code int
+		loc  string
+	}{
+		{"Europe/Berlin", http.StatusOK, "Europe/Berlin"},
+		{"UTC", http.StatusOK, "UTC"},
+		// The zone of the server is not the user's.
+		{"Local", http.StatusBadRequest, ""},
+		{"Mars/Olympus", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		store.loc = nil
+		w := httptest.NewRecorder()
+		fetchStats(w, httptest.NewRequest(http.MethodGet, "/todo/stats?tz="+tt.tz, nil))
+		if w.Code != tt.code {
+			t.Errorf("tz=%s answered %d %s, want %d", tt.tz, w.Code, w.Body, tt.code)
+			continue
+		}
+		got := ""
+		if store.loc != nil {
+			got = store.loc.String()
+		}
+		if got != tt.loc {
+			t.Errorf("tz=%s computed the stats in %q, want %q", tt.tz, got, tt.loc)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ndjsonType is the media type of answers streamed as one JSON value per
+// line.
+const ndjsonType = "application/x-ndjson"
+
+// listQuery selects the todos of todoStore.Each: those of List,
+// ListSummary when Summary, or ListArchived when Archived. List, when not
+// nil, keeps the todos of that list only.
+type listQuery struct {
+	Archived bool
+	Summary  bool
+	List     *string
+}
+
+// maxTodoPageSize bounds the pages of listTodoPage.
+const maxTodoPageSize = 1000
+
+// pageFilter narrows the todos of a page, see listTodoPage. Zero fields
+// keep every todo.
+type pageFilter struct {
+	Completed    *bool
+	CreatedAfter time.Time
+	Label        string
+}
+
+// listTodoPage returns up to size todos of q matching f in creation order,
+// starting after the todo of after, and whether more follow. Paging by the
+// ID of the last todo keeps todos created meanwhile from shifting the
+// pages.
+func listTodoPage(ctx context.Context, q listQuery, f pageFilter, after primitive.ObjectID, size int) ([]todoModel, bool, error) {
+	var todos []todoModel
+	err := repo.Each(ctx, q, func(tm todoModel) error {
+		switch {
+		case f.Completed != nil && tm.Completed != *f.Completed,
+			!f.CreatedAfter.IsZero() && !tm.CreatedAt.After(f.CreatedAfter),
+			f.Label != "" && !slices.Contains(tm.Labels, f.Label),
+			bytes.Compare(tm.ID[:], after[:]) <= 0:
+			return nil
+		}
+		todos = append(todos, tm)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	slices.SortFunc(todos, func(a, b todoModel) int { return bytes.Compare(a.ID[:], b.ID[:]) })
+	if len(todos) > size {
+		return todos[:size], true, nil
+	}
+	return todos, false, nil
+}
+
+// wantsNDJSON reports whether the Accept header of r asks for NDJSON.
+func wantsNDJSON(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(v, ",") {
+			if mt, _, err := mime.ParseMediaType(part); err == nil && mt == ndjsonType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// streamTodos answers GET /todo with the todos q selects, writing each as
+// it is read from the store instead of holding them all: as NDJSON, a todo
+// per line, or as the usual {"data": [...]}. The status goes out with the
+// first todo, so an error after it can no longer change it. NDJSON then
+// ends with a line {"message", "error", "code"} instead of a todo, JSON is
+// cut short so clients do not take the todos sent for the whole list.
+func streamTodos(w http.ResponseWriter, r *http.Request, q listQuery, ndjson bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	prefs, err := loadPreferences(ctx, tenantFrom(ctx))
+	cancel()
+	if err != nil {
+		storeErr(w, r, "could not fetch todos", err)
+		return
+	}
+	loc := prefs.location()
+
+	contentType, open, sep, end := ndjsonType, "", "", ""
+	if !ndjson {
+		contentType, open, sep, end = "application/json; charset=utf-8", `{"data":[`, ",", "]}"
+	}
+	n := 0
+	start := func() {
+		if n == 0 {
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(http.StatusOK)
+			io.WriteString(w, open)
+		}
+	}
+	// Reading every todo takes longer than a single store call, so only
+	// the deadline of the route bounds it.
+	err = repo.Each(r.Context(), q, func(t todoModel) error {
+		tt := toTodo(t)
+		dueFlags(&tt, loc)
+		b, err := json.Marshal(tt)
+		if err != nil {
+			return err
+		}
+		start()
+		if n > 0 {
+			io.WriteString(w, sep)
+		}
+		if ndjson {
+			b = append(b, '\n')
+		}
+		n++
+		_, err = w.Write(b)
+		return err
+	})
+	switch {
+	case err != nil && n == 0:
+		storeErr(w, r, "could not fetch todos", err)
+	case err != nil:
+		k := classify(err)
+		noteError(r.Context(), k.code, err)
+		slog.Warn("todo stream interrupted", "todos", n, "error", err)
+		if !ndjson {
+			panic(http.ErrAbortHandler)
+		}
+		json.NewEncoder(w).Encode(renderer.M{"message": "could not fetch todos", "error": k.describe(err), "code": k.code})
+	default:
+		start()
+		io.WriteString(w, end)
+	}
+}
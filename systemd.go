@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+var (
+	// inheritedListeners are the sockets inherited from systemd socket
+	// activation or the process upgraded from, see upgrade, by name:
+	// "redirect", "debug", "admin" and "grpc" for those listeners, "api" for the
+	// API.
+	inheritedListeners = map[string]net.Listener{}
+	// listening are the sockets served, by name, for upgrades to inherit.
+	listening   = map[string]net.Listener{}
+	listeningMu sync.Mutex
+	// notifySocket is where sd_notify messages go, see notifySystemd.
+	notifySocket string
+	// watchdogInterval is how often systemd expects a keep-alive.
+	watchdogInterval time.Duration
+)
+
+// loadSystemdConfig takes over the sockets of systemd socket activation
+// (LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES), and NOTIFY_SOCKET and
+// WATCHDOG_USEC of a Type=notify service. The variables are unset so they
+// are not passed on to child processes.
+func loadSystemdConfig() error {
+	notifySocket = os.Getenv("NOTIFY_SOCKET")
+	if v := os.Getenv("WATCHDOG_USEC"); v != "" && (os.Getenv("WATCHDOG_PID") == "" || os.Getenv("WATCHDOG_PID") == strconv.Itoa(os.Getpid())) {
+		usec, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || usec <= 0 {
+			return fmt.Errorf("invalid WATCHDOG_USEC %q", v)
+		}
+		watchdogInterval = time.Duration(usec) * time.Microsecond
+	}
+	defer func() {
+		for _, k := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", "NOTIFY_SOCKET", "WATCHDOG_USEC", "WATCHDOG_PID"} {
+			os.Unsetenv(k)
+		}
+	}()
+
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n; i++ {
+		name := "api"
+		if i < len(names) && (names[i] == "redirect" || names[i] == "debug" || names[i] == "admin" || names[i] == "grpc") {
+			name = names[i]
+		}
+		if _, ok := inheritedListeners[name]; ok {
+			return fmt.Errorf("more than one %s socket passed by systemd", name)
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("socket %d passed by systemd: %w", listenFDsStart+i, err)
+		}
+		inheritedListeners[name] = ln
+	}
+	return nil
+}
+
+// listener returns the socket inherited for name, or listens on addr with
+// tcpKeepAlive probes.
+func listener(name, addr string) (net.Listener, error) {
+	ln, ok := inheritedListeners[name]
+	if !ok {
+		var err error
+		lc := net.ListenConfig{KeepAlive: tcpKeepAlive}
+		if ln, err = lc.Listen(context.Background(), "tcp", addr); err != nil {
+			return nil, err
+		}
+	}
+	listeningMu.Lock()
+	listening[name] = ln
+	listeningMu.Unlock()
+	return ln, nil
+}
+
+// notifySystemd sends state, such as READY=1, to the service manager when
+// running under a Type=notify unit.
+func notifySystemd(state string) {
+	if notifySocket == "" {
+		return
+	}
+	addr := &net.UnixAddr{Name: notifySocket, Net: "unixgram"}
+	if strings.HasPrefix(notifySocket, "@") {
+		addr.Name = "\x00" + notifySocket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err == nil {
+		_, err = conn.Write([]byte(state))
+		conn.Close()
+	}
+	if err != nil {
+		slog.Warn("could not notify systemd", "component", "systemd", "state", state, "error", err)
+	}
+}
+
+// runSystemdWatchdog pings the systemd watchdog at half its interval until
+// ctx is done.
+func runSystemdWatchdog(ctx context.Context) {
+	if watchdogInterval == 0 {
+		return
+	}
+	ticker := time.NewTicker(watchdogInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			notifySystemd("WATCHDOG=1")
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	teamsChannelsCollectionName = "teams_channels"
+	teamsNotifiedCollectionName = "teams_notified"
+
+	teamsTimeout         = 10 * time.Second
+	teamsOverdueInterval = time.Minute
+	teamsNotifiedTTL     = 90 * 24 * time.Hour
+
+	teamsEventCreated   = "created"
+	teamsEventCompleted = "completed"
+	teamsEventOverdue   = "overdue"
+)
+
+var teamsEvents = []string{teamsEventCreated, teamsEventCompleted, teamsEventOverdue}
+
+// teamsChannel is the Teams incoming webhook a tenant is notified at. The
+// URL grants posting to the channel, so it is stored encrypted.
+type teamsChannel struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	TenantID    string             `bson:"tenant_id" json:"-"`
+	WebhookURL  string             `bson:"webhook_url" json:"-"`
+	Events      []string           `bson:"events" json:"events"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+	LastError   string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	LastErrorAt *time.Time         `bson:"last_error_at,omitempty" json:"last_error_at,omitempty"`
+}
+
+// teamsEnabled reports whether Teams notifications can be configured.
+// Created and completed todos are fed by the outbox.
+func teamsEnabled() bool {
+	return outboxEnabled
+}
+
+func teamsChannelsCollection() *mongo.Collection {
+	return db.Collection(teamsChannelsCollectionName, writeCollectionOpts)
+}
+
+func teamsNotifiedCollection() *mongo.Collection {
+	return db.Collection(teamsNotifiedCollectionName, writeCollectionOpts)
+}
+
+// ensureTeamsIndexes makes the channel unique per tenant, expires
+// notification records and indexes due dates for the overdue scan.
+func ensureTeamsIndexes(ctx context.Context) error {
+	if !teamsEnabled() {
+		return nil
+	}
+	_, err := teamsChannelsCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "tenant_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = teamsNotifiedCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(teamsNotifiedTTL.Seconds())),
+	})
+	if err != nil {
+		return err
+	}
+	return ensureDueIndex(ctx)
+}
+
+// teamsOnce records that the notification key was posted and reports false
+// when it already was.
+func teamsOnce(ctx context.Context, key string) (bool, error) {
+	_, err := teamsNotifiedCollection().InsertOne(ctx, bson.M{"_id": key, "at": time.Now()})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// teamsFact is one row of the fact set of a card.
+type teamsFact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+// teamsCard builds the message of an adaptive card with a heading, the
+// todo title and its facts.
+func teamsCard(heading, title string, facts []teamsFact) map[string]any {
+	body := []map[string]any{
+		{"type": "TextBlock", "text": heading, "size": "Medium", "weight": "Bolder", "wrap": true},
+		{"type": "TextBlock", "text": title, "wrap": true},
+	}
+	if len(facts) > 0 {
+		body = append(body, map[string]any{"type": "FactSet", "facts": facts})
+	}
+	return map[string]any{
+		"type": "message",
+		"attachments": []map[string]any{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]any{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body":    body,
+			},
+		}},
+	}
+}
+
+// todoFacts lists the details of a todo shown on its card.
+func todoFacts(list string, priority int, dueAt *time.Time) []teamsFact {
+	var facts []teamsFact
+	if list != "" {
+		facts = append(facts, teamsFact{"List", list})
+	}
+	if priority > 0 {
+		facts = append(facts, teamsFact{"Priority", strconv.Itoa(priority)})
+	}
+	if dueAt != nil {
+		facts = append(facts, teamsFact{"Due", dueAt.UTC().Format(time.RFC1123)})
+	}
+	return facts
+}
+
+// postTeams posts card to the channel and records the outcome on it, so
+// a broken webhook shows up in GET /teams.
+func postTeams(ctx context.Context, c teamsChannel, card map[string]any) error {
+	err := sendTeams(ctx, c, card)
+	update := bson.M{"$unset": bson.M{"last_error": "", "last_error_at": ""}}
+	if err != nil {
+		update = bson.M{"$set": bson.M{"last_error": err.Error(), "last_error_at": time.Now()}}
+	}
+	if _, uerr := teamsChannelsCollection().UpdateByID(ctx, c.ID, update); uerr != nil {
+		slog.Error("could not record outcome", "component", "teams", "channel", c.ID.Hex(), "error", uerr)
+	}
+	return err
+}
+
+func sendTeams(ctx context.Context, c teamsChannel, card map[string]any) error {
+	webhookURL, err := decryptField(c.ID, c.WebhookURL)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, teamsTimeout)
+	defer cancel()
+
+	body, _ := json.Marshal(card)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := outboundClient.Do(req)
+	if err != nil {
+		// The URL is a credential, keep it out of errors.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("teams: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("teams: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// teamsPublisher posts created and completed todos to the channel of their
+// tenant when it subscribed to the event. Reopening a todo allows its next
+// completion to be posted again.
+type teamsPublisher struct{}
+
+func (teamsPublisher) Name() string { return "teams" }
+
+func (teamsPublisher) Publish(ctx context.Context, ev todoEvent) error {
+	var event, heading string
+	switch {
+	case ev.Type == eventTodoCreated:
+		event, heading = teamsEventCreated, "New todo"
+	case ev.Type == eventTodoUpdated && ev.Todo.Completed == "true":
+		event, heading = teamsEventCompleted, "✅ Completed"
+	case ev.Type == eventTodoUpdated:
+		_, err := teamsNotifiedCollection().DeleteOne(ctx, bson.M{"_id": ev.TenantID + ":completed:" + ev.Todo.ID})
+		return err
+	default:
+		return nil
+	}
+
+	var c teamsChannel
+	err := teamsChannelsCollection().FindOne(ctx, bson.M{"tenant_id": ev.TenantID, "events": event}).Decode(&c)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	key := ev.TenantID + ":" + event + ":" + ev.Todo.ID
+	first, err := teamsOnce(ctx, key)
+	if err != nil || !first {
+		return err
+	}
+	card := teamsCard(heading, ev.Todo.Title, todoFacts(ev.Todo.List, ev.Todo.Priority, ev.Todo.DueAt))
+	if err := postTeams(ctx, c, card); err != nil {
+		teamsNotifiedCollection().DeleteOne(ctx, bson.M{"_id": key})
+		return err
+	}
+	return nil
+}
+
+// scanTeamsOverdue posts open todos as they become overdue to the channels
+// subscribed to overdue todos. It is scheduled every teamsOverdueInterval.
+func scanTeamsOverdue(ctx context.Context) error {
+	if !dbConnected.Load() {
+		return nil
+	}
+	cursor, err := teamsChannelsCollection().Find(ctx, bson.M{"events": teamsEventOverdue})
+	if err != nil {
+		return err
+	}
+	var channels []teamsChannel
+	if err := cursor.All(ctx, &channels); err != nil {
+		return err
+	}
+	for _, c := range channels {
+		if err := notifyTeamsOverdue(withTenant(ctx, c.TenantID), c); err != nil {
+			slog.Error("overdue todos not posted", "component", "teams", "tenant", c.TenantID, "error", err)
+		}
+	}
+	return nil
+}
+
+// notifyTeamsOverdue posts the todos of the tenant of ctx that went
+// overdue within the last day, once per due date.
+func notifyTeamsOverdue(ctx context.Context, c teamsChannel) error {
+	now := time.Now()
+	cursor, err := readCollection().Find(ctx, scoped(ctx, bson.M{
+		"completed": false,
+		"due_at":    bson.M{"$lte": now, "$gt": now.Add(-slackOverdueWindow)},
+	}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var tm todoModel
+		if err := cursor.Decode(&tm); err != nil {
+			return err
+		}
+		key := c.TenantID + ":overdue:" + tm.ID.Hex() + ":" + strconv.FormatInt(tm.DueAt.Unix(), 10)
+		first, err := teamsOnce(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !first {
+			continue
+		}
+		title, err := decryptField(tm.ID, tm.Title)
+		if err == nil {
+			err = postTeams(ctx, c, teamsCard("⏰ Overdue", title, todoFacts(tm.List, tm.Priority, tm.DueAt)))
+		}
+		if err != nil {
+			teamsNotifiedCollection().DeleteOne(ctx, bson.M{"_id": key})
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func teamsHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(requireStore)
+	rg.Use(requireTeams)
+	rg.Group(func(r chi.Router) {
+		r.Use(tenantMiddleware)
+		r.Get("/", fetchTeamsChannel)
+		r.Put("/", saveTeamsChannel)
+		r.Delete("/", deleteTeamsChannel)
+		r.Post("/test", testTeamsChannel)
+	})
+	return rg
+}
+
+func requireTeams(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !teamsEnabled() || !usingMongo() {
+			rnd.JSON(w, http.StatusNotImplemented, renderer.M{"message": "Teams notifications require TODO_OUTBOX and the mongo store", "error": "not implemented"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func findTeamsChannel(ctx context.Context) (teamsChannel, error) {
+	var c teamsChannel
+	err := teamsChannelsCollection().FindOne(ctx, bson.M{"tenant_id": tenantFrom(ctx)}).Decode(&c)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = errNotFound
+	}
+	return c, err
+}
+
+func fetchTeamsChannel(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	c, err := findTeamsChannel(ctx)
+	if err != nil {
+		storeErr(w, r, "could not fetch Teams channel", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": c})
+}
+
+// saveTeamsChannel sets the incoming webhook of the tenant from
+// {"webhook_url", "events"}. Events defaults to all of created, completed
+// and overdue. The URL may be omitted to only change the events.
+func saveTeamsChannel(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		WebhookURL string   `json:"webhook_url"`
+		Events     []string `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid request", "error": err.Error()})
+		return
+	}
+	if req.WebhookURL != "" {
+		u, err := url.Parse(req.WebhookURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "webhook_url must be an absolute https URL", "error": "bad request"})
+			return
+		}
+		if err := checkOutboundURL(r.Context(), req.WebhookURL); err != nil {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "webhook_url " + strings.TrimPrefix(err.Error(), "url "), "error": "bad request"})
+			return
+		}
+	}
+	if len(req.Events) == 0 {
+		req.Events = teamsEvents
+	}
+	for _, e := range req.Events {
+		if !slices.Contains(teamsEvents, e) {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "events must be one of " + strings.Join(teamsEvents, ", "), "error": "bad request"})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	c, err := findTeamsChannel(ctx)
+	created := errors.Is(err, errNotFound)
+	switch {
+	case created && req.WebhookURL == "":
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "webhook_url is required", "error": "bad request"})
+		return
+	case created:
+		c = teamsChannel{ID: primitive.NewObjectID(), TenantID: tenantFrom(ctx), CreatedAt: time.Now()}
+	case err != nil:
+		storeErr(w, r, "could not save Teams channel", err)
+		return
+	}
+
+	c.Events = req.Events
+	c.UpdatedAt = time.Now()
+	set := bson.M{"events": c.Events, "updated_at": c.UpdatedAt}
+	if req.WebhookURL != "" {
+		if c.WebhookURL, err = encryptField(c.ID, req.WebhookURL); err != nil {
+			rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "could not save Teams channel", "error": err.Error()})
+			return
+		}
+		set["webhook_url"] = c.WebhookURL
+	}
+	if created {
+		// A channel saved concurrently makes this a duplicate key, 409.
+		_, err = teamsChannelsCollection().InsertOne(ctx, c)
+	} else {
+		_, err = teamsChannelsCollection().UpdateByID(ctx, c.ID, bson.M{"$set": set})
+	}
+	if err != nil {
+		storeErr(w, r, "could not save Teams channel", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": c})
+}
+
+func deleteTeamsChannel(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	res, err := teamsChannelsCollection().DeleteOne(ctx, bson.M{"tenant_id": tenantFrom(ctx)})
+	if err == nil && res.DeletedCount == 0 {
+		err = errNotFound
+	}
+	if err != nil {
+		storeErr(w, r, "could not delete Teams channel", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"message": "Teams channel deleted successfully"})
+}
+
+// testTeamsChannel posts a sample card, to check the webhook URL.
+func testTeamsChannel(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	c, err := findTeamsChannel(ctx)
+	if err != nil {
+		storeErr(w, r, "could not fetch Teams channel", err)
+		return
+	}
+	if err := postTeams(ctx, c, teamsCard("Test notification", "Todo notifications will be posted here.", nil)); err != nil {
+		rnd.JSON(w, http.StatusBadGateway, renderer.M{"message": "could not post to Teams", "error": err.Error()})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"message": "test notification posted"})
+}
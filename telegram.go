@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	telegramChatsCollectionName    = "telegram_chats"
+	telegramPairingsCollectionName = "telegram_pairings"
+
+	telegramPollTimeout = 50 * time.Second
+	telegramTimeout     = 10 * time.Second
+	telegramPairingTTL  = 10 * time.Minute
+	telegramCodeDigits  = 8
+	telegramListLimit   = 30
+)
+
+var (
+	// telegramAPI is the Bot API base URL, followed by the bot token.
+	telegramAPI = "https://api.telegram.org/bot"
+
+	telegramToken         string
+	telegramWebhookSecret string
+)
+
+// loadTelegramConfig enables the Telegram bot when TODO_TELEGRAM_BOT_TOKEN
+// is set. With TODO_TELEGRAM_WEBHOOK_SECRET updates are received at POST
+// /telegram/webhook, otherwise the server long-polls for them.
+func loadTelegramConfig() error {
+	telegramToken = os.Getenv("TODO_TELEGRAM_BOT_TOKEN")
+	telegramWebhookSecret = os.Getenv("TODO_TELEGRAM_WEBHOOK_SECRET")
+	if telegramToken == "" {
+		if telegramWebhookSecret != "" {
+			return errors.New("TODO_TELEGRAM_WEBHOOK_SECRET requires TODO_TELEGRAM_BOT_TOKEN")
+		}
+		return nil
+	}
+	if !usingMongo() {
+		return errors.New("the Telegram bot requires the mongo store")
+	}
+	return nil
+}
+
+func telegramEnabled() bool {
+	return telegramToken != ""
+}
+
+type (
+	// telegramChat links a Telegram chat to the tenant that paired it.
+	telegramChat struct {
+		ChatID   int64     `bson:"_id"`
+		TenantID string    `bson:"tenant_id"`
+		PairedAt time.Time `bson:"paired_at"`
+	}
+
+	// telegramPairing is a one-time code handed out to link a chat.
+	telegramPairing struct {
+		Code      string    `bson:"_id"`
+		TenantID  string    `bson:"tenant_id"`
+		ExpiresAt time.Time `bson:"expires_at"`
+	}
+
+	telegramUpdate struct {
+		UpdateID int64 `json:"update_id"`
+		Message  *struct {
+			Chat struct {
+				ID int64 `json:"id"`
+			} `json:"chat"`
+			Text string `json:"text"`
+		} `json:"message"`
+	}
+)
+
+func telegramChatsCollection() *mongo.Collection {
+	return db.Collection(telegramChatsCollectionName, writeCollectionOpts)
+}
+
+func telegramPairingsCollection() *mongo.Collection {
+	return db.Collection(telegramPairingsCollectionName, writeCollectionOpts)
+}
+
+// ensureTelegramIndexes expires pairing codes.
+func ensureTelegramIndexes(ctx context.Context) error {
+	if !telegramEnabled() {
+		return nil
+	}
+	_, err := telegramPairingsCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// telegramCall calls a Bot API method and decodes its result into out.
+func telegramCall(ctx context.Context, method string, params, out interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, telegramAPI+telegramToken+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		OK          bool            `json:"ok"`
+		Result      json.RawMessage `json:"result"`
+		Description string          `json:"description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 8<<20)).Decode(&res); err != nil {
+		return fmt.Errorf("telegram: %s: %s", method, resp.Status)
+	}
+	if !res.OK {
+		return fmt.Errorf("telegram: %s: %s", method, res.Description)
+	}
+	if out != nil {
+		return json.Unmarshal(res.Result, out)
+	}
+	return nil
+}
+
+// runTelegramPoller long-polls for updates until ctx is done. It is used
+// when no webhook secret is configured; Telegram allows only one poller per
+// bot, so run a single instance or use the webhook.
+func runTelegramPoller(ctx context.Context) {
+	if !telegramEnabled() || telegramWebhookSecret != "" {
+		return
+	}
+	var offset int64
+	attempt := 0
+	for ctx.Err() == nil {
+		pollCtx, cancel := context.WithTimeout(ctx, telegramPollTimeout+telegramTimeout)
+		var updates []telegramUpdate
+		err := telegramCall(pollCtx, "getUpdates", map[string]interface{}{
+			"offset":          offset,
+			"timeout":         int(telegramPollTimeout.Seconds()),
+			"allowed_updates": []string{"message"},
+		}, &updates)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			attempt++
+			slog.Error("could not fetch updates", "component", "telegram", "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff(attempt)):
+			}
+			continue
+		}
+		attempt = 0
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			handleTelegramUpdate(ctx, u)
+		}
+	}
+}
+
+// telegramWebhook receives updates pushed by Telegram. The secret token
+// registered with setWebhook is checked on every request.
+func telegramWebhook(w http.ResponseWriter, r *http.Request) {
+	if !telegramEnabled() || telegramWebhookSecret == "" {
+		rnd.JSON(w, http.StatusNotImplemented, renderer.M{"message": "the Telegram webhook is not configured", "error": "not implemented"})
+		return
+	}
+	got := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if subtle.ConstantTimeCompare([]byte(got), []byte(telegramWebhookSecret)) != 1 {
+		rnd.JSON(w, http.StatusUnauthorized, renderer.M{"message": "invalid secret token", "error": "unauthorized"})
+		return
+	}
+	var u telegramUpdate
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&u); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid update", "error": err.Error()})
+		return
+	}
+	handleTelegramUpdate(r.Context(), u)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleTelegramUpdate answers a chat message:
+//
+//	/pair <code>   links the chat to the tenant that created the code
+//	/add <title>   creates a todo
+//	/list          lists open todos, numbered
+//	/done <n>      completes the nth todo of /list
+func handleTelegramUpdate(ctx context.Context, u telegramUpdate) {
+	if u.Message == nil || u.Message.Text == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, telegramTimeout)
+	defer cancel()
+
+	chatID := u.Message.Chat.ID
+	cmd, arg, _ := strings.Cut(strings.TrimSpace(u.Message.Text), " ")
+	cmd, _, _ = strings.Cut(cmd, "@") // /list@SomeBot in groups
+	arg = strings.TrimSpace(arg)
+
+	var reply string
+	if cmd == "/pair" {
+		reply = telegramPair(ctx, chatID, arg)
+	} else {
+		var chat telegramChat
+		err := telegramChatsCollection().FindOne(ctx, bson.M{"_id": chatID}).Decode(&chat)
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			reply = "This chat is not linked yet. Create a pairing code and send /pair <code>."
+		case err != nil:
+			slog.Error("could not load chat", "component", "telegram", "chat", chatID, "error", err)
+			reply = "Sorry, something went wrong."
+		default:
+			reply = telegramCommand(withTenant(ctx, chat.TenantID), cmd, arg)
+		}
+	}
+
+	err := telegramCall(ctx, "sendMessage", map[string]interface{}{"chat_id": chatID, "text": reply}, nil)
+	if err != nil {
+		slog.Error("could not reply", "component", "telegram", "chat", chatID, "error", err)
+	}
+}
+
+func telegramPair(ctx context.Context, chatID int64, code string) string {
+	if code == "" {
+		return "Usage: /pair <code>"
+	}
+	var p telegramPairing
+	err := telegramPairingsCollection().FindOneAndDelete(ctx, bson.M{"_id": code, "expires_at": bson.M{"$gt": time.Now()}}).Decode(&p)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "That code is invalid or expired."
+	}
+	if err == nil {
+		_, err = telegramChatsCollection().ReplaceOne(ctx, bson.M{"_id": chatID},
+			telegramChat{ChatID: chatID, TenantID: p.TenantID, PairedAt: time.Now()},
+			options.Replace().SetUpsert(true))
+	}
+	if err != nil {
+		slog.Error("could not pair chat", "component", "telegram", "chat", chatID, "error", err)
+		return "Sorry, the chat could not be linked."
+	}
+	return "Linked! Try /add, /list and /done."
+}
+
+func telegramCommand(ctx context.Context, cmd, arg string) string {
+	switch cmd {
+	case "/add":
+		if arg == "" {
+			return "Usage: /add <title>"
+		}
+		if len([]rune(arg)) > maxTitleLength {
+			return "That title is too long."
+		}
+		tm := todoModel{ID: primitive.NewObjectID(), Title: arg, CreatedAt: time.Now()}
+		if err := repo.Create(ctx, tm); err != nil {
+			slog.Error("could not create todo", "component", "telegram", "error", err)
+			return "Sorry, the todo could not be created."
+		}
+		return "Added: " + arg
+	case "/list":
+		open, err := openTodos(ctx)
+		if err != nil {
+			return "Sorry, the todos could not be listed."
+		}
+		if len(open) == 0 {
+			return "Nothing to do."
+		}
+		var b strings.Builder
+		for i, tm := range open {
+			if i == telegramListLimit {
+				fmt.Fprintf(&b, "…and %d more\n", len(open)-i)
+				break
+			}
+			fmt.Fprintf(&b, "%d. %s\n", i+1, tm.Title)
+		}
+		return b.String()
+	case "/done":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return "Usage: /done <number from /list>"
+		}
+		open, err := openTodos(ctx)
+		if err != nil {
+			return "Sorry, the todos could not be listed."
+		}
+		if n > len(open) {
+			return "There is no todo " + arg + ", see /list."
+		}
+		tm := open[n-1]
+		if err := repo.Update(ctx, tm.ID, bson.M{"completed": true}); err != nil {
+			slog.Error("could not complete todo", "component", "telegram", "todo", tm.ID.Hex(), "error", err)
+			return "Sorry, the todo could not be completed."
+		}
+		return "Done: " + tm.Title
+	default:
+		return "Commands: /add <title>, /list, /done <number>"
+	}
+}
+
+// openTodos returns the open todos of the tenant of ctx, oldest first, in
+// the order /list numbers them.
+func openTodos(ctx context.Context) ([]todoModel, error) {
+	todos, err := repo.List(ctx)
+	if err != nil {
+		slog.Error("could not list todos", "component", "telegram", "error", err)
+		return nil, err
+	}
+	var open []todoModel
+	for _, tm := range todos {
+		if !tm.Completed {
+			open = append(open, tm)
+		}
+	}
+	slices.SortFunc(open, func(a, b todoModel) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return a.ID.Timestamp().Compare(b.ID.Timestamp())
+	})
+	return open, nil
+}
+
+// createTelegramPairing serves POST /telegram/pairing. The returned code
+// links the chat it is sent from with /pair to the tenant of the request.
+func createTelegramPairing(w http.ResponseWriter, r *http.Request) {
+	if !telegramEnabled() {
+		rnd.JSON(w, http.StatusNotImplemented, renderer.M{"message": "the Telegram bot is not configured", "error": "not implemented"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	n, err := rand.Int(rand.Reader, big.NewInt(100_000_000))
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "could not create pairing code", "error": err.Error()})
+		return
+	}
+	p := telegramPairing{
+		Code:      fmt.Sprintf("%0*d", telegramCodeDigits, n),
+		TenantID:  tenantFrom(ctx),
+		ExpiresAt: time.Now().Add(telegramPairingTTL),
+	}
+	if _, err := telegramPairingsCollection().InsertOne(ctx, p); err != nil {
+		storeErr(w, r, "could not create pairing code", err)
+		return
+	}
+	rnd.JSON(w, http.StatusCreated, renderer.M{"code": p.Code, "expires_at": p.ExpiresAt})
+}
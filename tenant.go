@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Tenant modes select where the tenant identifier is read from. With
+// tenancy enabled every todo carries a tenant_id and every query is scoped
+// to the tenant of the request.
+const (
+	tenantModeOff       = ""
+	tenantModeHeader    = "header"
+	tenantModeSubdomain = "subdomain"
+
+	tenantHeader = "X-Tenant-ID"
+)
+
+var (
+	tenantMode   string
+	tenantDomain string
+	validTenant  = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+)
+
+type tenantKey struct{}
+
+// loadTenantConfig reads TODO_TENANT_MODE and, for subdomain mode,
+// TODO_TENANT_DOMAIN (e.g. todo.example.com for acme.todo.example.com).
+func loadTenantConfig() error {
+	tenantMode = os.Getenv("TODO_TENANT_MODE")
+	tenantDomain = strings.ToLower(os.Getenv("TODO_TENANT_DOMAIN"))
+
+	switch tenantMode {
+	case tenantModeOff, tenantModeHeader:
+	case tenantModeSubdomain:
+		if tenantDomain == "" {
+			return fmt.Errorf("TODO_TENANT_DOMAIN is required in subdomain tenant mode")
+		}
+	default:
+		return fmt.Errorf("invalid tenant mode %q", tenantMode)
+	}
+	return nil
+}
+
+// withTenant returns a copy of ctx scoped to tenant.
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// tenantFrom returns the tenant ctx is scoped to, or "" without tenancy.
+func tenantFrom(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// scoped adds the tenant discriminator of ctx to a query filter.
+func scoped(ctx context.Context, filter bson.M) bson.M {
+	if tenant := tenantFrom(ctx); tenant != "" {
+		filter["tenant_id"] = tenant
+	}
+	return filter
+}
+
+// tenantMiddleware resolves the tenant of each request and rejects requests
+// without one when tenancy is enabled.
+func tenantMiddleware(next http.Handler) http.Handler {
+	if tenantMode == tenantModeOff {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := requestTenant(r)
+		if !validTenant.MatchString(tenant) {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "missing or invalid tenant", "error": "bad request"})
+			return
+		}
+		noteTenant(r.Context(), tenant)
+		next.ServeHTTP(w, r.WithContext(withTenant(r.Context(), tenant)))
+	})
+}
+
+func requestTenant(r *http.Request) string {
+	if tenantMode == tenantModeHeader {
+		return strings.ToLower(r.Header.Get(tenantHeader))
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if !strings.HasSuffix(host, "."+tenantDomain) {
+		return ""
+	}
+	return strings.TrimSuffix(host, "."+tenantDomain)
+}
+
+// ensureTenantIndex creates the index backing tenant scoped queries.
+func ensureTenantIndex(ctx context.Context) error {
+	if tenantMode == tenantModeOff {
+		return nil
+	}
+	_, err := writeCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "createAt", Value: 1}},
+	})
+	return err
+}
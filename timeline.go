@@ -0,0 +1,123 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	// timelineItem is a bar of GET /lists/{id}/timeline, from the start
+	// date of a todo, or its creation without one, to its due date.
+	timelineItem struct {
+		ID        string    `json:"id"`
+		Title     string    `json:"title"`
+		Column    string    `json:"column"`
+		Completed bool      `json:"completed"`
+		Start     time.Time `json:"start"`
+		End       time.Time `json:"end"`
+		Overdue   bool      `json:"overdue"`
+		// DependsOn are the items this one waits for.
+		DependsOn []string `json:"depends_on"`
+	}
+
+	// timelineEdge is a dependency between two items: To waits for From.
+	// Late tells that To starts before From ends.
+	timelineEdge struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+		Late bool   `json:"late"`
+	}
+
+	// timeline is the data of GET /lists/{id}/timeline. Start and End span
+	// the items, and are nil without any.
+	timeline struct {
+		List  string         `json:"list"`
+		Start *time.Time     `json:"start"`
+		End   *time.Time     `json:"end"`
+		Items []timelineItem `json:"items"`
+		Edges []timelineEdge `json:"edges"`
+		// Unscheduled are the todos without a due date, which have no bar.
+		Unscheduled []todo `json:"unscheduled"`
+	}
+)
+
+// buildTimeline lays the todos of list out for a Gantt chart: the todos
+// with a due date as bars ordered by start, then end, the others apart.
+// Dependencies between bars become edges in the order of the items;
+// those on todos without a bar, or of other lists, are left out.
+func buildTimeline(list string, todos []todoModel, now time.Time) timeline {
+	tl := timeline{List: list, Items: []timelineItem{}, Edges: []timelineEdge{}, Unscheduled: []todo{}}
+	deps := map[string][]primitive.ObjectID{}
+	for _, t := range todos {
+		if t.DueAt == nil {
+			tl.Unscheduled = append(tl.Unscheduled, toTodo(t))
+			continue
+		}
+		item := timelineItem{
+			ID:        t.ID.Hex(),
+			Title:     t.Title,
+			Column:    boardColumns[columnOf(t)],
+			Completed: t.Completed,
+			Start:     t.CreatedAt,
+			End:       *t.DueAt,
+			Overdue:   !t.Completed && t.DueAt.Before(now),
+			DependsOn: []string{},
+		}
+		if t.StartDate != nil {
+			item.Start = *t.StartDate
+		}
+		// Imported todos can be due before they were created here.
+		if item.End.Before(item.Start) {
+			item.Start = item.End
+		}
+		tl.Items = append(tl.Items, item)
+		deps[item.ID] = t.DependsOn
+		if tl.Start == nil || item.Start.Before(*tl.Start) {
+			tl.Start = &item.Start
+		}
+		if tl.End == nil || item.End.After(*tl.End) {
+			tl.End = &item.End
+		}
+	}
+	slices.SortStableFunc(tl.Items, func(a, b timelineItem) int {
+		return cmp.Or(a.Start.Compare(b.Start), a.End.Compare(b.End))
+	})
+
+	items := make(map[string]*timelineItem, len(tl.Items))
+	for i := range tl.Items {
+		items[tl.Items[i].ID] = &tl.Items[i]
+	}
+	for i := range tl.Items {
+		to := &tl.Items[i]
+		for _, id := range deps[to.ID] {
+			from, ok := items[id.Hex()]
+			if !ok || from == to || slices.Contains(to.DependsOn, from.ID) {
+				continue
+			}
+			to.DependsOn = append(to.DependsOn, from.ID)
+			tl.Edges = append(tl.Edges, timelineEdge{From: from.ID, To: to.ID, Late: to.Start.Before(from.End)})
+		}
+	}
+	return tl
+}
+
+// fetchTimeline serves GET /lists/{id}/timeline, the todos of a list
+// shaped for Gantt-style rendering.
+func fetchTimeline(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	list := listParam(r)
+	board, err := loadBoard(ctx, list)
+	if err != nil {
+		storeErr(w, r, "could not fetch timeline", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": buildTimeline(list, slices.Concat(board...), time.Now())})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBuildTimeline(t *testing.T) {
+	day := func(d int) *time.Time {
+		t := time.Date(2024, 5, d, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	id := func(n int) primitive.ObjectID {
+		objID, _ := primitive.ObjectIDFromHex("66320000000000000000000" + string(rune('0'+n)))
+		return objID
+	}
+	todos := []todoModel{
+		// Designed before it is built, but starts too early.
+		{ID: id(1), Title: "Build", CreatedAt: *day(1), StartDate: day(3), DueAt: day(9), DependsOn: []primitive.ObjectID{id(2), id(3), id(9)}},
+		{ID: id(2), Title: "Design", CreatedAt: *day(1), DueAt: day(4)},
+		// No due date, so no bar and no edge.
+		{ID: id(3), Title: "Budget", CreatedAt: *day(1)},
+		{ID: id(4), Title: "Ship", CreatedAt: *day(2), StartDate: day(9), DueAt: day(10), DependsOn: []primitive.ObjectID{id(1), id(1)}},
+		// Due before it was imported.
+		{ID: id(5), Title: "Imported", CreatedAt: *day(6), DueAt: day(5)},
+	}
+	tl := buildTimeline("work", todos, *day(7))
+
+	var order []string
+	for _, item := range tl.Items {
+		order = append(order, item.Title)
+	}
+	if want := []string{"Design", "Build", "Imported", "Ship"}; !slices.Equal(order, want) {
+		t.Errorf("items %q, want %q", order, want)
+	}
+	if !tl.Start.Equal(*day(1)) || !tl.End.Equal(*day(10)) {
+		t.Errorf("spans %v to %v", tl.Start, tl.End)
+	}
+	build := tl.Items[1]
+	if !build.Start.Equal(*day(3)) || !slices.Equal(build.DependsOn, []string{id(2).Hex()}) || build.Overdue {
+		t.Errorf("build item %+v", build)
+	}
+	if imported := tl.Items[2]; !imported.Start.Equal(imported.End) || !imported.Overdue {
+		t.Errorf("imported item %+v", imported)
+	}
+
+	want := []timelineEdge{
+		{From: id(2).Hex(), To: id(1).Hex(), Late: true},
+		{From: id(1).Hex(), To: id(4).Hex(), Late: false},
+	}
+	if !slices.Equal(tl.Edges, want) {
+		t.Errorf("edges %+v, want %+v", tl.Edges, want)
+	}
+	if len(tl.Unscheduled) != 1 || tl.Unscheduled[0].Title != "Budget" {
+		t.Errorf("unscheduled %+v", tl.Unscheduled)
+	}
+}
+
+func TestValidateDependencies(t *testing.T) {
+	due := time.Date(2024, 5, 9, 0, 0, 0, 0, time.UTC)
+	after := due.Add(time.Hour)
+	tests := []struct {
+		todo todo
+		want string
+	}{
+		{todo{Title: "a", DueAt: &due, StartDate: &due, DependsOn: []string{"663200000000000000000001"}}, ""},
+		{todo{Title: "a", DueAt: &due, StartDate: &after}, "start_date must not be after due_at"},
+		{todo{Title: "a", DependsOn: []string{"nope"}}, "depends_on must hold todo ids"},
+		{todo{Title: "a", DependsOn: make([]string, maxDependencies+1)}, "too many dependencies"},
+	}
+	for _, tt := range tests {
+		if got := validateNewTodo(tt.todo); got != tt.want {
+			t.Errorf("validateNewTodo(%+v) = %q, want %q", tt.todo, got, tt.want)
+		}
+	}
+}
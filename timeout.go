@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// routeDuration applies to the paths under prefix, such as a handler
+// deadline. Zero means none.
+type routeDuration struct {
+	prefix string
+	d      time.Duration
+}
+
+// Handler deadline settings, see loadTimeoutConfig.
+var (
+	handlerTimeout = 30 * time.Second
+	// routeTimeouts are ordered from the longest prefix.
+	routeTimeouts []routeDuration
+)
+
+// streamingPrefixes are never given a deadline: their connections stay
+// open for as long as the client listens, or a profile runs.
+var streamingPrefixes = []string{"/ws", "/todo/events", "/graphql/stream", gatewayWatchPath, "/debug", "/dev/reload"}
+
+// loadTimeoutConfig reads TODO_HANDLER_TIMEOUT, the deadline of handlers,
+// and TODO_ROUTE_TIMEOUTS, deadlines of path prefixes overriding it, such
+// as "/import=55s,/todo/stats=10s". They default to 30s, or the write
+// timeout when it is shorter, since the connection is closed then anyway.
+func loadTimeoutConfig() error {
+	handlerTimeout = min(30*time.Second, writeTimeout)
+	if v := os.Getenv("TODO_HANDLER_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid TODO_HANDLER_TIMEOUT %q, must be a positive duration like 30s", v)
+		}
+		handlerTimeout = d
+	}
+
+	routeTimeouts = nil
+	for _, p := range streamingPrefixes {
+		routeTimeouts = append(routeTimeouts, routeDuration{prefix: p})
+	}
+	for _, entry := range strings.Split(os.Getenv("TODO_ROUTE_TIMEOUTS"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		prefix, v, ok := strings.Cut(entry, "=")
+		prefix = strings.TrimRight(strings.TrimSpace(prefix), "/")
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("invalid route timeout %q, expected /path=duration", entry)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid route timeout %q, expected /path=duration", entry)
+		}
+		if slices.ContainsFunc(streamingPrefixes, func(s string) bool { return underPrefix(prefix, s) }) {
+			return fmt.Errorf("route timeout %q: streaming routes have no deadline", entry)
+		}
+		routeTimeouts = append(routeTimeouts, routeDuration{prefix: prefix, d: d})
+	}
+	slices.SortStableFunc(routeTimeouts, func(a, b routeDuration) int { return len(b.prefix) - len(a.prefix) })
+	return nil
+}
+
+func underPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// timeoutFor returns the handler deadline of path.
+func timeoutFor(path string) time.Duration {
+	for _, t := range routeTimeouts {
+		if underPrefix(path, t.prefix) {
+			return t.d
+		}
+	}
+	return handlerTimeout
+}
+
+// timeoutMiddleware gives handlers a context with the deadline of their
+// route. A handler that runs out of time answers 504, whether it wrote
+// nothing or an error caused by the expired context.
+func timeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := timeoutFor(r.URL.Path)
+		if d == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		tw := &timeoutWriter{ResponseWriter: w, ctx: ctx}
+		next.ServeHTTP(tw, r.WithContext(ctx))
+		if !tw.wrote && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			tw.timedOut()
+		}
+	})
+}
+
+// timeoutWriter replaces the error a handler answers once its deadline
+// passed by a 504.
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	wrote   bool
+	swallow bool
+}
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+	if status >= 400 && errors.Is(w.ctx.Err(), context.DeadlineExceeded) {
+		w.swallow = true
+		w.timedOut()
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.swallow {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timeoutWriter) timedOut() {
+	w.wrote = true
+	w.ResponseWriter.Header().Del("Content-Length")
+	rnd.JSON(w.ResponseWriter, http.StatusGatewayTimeout, renderer.M{"message": "the request took too long", "error": "gateway timeout"})
+}
+
+func (w *timeoutWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// keepTimeouts restores the handler deadlines when the test ends.
+func keepTimeouts(t *testing.T) {
+	prevTimeout, prevRoutes := handlerTimeout, routeTimeouts
+	t.Cleanup(func() { handlerTimeout, routeTimeouts = prevTimeout, prevRoutes })
+}
+
+func TestTimeoutFor(t *testing.T) {
+	keepTimeouts(t)
+	t.Setenv("TODO_HANDLER_TIMEOUT", "30s")
+	t.Setenv("TODO_ROUTE_TIMEOUTS", "/import=55s, /todo/stats/=10s,/todo=20s")
+	if err := loadTimeoutConfig(); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]time.Duration{
+		"/":                    30 * time.Second,
+		"/todox":               30 * time.Second,
+		"/todo":                20 * time.Second,
+		"/todo/66320000":       20 * time.Second,
+		"/todo/stats":          10 * time.Second,
+		"/import/todoist":      55 * time.Second,
+		"/ws":                  0,
+		"/todo/events":         0,
+		"/debug/pprof/profile": 0,
+	} {
+		if got := timeoutFor(path); got != want {
+			t.Errorf("timeoutFor(%s) = %s, want %s", path, got, want)
+		}
+	}
+
+	for _, v := range []string{"/import", "import=5s", "/import=0s", "/ws/x=5s"} {
+		t.Setenv("TODO_ROUTE_TIMEOUTS", v)
+		if err := loadTimeoutConfig(); err == nil {
+			t.Errorf("TODO_ROUTE_TIMEOUTS=%s accepted", v)
+		}
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	prevRnd := rnd
+	rnd = renderer.New()
+	t.Cleanup(func() { rnd = prevRnd })
+	keepTimeouts(t)
+	t.Setenv("TODO_HANDLER_TIMEOUT", "20ms")
+	t.Setenv("TODO_ROUTE_TIMEOUTS", "")
+	if err := loadTimeoutConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+	}{
+		{"in time", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		}, http.StatusCreated},
+		{"nothing written", func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}, http.StatusGatewayTimeout},
+		{"error once expired", func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			http.Error(w, r.Context().Err().Error(), http.StatusInternalServerError)
+		}, http.StatusGatewayTimeout},
+		// A handler that got its answer out late is not undone.
+		{"success once expired", func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			w.Write([]byte("[]"))
+		}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			timeoutMiddleware(tt.handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todo", nil))
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	timeoutMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); ok {
+			t.Error("a streaming route got a deadline")
+		}
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ws", nil))
+}
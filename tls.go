@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLS settings, see loadTLSConfig.
+var (
+	tlsCertFile string
+	tlsKeyFile  string
+	// autocertManager obtains certificates from Let's Encrypt when
+	// TODO_AUTOCERT_HOSTS is set.
+	autocertManager *autocert.Manager
+	// redirectAddr is where plain HTTP is redirected to HTTPS, and ACME
+	// http-01 challenges are answered.
+	redirectAddr string
+)
+
+// loadTLSConfig reads TODO_TLS_CERT_FILE and TODO_TLS_KEY_FILE to serve
+// HTTPS with a certificate, or TODO_AUTOCERT_HOSTS to obtain one for the
+// listed hosts, cached in TODO_AUTOCERT_CACHE. TODO_HTTP_REDIRECT_ADDR
+// serves the redirect to HTTPS, on :80 by default with autocert.
+func loadTLSConfig() error {
+	tlsCertFile = os.Getenv("TODO_TLS_CERT_FILE")
+	tlsKeyFile = os.Getenv("TODO_TLS_KEY_FILE")
+	hosts := os.Getenv("TODO_AUTOCERT_HOSTS")
+	redirectAddr = os.Getenv("TODO_HTTP_REDIRECT_ADDR")
+	if ln, ok := inheritedListeners["redirect"]; ok && redirectAddr == "" {
+		redirectAddr = ln.Addr().String()
+	}
+
+	switch {
+	case (tlsCertFile == "") != (tlsKeyFile == ""):
+		return errors.New("TODO_TLS_CERT_FILE and TODO_TLS_KEY_FILE must be set together")
+	case tlsCertFile != "" && hosts != "":
+		return errors.New("TODO_AUTOCERT_HOSTS cannot be combined with TODO_TLS_CERT_FILE")
+	case tlsCertFile != "":
+		if _, err := tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile); err != nil {
+			return err
+		}
+	case hosts != "":
+		var names []string
+		for _, h := range strings.Split(hosts, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				names = append(names, h)
+			}
+		}
+		autocertManager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(names...),
+			Cache:      autocert.DirCache(envOr("TODO_AUTOCERT_CACHE", "autocert-cache")),
+			Email:      os.Getenv("TODO_AUTOCERT_EMAIL"),
+		}
+		if redirectAddr == "" {
+			redirectAddr = ":80"
+		}
+	case redirectAddr != "":
+		return errors.New("TODO_HTTP_REDIRECT_ADDR requires TLS")
+	}
+	if redirectAddr != "" {
+		if _, _, err := net.SplitHostPort(redirectAddr); err != nil {
+			return fmt.Errorf("invalid TODO_HTTP_REDIRECT_ADDR %q: %w", redirectAddr, err)
+		}
+	}
+	return nil
+}
+
+func tlsEnabled() bool {
+	return tlsCertFile != "" || autocertManager != nil
+}
+
+// listen serves srv on the socket passed by systemd or its address, over
+// TLS when it is configured, which negotiates HTTP/2 unless disabled.
+// systemd is told the server is ready once it accepts connections.
+func listen(srv *http.Server) error {
+	ln, err := listener("api", srv.Addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("listening", "addr", ln.Addr().String(), "tls", tlsEnabled(), "http2", http2Enabled && tlsEnabled() || h2cEnabled)
+	notifySystemd("READY=1")
+	notifyUpgraded()
+	switch {
+	case autocertManager != nil:
+		srv.TLSConfig = autocertManager.TLSConfig()
+		disableHTTP2(srv)
+		return srv.ServeTLS(ln, "", "")
+	case tlsCertFile != "":
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		disableHTTP2(srv)
+		return srv.ServeTLS(ln, tlsCertFile, tlsKeyFile)
+	default:
+		return srv.Serve(ln)
+	}
+}
+
+// redirectServer returns the server redirecting plain HTTP to HTTPS, or
+// nil when there is none. With autocert it also answers the http-01
+// challenges.
+func redirectServer() *http.Server {
+	if redirectAddr == "" {
+		return nil
+	}
+	var h http.Handler = http.HandlerFunc(redirectToHTTPS)
+	if autocertManager != nil {
+		h = autocertManager.HTTPHandler(h)
+	}
+	return limitSlowClients(&http.Server{
+		Addr:         redirectAddr,
+		Handler:      h,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	})
+}
+
+// redirectToHTTPS sends the request to the same URL over HTTPS on the
+// port of the main listener.
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if _, port, err := net.SplitHostPort(listenAddr); err == nil && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// serveRedirect runs the redirect server until it is shut down.
+func serveRedirect(srv *http.Server) {
+	slog.Info("redirecting to HTTPS", "addr", srv.Addr)
+	ln, err := listener("redirect", srv.Addr)
+	if err == nil {
+		err = srv.Serve(ln)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("redirect listener failed", "error", err)
+	}
+}
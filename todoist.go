@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// todoistSyncURL is the Todoist Sync API endpoint used with an API token.
+var todoistSyncURL = "https://api.todoist.com/sync/v9/sync"
+
+// todoistExport is the part of a Todoist Sync API response the importer
+// reads. The same JSON can be uploaded as an export file.
+type todoistExport struct {
+	Projects []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"projects"`
+	Items []struct {
+		ID        string   `json:"id"`
+		ProjectID string   `json:"project_id"`
+		Content   string   `json:"content"`
+		Priority  int      `json:"priority"`
+		Labels    []string `json:"labels"`
+		Checked   bool     `json:"checked"`
+		IsDeleted bool     `json:"is_deleted"`
+		AddedAt   string   `json:"added_at"`
+		Due       *struct {
+			Date        string `json:"date"`
+			IsRecurring bool   `json:"is_recurring"`
+		} `json:"due"`
+	} `json:"items"`
+}
+
+// importTodoist serves POST /import/todoist. The body is either a Todoist
+// Sync API response with projects and items, or {"token": "..."} to fetch
+// one with that API token. ?dry_run=true only returns the report.
+//
+// Projects become lists. Todoist priorities run from 4, urgent, to 1,
+// none, and map to priority 1 to 3 or no priority.
+func importTodoist(w http.ResponseWriter, r *http.Request) {
+	dry, ok := dryRun(w, r)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBodySize))
+	if err != nil {
+		rnd.JSON(w, http.StatusRequestEntityTooLarge, renderer.M{"message": "import is too large", "error": err.Error()})
+		return
+	}
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid request", "error": err.Error()})
+		return
+	}
+	if req.Token != "" {
+		if body, err = fetchTodoist(r.Context(), req.Token); err != nil {
+			rnd.JSON(w, http.StatusBadGateway, renderer.M{"message": "could not fetch from Todoist", "error": err.Error()})
+			return
+		}
+	}
+
+	var export todoistExport
+	if err := json.Unmarshal(body, &export); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid Todoist export", "error": err.Error()})
+		return
+	}
+	if len(export.Items) > maxImportTodos {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": fmt.Sprintf("at most %d tasks can be imported at once", maxImportTodos), "error": "bad request"})
+		return
+	}
+
+	rep, todos := mapTodoist(export)
+	rep.DryRun = dry
+	finishImport(w, r, rep, todos)
+}
+
+func fetchTodoist(ctx context.Context, token string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	form := url.Values{"sync_token": {"*"}, "resource_types": {`["projects","items"]`}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, todoistSyncURL, bytes.NewBufferString(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxImportBodySize))
+}
+
+func mapTodoist(export todoistExport) (*importReport, []todoModel) {
+	rep := &importReport{Source: "todoist"}
+	projects := map[string]string{}
+	for _, p := range export.Projects {
+		name := truncate(p.Name, maxListLength)
+		projects[p.ID] = name
+		rep.Lists = append(rep.Lists, name)
+	}
+
+	var todos []todoModel
+	for _, it := range export.Items {
+		if it.IsDeleted {
+			continue
+		}
+		rep.Found++
+		title := strings.TrimSpace(it.Content)
+		if title == "" {
+			rep.Skipped++
+			rep.warnf("task %s: empty content, skipped", it.ID)
+			continue
+		}
+		if len([]rune(title)) > maxTitleLength {
+			rep.warnf("task %s: content truncated to %d characters", it.ID, maxTitleLength)
+			title = truncate(title, maxTitleLength)
+		}
+
+		tm := todoModel{
+			ID:        primitive.NewObjectID(),
+			Title:     title,
+			Completed: it.Checked,
+			CreatedAt: time.Now(),
+			List:      projects[it.ProjectID],
+			Source:    "todoist:" + it.ID,
+		}
+		if t, err := time.Parse(time.RFC3339Nano, it.AddedAt); err == nil {
+			tm.CreatedAt = t
+		}
+		if it.Priority >= 2 && it.Priority <= 4 {
+			tm.Priority = 5 - it.Priority
+		}
+		for _, l := range it.Labels {
+			if len(tm.Labels) == maxLabels {
+				rep.warnf("task %s: only the first %d labels were kept", it.ID, maxLabels)
+				break
+			}
+			if l = truncate(strings.TrimSpace(l), maxLabelLength); l != "" {
+				tm.Labels = append(tm.Labels, l)
+			}
+		}
+		if it.Due != nil {
+			if due, ok := parseDueDate(it.Due.Date); ok {
+				tm.DueAt = &due
+			} else {
+				rep.warnf("task %s: unrecognized due date %q dropped", it.ID, it.Due.Date)
+			}
+			if it.Due.IsRecurring {
+				rep.warnf("task %s: recurrence is not supported, only the next due date was kept", it.ID)
+			}
+		}
+		todos = append(todos, tm)
+	}
+	return rep, todos
+}
+
+// parseDueDate accepts a date, a floating date and time, or a date and time
+// with zone. Dates without zone are taken as UTC.
+func parseDueDate(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+const (
+	traceQueueSize     = 4096
+	traceBatchSize     = 512
+	traceFlushInterval = 5 * time.Second
+	traceExportTimeout = 10 * time.Second
+
+	// Span kinds and status codes of OTLP.
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+	spanStatusError  = 2
+)
+
+var (
+	// otlpEndpoint is the OTLP/HTTP traces URL spans are exported to.
+	// Tracing is off without one.
+	otlpEndpoint string
+	// traceSampling is the share of new traces that are recorded, see
+	// loadTraceSamplingSettings. Traces started upstream follow the
+	// sampled flag of traceparent.
+	traceSampling atomic.Pointer[samplingPolicy]
+	// traceErrors records the server span of requests answered 5xx even
+	// when their trace is not sampled.
+	traceErrors      atomic.Bool
+	traceServiceName = "todo"
+
+	spanQueue = make(chan *span, traceQueueSize)
+	// mongoSpans holds the spans of MongoDB commands in flight by request
+	// ID, until the command succeeds or fails.
+	mongoSpans sync.Map
+)
+
+func loadTracingConfig() error {
+	endpoint := os.Getenv("TODO_OTLP_ENDPOINT")
+	if endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid TODO_OTLP_ENDPOINT %q", endpoint)
+	}
+	// Like OTEL_EXPORTER_OTLP_ENDPOINT, a base URL gets the traces path.
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	otlpEndpoint = u.String()
+	traceServiceName = envOr("TODO_SERVICE_NAME", traceServiceName)
+	return nil
+}
+
+// loadTraceSamplingSettings reads TODO_TRACE_SAMPLE_RATIO, the share of
+// new traces recorded, TODO_TRACE_SAMPLE_ROUTES, shares of path prefixes
+// overriding it, and TODO_TRACE_ERRORS, whether requests answered 5xx are
+// recorded whatever the sampling decided, true by default.
+func loadTraceSamplingSettings() (func(), error) {
+	p, err := loadSamplingPolicy("TODO_TRACE_SAMPLE_RATIO", "TODO_TRACE_SAMPLE_ROUTES")
+	if err != nil {
+		return nil, err
+	}
+	errs := true
+	if v := os.Getenv("TODO_TRACE_ERRORS"); v != "" {
+		if errs, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid TODO_TRACE_ERRORS %q, must be true or false", v)
+		}
+	}
+	return func() {
+		traceSampling.Store(p)
+		traceErrors.Store(errs)
+	}, nil
+}
+
+func tracingEnabled() bool {
+	return otlpEndpoint != ""
+}
+
+// span is a unit of work of a trace. Spans that are not sampled are still
+// carried in the context so their trace ID propagates, but never
+// exported.
+type span struct {
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte
+	sampled bool
+
+	name  string
+	kind  int
+	start time.Time
+	end   time.Time
+	attrs map[string]any
+	err   string
+}
+
+type spanKey struct{}
+
+func spanFrom(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// startSpan starts a span that is a child of the span of ctx, or the root
+// of a new trace.
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	s := &span{name: name, kind: kind, start: time.Now(), attrs: map[string]any{}}
+	rand.Read(s.spanID[:])
+	if p := spanFrom(ctx); p != nil {
+		s.traceID, s.parent, s.sampled = p.traceID, p.spanID, p.sampled
+	} else {
+		rand.Read(s.traceID[:])
+		s.sampled = sampleTrace(s.traceID, traceSampling.Load().ratio)
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// sampleTrace decides on a new trace from its ID, so every instance
+// decides the same for a given trace.
+func sampleTrace(traceID [16]byte, ratio float64) bool {
+	n := binary.BigEndian.Uint64(traceID[8:])
+	return float64(n>>11)/(1<<53) < ratio
+}
+
+func (s *span) set(key string, value any) {
+	s.attrs[key] = value
+}
+
+// finish ends the span and queues it for export. Spans are dropped when
+// the exporter falls behind.
+func (s *span) finish() {
+	s.end = time.Now()
+	if !s.sampled || !tracingEnabled() {
+		return
+	}
+	select {
+	case spanQueue <- s:
+	default:
+	}
+}
+
+// parseTraceparent reads a W3C traceparent header into a remote parent
+// span.
+func parseTraceparent(h string) (*span, bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return nil, false
+	}
+	var s span
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return nil, false
+	}
+	if _, err := hex.Decode(s.traceID[:], []byte(parts[1])); err != nil || s.traceID == [16]byte{} {
+		return nil, false
+	}
+	if _, err := hex.Decode(s.spanID[:], []byte(parts[2])); err != nil || s.spanID == [8]byte{} {
+		return nil, false
+	}
+	s.sampled = flags[0]&1 == 1
+	return &s, true
+}
+
+// tracingMiddleware records a server span per request, continuing the
+// trace of an incoming traceparent header. New traces are sampled by the
+// policy of their path.
+func tracingMiddleware(next http.Handler) http.Handler {
+	if !tracingEnabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		parent, continued := parseTraceparent(r.Header.Get("traceparent"))
+		if continued {
+			ctx = context.WithValue(ctx, spanKey{}, parent)
+		}
+		ctx, s := startSpan(ctx, r.Method, spanKindServer)
+		if !continued {
+			s.sampled = sampleTrace(s.traceID, traceSampling.Load().ratioFor(r.URL.Path))
+		}
+		s.set("http.request.method", r.Method)
+		s.set("url.path", r.URL.Path)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+			s.name = r.Method + " " + rctx.RoutePattern()
+			s.set("http.route", rctx.RoutePattern())
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		s.set("http.response.status_code", status)
+		if status >= 500 {
+			s.err = http.StatusText(status)
+			// Only this span: the others of the request were dropped.
+			s.sampled = s.sampled || traceErrors.Load()
+		}
+		s.finish()
+	})
+}
+
+func traceMongoStarted(ctx context.Context, e *event.CommandStartedEvent) {
+	// Commands outside of a sampled request, like those of background
+	// workers, are not traced.
+	if p := spanFrom(ctx); p == nil || !p.sampled {
+		return
+	}
+	_, s := startSpan(ctx, "mongodb "+e.CommandName, spanKindClient)
+	s.set("db.system", "mongodb")
+	s.set("db.name", e.DatabaseName)
+	s.set("db.operation", e.CommandName)
+	mongoSpans.Store(e.RequestID, s)
+}
+
+func traceMongoFinished(requestID int64, failure string) {
+	v, ok := mongoSpans.LoadAndDelete(requestID)
+	if !ok {
+		return
+	}
+	s := v.(*span)
+	s.err = failure
+	s.finish()
+}
+
+// runTraceExporter sends the finished spans to the OTLP endpoint in
+// batches until ctx is done, then flushes what is left.
+func runTraceExporter(ctx context.Context) {
+	if !tracingEnabled() {
+		return
+	}
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+
+	var batch []*span
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := exportSpans(ctx, batch); err != nil {
+			slog.Error("could not export spans", "component", "tracing", "count", len(batch), "error", err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case s := <-spanQueue:
+			if batch = append(batch, s); len(batch) >= traceBatchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		case <-ctx.Done():
+			for len(spanQueue) > 0 {
+				batch = append(batch, <-spanQueue)
+			}
+			flushCtx, cancel := context.WithTimeout(context.Background(), traceExportTimeout)
+			flush(flushCtx)
+			cancel()
+			return
+		}
+	}
+}
+
+// otlpValue encodes an attribute value in the OTLP JSON encoding.
+func otlpValue(v any) map[string]any {
+	switch v := v.(type) {
+	case int:
+		return map[string]any{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case bool:
+		return map[string]any{"boolValue": v}
+	default:
+		return map[string]any{"stringValue": fmt.Sprint(v)}
+	}
+}
+
+func otlpAttributes(attrs map[string]any) []map[string]any {
+	out := []map[string]any{}
+	for k, v := range attrs {
+		out = append(out, map[string]any{"key": k, "value": otlpValue(v)})
+	}
+	return out
+}
+
+// exportSpans posts spans to the endpoint with the JSON encoding of
+// OTLP/HTTP.
+func exportSpans(ctx context.Context, spans []*span) error {
+	encoded := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		m := map[string]any{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+		}
+		if s.parent != [8]byte{} {
+			m["parentSpanId"] = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != "" {
+			m["status"] = map[string]any{"code": spanStatusError, "message": s.err}
+		}
+		encoded = append(encoded, m)
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []map[string]any{{
+			"resource": map[string]any{
+				"attributes": otlpAttributes(map[string]any{"service.name": traceServiceName}),
+			},
+			"scopeSpans": []map[string]any{{
+				"scope": map[string]any{"name": "github.com/qasim-invodev/todo"},
+				"spans": encoded,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, traceExportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, otlpEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("unexpected status " + resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// trelloExport is the part of a Trello board JSON export the importer reads.
+type trelloExport struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Lists []struct {
+		ID     string `json:"id"`
+		Closed bool   `json:"closed"`
+	} `json:"lists"`
+	Labels []struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		Color string `json:"color"`
+	} `json:"labels"`
+	Cards []struct {
+		ID           string   `json:"id"`
+		Name         string   `json:"name"`
+		IDList       string   `json:"idList"`
+		IDLabels     []string `json:"idLabels"`
+		IDChecklists []string `json:"idChecklists"`
+		Closed       bool     `json:"closed"`
+		Due          string   `json:"due"`
+		DueComplete  bool     `json:"dueComplete"`
+	} `json:"cards"`
+	Checklists []struct {
+		ID         string `json:"id"`
+		Name       string `json:"name"`
+		CheckItems []struct {
+			ID    string `json:"id"`
+			Name  string `json:"name"`
+			State string `json:"state"`
+		} `json:"checkItems"`
+	} `json:"checklists"`
+}
+
+// importTrello serves POST /import/trello with the JSON export of a board,
+// or a JSON array of them. ?dry_run=true only returns the report.
+//
+// Each board becomes a list and each open card a todo, completed when its
+// due date is marked complete. Checklist items become todos of their own,
+// titled "<card>: <item>", and carry the card's labels. Labels without a
+// name use their color. Cards are matched by their Trello id, so exporting
+// a board again and re-importing it only adds the new cards.
+func importTrello(w http.ResponseWriter, r *http.Request) {
+	dry, ok := dryRun(w, r)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBodySize))
+	if err != nil {
+		rnd.JSON(w, http.StatusRequestEntityTooLarge, renderer.M{"message": "import is too large", "error": err.Error()})
+		return
+	}
+
+	var boards []trelloExport
+	if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "[") {
+		err = json.Unmarshal(body, &boards)
+	} else {
+		boards = make([]trelloExport, 1)
+		err = json.Unmarshal(body, &boards[0])
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid Trello export", "error": err.Error()})
+		return
+	}
+
+	rep := &importReport{Source: "trello", DryRun: dry}
+	var todos []todoModel
+	for _, b := range boards {
+		todos = append(todos, mapTrello(rep, b)...)
+	}
+	if len(todos) > maxImportTodos {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": fmt.Sprintf("at most %d todos can be imported at once", maxImportTodos), "error": "bad request"})
+		return
+	}
+	finishImport(w, r, rep, todos)
+}
+
+func mapTrello(rep *importReport, b trelloExport) []todoModel {
+	list := truncate(strings.TrimSpace(b.Name), maxListLength)
+	rep.Lists = append(rep.Lists, list)
+
+	closedLists := map[string]bool{}
+	for _, l := range b.Lists {
+		closedLists[l.ID] = l.Closed
+	}
+	labels := map[string]string{}
+	for _, l := range b.Labels {
+		name := strings.TrimSpace(l.Name)
+		if name == "" {
+			name = l.Color
+		}
+		labels[l.ID] = truncate(name, maxLabelLength)
+	}
+	checklists := map[string]int{}
+	for i, c := range b.Checklists {
+		checklists[c.ID] = i
+	}
+
+	var todos []todoModel
+	for _, c := range b.Cards {
+		rep.Found++
+		if c.Closed || closedLists[c.IDList] {
+			rep.Skipped++
+			continue
+		}
+		title := strings.TrimSpace(c.Name)
+		if title == "" {
+			rep.Skipped++
+			rep.warnf("card %s: empty name, skipped", c.ID)
+			continue
+		}
+		if len([]rune(title)) > maxTitleLength {
+			rep.warnf("card %s: name truncated to %d characters", c.ID, maxTitleLength)
+		}
+
+		card := todoModel{
+			ID:        primitive.NewObjectID(),
+			Title:     truncate(title, maxTitleLength),
+			Completed: c.DueComplete,
+			CreatedAt: trelloCreated(c.ID),
+			List:      list,
+			Source:    "trello:" + c.ID,
+		}
+		for _, id := range c.IDLabels {
+			if len(card.Labels) == maxLabels {
+				rep.warnf("card %s: only the first %d labels were kept", c.ID, maxLabels)
+				break
+			}
+			if l := labels[id]; l != "" {
+				card.Labels = append(card.Labels, l)
+			}
+		}
+		if c.Due != "" {
+			if due, err := time.Parse(time.RFC3339Nano, c.Due); err == nil {
+				card.DueAt = &due
+			} else {
+				rep.warnf("card %s: unrecognized due date %q dropped", c.ID, c.Due)
+			}
+		}
+		todos = append(todos, card)
+
+		for _, clID := range c.IDChecklists {
+			i, ok := checklists[clID]
+			if !ok {
+				continue
+			}
+			for _, item := range b.Checklists[i].CheckItems {
+				name := strings.TrimSpace(item.Name)
+				if name == "" {
+					continue
+				}
+				sub := card
+				sub.ID = primitive.NewObjectID()
+				sub.Title = truncate(card.Title+": "+name, maxTitleLength)
+				sub.Completed = item.State == "complete"
+				sub.Source = "trello:" + c.ID + ":" + item.ID
+				todos = append(todos, sub)
+			}
+		}
+	}
+	return todos
+}
+
+// trelloCreated returns the creation time encoded in a Trello id, which
+// like an ObjectID starts with a timestamp, or now when id is not one.
+func trelloCreated(id string) time.Time {
+	if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+		return oid.Timestamp()
+	}
+	return time.Now()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const ttlCleanupInterval = 10 * time.Minute
+
+// ttlCollections are the collections whose documents expire through a TTL
+// index, with the field and the age it expires them at.
+var ttlCollections = []struct {
+	coll  func() *mongo.Collection
+	field string
+	ttl   time.Duration
+}{
+	{githubHookTodosCollection, "at", githubHookTodosTTL},
+	{jobsCollection, "finished_at", jobRetention},
+	{outboxCollection, "delivered_at", outboxRetention},
+	{pushNotifiedCollection, "at", pushNotifiedTTL},
+	{slackNotifiedCollection, "at", slackNotifiedTTL},
+	{teamsNotifiedCollection, "at", teamsNotifiedTTL},
+	{telegramPairingsCollection, "expires_at", 0},
+	{deliveriesCollection, "created_at", webhookHistory},
+}
+
+var ttlDeleted = newCounterVec("todo_ttl_cleanup_deleted_total",
+	"Expired documents the ttl-cleanup task deleted, by collection.", "collection")
+
+// cleanupExpired deletes the documents of ttlCollections that have expired.
+// The TTL monitor of MongoDB removes them about once a minute, falls
+// behind under load and can be turned off, so the task keeps claims and
+// histories from outliving their TTL. It is scheduled every
+// ttlCleanupInterval.
+func cleanupExpired(ctx context.Context) error {
+	if !storeAvailable() {
+		return nil
+	}
+	now := time.Now()
+	for _, c := range ttlCollections {
+		coll := c.coll()
+		var deleted int64
+		err := withRetry(ctx, func(ctx context.Context) error {
+			res, err := coll.DeleteMany(ctx, bson.M{c.field: bson.M{"$lt": now.Add(-c.ttl)}})
+			if err != nil {
+				return err
+			}
+			deleted = res.DeletedCount
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if deleted > 0 {
+			ttlDeleted.add(float64(deleted), coll.Name())
+			slog.Info("deleted expired documents", "component", "ttl-cleanup", "collection", coll.Name(), "count", deleted)
+		}
+	}
+	return nil
+}
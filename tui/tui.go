@@ -0,0 +1,294 @@
+// Package tui is an interactive terminal UI for todos, run by `todocli tui`
+// against the API and by the server's -tui flag against the store.
+package tui
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/qasim-invodev/todo/api"
+)
+
+// syncInterval is how often the todos are reloaded, picking up changes
+// made elsewhere.
+const syncInterval = 10 * time.Second
+
+// Store is what the UI reads and changes todos through.
+type Store interface {
+	List(ctx context.Context) ([]api.Todo, error)
+	Add(ctx context.Context, title, list string) error
+	SetCompleted(ctx context.Context, t api.Todo, completed bool) error
+	Delete(ctx context.Context, id string) error
+}
+
+// Run shows the UI until the user quits or ctx is done.
+func Run(ctx context.Context, s Store) error {
+	_, err := tea.NewProgram(&model{ctx: ctx, store: s}, tea.WithAltScreen(), tea.WithContext(ctx)).Run()
+	return err
+}
+
+const (
+	modeBrowse = iota
+	modeAdd
+	modeConfirmDelete
+)
+
+// allLists is the first tab, showing the todos of every list.
+const allLists = "All"
+
+type (
+	loadedMsg struct {
+		todos []api.Todo
+		err   error
+	}
+	doneMsg struct {
+		status string
+		err    error
+	}
+	tickMsg struct{}
+)
+
+type model struct {
+	ctx   context.Context
+	store Store
+
+	todos  []api.Todo
+	lists  []string
+	list   int
+	cursor int
+	mode   int
+	input  []rune
+	status string
+	err    error
+	width  int
+}
+
+func (m *model) Init() tea.Cmd {
+	return tea.Batch(m.load(), tick())
+}
+
+func tick() tea.Cmd {
+	return tea.Tick(syncInterval, func(time.Time) tea.Msg { return tickMsg{} })
+}
+
+func (m *model) load() tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(m.ctx, 10*time.Second)
+		defer cancel()
+		todos, err := m.store.List(ctx)
+		return loadedMsg{todos, err}
+	}
+}
+
+// run performs op in the background and reports status when it succeeds.
+func (m *model) run(status string, op func(ctx context.Context) error) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(m.ctx, 10*time.Second)
+		defer cancel()
+		return doneMsg{status, op(ctx)}
+	}
+}
+
+// visible returns the todos of the selected list, open ones first.
+func (m *model) visible() []api.Todo {
+	var shown []api.Todo
+	for _, t := range m.todos {
+		if m.list == 0 || t.List == m.lists[m.list] {
+			shown = append(shown, t)
+		}
+	}
+	slices.SortStableFunc(shown, func(a, b api.Todo) int {
+		if a.Completed != b.Completed {
+			return strings.Compare(a.Completed, b.Completed)
+		}
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return shown
+}
+
+func (m *model) setTodos(todos []api.Todo) {
+	selected := allLists
+	if m.list < len(m.lists) {
+		selected = m.lists[m.list]
+	}
+	m.todos = todos
+	m.lists = []string{allLists}
+	for _, t := range todos {
+		if t.List != "" && !slices.Contains(m.lists, t.List) {
+			m.lists = append(m.lists, t.List)
+		}
+	}
+	slices.Sort(m.lists[1:])
+	m.list = max(slices.Index(m.lists, selected), 0)
+	m.clampCursor()
+}
+
+func (m *model) clampCursor() {
+	m.cursor = min(m.cursor, len(m.visible())-1)
+	m.cursor = max(m.cursor, 0)
+}
+
+func (m *model) selected() (api.Todo, bool) {
+	shown := m.visible()
+	if m.cursor >= len(shown) {
+		return api.Todo{}, false
+	}
+	return shown[m.cursor], true
+}
+
+func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+	case loadedMsg:
+		m.err = msg.err
+		if msg.err == nil {
+			m.setTodos(msg.todos)
+		}
+	case doneMsg:
+		m.err = msg.err
+		if msg.err == nil {
+			m.status = msg.status
+		}
+		return m, m.load()
+	case tickMsg:
+		return m, tea.Batch(m.load(), tick())
+	case tea.KeyMsg:
+		switch m.mode {
+		case modeAdd:
+			return m.updateAdd(msg)
+		case modeConfirmDelete:
+			m.mode = modeBrowse
+			t, ok := m.selected()
+			if msg.String() != "y" || !ok {
+				m.status = "not deleted"
+				return m, nil
+			}
+			return m, m.run("deleted "+t.Title, func(ctx context.Context) error { return m.store.Delete(ctx, t.ID) })
+		}
+		return m.updateBrowse(msg)
+	}
+	return m, nil
+}
+
+func (m *model) updateBrowse(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	m.status = ""
+	switch msg.String() {
+	case "q", "ctrl+c":
+		return m, tea.Quit
+	case "up", "k":
+		m.cursor = max(m.cursor-1, 0)
+	case "down", "j":
+		m.cursor++
+		m.clampCursor()
+	case "left", "h", "shift+tab":
+		m.list = (m.list + len(m.lists) - 1) % max(len(m.lists), 1)
+		m.clampCursor()
+	case "right", "l", "tab":
+		m.list = (m.list + 1) % max(len(m.lists), 1)
+		m.clampCursor()
+	case "r":
+		m.status = "refreshing"
+		return m, m.load()
+	case "a":
+		m.mode, m.input = modeAdd, nil
+	case " ", "x", "enter":
+		if t, ok := m.selected(); ok {
+			completed := t.Completed != "true"
+			status := "completed "
+			if !completed {
+				status = "reopened "
+			}
+			return m, m.run(status+t.Title, func(ctx context.Context) error { return m.store.SetCompleted(ctx, t, completed) })
+		}
+	case "d", "delete":
+		if _, ok := m.selected(); ok {
+			m.mode = modeConfirmDelete
+		}
+	}
+	return m, nil
+}
+
+func (m *model) updateAdd(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEsc:
+		m.mode = modeBrowse
+	case tea.KeyEnter:
+		m.mode = modeBrowse
+		title := strings.TrimSpace(string(m.input))
+		if title == "" {
+			return m, nil
+		}
+		list := ""
+		if m.list > 0 {
+			list = m.lists[m.list]
+		}
+		return m, m.run("added "+title, func(ctx context.Context) error { return m.store.Add(ctx, title, list) })
+	case tea.KeyBackspace:
+		if len(m.input) > 0 {
+			m.input = m.input[:len(m.input)-1]
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.input = append(m.input, msg.Runes...)
+	}
+	return m, nil
+}
+
+func (m *model) View() string {
+	var b strings.Builder
+	for i, l := range m.lists {
+		if i == m.list {
+			fmt.Fprintf(&b, "[%s] ", l)
+		} else {
+			fmt.Fprintf(&b, " %s  ", l)
+		}
+	}
+	b.WriteString("\n\n")
+
+	shown := m.visible()
+	if len(shown) == 0 {
+		b.WriteString("  Nothing to do.\n")
+	}
+	for i, t := range shown {
+		cursor, check := "  ", "[ ]"
+		if i == m.cursor {
+			cursor = "> "
+		}
+		if t.Completed == "true" {
+			check = "[x]"
+		}
+		line := cursor + check + " " + t.Title
+		if t.DueAt != nil {
+			line += "  (due " + t.DueAt.Local().Format("Jan 2 15:04") + ")"
+		}
+		if m.width > 0 && len([]rune(line)) > m.width {
+			line = string([]rune(line)[:m.width-1]) + "…"
+		}
+		b.WriteString(line + "\n")
+	}
+
+	b.WriteString("\n")
+	switch {
+	case m.mode == modeAdd:
+		fmt.Fprintf(&b, "New todo: %s█\n", string(m.input))
+		b.WriteString("enter save • esc cancel\n")
+		return b.String()
+	case m.mode == modeConfirmDelete:
+		b.WriteString("Delete this todo? y/n\n")
+		return b.String()
+	case m.err != nil:
+		fmt.Fprintf(&b, "error: %v\n", m.err)
+	case m.status != "":
+		b.WriteString(m.status + "\n")
+	default:
+		b.WriteString("\n")
+	}
+	b.WriteString("↑/↓ move • ←/→ list • space complete • a add • d delete • r refresh • q quit\n")
+	return b.String()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/qasim-invodev/todo/api"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// repoStore lets the terminal UI of the -tui flag work directly on the
+// store. Events still go through the outbox, which the server dispatches.
+type repoStore struct{}
+
+func (repoStore) List(ctx context.Context) ([]api.Todo, error) {
+	todos, err := repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]api.Todo, 0, len(todos))
+	for _, t := range todos {
+		list = append(list, toTodo(t))
+	}
+	return list, nil
+}
+
+func (repoStore) Add(ctx context.Context, title, list string) error {
+	if msg := validateTitle(title); msg != "" {
+		return errors.New(msg)
+	}
+	return repo.Create(ctx, todoModel{
+		ID:        primitive.NewObjectID(),
+		Title:     title,
+		CreatedAt: time.Now(),
+		List:      list,
+	})
+}
+
+func (repoStore) SetCompleted(ctx context.Context, t api.Todo, completed bool) error {
+	id, err := primitive.ObjectIDFromHex(t.ID)
+	if err != nil {
+		return err
+	}
+	return repo.Update(ctx, id, bson.M{"completed": completed})
+}
+
+func (repoStore) Delete(ctx context.Context, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	deleted, err := repo.Delete(ctx, objID)
+	if err == nil {
+		deleteBlobs(deleted.Attachments)
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// upgradeTimeout is how long a new process has to start serving before
+// the upgrade is given up and the old one keeps serving.
+const upgradeTimeout = 30 * time.Second
+
+// upgradeReady, in a process started by upgrade, is told once it serves.
+var upgradeReady *os.File
+
+// loadUpgradeConfig takes over the sockets passed by the process upgraded
+// from: TODO_UPGRADE_FDS names them in order from fd 3, and
+// TODO_UPGRADE_READY_FD is the pipe to report readiness on. Both are set
+// by upgrade and unset here.
+func loadUpgradeConfig() error {
+	fds, ready := os.Getenv("TODO_UPGRADE_FDS"), os.Getenv("TODO_UPGRADE_READY_FD")
+	os.Unsetenv("TODO_UPGRADE_FDS")
+	os.Unsetenv("TODO_UPGRADE_READY_FD")
+	if ready == "" {
+		return nil
+	}
+	fd, err := strconv.Atoi(ready)
+	if err != nil || fd < listenFDsStart {
+		return fmt.Errorf("invalid TODO_UPGRADE_READY_FD %q", ready)
+	}
+	upgradeReady = os.NewFile(uintptr(fd), "upgrade-ready")
+	for i, name := range strings.Split(fds, ":") {
+		if name == "" {
+			continue
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s socket passed by the upgraded process: %w", name, err)
+		}
+		inheritedListeners[name] = ln
+	}
+	return nil
+}
+
+// notifyUpgraded tells the process upgraded from that this one serves, so
+// it can drain and exit.
+func notifyUpgraded() {
+	if upgradeReady == nil {
+		return
+	}
+	notifySystemd("MAINPID=" + strconv.Itoa(os.Getpid()))
+	upgradeReady.Write([]byte{1})
+	upgradeReady.Close()
+	upgradeReady = nil
+}
+
+// awaitStop waits for SIGINT or SIGTERM, or for SIGUSR2 to upgrade to the
+// binary at the same path. A failed upgrade is logged and the server keeps
+// serving. upgraded reports whether a new process took over.
+func awaitStop(signals <-chan os.Signal) (upgraded bool) {
+	for sig := range signals {
+		if sig != syscall.SIGUSR2 {
+			return false
+		}
+		if err := upgrade(); err != nil {
+			slog.Error("upgrade failed, still serving", "component", "upgrade", "error", err)
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// upgrade starts the binary at the path of this one with the same
+// arguments, passing it the sockets being served, and waits until it
+// serves them too. The config file is read again, the rest of the
+// environment is inherited.
+func upgrade() error {
+	if writes != nil {
+		return errors.New("upgrades are not supported with TODO_WRITE_BUFFER, which only one process can open")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	listeningMu.Lock()
+	names := make([]string, 0, len(listening))
+	for name := range listening {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var files []*os.File
+	for _, name := range names {
+		f, err := listening[name].(interface{ File() (*os.File, error) }).File()
+		if err != nil {
+			listeningMu.Unlock()
+			return fmt.Errorf("could not pass the %s socket: %w", name, err)
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+	listeningMu.Unlock()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	env := []string{
+		"TODO_UPGRADE_FDS=" + strings.Join(names, ":"),
+		"TODO_UPGRADE_READY_FD=" + strconv.Itoa(listenFDsStart+len(files)),
+	}
+	if notifySocket != "" {
+		env = append(env, "NOTIFY_SOCKET="+notifySocket)
+	}
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		if fv, ok := fileVars[k]; !ok || fv != v {
+			env = append(env, kv)
+		}
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = env
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, w)
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+	slog.Info("upgrading", "component", "upgrade", "pid", cmd.Process.Pid)
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err := <-ready:
+		if err == nil {
+			slog.Info("new process serving, draining", "component", "upgrade", "pid", cmd.Process.Pid)
+			return nil
+		}
+		cmd.Process.Kill()
+		return fmt.Errorf("new process exited before serving: %v", <-exited)
+	case <-time.After(upgradeTimeout):
+		cmd.Process.Kill()
+		return fmt.Errorf("new process not serving after %s", upgradeTimeout)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// Build information, set at link time:
+//
+//	go build -ldflags "-X main.version=v1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// Unset, they fall back to what the Go toolchain recorded in the binary,
+// see buildInfo.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// buildInfo returns the version, commit and build date of the binary.
+// Builds from a git checkout record the commit and its time, and go
+// install records the module version.
+func buildInfo() (v, c, date string) {
+	v, c, date = version, commit, buildDate
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v, c, date
+	}
+	if v == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		v = info.Main.Version
+	}
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision" && c == "":
+			c = s.Value
+		case s.Key == "vcs.time" && date == "":
+			date = s.Value
+		case s.Key == "vcs.modified" && s.Value == "true" && commit == "":
+			c += "-dirty"
+		}
+	}
+	if v == "" {
+		v = "dev"
+	}
+	return v, c, date
+}
+
+// versionHandler reports what is deployed.
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	v, c, date := buildInfo()
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"version":    v,
+		"commit":     c,
+		"build_date": date,
+		"go_version": runtime.Version(),
+		"platform":   runtime.GOOS + "/" + runtime.GOARCH,
+	})
+}
@@ -0,0 +1,729 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	webhooksCollectionName   = "webhooks"
+	deliveriesCollectionName = "webhook_deliveries"
+
+	webhookPollInterval   = time.Second
+	webhookLease          = 30 * time.Second
+	webhookMaxBackoff     = time.Hour
+	webhookHistory        = 30 * 24 * time.Hour
+	webhookAttemptsKept   = 10
+	maxWebhooksPerTenant  = 20
+	webhookDeliveriesPage = 50
+
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+)
+
+var webhookEventTypes = []string{eventTodoCreated, eventTodoUpdated, eventTodoDeleted}
+
+// webhookSettings are read by loadWebhookSettings and can be reloaded,
+// see reloadConfig.
+type webhookSettings struct {
+	timeout     time.Duration
+	maxAttempts int
+	// workers is how many deliveries are sent at the same time, and
+	// perEndpoint how many of them may go to the same webhook.
+	workers     int
+	perEndpoint int
+}
+
+var webhookConfig atomic.Pointer[webhookSettings]
+
+// loadWebhookSettings reads TODO_WEBHOOK_TIMEOUT, how long an endpoint
+// gets to answer a delivery, TODO_WEBHOOK_MAX_ATTEMPTS, after how many
+// attempts a delivery is given up, TODO_WEBHOOK_WORKERS, how many
+// deliveries are sent at the same time, and TODO_WEBHOOK_PER_ENDPOINT, how
+// many of them may go to the same webhook.
+func loadWebhookSettings() (func(), error) {
+	s := &webhookSettings{timeout: 10 * time.Second, maxAttempts: 10, workers: 8, perEndpoint: 2}
+	if v := os.Getenv("TODO_WEBHOOK_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > webhookLease {
+			return nil, fmt.Errorf("invalid TODO_WEBHOOK_TIMEOUT %q, must be a positive duration up to %s", v, webhookLease)
+		}
+		s.timeout = d
+	}
+	if v := os.Getenv("TODO_WEBHOOK_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid TODO_WEBHOOK_MAX_ATTEMPTS %q", v)
+		}
+		s.maxAttempts = n
+	}
+	if v := os.Getenv("TODO_WEBHOOK_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid TODO_WEBHOOK_WORKERS %q, must be a positive number", v)
+		}
+		s.workers = n
+	}
+	if v := os.Getenv("TODO_WEBHOOK_PER_ENDPOINT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid TODO_WEBHOOK_PER_ENDPOINT %q, must be a positive number", v)
+		}
+		s.perEndpoint = n
+	}
+	return func() { webhookConfig.Store(s) }, nil
+}
+
+type (
+	// webhook is an endpoint registered for a tenant. The secret signs the
+	// payloads and is stored encrypted when encryption is enabled. Zapier
+	// subscriptions are webhooks with a Trigger, see zapier.go.
+	webhook struct {
+		ID        primitive.ObjectID `bson:"_id" json:"id"`
+		TenantID  string             `bson:"tenant_id" json:"-"`
+		URL       string             `bson:"url" json:"url"`
+		Secret    string             `bson:"secret" json:"-"`
+		Events    []string           `bson:"events" json:"events"`
+		Trigger   string             `bson:"zapier_trigger,omitempty" json:"-"`
+		CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	}
+
+	// webhookDelivery is one event queued for one webhook together with
+	// the outcome of its attempts.
+	webhookDelivery struct {
+		ID            primitive.ObjectID `bson:"_id" json:"id"`
+		WebhookID     primitive.ObjectID `bson:"webhook_id" json:"-"`
+		EventID       string             `bson:"event_id" json:"event_id"`
+		EventType     string             `bson:"event_type" json:"event_type"`
+		Payload       []byte             `bson:"payload" json:"-"`
+		Status        string             `bson:"status" json:"status"`
+		Attempts      int                `bson:"attempts" json:"attempts"`
+		NextAttemptAt time.Time          `bson:"next_attempt_at" json:"next_attempt_at"`
+		CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+		DeliveredAt   *time.Time         `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+		History       []deliveryAttempt  `bson:"history,omitempty" json:"history"`
+	}
+
+	deliveryAttempt struct {
+		At         time.Time `bson:"at" json:"at"`
+		StatusCode int       `bson:"status_code,omitempty" json:"status_code,omitempty"`
+		Error      string    `bson:"error,omitempty" json:"error,omitempty"`
+		DurationMS int64     `bson:"duration_ms" json:"duration_ms"`
+	}
+)
+
+func webhooksCollection() *mongo.Collection {
+	return db.Collection(webhooksCollectionName, writeCollectionOpts)
+}
+
+func deliveriesCollection() *mongo.Collection {
+	return db.Collection(deliveriesCollectionName, writeCollectionOpts)
+}
+
+// webhooksEnabled reports whether webhooks can be used. They are fed by the
+// outbox, so it requires TODO_OUTBOX.
+func webhooksEnabled() bool {
+	return outboxEnabled
+}
+
+// ensureWebhookIndexes creates the indexes for tenant lookups, delivery
+// polling and idempotent enqueueing, and expires old deliveries.
+func ensureWebhookIndexes(ctx context.Context) error {
+	if !webhooksEnabled() {
+		return nil
+	}
+	_, err := webhooksCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "tenant_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = deliveriesCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "webhook_id", Value: 1}, {Key: "event_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(webhookHistory.Seconds())),
+		},
+	})
+	return err
+}
+
+// webhookPublisher turns outbox events into deliveries for every matching
+// webhook. Enqueueing is idempotent, so redelivered events are not sent
+// twice.
+type webhookPublisher struct{}
+
+func (webhookPublisher) Name() string { return "webhooks" }
+
+func (webhookPublisher) Publish(ctx context.Context, ev todoEvent) error {
+	cursor, err := webhooksCollection().Find(ctx, bson.M{"tenant_id": ev.TenantID, "events": ev.Type})
+	if err != nil {
+		return err
+	}
+	var hooks []webhook
+	if err := cursor.All(ctx, &hooks); err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, h := range hooks {
+		body := payload
+		if h.Trigger != "" {
+			var ok bool
+			if body, ok = zapierPayload(h.Trigger, ev); !ok {
+				continue
+			}
+		}
+		_, err := deliveriesCollection().InsertOne(ctx, webhookDelivery{
+			ID:            primitive.NewObjectID(),
+			WebhookID:     h.ID,
+			EventID:       ev.ID,
+			EventType:     ev.Type,
+			Payload:       body,
+			Status:        deliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// runWebhookDispatcher sends pending deliveries until ctx is done, from a
+// pool of workers so a slow endpoint only holds up its own deliveries.
+// Like the outbox, deliveries are claimed with a lease. It returns once
+// the deliveries being sent are done.
+func runWebhookDispatcher(ctx context.Context) {
+	if !webhooksEnabled() {
+		return
+	}
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	pool := newDeliveryPool()
+	defer pool.wait()
+	for {
+		dispatchDeliveries(ctx, pool)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-pool.freed:
+		}
+	}
+}
+
+// deliveryPool counts the deliveries being sent, in all and by webhook.
+type deliveryPool struct {
+	mu      sync.Mutex
+	running int
+	busy    map[primitive.ObjectID]int
+	wg      sync.WaitGroup
+	// freed is signaled when a delivery is done, so the dispatcher need
+	// not wait for the next poll to use the worker again.
+	freed chan struct{}
+}
+
+// webhooksInFlight counts the deliveries being sent, for /metrics.
+var webhooksInFlight atomic.Int64
+
+func newDeliveryPool() *deliveryPool {
+	return &deliveryPool{busy: map[primitive.ObjectID]int{}, freed: make(chan struct{}, 1)}
+}
+
+// saturated returns the webhooks sent s.perEndpoint deliveries already,
+// and false when s.workers deliveries are being sent.
+func (p *deliveryPool) saturated(s *webhookSettings) ([]primitive.ObjectID, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running >= s.workers {
+		return nil, false
+	}
+	var ids []primitive.ObjectID
+	for id, n := range p.busy {
+		if n >= s.perEndpoint {
+			ids = append(ids, id)
+		}
+	}
+	return ids, true
+}
+
+// send sends d in the background.
+func (p *deliveryPool) send(ctx context.Context, d webhookDelivery) {
+	p.mu.Lock()
+	p.running++
+	p.busy[d.WebhookID]++
+	p.mu.Unlock()
+	webhooksInFlight.Add(1)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		sendDelivery(ctx, d)
+
+		webhooksInFlight.Add(-1)
+		p.mu.Lock()
+		p.running--
+		if p.busy[d.WebhookID]--; p.busy[d.WebhookID] == 0 {
+			delete(p.busy, d.WebhookID)
+		}
+		p.mu.Unlock()
+		select {
+		case p.freed <- struct{}{}:
+		default:
+		}
+	}()
+}
+
+func (p *deliveryPool) idle() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.running == 0
+}
+
+func (p *deliveryPool) wait() {
+	p.wg.Wait()
+}
+
+// dispatchDeliveries hands the deliveries that are due to pool until none
+// is left, every worker is busy or ctx is done, and reports whether it
+// stopped as every worker was. Deliveries of webhooks at their limit are
+// left for later.
+func dispatchDeliveries(ctx context.Context, pool *deliveryPool) bool {
+	for ctx.Err() == nil {
+		skip, ok := pool.saturated(webhookConfig.Load())
+		if !ok {
+			return true
+		}
+		d, err := claimDelivery(ctx, skip)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("could not claim delivery", "component", "webhooks", "error", err)
+			}
+			return false
+		}
+		pool.send(ctx, d)
+	}
+	return false
+}
+
+// drainDeliveries sends the deliveries that are due until none is left or
+// ctx is done, and waits for them.
+func drainDeliveries(ctx context.Context) {
+	pool := newDeliveryPool()
+	defer pool.wait()
+	for ctx.Err() == nil {
+		if !dispatchDeliveries(ctx, pool) && pool.idle() {
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-pool.freed:
+		}
+	}
+}
+
+// claimDelivery leases the next due delivery of a webhook not in skip.
+func claimDelivery(ctx context.Context, skip []primitive.ObjectID) (webhookDelivery, error) {
+	now := time.Now()
+	filter := bson.M{"status": deliveryPending, "next_attempt_at": bson.M{"$lte": now}}
+	if len(skip) > 0 {
+		filter["webhook_id"] = bson.M{"$nin": skip}
+	}
+	var d webhookDelivery
+	err := deliveriesCollection().FindOneAndUpdate(ctx,
+		filter,
+		bson.M{"$set": bson.M{"next_attempt_at": now.Add(webhookLease)}},
+		options.FindOneAndUpdate().SetSort(bson.M{"_id": 1}).SetReturnDocument(options.After),
+	).Decode(&d)
+	return d, err
+}
+
+// sendDelivery posts d to its webhook and records the attempt. Any 2xx
+// answer counts as delivered, everything else is retried with exponential
+// backoff until the maximum number of attempts.
+func sendDelivery(ctx context.Context, d webhookDelivery) {
+	var h webhook
+	err := webhooksCollection().FindOne(ctx, bson.M{"_id": d.WebhookID}).Decode(&h)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// The webhook was deleted, its deliveries go with it.
+		deliveriesCollection().DeleteOne(ctx, bson.M{"_id": d.ID})
+		return
+	}
+	if err != nil {
+		slog.Error("could not load webhook", "component", "webhooks", "webhook", d.WebhookID.Hex(), "error", err)
+		return
+	}
+
+	start := time.Now()
+	attempt := deliveryAttempt{At: start}
+	status, err := postWebhook(ctx, h, d)
+	attempt.DurationMS = time.Since(start).Milliseconds()
+	if status == http.StatusGone && h.Trigger != "" {
+		// Zapier answers 410 once the Zap was turned off.
+		removeWebhook(ctx, h.ID)
+		return
+	}
+	attempt.StatusCode = status
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	set := bson.M{"attempts": d.Attempts + 1}
+	switch {
+	case err == nil:
+		set["status"] = deliveryDelivered
+		set["delivered_at"] = time.Now()
+	case d.Attempts+1 >= webhookConfig.Load().maxAttempts:
+		set["status"] = deliveryFailed
+		slog.Error("giving up on delivery", "component", "webhooks", "delivery", d.ID.Hex(), "url", h.URL, "attempts", d.Attempts+1, "error", err)
+	default:
+		delay := min(time.Second<<min(d.Attempts+1, 12), webhookMaxBackoff)
+		set["next_attempt_at"] = time.Now().Add(delay/2 + mathrand.N(delay/2))
+	}
+	_, err = deliveriesCollection().UpdateByID(ctx, d.ID, bson.M{
+		"$set":  set,
+		"$push": bson.M{"history": bson.M{"$each": bson.A{attempt}, "$slice": -webhookAttemptsKept}},
+	})
+	if err != nil {
+		slog.Error("could not record delivery", "component", "webhooks", "delivery", d.ID.Hex(), "error", err)
+	}
+}
+
+// postWebhook sends the payload of d signed with the webhook secret,
+// through outboundClient so it only reaches public addresses. The
+// X-Todo-Signature header is "sha256=" followed by the hex HMAC-SHA256 of
+// "<X-Todo-Timestamp>.<body>".
+func postWebhook(ctx context.Context, h webhook, d webhookDelivery) (int, error) {
+	secret, err := decryptField(h.ID, h.Secret)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, webhookConfig.Load().timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(d.Payload)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Todo-Event", d.EventType)
+	req.Header.Set("X-Todo-Delivery", d.ID.Hex())
+	req.Header.Set("X-Todo-Timestamp", timestamp)
+	req.Header.Set("X-Todo-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := outboundClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func webhookHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(requireStore)
+	rg.Use(tenantMiddleware)
+	rg.Use(requireWebhooks)
+	rg.Group(func(r chi.Router) {
+		r.Get("/", fetchWebhooks)
+		r.Post("/", createWebhook)
+		r.Get("/{id}", fetchWebhook)
+		r.Put("/{id}", updateWebhook)
+		r.Delete("/{id}", deleteWebhook)
+		r.Get("/{id}/deliveries", fetchDeliveries)
+	})
+	return rg
+}
+
+func requireWebhooks(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !webhooksEnabled() {
+			rnd.JSON(w, http.StatusNotImplemented, renderer.M{"message": "webhooks require TODO_OUTBOX", "error": "not implemented"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// webhookRequest is the body of POST and PUT /webhooks. Events defaults to
+// all event types. Without a secret, POST generates one.
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+// validate checks the request and fills in the default events. The URL
+// must resolve to public addresses, see checkOutboundURL.
+func (req *webhookRequest) validate(ctx context.Context) string {
+	if err := checkOutboundURL(ctx, req.URL); err != nil {
+		return err.Error()
+	}
+	if len(req.Events) == 0 {
+		req.Events = webhookEventTypes
+	}
+	for _, e := range req.Events {
+		if !slices.Contains(webhookEventTypes, e) {
+			return "unknown event type " + strconv.Quote(e)
+		}
+	}
+	return ""
+}
+
+func fetchWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	cursor, err := webhooksCollection().Find(ctx, plainWebhooks(ctx))
+	if err != nil {
+		storeErr(w, r, "could not fetch webhooks", err)
+		return
+	}
+	hooks := []webhook{}
+	if err := cursor.All(ctx, &hooks); err != nil {
+		storeErr(w, r, "could not fetch webhooks", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": hooks})
+}
+
+func fetchWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	var h webhook
+	err := webhooksCollection().FindOne(ctx, bson.M{"_id": id, "tenant_id": tenantFrom(ctx)}).Decode(&h)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = errNotFound
+	}
+	if err != nil {
+		storeErr(w, r, "could not fetch webhook", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": h})
+}
+
+// createWebhook registers a webhook. The secret is only ever returned here.
+func createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid request", "error": err.Error()})
+		return
+	}
+	if msg := req.validate(r.Context()); msg != "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": msg, "error": "bad request"})
+		return
+	}
+	if req.Secret == "" {
+		b := make([]byte, 32)
+		rand.Read(b)
+		req.Secret = hex.EncodeToString(b)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	n, err := webhooksCollection().CountDocuments(ctx, plainWebhooks(ctx))
+	if err != nil {
+		storeErr(w, r, "could not create webhook", err)
+		return
+	}
+	if n >= maxWebhooksPerTenant {
+		rnd.JSON(w, http.StatusConflict, renderer.M{"message": "webhook limit reached", "error": "conflict"})
+		return
+	}
+
+	h := webhook{
+		ID:        primitive.NewObjectID(),
+		TenantID:  tenantFrom(ctx),
+		URL:       req.URL,
+		Events:    req.Events,
+		CreatedAt: time.Now(),
+	}
+	if h.Secret, err = encryptField(h.ID, req.Secret); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "could not create webhook", "error": err.Error()})
+		return
+	}
+	if _, err := webhooksCollection().InsertOne(ctx, h); err != nil {
+		storeErr(w, r, "could not create webhook", err)
+		return
+	}
+	rnd.JSON(w, http.StatusCreated, renderer.M{"data": h, "secret": req.Secret})
+}
+
+// updateWebhook replaces the URL and events of a webhook, and its secret
+// when one is given.
+func updateWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid request", "error": err.Error()})
+		return
+	}
+	if msg := req.validate(r.Context()); msg != "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": msg, "error": "bad request"})
+		return
+	}
+
+	set := bson.M{"url": req.URL, "events": req.Events}
+	if req.Secret != "" {
+		secret, err := encryptField(id, req.Secret)
+		if err != nil {
+			rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "could not update webhook", "error": err.Error()})
+			return
+		}
+		set["secret"] = secret
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	res, err := webhooksCollection().UpdateOne(ctx, bson.M{"_id": id, "tenant_id": tenantFrom(ctx)}, bson.M{"$set": set})
+	if err == nil && res.MatchedCount == 0 {
+		err = errNotFound
+	}
+	if err != nil {
+		storeErr(w, r, "could not update webhook", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"message": "webhook updated successfully"})
+}
+
+func deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	res, err := webhooksCollection().DeleteOne(ctx, bson.M{"_id": id, "tenant_id": tenantFrom(ctx)})
+	if err == nil && res.DeletedCount == 0 {
+		err = errNotFound
+	}
+	if err != nil {
+		storeErr(w, r, "could not delete webhook", err)
+		return
+	}
+	if _, err := deliveriesCollection().DeleteMany(ctx, bson.M{"webhook_id": id}); err != nil {
+		slog.Error("could not delete deliveries", "component", "webhooks", "webhook", id.Hex(), "error", err)
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"message": "webhook deleted successfully"})
+}
+
+// removeWebhook deletes a webhook and its deliveries.
+func removeWebhook(ctx context.Context, id primitive.ObjectID) {
+	if _, err := webhooksCollection().DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		slog.Error("could not delete webhook", "component", "webhooks", "webhook", id.Hex(), "error", err)
+		return
+	}
+	if _, err := deliveriesCollection().DeleteMany(ctx, bson.M{"webhook_id": id}); err != nil {
+		slog.Error("could not delete deliveries", "component", "webhooks", "webhook", id.Hex(), "error", err)
+	}
+}
+
+// plainWebhooks filters the webhooks of the tenant of ctx registered at
+// /webhooks, leaving out Zapier subscriptions.
+func plainWebhooks(ctx context.Context) bson.M {
+	return bson.M{"tenant_id": tenantFrom(ctx), "zapier_trigger": bson.M{"$exists": false}}
+}
+
+// fetchDeliveries lists the latest deliveries of a webhook with the
+// outcome of their recent attempts.
+func fetchDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	n, err := webhooksCollection().CountDocuments(ctx, bson.M{"_id": id, "tenant_id": tenantFrom(ctx)})
+	if err == nil && n == 0 {
+		err = errNotFound
+	}
+	if err != nil {
+		storeErr(w, r, "could not fetch deliveries", err)
+		return
+	}
+
+	cursor, err := deliveriesCollection().Find(ctx, bson.M{"webhook_id": id},
+		options.Find().SetSort(bson.M{"_id": -1}).SetLimit(webhookDeliveriesPage))
+	if err != nil {
+		storeErr(w, r, "could not fetch deliveries", err)
+		return
+	}
+	deliveries := []webhookDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		storeErr(w, r, "could not fetch deliveries", err)
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": deliveries})
+}
+
+func webhookID(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid id", "error": "bad request"})
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPostWebhook(t *testing.T) {
+	allowOutbound(t, "127.0.0.0/8", "::1/128")
+	apply, err := loadWebhookSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := webhookConfig.Load()
+	apply()
+	t.Cleanup(func() { webhookConfig.Store(prev) })
+
+	const secret = "s3cret"
+	status := http.StatusNoContent
+	var got http.Header
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	h := webhook{ID: primitive.NewObjectID(), URL: srv.URL, Secret: secret}
+	d := webhookDelivery{ID: primitive.NewObjectID(), EventType: eventTodoCreated, Payload: []byte(`{"type":"todo.created"}`)}
+
+	code, err := postWebhook(context.Background(), h, d)
+	if err != nil || code != http.StatusNoContent {
+		t.Fatalf("postWebhook = %d, %v, want 204", code, err)
+	}
+	if string(body) != string(d.Payload) {
+		t.Errorf("body = %s, want %s", body, d.Payload)
+	}
+	if got.Get("X-Todo-Event") != eventTodoCreated || got.Get("X-Todo-Delivery") != d.ID.Hex() {
+		t.Errorf("event %q, delivery %q", got.Get("X-Todo-Event"), got.Get("X-Todo-Delivery"))
+	}
+	timestamp := got.Get("X-Todo-Timestamp")
+	if sec, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(sec, 0)) > time.Minute {
+		t.Errorf("X-Todo-Timestamp = %q", timestamp)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(d.Payload)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); got.Get("X-Todo-Signature") != want {
+		t.Errorf("X-Todo-Signature = %q, want %q", got.Get("X-Todo-Signature"), want)
+	}
+
+	status = http.StatusInternalServerError
+	if code, err := postWebhook(context.Background(), h, d); err == nil || code != http.StatusInternalServerError {
+		t.Errorf("postWebhook on a 500 = %d, %v, want an error", code, err)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	webPushTTL       = 24 * time.Hour
+	webPushTimeout   = 10 * time.Second
+	webPushRecordLen = 4096
+	// webPushMaxPayload is the largest plaintext fitting in one record
+	// with the delimiter and the GCM tag.
+	webPushMaxPayload = webPushRecordLen - 17
+)
+
+// vapidKey signs Web Push requests, see RFC 8292.
+type vapidKey struct {
+	private *ecdsa.PrivateKey
+	// public is the uncompressed point browsers subscribe with.
+	public  []byte
+	subject string
+}
+
+// parseVAPIDKey reads a private key in the base64url encoding of the raw
+// 32-byte scalar that web-push tools generate.
+func parseVAPIDKey(encoded, subject string) (*vapidKey, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return nil, fmt.Errorf("not base64url: %w", err)
+	}
+	k, err := ecdh.P256().NewPrivateKey(b)
+	if err != nil {
+		return nil, err
+	}
+	public := k.PublicKey().Bytes()
+	return &vapidKey{
+		private: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(public[1:33]),
+				Y:     new(big.Int).SetBytes(public[33:]),
+			},
+			D: new(big.Int).SetBytes(b),
+		},
+		public:  public,
+		subject: subject,
+	}, nil
+}
+
+func (k *vapidKey) publicKey() string {
+	return base64.RawURLEncoding.EncodeToString(k.public)
+}
+
+// authorization returns the vapid Authorization header for endpoint: an
+// ES256 JWT for the origin of the push service and the public key.
+func (k *vapidKey) authorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, _ := json.Marshal(map[string]any{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": k.subject,
+	})
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, k.private, digest[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return "vapid t=" + unsigned + "." + base64.RawURLEncoding.EncodeToString(sig) + ", k=" + k.publicKey(), nil
+}
+
+// hkdf derives n bytes from ikm, see RFC 5869. n is at most 32, so the
+// expansion is a single block.
+func hkdf(salt, ikm, info []byte, n int) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	prk := mac.Sum(nil)
+	mac = hmac.New(sha256.New, prk)
+	mac.Write(info)
+	mac.Write([]byte{1})
+	return mac.Sum(nil)[:n]
+}
+
+// encryptWebPush encrypts payload for a subscription with the aes128gcm
+// content coding of RFC 8291. p256dh and auth are the keys of the
+// subscription.
+func encryptWebPush(payload, p256dh, auth []byte) ([]byte, error) {
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return sealWebPush(payload, p256dh, auth, asPrivate, salt)
+}
+
+// sealWebPush is encryptWebPush with the key pair of the application
+// server and the salt given, which are random for every message.
+func sealWebPush(payload, p256dh, auth []byte, asPrivate *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	if len(payload) > webPushMaxPayload {
+		return nil, errors.New("payload too large")
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(p256dh)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	shared, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+
+	keyInfo := append([]byte("WebPush: info\x00"), p256dh...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := hkdf(auth, shared, keyInfo, 32)
+
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.Write(salt)
+	binary.Write(&out, binary.BigEndian, uint32(webPushRecordLen))
+	out.WriteByte(byte(len(asPublic)))
+	out.Write(asPublic)
+	// A single record ends with the 0x02 delimiter and no padding.
+	out.Write(gcm.Seal(nil, nonce, append(payload[:len(payload):len(payload)], 2), nil))
+	return out.Bytes(), nil
+}
+
+// errPushGone is returned when the push service reports a subscription
+// expired or unsubscribed.
+var errPushGone = errors.New("push subscription is gone")
+
+// sendWebPush delivers payload to a Web Push subscription. The endpoint
+// comes from the browser of the user, so it goes through outboundClient.
+func sendWebPush(ctx context.Context, k *vapidKey, endpoint string, p256dh, auth, payload []byte) error {
+	body, err := encryptWebPush(payload, p256dh, auth)
+	if err != nil {
+		return err
+	}
+	authorization, err := k.authorization(endpoint)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, webPushTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(webPushTTL.Seconds())))
+	resp, err := outboundClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return errPushGone
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("web push: unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func b64(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestSealWebPush encrypts the example of RFC 8291, section 5.
+func TestSealWebPush(t *testing.T) {
+	asPrivate, err := ecdh.P256().NewPrivateKey(b64(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256dh := b64(t, "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4")
+	auth := b64(t, "BTBZMqHH6r4Tts7J_aSIgg")
+	salt := b64(t, "DGv6ra1nlYgDCS1FRnbzlw")
+
+	got, err := sealWebPush([]byte("When I grow up, I want to be a watermelon"), p256dh, auth, asPrivate, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+	if enc := base64.RawURLEncoding.EncodeToString(got); enc != want {
+		t.Errorf("encrypted\n%s, want\n%s", enc, want)
+	}
+
+	if _, err := sealWebPush(make([]byte, webPushMaxPayload+1), p256dh, auth, asPrivate, salt); err == nil {
+		t.Error("a payload larger than a record was encrypted")
+	}
+	if _, err := sealWebPush(nil, p256dh[:33], auth, asPrivate, salt); err == nil {
+		t.Error("a truncated p256dh key was accepted")
+	}
+}
+
+func TestVAPIDAuthorization(t *testing.T) {
+	k, err := parseVAPIDKey("yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw", "mailto:ops@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := k.publicKey(), "BP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A8"; got != want {
+		t.Errorf("public key %s, want %s", got, want)
+	}
+
+	header, err := k.authorization("https://push.example.net/wpush/v2/abc?x=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, public, ok := strings.Cut(strings.TrimPrefix(header, "vapid t="), ", k=")
+	if !ok || !strings.HasPrefix(header, "vapid t=") || public != k.publicKey() {
+		t.Fatalf("authorization %q, want vapid t=..., k=%s", header, k.publicKey())
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token %q is not a JWT", token)
+	}
+
+	var jwtHeader struct{ Typ, Alg string }
+	var claims struct {
+		Aud string
+		Exp int64
+		Sub string
+	}
+	if err := json.Unmarshal(b64(t, parts[0]), &jwtHeader); err != nil || jwtHeader.Alg != "ES256" {
+		t.Errorf("JWT header %s, want ES256", b64(t, parts[0]))
+	}
+	if err := json.Unmarshal(b64(t, parts[1]), &claims); err != nil {
+		t.Fatal(err)
+	}
+	// The expiry must be within 24 hours, see RFC 8292, section 2.
+	exp := time.Unix(claims.Exp, 0)
+	if claims.Aud != "https://push.example.net" || claims.Sub != "mailto:ops@example.com" || exp.Before(time.Now()) || exp.After(time.Now().Add(24*time.Hour)) {
+		t.Errorf("claims %+v", claims)
+	}
+
+	point := b64(t, public)
+	pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(point[1:33]), Y: new(big.Int).SetBytes(point[33:])}
+	sig := b64(t, parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if len(sig) != 64 || !ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		t.Error("the signature does not verify with the public key")
+	}
+}
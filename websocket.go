@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// The WebSocket endpoint only pushes events, so a minimal server side of
+// RFC 6455 is enough: text frames out, control frames in, client messages
+// are read and discarded.
+const (
+	wsGUID         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsPingInterval = 30 * time.Second
+	wsWriteTimeout = 10 * time.Second
+	wsMaxFrameSize = 4096
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// wsHandler serves GET /ws. Once upgraded the client receives every
+// create, update and delete of its tenant as a JSON text message.
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "websocket upgrade required", "error": "bad request"})
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		rnd.JSON(w, http.StatusUpgradeRequired, renderer.M{"message": "unsupported websocket version", "error": "upgrade required"})
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "websocket not supported", "error": "internal error"})
+		return
+	}
+
+	events, _, cancel := changes.subscribe(tenantFrom(r.Context()), 0)
+	defer cancel()
+
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAccept(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	// Deadlines set by the http.Server still apply after hijacking.
+	conn.SetDeadline(time.Time{})
+	ws := &wsConn{conn: conn}
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		ws.readLoop(rw.Reader)
+	}()
+
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case c, ok := <-events:
+			if !ok {
+				// Fell behind, the client reconnects and refetches.
+				ws.writeFrame(wsOpClose, closePayload(1008, "too slow"))
+				return
+			}
+			msg, err := json.Marshal(c.Event)
+			if err != nil {
+				continue
+			}
+			if ws.writeFrame(wsOpText, msg) != nil {
+				return
+			}
+		case <-ticker.C:
+			if ws.writeFrame(wsOpPing, nil) != nil {
+				return
+			}
+		}
+	}
+}
+
+// wsAccept returns the Sec-WebSocket-Accept answering the client's key.
+func wsAccept(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+type wsConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// writeFrame writes a single unmasked, unfragmented frame.
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	header := []byte{0x80 | op, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := (&net.Buffers{header, payload}).WriteTo(c.conn)
+	return err
+}
+
+// readLoop answers pings and closes until the connection fails, times out
+// or the client closes it.
+func (c *wsConn) readLoop(r *bufio.Reader) {
+	msgs := &wsReader{r: r}
+	for {
+		// Clients answer the pings, a silent one is gone.
+		c.conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+		op, payload, err := msgs.next()
+		switch {
+		case errors.Is(err, errFrameTooLarge):
+			c.writeFrame(wsOpClose, closePayload(1009, "message too big"))
+			return
+		case errors.Is(err, errWSProtocol):
+			c.writeFrame(wsOpClose, closePayload(1002, "protocol error"))
+			return
+		case err != nil:
+			return
+		}
+		switch op {
+		case wsOpClose:
+			c.writeFrame(wsOpClose, payload)
+			return
+		case wsOpPing:
+			c.writeFrame(wsOpPong, payload)
+		}
+	}
+}
+
+var (
+	errFrameTooLarge = errors.New("websocket frame too large")
+	errWSProtocol    = errors.New("websocket protocol error")
+)
+
+// wsFrame is a frame as read, its payload unmasked.
+type wsFrame struct {
+	fin     bool
+	op      byte
+	masked  bool
+	payload []byte
+}
+
+// readFrame reads one frame. Frames with reserved bits or opcodes, and
+// control frames that are fragmented or longer than 125 bytes, are protocol
+// errors.
+func readFrame(r *bufio.Reader) (f wsFrame, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return f, err
+	}
+	f.fin = head[0]&0x80 != 0
+	f.op = head[0] & 0x0F
+	f.masked = head[1]&0x80 != 0
+	if head[0]&0x70 != 0 {
+		return f, fmt.Errorf("%w: reserved bits set", errWSProtocol)
+	}
+	switch f.op {
+	case wsOpContinuation, wsOpText, wsOpBinary, wsOpClose, wsOpPing, wsOpPong:
+	default:
+		return f, fmt.Errorf("%w: reserved opcode %#x", errWSProtocol, f.op)
+	}
+
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return f, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return f, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+		if n>>63 != 0 {
+			return f, fmt.Errorf("%w: length with the most significant bit set", errWSProtocol)
+		}
+	}
+	if f.op >= wsOpClose && (n > 125 || !f.fin) {
+		return f, fmt.Errorf("%w: control frame fragmented or longer than 125 bytes", errWSProtocol)
+	}
+	if n > wsMaxFrameSize {
+		return f, errFrameTooLarge
+	}
+
+	var mask [4]byte
+	if f.masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return f, err
+		}
+	}
+	f.payload = make([]byte, n)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return f, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+	return f, nil
+}
+
+// wsReader reads the messages of a client, reassembling fragmented ones.
+// Control frames, which may come between fragments, are returned on their
+// own.
+type wsReader struct {
+	r       *bufio.Reader
+	op      byte // of the fragmented message being read, if any
+	partial []byte
+}
+
+// next returns the opcode and payload of the next message or control frame.
+// Clients must mask their frames.
+func (m *wsReader) next() (op byte, payload []byte, err error) {
+	for {
+		f, err := readFrame(m.r)
+		if err != nil {
+			return 0, nil, err
+		}
+		if !f.masked {
+			return 0, nil, fmt.Errorf("%w: unmasked client frame", errWSProtocol)
+		}
+		switch {
+		case f.op >= wsOpClose:
+			return f.op, f.payload, nil
+		case f.op == wsOpContinuation && m.op == 0:
+			return 0, nil, fmt.Errorf("%w: continuation frame without a message", errWSProtocol)
+		case f.op != wsOpContinuation && m.op != 0:
+			return 0, nil, fmt.Errorf("%w: new message before the end of a fragmented one", errWSProtocol)
+		case f.op != wsOpContinuation:
+			m.op = f.op
+		}
+		if len(m.partial)+len(f.payload) > wsMaxFrameSize {
+			return 0, nil, errFrameTooLarge
+		}
+		m.partial = append(m.partial, f.payload...)
+		if f.fin {
+			op, payload = m.op, m.partial
+			m.op, m.partial = 0, nil
+			return op, payload, nil
+		}
+	}
+}
+
+func closePayload(code uint16, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, code), reason...)
+}
+
+// headerContains reports whether the comma separated header name contains
+// token, case insensitively.
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
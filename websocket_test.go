@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// maskedFrame encodes a client frame, masked with the key of RFC 6455,
+// section 5.7.
+func maskedFrame(b0 byte, payload []byte) []byte {
+	mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+	frame := []byte{b0, 0x80}
+	switch n := len(payload); {
+	case n < 126:
+		frame[1] |= byte(n)
+	case n <= 0xFFFF:
+		frame[1] |= 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame[1] |= 127
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, mask...)
+	for i, c := range payload {
+		frame = append(frame, c^mask[i%4])
+	}
+	return frame
+}
+
+func frameReader(frames ...[]byte) *bufio.Reader {
+	return bufio.NewReader(bytes.NewReader(bytes.Join(frames, nil)))
+}
+
+func TestWSAccept(t *testing.T) {
+	// RFC 6455, section 1.3.
+	if got := wsAccept("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("accept %s", got)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	long := bytes.Repeat([]byte("a"), 256)
+	tests := []struct {
+		name  string
+		frame []byte
+		want  wsFrame
+		err   error
+	}{
+		// The examples of RFC 6455, section 5.7.
+		{"unmasked", []byte("\x81\x05Hello"), wsFrame{fin: true, op: wsOpText, payload: []byte("Hello")}, nil},
+		{"masked", []byte("\x81\x85\x37\xfa\x21\x3d\x7f\x9f\x4d\x51\x58"),
+			wsFrame{fin: true, op: wsOpText, masked: true, payload: []byte("Hello")}, nil},
+		{"fragment", []byte("\x01\x03Hel"), wsFrame{op: wsOpText, payload: []byte("Hel")}, nil},
+		{"16-bit length", maskedFrame(0x82, long), wsFrame{fin: true, op: wsOpBinary, masked: true, payload: long}, nil},
+		{"64-bit length", maskedFrame(0x82, make([]byte, 0x10000)), wsFrame{}, errFrameTooLarge},
+		{"64-bit length with the top bit", []byte("\x82\xff\x80\x00\x00\x00\x00\x00\x00\x01"), wsFrame{}, errWSProtocol},
+		{"too large", maskedFrame(0x82, make([]byte, wsMaxFrameSize+1)), wsFrame{}, errFrameTooLarge},
+		{"long control frame", maskedFrame(0x89, make([]byte, 126)), wsFrame{}, errWSProtocol},
+		{"fragmented control frame", maskedFrame(0x09, nil), wsFrame{}, errWSProtocol},
+		{"reserved bits", maskedFrame(0xC1, []byte("x")), wsFrame{}, errWSProtocol},
+		{"reserved opcode", maskedFrame(0x83, nil), wsFrame{}, errWSProtocol},
+		{"truncated", []byte("\x81\x85\x37\xfa\x21\x3d\x7f"), wsFrame{}, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := readFrame(frameReader(tt.frame))
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.fin != tt.want.fin || f.op != tt.want.op || f.masked != tt.want.masked || !bytes.Equal(f.payload, tt.want.payload) {
+				t.Errorf("frame %+v, want %+v", f, tt.want)
+			}
+		})
+	}
+}
+
+func TestWSReader(t *testing.T) {
+	m := &wsReader{r: frameReader(
+		maskedFrame(0x01, []byte("Hel")),
+		maskedFrame(0x89, []byte("ping")),
+		maskedFrame(0x00, []byte("lo ")),
+		maskedFrame(0x80, []byte("world")),
+		maskedFrame(0x82, []byte{1, 2}),
+		maskedFrame(0x88, closePayload(1000, "")),
+	)}
+	want := []struct {
+		op      byte
+		payload string
+	}{
+		{wsOpPing, "ping"},
+		{wsOpText, "Hello world"},
+		{wsOpBinary, "\x01\x02"},
+		{wsOpClose, "\x03\xe8"},
+	}
+	for _, w := range want {
+		op, payload, err := m.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if op != w.op || string(payload) != w.payload {
+			t.Errorf("message %#x %q, want %#x %q", op, payload, w.op, w.payload)
+		}
+	}
+
+	invalid := map[string]*bufio.Reader{
+		"unmasked":                  frameReader([]byte("\x81\x05Hello")),
+		"continuation first":        frameReader(maskedFrame(0x80, []byte("x"))),
+		"message inside a fragment": frameReader(maskedFrame(0x01, []byte("x")), maskedFrame(0x81, []byte("y"))),
+	}
+	for name, r := range invalid {
+		if _, _, err := (&wsReader{r: r}).next(); !errors.Is(err, errWSProtocol) {
+			t.Errorf("%s: error %v, want a protocol error", name, err)
+		}
+	}
+
+	half := bytes.Repeat([]byte("a"), wsMaxFrameSize/2+1)
+	m = &wsReader{r: frameReader(maskedFrame(0x01, half), maskedFrame(0x80, half))}
+	if _, _, err := m.next(); !errors.Is(err, errFrameTooLarge) {
+		t.Errorf("reassembled a message of %d bytes: %v", 2*len(half), err)
+	}
+}
+
+func TestWSWriteFrame(t *testing.T) {
+	for _, tt := range []struct {
+		size   int
+		header string
+	}{
+		{125, "\x81\x7d"},
+		{126, "\x81\x7e\x00\x7e"},
+		{0xFFFF, "\x81\x7e\xff\xff"},
+		{0x10000, "\x81\x7f\x00\x00\x00\x00\x00\x01\x00\x00"},
+	} {
+		server, client := net.Pipe()
+		payload := []byte(strings.Repeat("a", tt.size))
+		go func() {
+			(&wsConn{conn: server}).writeFrame(wsOpText, payload)
+			server.Close()
+		}()
+		got, err := io.ReadAll(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := append([]byte(tt.header), payload...)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%d bytes: header %x, want %x", tt.size, got[:min(len(got), 10)], tt.header)
+		}
+	}
+}
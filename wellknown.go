@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// securityTxtExpiry is how long after startup the generated security.txt
+// expires. RFC 9116 recommends less than a year.
+const securityTxtExpiry = 180 * 24 * time.Hour
+
+// defaultRobotsTxt keeps crawlers out: the pages are a user's todos.
+const defaultRobotsTxt = "User-agent: *\nDisallow: /\n"
+
+var (
+	robotsTxt = defaultRobotsTxt
+	// securityTxt is the contents of security.txt, which is answered 404
+	// when none is configured.
+	securityTxt string
+)
+
+// loadWellKnownConfig reads TODO_ROBOTS_TXT, a file served as robots.txt,
+// and the contents of /.well-known/security.txt: either a file in
+// TODO_SECURITY_TXT, or one generated from TODO_SECURITY_CONTACT,
+// comma-separated mailto:, https: or tel: URIs, with
+// TODO_SECURITY_POLICY, the URL of a disclosure policy.
+func loadWellKnownConfig() error {
+	robotsTxt = defaultRobotsTxt
+	if path := os.Getenv("TODO_ROBOTS_TXT"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		robotsTxt = string(b)
+	}
+
+	securityTxt = ""
+	path, contacts := os.Getenv("TODO_SECURITY_TXT"), os.Getenv("TODO_SECURITY_CONTACT")
+	switch {
+	case path != "" && contacts != "":
+		return fmt.Errorf("TODO_SECURITY_TXT and TODO_SECURITY_CONTACT are exclusive")
+	case path != "":
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		securityTxt = string(b)
+	case contacts != "":
+		var b strings.Builder
+		for _, c := range strings.Split(contacts, ",") {
+			c = strings.TrimSpace(c)
+			u, err := url.Parse(c)
+			if err != nil || (u.Scheme != "mailto" && u.Scheme != "https" && u.Scheme != "tel") {
+				return fmt.Errorf("invalid TODO_SECURITY_CONTACT %q, must be a mailto:, https: or tel: URI", c)
+			}
+			fmt.Fprintf(&b, "Contact: %s\n", c)
+		}
+		fmt.Fprintf(&b, "Expires: %s\n", time.Now().Add(securityTxtExpiry).UTC().Format(time.RFC3339))
+		if policy := os.Getenv("TODO_SECURITY_POLICY"); policy != "" {
+			if u, err := url.Parse(policy); err != nil || u.Scheme != "https" {
+				return fmt.Errorf("invalid TODO_SECURITY_POLICY %q, must be an https: URL", policy)
+			}
+			fmt.Fprintf(&b, "Policy: %s\n", policy)
+		}
+		b.WriteString("Preferred-Languages: en\n")
+		securityTxt = b.String()
+	}
+	return nil
+}
+
+// faviconHandler serves favicon.ico of staticFS, so browsers asking for it
+// on every page do not fill the logs with 404s.
+func faviconHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := fs.Stat(staticFS, "favicon.ico"); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeFileFS(w, r, staticFS, "favicon.ico")
+}
+
+func robotsTxtHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write([]byte(robotsTxt))
+}
+
+func securityTxtHandler(w http.ResponseWriter, r *http.Request) {
+	if securityTxt == "" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write([]byte(securityTxt))
+}
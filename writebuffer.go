@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Operations that can be buffered while Mongo is unreachable.
+const (
+	bufferedCreate = "create"
+	bufferedUpdate = "update"
+	bufferedDelete = "delete"
+	bufferedUpsert = "upsert"
+	bufferedMove   = "move"
+)
+
+const writeBufferReplayInterval = 2 * time.Second
+
+var writeBufferBucket = []byte("writes")
+
+type (
+	// bufferedWrite is a mutation queued for replay.
+	bufferedWrite struct {
+		Op     string                 `json:"op"`
+		Tenant string                 `json:"tenant,omitempty"`
+		ID     primitive.ObjectID     `json:"id"`
+		Todo   *todoModel             `json:"todo,omitempty"`
+		Fields map[string]interface{} `json:"fields,omitempty"`
+		Place  *boardPlace            `json:"place,omitempty"`
+	}
+
+	// bufferedEntry is the stored form of a bufferedWrite. Data is encrypted
+	// with the todo ID like any other field when encryption is enabled.
+	bufferedEntry struct {
+		ID   primitive.ObjectID `json:"id"`
+		Data string             `json:"data"`
+	}
+
+	// writeBuffer is a durable FIFO of writes kept in a bbolt file.
+	writeBuffer struct {
+		db *bolt.DB
+	}
+)
+
+// writes is nil unless TODO_WRITE_BUFFER names the bbolt file to queue
+// writes in.
+var writes *writeBuffer
+
+func openWriteBuffer() error {
+	path := os.Getenv("TODO_WRITE_BUFFER")
+	if path == "" {
+		return nil
+	}
+	bdb, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("could not open write buffer %s: %w", path, err)
+	}
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(writeBufferBucket)
+		return err
+	})
+	if err != nil {
+		bdb.Close()
+		return err
+	}
+
+	writes = &writeBuffer{db: bdb}
+	if n := writes.Len(); n > 0 {
+		slog.Info("writes waiting for replay", "component", "write_buffer", "count", n)
+	}
+	return nil
+}
+
+// Len returns the number of queued writes.
+func (b *writeBuffer) Len() int {
+	n := 0
+	b.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(writeBufferBucket).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// Push appends w to the queue.
+func (b *writeBuffer) Push(w bufferedWrite) error {
+	raw, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	data, err := encryptField(w.ID, string(raw))
+	if err != nil {
+		return err
+	}
+	entry, err := json.Marshal(bufferedEntry{ID: w.ID, Data: data})
+	if err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(writeBufferBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return bucket.Put(key, entry)
+	})
+}
+
+// Peek returns the oldest queued write and its key.
+func (b *writeBuffer) Peek() (key []byte, w bufferedWrite, ok bool, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		k, v := tx.Bucket(writeBufferBucket).Cursor().First()
+		if k == nil {
+			return nil
+		}
+		ok = true
+		key = append([]byte(nil), k...)
+
+		var entry bufferedEntry
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return err
+		}
+		raw, err := decryptField(entry.ID, entry.Data)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal([]byte(raw), &w)
+	})
+	return key, w, ok, err
+}
+
+// Remove deletes the queued write under key.
+func (b *writeBuffer) Remove(key []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(writeBufferBucket).Delete(key)
+	})
+}
+
+func (b *writeBuffer) Close() error {
+	return b.db.Close()
+}
+
+// bufferOr runs write, or queues w instead when Mongo is unreachable. While
+// earlier writes are still queued, w is queued behind them so writes are
+// applied in order. queued reports whether w went to the buffer.
+func bufferOr(w bufferedWrite, write func() error) (queued bool, err error) {
+	if writes == nil {
+		return false, write()
+	}
+	if writes.Len() == 0 && storeAvailable() {
+		err = write()
+		if !isTransient(err) {
+			return false, err
+		}
+	}
+	if err := writes.Push(w); err != nil {
+		return false, fmt.Errorf("could not buffer write: %w", err)
+	}
+	return true, nil
+}
+
+// runWriteBufferReplay applies queued writes in order whenever Mongo is
+// reachable, until ctx is done.
+func runWriteBufferReplay(ctx context.Context) {
+	if writes == nil {
+		return
+	}
+	ticker := time.NewTicker(writeBufferReplayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if writes.Len() == 0 {
+			continue
+		}
+		if !storeAvailable() {
+			continue
+		}
+		if n, err := replayWrites(ctx); n > 0 || err != nil {
+			slog.Info("replayed writes", "component", "write_buffer", "count", n, "remaining", writes.Len(), "error", err)
+		}
+	}
+}
+
+// replayWrites applies queued writes until the queue is empty or a
+// transient error occurs. Writes failing for any other reason are dropped.
+func replayWrites(ctx context.Context) (int, error) {
+	n := 0
+	for {
+		key, w, ok, err := writes.Peek()
+		if err != nil || !ok {
+			return n, err
+		}
+
+		opCtx, cancel := context.WithTimeout(withTenant(ctx, w.Tenant), requestTimeout)
+		err = applyBufferedWrite(opCtx, w)
+		cancel()
+		if isTransient(err) || ctx.Err() != nil {
+			return n, err
+		}
+		if err != nil && !errors.Is(err, errNotFound) {
+			slog.Error("dropping write", "component", "write_buffer", "op", w.Op, "todo", w.ID.Hex(), "error", err)
+		}
+		if err := writes.Remove(key); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+func applyBufferedWrite(ctx context.Context, w bufferedWrite) error {
+	switch w.Op {
+	case bufferedCreate:
+		if w.Todo == nil {
+			return errors.New("buffered create without todo")
+		}
+		// The preferences may have been unavailable when the create was
+		// queued.
+		if w.Todo.List == "" {
+			prefs, err := loadPreferences(ctx, w.Tenant)
+			if err != nil {
+				return err
+			}
+			w.Todo.List = prefs.DefaultList
+		}
+		err := repo.Create(ctx, *w.Todo)
+		if mongo.IsDuplicateKeyError(err) {
+			return nil
+		}
+		return err
+	case bufferedUpdate:
+		// JSON decoded the numbers as float64, the fields are integers.
+		for k, v := range w.Fields {
+			if f, ok := v.(float64); ok && f == math.Trunc(f) {
+				w.Fields[k] = int(f)
+			}
+		}
+		return repo.Update(ctx, w.ID, bson.M(w.Fields))
+	case bufferedUpsert:
+		if w.Todo == nil {
+			return errors.New("buffered upsert without todo")
+		}
+		_, err := repo.Upsert(ctx, *w.Todo)
+		return err
+	case bufferedMove:
+		if w.Place == nil {
+			return errors.New("buffered move without place")
+		}
+		_, err := repo.Move(ctx, w.ID, *w.Place)
+		return err
+	case bufferedDelete:
+		deleted, err := repo.Delete(ctx, w.ID)
+		if err == nil {
+			deleteBlobs(deleted.Attachments)
+		}
+		return err
+	}
+	return fmt.Errorf("unknown buffered operation %q", w.Op)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// useWriteBuffer queues writes in a bbolt file of the test while MongoDB
+// is down, until the test ends.
+func useWriteBuffer(t *testing.T) {
+	t.Setenv("TODO_WRITE_BUFFER", filepath.Join(t.TempDir(), "writes.db"))
+	prevWrites, prevRepo, prevRnd := writes, repo, rnd
+	if err := openWriteBuffer(); err != nil {
+		t.Fatal(err)
+	}
+	repo, rnd = mongoRepository{}, renderer.New()
+	dbConnected.Store(false)
+	t.Cleanup(func() {
+		writes.Close()
+		writes, repo, rnd = prevWrites, prevRepo, prevRnd
+	})
+}
+
+// TestCreateWhileStoreDown queues a create while MongoDB is down, which
+// must not need the preferences of the tenant.
+func TestCreateWhileStoreDown(t *testing.T) {
+	useWriteBuffer(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/todo", strings.NewReader(`{"title":"buy milk"}`))
+	rec := httptest.NewRecorder()
+	createTodo(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+	}
+
+	_, w, ok, err := writes.Peek()
+	if err != nil || !ok {
+		t.Fatalf("nothing queued: %v", err)
+	}
+	if w.Op != bufferedCreate || w.Todo == nil || w.Todo.Title != "buy milk" {
+		t.Errorf("queued %+v, want the create of the todo", w)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	zapierNewTodo       = "new_todo"
+	zapierCompletedTodo = "completed_todo"
+
+	maxZapierHooksPerTenant = 100
+	// zapierPollLimit bounds the todos returned to a polling trigger.
+	// Zapier deduplicates on id, so it only needs the newest ones.
+	zapierPollLimit = 100
+)
+
+// zapierTriggers maps the trigger keys of the Zapier app to the event
+// their REST hooks subscribe to.
+var zapierTriggers = map[string]string{
+	zapierNewTodo:       eventTodoCreated,
+	zapierCompletedTodo: eventTodoUpdated,
+}
+
+// zapierHandlers serves a Zapier app authenticating with the tenant:
+//
+//	GET    /zapier/me                   the authentication test
+//	POST   /zapier/hooks                subscribes a REST hook
+//	DELETE /zapier/hooks/{id}           unsubscribes it
+//	GET    /zapier/triggers/{trigger}   polling triggers and sample data
+//
+// REST hooks are webhooks posting the bare todo, delivered and retried
+// like any other webhook.
+func zapierHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(requireStore)
+	rg.Use(tenantMiddleware)
+	rg.Group(func(r chi.Router) {
+		r.Get("/me", zapierMe)
+		r.Get("/triggers/{trigger}", pollZapierTrigger)
+	})
+	rg.Group(func(r chi.Router) {
+		r.Use(requireWebhooks)
+		r.Post("/hooks", subscribeZapier)
+		r.Delete("/hooks/{id}", unsubscribeZapier)
+	})
+	return rg
+}
+
+func zapierMe(w http.ResponseWriter, r *http.Request) {
+	rnd.JSON(w, http.StatusOK, renderer.M{"tenant": tenantFrom(r.Context())})
+}
+
+// zapierPayload returns the body posted to a REST hook of trigger for ev,
+// or false when ev does not fire the trigger.
+func zapierPayload(trigger string, ev todoEvent) ([]byte, bool) {
+	if trigger == zapierCompletedTodo && ev.Todo.Completed != "true" {
+		return nil, false
+	}
+	b, err := json.Marshal(ev.Todo)
+	return b, err == nil
+}
+
+// zapierHookRequest is the body Zapier sends on subscribe.
+type zapierHookRequest struct {
+	TargetURL string `json:"target_url"`
+	Event     string `json:"event"`
+}
+
+// subscribeZapier registers a REST hook. Zapier keeps the returned id to
+// unsubscribe when the Zap is turned off.
+func subscribeZapier(w http.ResponseWriter, r *http.Request) {
+	var req zapierHookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "invalid request", "error": err.Error()})
+		return
+	}
+	u, err := url.Parse(req.TargetURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "target_url must be an absolute https URL", "error": "bad request"})
+		return
+	}
+	if err := checkOutboundURL(r.Context(), req.TargetURL); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "target_url " + strings.TrimPrefix(err.Error(), "url "), "error": "bad request"})
+		return
+	}
+	event, ok := zapierTriggers[req.Event]
+	if !ok {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "event must be one of " + strings.Join(zapierTriggerKeys(), ", "), "error": "bad request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	n, err := webhooksCollection().CountDocuments(ctx, bson.M{"tenant_id": tenantFrom(ctx), "zapier_trigger": bson.M{"$exists": true}})
+	if err != nil {
+		storeErr(w, r, "could not subscribe", err)
+		return
+	}
+	if n >= maxZapierHooksPerTenant {
+		rnd.JSON(w, http.StatusConflict, renderer.M{"message": "subscription limit reached", "error": "conflict"})
+		return
+	}
+
+	// Zapier does not verify signatures, but deliveries are signed anyway.
+	b := make([]byte, 32)
+	rand.Read(b)
+	h := webhook{
+		ID:        primitive.NewObjectID(),
+		TenantID:  tenantFrom(ctx),
+		URL:       req.TargetURL,
+		Events:    []string{event},
+		Trigger:   req.Event,
+		CreatedAt: time.Now(),
+	}
+	if h.Secret, err = encryptField(h.ID, hex.EncodeToString(b)); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{"message": "could not subscribe", "error": err.Error()})
+		return
+	}
+	if _, err := webhooksCollection().InsertOne(ctx, h); err != nil {
+		storeErr(w, r, "could not subscribe", err)
+		return
+	}
+	rnd.JSON(w, http.StatusCreated, renderer.M{"id": h.ID, "target_url": h.URL, "event": h.Trigger})
+}
+
+func unsubscribeZapier(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	n, err := webhooksCollection().CountDocuments(ctx, bson.M{"_id": id, "tenant_id": tenantFrom(ctx), "zapier_trigger": bson.M{"$exists": true}})
+	if err == nil && n == 0 {
+		err = errNotFound
+	}
+	if err != nil {
+		storeErr(w, r, "could not unsubscribe", err)
+		return
+	}
+	removeWebhook(ctx, id)
+	rnd.JSON(w, http.StatusOK, renderer.M{"message": "unsubscribed successfully"})
+}
+
+// pollZapierTrigger answers a polling trigger, and the sample request of a
+// REST hook trigger, with a bare array of todos, newest first.
+func pollZapierTrigger(w http.ResponseWriter, r *http.Request) {
+	trigger := chi.URLParam(r, "trigger")
+	if _, ok := zapierTriggers[trigger]; !ok {
+		rnd.JSON(w, http.StatusNotFound, renderer.M{"message": "unknown trigger", "error": "not found"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	todos, err := repo.List(ctx)
+	if err != nil {
+		storeErr(w, r, "could not fetch todos", err)
+		return
+	}
+	slices.SortFunc(todos, func(a, b todoModel) int { return b.CreatedAt.Compare(a.CreatedAt) })
+
+	items := []todo{}
+	for _, tm := range todos {
+		if len(items) == zapierPollLimit {
+			break
+		}
+		if trigger == zapierCompletedTodo && !tm.Completed {
+			continue
+		}
+		items = append(items, toTodo(tm))
+	}
+	rnd.JSON(w, http.StatusOK, items)
+}
+
+func zapierTriggerKeys() []string {
+	keys := make([]string, 0, len(zapierTriggers))
+	for k := range zapierTriggers {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}